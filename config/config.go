@@ -1,125 +1,319 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
-	"math"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-func LoadConfig(path string) IConfig {
-	envMap, err := godotenv.Read(path)
-
+// LoadConfig reads the dotenv file at path, real environment variables win
+// over the file. Every invalid value is reported at once in a
+// *ValidationError.
+func LoadConfig(path string) (IConfig, error) {
+	cfg, err := load(path)
 	if err != nil {
-		log.Fatalf("Load dotenv failed: %v", err)
+		return nil, err
 	}
+	return cfg, nil
+}
 
-	return &config{
-		app: &app{
-			host: envMap["APP_HOST"],
-			port: func() int {
-				p, err := strconv.Atoi(envMap["APP_PORT"])
-
-				if err != nil {
-					log.Fatalf("Load port failed: %v", err)
-				}
-				return p
-			}(),
-			name:    envMap["APP_NAME"],
-			version: envMap["APP_VERSION"],
-			readTimeout: func() time.Duration {
-				t, err := strconv.Atoi(envMap["APP_READ_TIMEOUT"])
+func load(path string) (*config, error) {
+	envMap, err := godotenv.Read(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("load dotenv failed: %w", err)
+		}
+		// Containers are configured by environment variables only
+		log.Printf("dotenv %s not found, use environment variables", path)
+		envMap = make(map[string]string)
+	}
 
-				if err != nil {
-					log.Fatalf("Load read timeout failed: %v", err)
-				}
-				return time.Duration(int64(t) * int64(math.Pow10(9)))
-			}(),
-			writeTimeout: func() time.Duration {
-				t, err := strconv.Atoi(envMap["APP_WRTIE_TIMEOUT"])
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if _, ok := envMap[k]; ok || hasEnvPrefix(k) {
+			envMap[k] = v
+		}
+	}
 
-				if err != nil {
-					log.Fatalf("Load write timeout failed: %v", err)
-				}
-				return time.Duration(int64(t) * int64(math.Pow10(9)))
-			}(),
-			bodyLimit: func() int {
-				b, err := strconv.Atoi(envMap["APP_BODY_LIMIT"])
+	r := &envReader{env: envMap}
 
-				if err != nil {
-					log.Fatalf("Load body limit failed: %v", err)
-				}
-				return b
-			}(),
-			fileLimit: func() int {
-				f, err := strconv.Atoi(envMap["APP_FILE_LIMIT"])
+	dyn := new(atomic.Pointer[dynamic])
+	dyn.Store(&dynamic{
+		fileLimit:        r.positiveInt("APP_FILE_LIMIT"),
+		accessExpiresAt:  r.positiveInt("JWT_ACCESS_EXPIRES"),
+		refreshExpiresAt: r.positiveInt("JWT_REFRESH_EXPIRES"),
+		corsAllowOrigins: r.strOr("CORS_ALLOW_ORIGINS", "*"),
+	})
 
-				if err != nil {
-					log.Fatalf("Load file limit failed: %v", err)
+	cfg := &config{
+		path:    path,
+		dynamic: dyn,
+		app: &app{
+			dyn:         dyn,
+			host:        r.str("APP_HOST"),
+			port:        r.port("APP_PORT"),
+			name:        r.str("APP_NAME"),
+			version:     r.str("APP_VERSION"),
+			readTimeout: r.duration("APP_READ_TIMEOUT"),
+			// APP_WRTIE_TIMEOUT is the old misspelled key
+			writeTimeout: func() time.Duration {
+				if _, ok := envMap["APP_WRITE_TIMEOUT"]; !ok {
+					if _, ok := envMap["APP_WRTIE_TIMEOUT"]; ok {
+						return r.duration("APP_WRTIE_TIMEOUT")
+					}
 				}
-				return f
+				return r.duration("APP_WRITE_TIMEOUT")
 			}(),
-			gcpbucket: envMap["APP_GCP_BUCKET"],
+			bodyLimit:       r.positiveInt("APP_BODY_LIMIT"),
+			gcpbucket:       r.str("APP_GCP_BUCKET"),
+			idempotencyTTL:  r.durationOr("APP_IDEMPOTENCY_TTL", 24*time.Hour),
+			trustedProxies:  r.networks("APP_TRUSTED_PROXIES"),
+			idleTimeout:     r.durationOr("APP_IDLE_TIMEOUT", 2*time.Minute),
+			concurrency:     r.positiveIntOr("APP_CONCURRENCY", 256*1024),
+			readBufferSize:  r.positiveIntOr("APP_READ_BUFFER_SIZE", 4096),
+			tlsCertFile:     r.file("APP_TLS_CERT_FILE"),
+			tlsKeyFile:      r.file("APP_TLS_KEY_FILE"),
+			shutdownTimeout: r.durationOr("APP_SHUTDOWN_TIMEOUT", 30*time.Second),
+			shutdownDelay:   r.offDurationOr("APP_SHUTDOWN_DELAY", 0),
+			currency:        r.currencyOr("APP_CURRENCY", "THB"),
+		},
+		admin: &admin{
+			host:         r.strOr("ADMIN_HOST", "127.0.0.1"),
+			port:         r.portOr("ADMIN_PORT", 0),
+			tlsCertFile:  r.file("ADMIN_TLS_CERT_FILE"),
+			tlsKeyFile:   r.file("ADMIN_TLS_KEY_FILE"),
+			clientCAFile: r.file("ADMIN_TLS_CLIENT_CA_FILE"),
 		},
 		db: &db{
-			host: envMap["DB_HOST"],
-			port: func() int {
-				p, err := strconv.Atoi(envMap["DB_PORT"])
-
-				if err != nil {
-					log.Fatalf("Load Db port failed: %v", err)
-				}
-				return p
-			}(),
-			protocol: envMap["DB_PROTOCOL"],
-			username: envMap["DB_USERNAME"],
-			password: envMap["DB_PASSWORD"],
-			database: envMap["DB_DATABASE"],
-			sslMode:  envMap["DB_SSL_MODE"],
-			maxConnections: func() int {
-				m, err := strconv.Atoi(envMap["DB_MAX_CONNECTIONS"])
-
-				if err != nil {
-					log.Fatalf("Load Db max connections failed: %v", err)
-				}
-				return m
-			}(),
+			host:           r.required("DB_HOST"),
+			port:           r.port("DB_PORT"),
+			protocol:       r.str("DB_PROTOCOL"),
+			username:       r.required("DB_USERNAME"),
+			password:       r.secret("DB_PASSWORD", 0),
+			database:       r.required("DB_DATABASE"),
+			sslMode:        r.oneOf("DB_SSL_MODE", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			maxConnections: r.positiveInt("DB_MAX_CONNECTIONS"),
 		},
 		jwt: &jwt{
-			adminKey:  envMap["JWT_ADMIN_KEY"],
-			secretKey: envMap["JWT_SECRET_KEY"],
-			apiKey:    envMap["JWT_API_KEY"],
-			accessExpiresAt: func() int {
-				t, err := strconv.Atoi(envMap["JWT_ACCESS_EXPIRES"])
+			dyn:       dyn,
+			adminKey:  r.secret("JWT_ADMIN_KEY", minSecretLength),
+			secretKey: r.secret("JWT_SECRET_KEY", minSecretLength),
+			apiKey:    r.secret("JWT_API_KEY", minSecretLength),
+		},
+		cors: &cors{
+			dyn:              dyn,
+			allowMethods:     r.strOr("CORS_ALLOW_METHODS", "GET,POST,HEAD,PUT,DELETE,PATCH"),
+			allowHeaders:     r.str("CORS_ALLOW_HEADERS"),
+			exposeHeaders:    r.strOr("CORS_EXPOSE_HEADERS", "X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,Retry-After,Location,Upload-Offset,Upload-Length,X-Cart-Token"),
+			allowCredentials: r.boolOr("CORS_ALLOW_CREDENTIALS", false),
+			maxAge:           r.durationOr("CORS_MAX_AGE", 10*time.Minute),
+		},
+		security: &security{
+			hstsMaxAge:            r.durationOr("SECURITY_HSTS_MAX_AGE", 180*24*time.Hour),
+			frameOptions:          r.oneOfOr("SECURITY_FRAME_OPTIONS", "DENY", "DENY", "SAMEORIGIN"),
+			contentSecurityPolicy: r.strOr("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'"),
+		},
+		image: &imageConfig{
+			renditions: func() []*ImageRendition {
+				v := r.strOr("IMAGE_RENDITIONS", "thumbnail:200,medium:800,large:1600")
+				renditions, err := parseImageRenditions(v)
 				if err != nil {
-					log.Fatalf("Load Jwt access expires at failed: %v", err)
+					r.fail("IMAGE_RENDITIONS", err)
 				}
-				return t
+				return renditions
 			}(),
-			refreshExpiresAt: func() int {
-				t, err := strconv.Atoi(envMap["JWT_REFRESH_EXPIRES"])
-				if err != nil {
-					log.Fatalf("Load Jwt refresh expires at failed: %v", err)
+			quality:      r.positiveIntOr("IMAGE_QUALITY", 85),
+			webp:         r.boolOr("IMAGE_WEBP", false),
+			webpEncoder:  r.strOr("IMAGE_WEBP_ENCODER", "cwebp"),
+			workers:      r.positiveIntOr("IMAGE_WORKERS", 4),
+			maxDimension: r.positiveIntOr("IMAGE_MAX_DIMENSION", 10000),
+			maxPixels:    r.positiveIntOr("IMAGE_MAX_PIXELS", 40000000),
+		},
+		storage: &storageConfig{
+			driver:        r.oneOfOr("STORAGE_DRIVER", "gcs", "gcs", "local"),
+			localDir:      r.strOr("STORAGE_LOCAL_DIR", "./assets/uploads"),
+			localUrl:      r.str("STORAGE_LOCAL_URL"),
+			signingKey:    r.str("STORAGE_SIGNING_KEY"),
+			visibility:    r.oneOfOr("STORAGE_VISIBILITY", "public", "public", "private"),
+			signedUrlTTL:  r.durationOr("STORAGE_SIGNED_URL_TTL", 15*time.Minute),
+			uploadWorkers: r.positiveIntOr("STORAGE_UPLOAD_WORKERS", 5),
+			deleteWorkers: r.positiveIntOr("STORAGE_DELETE_WORKERS", 5),
+			stagingDir:    r.strOr("STORAGE_STAGING_DIR", "./assets/staging"),
+			uploadMaxSize: r.positiveIntOr("STORAGE_UPLOAD_MAX_SIZE", 1<<30),
+			uploadTTL:     r.durationOr("STORAGE_UPLOAD_SESSION_TTL", 24*time.Hour),
+			gcInterval:    r.offDurationOr("STORAGE_GC_INTERVAL", time.Hour),
+			gcGrace:       r.durationOr("STORAGE_GC_GRACE", 24*time.Hour),
+		},
+		payment: &paymentConfig{
+			provider:           r.oneOf("PAYMENT_PROVIDER", "mock", "omise"),
+			simulate:           r.boolOr("PAYMENT_SIMULATE", false),
+			webhookSecret:      r.str("PAYMENT_WEBHOOK_SECRET"),
+			webhookTolerance:   r.durationOr("PAYMENT_WEBHOOK_TOLERANCE", 5*time.Minute),
+			omiseApiUrl:        r.strOr("PAYMENT_OMISE_API_URL", "https://api.omise.co"),
+			omiseSecretKey:     r.str("PAYMENT_OMISE_SECRET_KEY"),
+			omiseWebhookSecret: r.str("PAYMENT_OMISE_WEBHOOK_SECRET"),
+		},
+		scan: &scan{
+			clamdAddr: r.str("SCAN_CLAMD_ADDR"),
+			timeout:   r.durationOr("SCAN_TIMEOUT", 30*time.Second),
+		},
+		rateLimit: &rateLimit{
+			policies: func() map[string]*RateLimitPolicy {
+				policies := make(map[string]*RateLimitPolicy)
+				for k, v := range envMap {
+					if !strings.HasPrefix(k, "RATE_LIMIT_") {
+						continue
+					}
+
+					name := strings.ToLower(strings.TrimPrefix(k, "RATE_LIMIT_"))
+					p, err := parseRateLimitPolicy(name, v)
+					if err != nil {
+						r.fail(k, err)
+						continue
+					}
+					policies[name] = p
 				}
-				return t
+				return policies
 			}(),
 		},
 	}
+
+	r.pair("APP_TLS_CERT_FILE", "APP_TLS_KEY_FILE")
+	r.pair("ADMIN_TLS_CERT_FILE", "ADMIN_TLS_KEY_FILE")
+	if cfg.admin.clientCAFile != "" && cfg.admin.tlsCertFile == "" {
+		r.fail("ADMIN_TLS_CLIENT_CA_FILE", fmt.Errorf("%w, needs ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE", ErrInvalidValue))
+	}
+
+	if cfg.image.quality > 100 {
+		r.fail("IMAGE_QUALITY", fmt.Errorf("%w, must be between 1 and 100", ErrOutOfRange))
+	}
+	if cfg.storage.driver == "local" {
+		if len(cfg.storage.signingKey) < minSecretLength {
+			r.fail("STORAGE_SIGNING_KEY", fmt.Errorf("%w, need at least %d characters for the local driver", ErrTooShort, minSecretLength))
+		}
+		// The local driver is served by the app itself
+		if cfg.storage.localUrl == "" {
+			scheme := "http"
+			if cfg.app.tlsCertFile != "" {
+				scheme = "https"
+			}
+			cfg.storage.localUrl = fmt.Sprintf("%s://%s/v1/files/local", scheme, cfg.app.Url())
+		}
+	}
+	switch cfg.payment.provider {
+	case "mock":
+		// Without a secret the mock provider signs with a random one, only
+		// the app itself can send its webhooks then
+		if s := cfg.payment.webhookSecret; s != "" && len(s) < minSecretLength {
+			r.fail("PAYMENT_WEBHOOK_SECRET", fmt.Errorf("%w, need at least %d characters", ErrTooShort, minSecretLength))
+		}
+	case "omise":
+		if cfg.payment.simulate {
+			r.fail("PAYMENT_SIMULATE", fmt.Errorf("%w, only the mock provider can be simulated", ErrInvalidValue))
+		}
+		if cfg.payment.omiseSecretKey == "" {
+			r.fail("PAYMENT_OMISE_SECRET_KEY", fmt.Errorf("%w for the omise provider", ErrMissing))
+		}
+		// Omise hands out the webhook secret base64 encoded
+		if _, err := base64.StdEncoding.DecodeString(cfg.payment.omiseWebhookSecret); err != nil || cfg.payment.omiseWebhookSecret == "" {
+			r.fail("PAYMENT_OMISE_WEBHOOK_SECRET", fmt.Errorf("%w, must be the base64 secret of the omise dashboard", ErrInvalidValue))
+		}
+	}
+	if a := cfg.scan.clamdAddr; a != "" && !strings.HasPrefix(a, "tcp://") && !strings.HasPrefix(a, "unix://") {
+		r.fail("SCAN_CLAMD_ADDR", fmt.Errorf("%w, must start with tcp:// or unix://", ErrInvalidValue))
+	}
+	// There is no webp encoder in go, the cwebp tool does the encoding
+	if cfg.image.webp {
+		if _, err := exec.LookPath(cfg.image.webpEncoder); err != nil {
+			r.fail("IMAGE_WEBP_ENCODER", fmt.Errorf("%w, %v", ErrInvalidValue, err))
+		}
+	}
+
+	// Browsers refuse credentials together with a wildcard origin
+	if cfg.cors.allowCredentials && cfg.cors.AllowOrigins() == "*" {
+		r.fail("CORS_ALLOW_CREDENTIALS", fmt.Errorf("%w, cannot be true when CORS_ALLOW_ORIGINS is *", ErrInvalidValue))
+	}
+
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var envPrefixes = []string{"APP_", "ADMIN_", "DB_", "JWT_", "CORS_", "SECURITY_", "IMAGE_", "SCAN_", "STORAGE_", "PAYMENT_", "RATE_LIMIT_"}
+
+func hasEnvPrefix(key string) bool {
+	for _, p := range envPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
 }
 
 type IConfig interface {
 	App() IAppConfig
+	Admin() IAdminConfig
 	Db() IDbConfig
 	Jwt() IJwtConfig
+	Cors() ICorsConfig
+	Security() ISecurityConfig
+	Image() IImageConfig
+	Scan() IScanConfig
+	Storage() IStorageConfig
+	Payment() IPaymentConfig
+	RateLimit() IRateLimitConfig
+	Reload() error
 }
 
 type config struct {
-	app *app
-	db  *db
-	jwt *jwt
+	path      string
+	dynamic   *atomic.Pointer[dynamic]
+	app       *app
+	admin     *admin
+	db        *db
+	jwt       *jwt
+	cors      *cors
+	security  *security
+	image     *imageConfig
+	scan      *scan
+	storage   *storageConfig
+	payment   *paymentConfig
+	rateLimit *rateLimit
+}
+
+// dynamic holds the settings that can be reloaded at runtime. It is never
+// modified in place, a reload swaps the whole snapshot so readers always
+// see one consistent version.
+type dynamic struct {
+	fileLimit        int
+	accessExpiresAt  int
+	refreshExpiresAt int
+	corsAllowOrigins string
+}
+
+func updateDynamic(p *atomic.Pointer[dynamic], fn func(d *dynamic)) {
+	for {
+		old := p.Load()
+		next := *old
+		fn(&next)
+		if p.CompareAndSwap(old, &next) {
+			return
+		}
+	}
 }
 
 type IAppConfig interface {
@@ -131,18 +325,38 @@ type IAppConfig interface {
 	BodyLimit() int
 	FileLimit() int
 	GCPBucket() string
+	IdempotencyTTL() time.Duration
+	TrustedProxies() []*net.IPNet
+	IdleTimeout() time.Duration
+	Concurrency() int
+	ReadBufferSize() int
+	TLSCertFile() string
+	TLSKeyFile() string
+	ShutdownTimeout() time.Duration
+	ShutdownDelay() time.Duration
+	Currency() string
 }
 
 type app struct {
-	host         string
-	port         int
-	name         string
-	version      string
-	readTimeout  time.Duration
-	writeTimeout time.Duration
-	bodyLimit    int
-	fileLimit    int
-	gcpbucket    string
+	dyn             *atomic.Pointer[dynamic]
+	host            string
+	port            int
+	name            string
+	version         string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	bodyLimit       int
+	gcpbucket       string
+	idempotencyTTL  time.Duration
+	trustedProxies  []*net.IPNet
+	idleTimeout     time.Duration
+	concurrency     int
+	readBufferSize  int
+	tlsCertFile     string
+	tlsKeyFile      string
+	shutdownTimeout time.Duration
+	shutdownDelay   time.Duration
+	currency        string
 }
 
 func (c *config) App() IAppConfig {
@@ -168,11 +382,85 @@ func (a *app) BodyLimit() int {
 	return a.bodyLimit
 }
 func (a *app) FileLimit() int {
-	return a.fileLimit
+	return a.dyn.Load().fileLimit
 }
 func (a *app) GCPBucket() string {
 	return a.gcpbucket
 }
+func (a *app) IdempotencyTTL() time.Duration {
+	return a.idempotencyTTL
+}
+func (a *app) TrustedProxies() []*net.IPNet {
+	return a.trustedProxies
+}
+func (a *app) IdleTimeout() time.Duration {
+	return a.idleTimeout
+}
+func (a *app) Concurrency() int {
+	return a.concurrency
+}
+func (a *app) ReadBufferSize() int {
+	return a.readBufferSize
+}
+func (a *app) TLSCertFile() string {
+	return a.tlsCertFile
+}
+func (a *app) TLSKeyFile() string {
+	return a.tlsKeyFile
+}
+func (a *app) ShutdownTimeout() time.Duration {
+	return a.shutdownTimeout
+}
+
+// ShutdownDelay is how long the server keeps serving while reporting not
+// ready, so load balancers can take it out before connections are closed.
+func (a *app) ShutdownDelay() time.Duration {
+	return a.shutdownDelay
+}
+
+// Currency is the ISO 4217 code products are priced in unless they say
+// otherwise, the base of price conversions.
+func (a *app) Currency() string {
+	return a.currency
+}
+
+// IAdminConfig is the internal listener for admin and metrics routes,
+// it is disabled when ADMIN_PORT is not set.
+type IAdminConfig interface {
+	Enabled() bool
+	Url() string
+	TLSCertFile() string
+	TLSKeyFile() string
+	ClientCAFile() string
+}
+
+type admin struct {
+	host         string
+	port         int
+	tlsCertFile  string
+	tlsKeyFile   string
+	clientCAFile string
+}
+
+func (c *config) Admin() IAdminConfig {
+	return c.admin
+}
+
+func (a *admin) Enabled() bool {
+	return a.port != 0
+}
+func (a *admin) Url() string {
+	return fmt.Sprintf("%s:%d", a.host, a.port)
+}
+func (a *admin) TLSCertFile() string {
+	return a.tlsCertFile
+}
+func (a *admin) TLSKeyFile() string {
+	return a.tlsKeyFile
+}
+func (a *admin) ClientCAFile() string {
+	return a.clientCAFile
+}
 
 type IDbConfig interface {
 	Url() string
@@ -220,21 +508,460 @@ type IJwtConfig interface {
 }
 
 type jwt struct {
-	adminKey         string
-	secretKey        string
-	apiKey           string
-	accessExpiresAt  int
-	refreshExpiresAt int
+	dyn       *atomic.Pointer[dynamic]
+	adminKey  string
+	secretKey string
+	apiKey    string
 }
 
 func (c *config) Jwt() IJwtConfig {
 	return c.jwt
 }
 
-func (j *jwt) SecretKey() []byte          { return []byte(j.secretKey) }
-func (j *jwt) AdminKey() []byte           { return []byte(j.adminKey) }
-func (j *jwt) ApiKey() []byte             { return []byte(j.apiKey) }
-func (j *jwt) AccessExpiresAt() int       { return j.accessExpiresAt }
-func (j *jwt) RefreshExpiresAt() int      { return j.refreshExpiresAt }
-func (j *jwt) SetJwtAccessExpires(t int)  { j.accessExpiresAt = t }
-func (j *jwt) SetJwtRefreshExpires(t int) { j.refreshExpiresAt = t }
+func (j *jwt) SecretKey() []byte     { return []byte(j.secretKey) }
+func (j *jwt) AdminKey() []byte      { return []byte(j.adminKey) }
+func (j *jwt) ApiKey() []byte        { return []byte(j.apiKey) }
+func (j *jwt) AccessExpiresAt() int  { return j.dyn.Load().accessExpiresAt }
+func (j *jwt) RefreshExpiresAt() int { return j.dyn.Load().refreshExpiresAt }
+func (j *jwt) SetJwtAccessExpires(t int) {
+	updateDynamic(j.dyn, func(d *dynamic) { d.accessExpiresAt = t })
+}
+func (j *jwt) SetJwtRefreshExpires(t int) {
+	updateDynamic(j.dyn, func(d *dynamic) { d.refreshExpiresAt = t })
+}
+
+type ICorsConfig interface {
+	AllowOrigins() string
+	AllowMethods() string
+	AllowHeaders() string
+	ExposeHeaders() string
+	AllowCredentials() bool
+	MaxAge() time.Duration
+}
+
+type cors struct {
+	dyn              *atomic.Pointer[dynamic]
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+func (c *config) Cors() ICorsConfig {
+	return c.cors
+}
+
+func (c *cors) AllowOrigins() string   { return c.dyn.Load().corsAllowOrigins }
+func (c *cors) AllowMethods() string   { return c.allowMethods }
+func (c *cors) AllowHeaders() string   { return c.allowHeaders }
+func (c *cors) ExposeHeaders() string  { return c.exposeHeaders }
+func (c *cors) AllowCredentials() bool { return c.allowCredentials }
+func (c *cors) MaxAge() time.Duration  { return c.maxAge }
+
+type ISecurityConfig interface {
+	HSTSMaxAge() time.Duration
+	FrameOptions() string
+	ContentSecurityPolicy() string
+}
+
+type security struct {
+	hstsMaxAge            time.Duration
+	frameOptions          string
+	contentSecurityPolicy string
+}
+
+func (c *config) Security() ISecurityConfig {
+	return c.security
+}
+
+func (s *security) HSTSMaxAge() time.Duration     { return s.hstsMaxAge }
+func (s *security) FrameOptions() string          { return s.frameOptions }
+func (s *security) ContentSecurityPolicy() string { return s.contentSecurityPolicy }
+
+type IImageConfig interface {
+	Renditions() []*ImageRendition
+	Quality() int
+	WebP() bool
+	WebPEncoder() string
+	Workers() int
+	MaxDimension() int
+	MaxPixels() int
+}
+
+// ImageRendition is a resized copy of an uploaded image, fitted into a
+// Width x Width box.
+type ImageRendition struct {
+	Name  string
+	Width int
+}
+
+type imageConfig struct {
+	renditions   []*ImageRendition
+	quality      int
+	webp         bool
+	webpEncoder  string
+	workers      int
+	maxDimension int
+	maxPixels    int
+}
+
+func (c *config) Image() IImageConfig {
+	return c.image
+}
+
+func (i *imageConfig) Renditions() []*ImageRendition { return i.renditions }
+func (i *imageConfig) Quality() int                  { return i.quality }
+func (i *imageConfig) WebP() bool                    { return i.webp }
+func (i *imageConfig) WebPEncoder() string           { return i.webpEncoder }
+func (i *imageConfig) Workers() int                  { return i.workers }
+func (i *imageConfig) MaxDimension() int             { return i.maxDimension }
+func (i *imageConfig) MaxPixels() int                { return i.maxPixels }
+
+// IMAGE_RENDITIONS=<name>:<width>[,<name>:<width>...], empty turns
+// renditions off.
+func parseImageRenditions(value string) ([]*ImageRendition, error) {
+	renditions := make([]*ImageRendition, 0)
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, width, ok := strings.Cut(item, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expect <name>:<width> but got %q", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rendition %s is duplicated", name)
+		}
+		seen[name] = true
+
+		w, err := strconv.Atoi(strings.TrimSpace(width))
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("width of %s must be a positive number", name)
+		}
+		renditions = append(renditions, &ImageRendition{
+			Name:  name,
+			Width: w,
+		})
+	}
+	return renditions, nil
+}
+
+type IStorageConfig interface {
+	Driver() string // gcs or local
+	LocalDir() string
+	LocalUrl() string
+	SigningKey() string
+	Private() bool // Default visibility of uploads
+	SignedUrlTTL() time.Duration
+	UploadWorkers() int // Objects stored at the same time per request
+	DeleteWorkers() int
+	StagingDir() string // Chunks of resumable uploads before completion
+	UploadMaxSize() int // Bytes of one resumable upload
+	UploadTTL() time.Duration
+	GcInterval() time.Duration // Zero turns the periodic garbage collector off
+	GcGrace() time.Duration
+}
+
+type storageConfig struct {
+	driver        string
+	localDir      string
+	localUrl      string
+	signingKey    string
+	visibility    string
+	signedUrlTTL  time.Duration
+	uploadWorkers int
+	deleteWorkers int
+	stagingDir    string
+	uploadMaxSize int
+	uploadTTL     time.Duration
+	gcInterval    time.Duration
+	gcGrace       time.Duration
+}
+
+func (c *config) Storage() IStorageConfig {
+	return c.storage
+}
+
+func (s *storageConfig) Driver() string              { return s.driver }
+func (s *storageConfig) LocalDir() string            { return s.localDir }
+func (s *storageConfig) LocalUrl() string            { return s.localUrl }
+func (s *storageConfig) SigningKey() string          { return s.signingKey }
+func (s *storageConfig) Private() bool               { return s.visibility == "private" }
+func (s *storageConfig) SignedUrlTTL() time.Duration { return s.signedUrlTTL }
+func (s *storageConfig) UploadWorkers() int          { return s.uploadWorkers }
+func (s *storageConfig) DeleteWorkers() int          { return s.deleteWorkers }
+func (s *storageConfig) StagingDir() string          { return s.stagingDir }
+func (s *storageConfig) UploadMaxSize() int          { return s.uploadMaxSize }
+func (s *storageConfig) UploadTTL() time.Duration    { return s.uploadTTL }
+func (s *storageConfig) GcInterval() time.Duration   { return s.gcInterval }
+func (s *storageConfig) GcGrace() time.Duration      { return s.gcGrace }
+
+type IPaymentConfig interface {
+	Provider() string // mock or omise
+	// Simulate mounts the route that plays the payer of the mock provider,
+	// for development only
+	Simulate() bool
+	// WebhookSecret signs the webhooks of the mock provider, random when
+	// empty
+	WebhookSecret() string
+	// WebhookTolerance is how old a signed webhook may be
+	WebhookTolerance() time.Duration
+	OmiseApiUrl() string
+	OmiseSecretKey() string
+	OmiseWebhookSecret() string
+}
+
+type paymentConfig struct {
+	provider           string
+	simulate           bool
+	webhookSecret      string
+	webhookTolerance   time.Duration
+	omiseApiUrl        string
+	omiseSecretKey     string
+	omiseWebhookSecret string
+}
+
+func (c *config) Payment() IPaymentConfig {
+	return c.payment
+}
+
+func (p *paymentConfig) Provider() string                { return p.provider }
+func (p *paymentConfig) Simulate() bool                  { return p.simulate }
+func (p *paymentConfig) WebhookSecret() string           { return p.webhookSecret }
+func (p *paymentConfig) WebhookTolerance() time.Duration { return p.webhookTolerance }
+func (p *paymentConfig) OmiseApiUrl() string             { return p.omiseApiUrl }
+func (p *paymentConfig) OmiseSecretKey() string          { return p.omiseSecretKey }
+func (p *paymentConfig) OmiseWebhookSecret() string      { return p.omiseWebhookSecret }
+
+type IScanConfig interface {
+	ClamdAddr() string // Empty when uploads are not scanned
+	Timeout() time.Duration
+}
+
+type scan struct {
+	clamdAddr string
+	timeout   time.Duration
+}
+
+func (c *config) Scan() IScanConfig {
+	return c.scan
+}
+
+func (s *scan) ClamdAddr() string      { return s.clamdAddr }
+func (s *scan) Timeout() time.Duration { return s.timeout }
+
+type IRateLimitConfig interface {
+	Policy(name string) *RateLimitPolicy
+}
+
+// RateLimitPolicy is a token bucket holding up to Burst tokens, refilled
+// with Limit tokens every Period. KeyBy is one of "ip", "user" or "apikey".
+type RateLimitPolicy struct {
+	Name   string
+	KeyBy  string
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+type rateLimit struct {
+	policies map[string]*RateLimitPolicy
+}
+
+func (c *config) RateLimit() IRateLimitConfig {
+	return c.rateLimit
+}
+
+func (r *rateLimit) Policy(name string) *RateLimitPolicy {
+	return r.policies[name]
+}
+
+// RATE_LIMIT_<NAME>=<key_by>,<limit>,<period>[,<burst>]
+// e.g. RATE_LIMIT_AUTH=ip,10,1m,20
+func parseRateLimitPolicy(name, value string) (*RateLimitPolicy, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 && len(fields) != 4 {
+		return nil, fmt.Errorf("expect <key_by>,<limit>,<period>[,<burst>] but got %q", value)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	p := &RateLimitPolicy{
+		Name:  name,
+		KeyBy: strings.ToLower(fields[0]),
+	}
+
+	switch p.KeyBy {
+	case "ip", "user", "apikey":
+	default:
+		return nil, fmt.Errorf("key_by must be ip, user or apikey")
+	}
+
+	limit, err := strconv.Atoi(fields[1])
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("limit must be a positive number")
+	}
+	p.Limit = limit
+
+	period, err := time.ParseDuration(fields[2])
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("period must be a positive duration")
+	}
+	p.Period = period
+
+	p.Burst = limit
+	if len(fields) == 4 {
+		burst, err := strconv.Atoi(fields[3])
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("burst must be a positive number")
+		}
+		p.Burst = burst
+	}
+
+	return p, nil
+}
+
+type entry struct {
+	key        string
+	value      string
+	secret     bool
+	reloadable bool
+}
+
+func (c *config) entries() []*entry {
+	entries := []*entry{
+		{key: "APP_HOST", value: c.app.host},
+		{key: "APP_PORT", value: strconv.Itoa(c.app.port)},
+		{key: "APP_NAME", value: c.app.name},
+		{key: "APP_VERSION", value: c.app.version},
+		{key: "APP_READ_TIMEOUT", value: c.app.readTimeout.String()},
+		{key: "APP_WRITE_TIMEOUT", value: c.app.writeTimeout.String()},
+		{key: "APP_BODY_LIMIT", value: strconv.Itoa(c.app.bodyLimit)},
+		{key: "APP_FILE_LIMIT", value: strconv.Itoa(c.app.FileLimit()), reloadable: true},
+		{key: "APP_GCP_BUCKET", value: c.app.gcpbucket},
+		{key: "APP_IDEMPOTENCY_TTL", value: c.app.idempotencyTTL.String()},
+		{key: "APP_TRUSTED_PROXIES", value: func() string {
+			proxies := make([]string, 0, len(c.app.trustedProxies))
+			for _, p := range c.app.trustedProxies {
+				proxies = append(proxies, p.String())
+			}
+			return strings.Join(proxies, ",")
+		}()},
+		{key: "APP_IDLE_TIMEOUT", value: c.app.idleTimeout.String()},
+		{key: "APP_CONCURRENCY", value: strconv.Itoa(c.app.concurrency)},
+		{key: "APP_READ_BUFFER_SIZE", value: strconv.Itoa(c.app.readBufferSize)},
+		{key: "APP_TLS_CERT_FILE", value: c.app.tlsCertFile},
+		{key: "APP_TLS_KEY_FILE", value: c.app.tlsKeyFile},
+		{key: "APP_SHUTDOWN_TIMEOUT", value: c.app.shutdownTimeout.String()},
+		{key: "APP_SHUTDOWN_DELAY", value: c.app.shutdownDelay.String()},
+		{key: "APP_CURRENCY", value: c.app.currency},
+		{key: "ADMIN_HOST", value: c.admin.host},
+		{key: "ADMIN_PORT", value: strconv.Itoa(c.admin.port)},
+		{key: "ADMIN_TLS_CERT_FILE", value: c.admin.tlsCertFile},
+		{key: "ADMIN_TLS_KEY_FILE", value: c.admin.tlsKeyFile},
+		{key: "ADMIN_TLS_CLIENT_CA_FILE", value: c.admin.clientCAFile},
+		{key: "DB_HOST", value: c.db.host},
+		{key: "DB_PORT", value: strconv.Itoa(c.db.port)},
+		{key: "DB_PROTOCOL", value: c.db.protocol},
+		{key: "DB_USERNAME", value: c.db.username},
+		{key: "DB_PASSWORD", value: c.db.password, secret: true},
+		{key: "DB_DATABASE", value: c.db.database},
+		{key: "DB_SSL_MODE", value: c.db.sslMode},
+		{key: "DB_MAX_CONNECTIONS", value: strconv.Itoa(c.db.maxConnections)},
+		{key: "JWT_ADMIN_KEY", value: c.jwt.adminKey, secret: true},
+		{key: "JWT_SECRET_KEY", value: c.jwt.secretKey, secret: true},
+		{key: "JWT_API_KEY", value: c.jwt.apiKey, secret: true},
+		{key: "JWT_ACCESS_EXPIRES", value: strconv.Itoa(c.jwt.AccessExpiresAt()), reloadable: true},
+		{key: "JWT_REFRESH_EXPIRES", value: strconv.Itoa(c.jwt.RefreshExpiresAt()), reloadable: true},
+		{key: "CORS_ALLOW_ORIGINS", value: c.cors.AllowOrigins(), reloadable: true},
+		{key: "CORS_ALLOW_METHODS", value: c.cors.allowMethods},
+		{key: "CORS_ALLOW_HEADERS", value: c.cors.allowHeaders},
+		{key: "CORS_EXPOSE_HEADERS", value: c.cors.exposeHeaders},
+		{key: "CORS_ALLOW_CREDENTIALS", value: strconv.FormatBool(c.cors.allowCredentials)},
+		{key: "CORS_MAX_AGE", value: c.cors.maxAge.String()},
+		{key: "SECURITY_HSTS_MAX_AGE", value: c.security.hstsMaxAge.String()},
+		{key: "SECURITY_FRAME_OPTIONS", value: c.security.frameOptions},
+		{key: "SECURITY_CSP", value: c.security.contentSecurityPolicy},
+		{key: "IMAGE_RENDITIONS", value: func() string {
+			renditions := make([]string, 0, len(c.image.renditions))
+			for _, r := range c.image.renditions {
+				renditions = append(renditions, fmt.Sprintf("%s:%d", r.Name, r.Width))
+			}
+			return strings.Join(renditions, ",")
+		}()},
+		{key: "IMAGE_QUALITY", value: strconv.Itoa(c.image.quality)},
+		{key: "IMAGE_WEBP", value: strconv.FormatBool(c.image.webp)},
+		{key: "IMAGE_WEBP_ENCODER", value: c.image.webpEncoder},
+		{key: "IMAGE_WORKERS", value: strconv.Itoa(c.image.workers)},
+		{key: "IMAGE_MAX_DIMENSION", value: strconv.Itoa(c.image.maxDimension)},
+		{key: "IMAGE_MAX_PIXELS", value: strconv.Itoa(c.image.maxPixels)},
+		{key: "STORAGE_DRIVER", value: c.storage.driver},
+		{key: "STORAGE_LOCAL_DIR", value: c.storage.localDir},
+		{key: "STORAGE_LOCAL_URL", value: c.storage.localUrl},
+		{key: "STORAGE_SIGNING_KEY", value: c.storage.signingKey, secret: true},
+		{key: "STORAGE_VISIBILITY", value: c.storage.visibility},
+		{key: "STORAGE_SIGNED_URL_TTL", value: c.storage.signedUrlTTL.String()},
+		{key: "STORAGE_UPLOAD_WORKERS", value: strconv.Itoa(c.storage.uploadWorkers)},
+		{key: "STORAGE_DELETE_WORKERS", value: strconv.Itoa(c.storage.deleteWorkers)},
+		{key: "STORAGE_STAGING_DIR", value: c.storage.stagingDir},
+		{key: "STORAGE_UPLOAD_MAX_SIZE", value: strconv.Itoa(c.storage.uploadMaxSize)},
+		{key: "STORAGE_UPLOAD_SESSION_TTL", value: c.storage.uploadTTL.String()},
+		{key: "STORAGE_GC_INTERVAL", value: c.storage.gcInterval.String()},
+		{key: "STORAGE_GC_GRACE", value: c.storage.gcGrace.String()},
+		{key: "PAYMENT_PROVIDER", value: c.payment.provider},
+		{key: "PAYMENT_SIMULATE", value: strconv.FormatBool(c.payment.simulate)},
+		{key: "PAYMENT_WEBHOOK_SECRET", value: c.payment.webhookSecret, secret: true},
+		{key: "PAYMENT_WEBHOOK_TOLERANCE", value: c.payment.webhookTolerance.String()},
+		{key: "PAYMENT_OMISE_API_URL", value: c.payment.omiseApiUrl},
+		{key: "PAYMENT_OMISE_SECRET_KEY", value: c.payment.omiseSecretKey, secret: true},
+		{key: "PAYMENT_OMISE_WEBHOOK_SECRET", value: c.payment.omiseWebhookSecret, secret: true},
+		{key: "SCAN_CLAMD_ADDR", value: c.scan.clamdAddr},
+		{key: "SCAN_TIMEOUT", value: c.scan.timeout.String()},
+	}
+
+	names := make([]string, 0, len(c.rateLimit.policies))
+	for name := range c.rateLimit.policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := c.rateLimit.policies[name]
+		entries = append(entries, &entry{
+			key:   "RATE_LIMIT_" + strings.ToUpper(name),
+			value: fmt.Sprintf("%s,%d,%s,%d", p.KeyBy, p.Limit, p.Period, p.Burst),
+		})
+	}
+	return entries
+}
+
+// Check loads the config at path and prints the effective values with
+// secrets masked.
+func Check(path string, w io.Writer) error {
+	cfg, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range cfg.entries() {
+		value := e.value
+		if e.secret {
+			value = mask(value)
+		}
+		fmt.Fprintf(w, "%s=%s\n", e.key, value)
+	}
+	return nil
+}
+
+// mask hides the secret and its length, it only tells whether it is set.
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "********"
+}
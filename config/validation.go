@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/utils"
+)
+
+const minSecretLength = 32
+
+// FieldError is a problem with one configuration key.
+type FieldError struct {
+	Key string
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError holds every problem found while loading the config.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, "  - "+err.Error())
+	}
+	return fmt.Sprintf("config is invalid (%d problems):\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+var (
+	ErrMissing      = errors.New("is required")
+	ErrTooShort     = errors.New("is too short")
+	ErrInvalidValue = errors.New("is invalid")
+	ErrOutOfRange   = errors.New("is out of range")
+)
+
+// envReader converts env values and collects every failure instead of
+// stopping at the first one.
+type envReader struct {
+	env  map[string]string
+	errs []*FieldError
+}
+
+func (r *envReader) fail(key string, err error) {
+	r.errs = append(r.errs, &FieldError{Key: key, Err: err})
+}
+
+func (r *envReader) err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: r.errs}
+}
+
+func (r *envReader) str(key string) string {
+	return strings.TrimSpace(r.env[key])
+}
+
+func (r *envReader) strOr(key, def string) string {
+	if v := r.str(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (r *envReader) required(key string) string {
+	v := r.str(key)
+	if v == "" {
+		r.fail(key, ErrMissing)
+	}
+	return v
+}
+
+func (r *envReader) secret(key string, minLength int) string {
+	v := r.required(key)
+	if v != "" && len(v) < minLength {
+		r.fail(key, fmt.Errorf("%w, need at least %d characters", ErrTooShort, minLength))
+	}
+	return v
+}
+
+func (r *envReader) int(key string) int {
+	v := r.required(key)
+	if v == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		r.fail(key, fmt.Errorf("%w, %q is not a number", ErrInvalidValue, v))
+	}
+	return i
+}
+
+func (r *envReader) positiveInt(key string) int {
+	v := r.int(key)
+	if r.str(key) != "" && v <= 0 {
+		r.fail(key, fmt.Errorf("%w, must be more than 0", ErrOutOfRange))
+	}
+	return v
+}
+
+func (r *envReader) port(key string) int {
+	v := r.int(key)
+	if r.str(key) != "" && (v < 1 || v > 65535) {
+		r.fail(key, fmt.Errorf("%w, must be between 1 and 65535", ErrOutOfRange))
+	}
+	return v
+}
+
+func (r *envReader) positiveIntOr(key string, def int) int {
+	if r.str(key) == "" {
+		return def
+	}
+	return r.positiveInt(key)
+}
+
+func (r *envReader) portOr(key string, def int) int {
+	if r.str(key) == "" {
+		return def
+	}
+	return r.port(key)
+}
+
+// file is an optional path that must exist when it is set.
+func (r *envReader) file(key string) string {
+	v := r.str(key)
+	if v == "" {
+		return v
+	}
+
+	if _, err := os.Stat(v); err != nil {
+		r.fail(key, fmt.Errorf("%w, %v", ErrInvalidValue, err))
+	}
+	return v
+}
+
+// pair fails when only one of the two keys is set.
+func (r *envReader) pair(a, b string) {
+	if (r.str(a) == "") != (r.str(b) == "") {
+		r.fail(a, fmt.Errorf("%w, %s and %s must be set together", ErrInvalidValue, a, b))
+	}
+}
+
+// duration accepts a duration string like 30s, a bare number is seconds.
+func (r *envReader) duration(key string) time.Duration {
+	v := r.required(key)
+	if v == "" {
+		return 0
+	}
+	return r.parseDuration(key, v, false)
+}
+
+func (r *envReader) durationOr(key string, def time.Duration) time.Duration {
+	v := r.str(key)
+	if v == "" {
+		return def
+	}
+	return r.parseDuration(key, v, false)
+}
+
+// offDurationOr is durationOr for the durations where 0 turns a feature off.
+func (r *envReader) offDurationOr(key string, def time.Duration) time.Duration {
+	v := r.str(key)
+	if v == "" {
+		return def
+	}
+	return r.parseDuration(key, v, true)
+}
+
+func (r *envReader) parseDuration(key, v string, zero bool) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		s, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			r.fail(key, fmt.Errorf("%w, %q is not a duration", ErrInvalidValue, v))
+			return 0
+		}
+		d = time.Duration(s) * time.Second
+	}
+
+	switch {
+	case zero && d < 0:
+		r.fail(key, fmt.Errorf("%w, must be 0 or more", ErrOutOfRange))
+	case !zero && d <= 0:
+		r.fail(key, fmt.Errorf("%w, must be more than 0", ErrOutOfRange))
+	}
+	return d
+}
+
+func (r *envReader) boolOr(key string, def bool) bool {
+	v := r.str(key)
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.fail(key, fmt.Errorf("%w, %q is not true or false", ErrInvalidValue, v))
+	}
+	return b
+}
+
+// networks reads a comma separated list of ips and cidrs.
+func (r *envReader) networks(key string) []*net.IPNet {
+	v := r.str(key)
+	if v == "" {
+		return nil
+	}
+
+	values := strings.Split(v, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+
+	networks, err := utils.ParseNetworks(values)
+	if err != nil {
+		r.fail(key, fmt.Errorf("%w, %v", ErrInvalidValue, err))
+	}
+	return networks
+}
+
+func (r *envReader) oneOfOr(key, def string, values ...string) string {
+	if r.str(key) == "" {
+		return def
+	}
+	return r.oneOf(key, values...)
+}
+
+func (r *envReader) oneOf(key string, values ...string) string {
+	v := r.required(key)
+	if v == "" {
+		return v
+	}
+
+	for _, allowed := range values {
+		if v == allowed {
+			return v
+		}
+	}
+	r.fail(key, fmt.Errorf("%w, %q must be one of %s", ErrInvalidValue, v, strings.Join(values, ", ")))
+	return v
+}
+
+// currencyOr reads an ISO 4217 code kawaiimoney supports.
+func (r *envReader) currencyOr(key, def string) string {
+	v := strings.ToUpper(r.strOr(key, def))
+	if _, err := kawaiimoney.Lookup(v); err != nil {
+		r.fail(key, fmt.Errorf("%w, %v", ErrInvalidValue, err))
+	}
+	return v
+}
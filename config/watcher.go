@@ -0,0 +1,80 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Reload reads the config file again and applies the reloadable settings
+// all at once. Other settings need a restart, their changes are logged and
+// ignored.
+func (c *config) Reload() error {
+	next, err := load(c.path)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]*entry)
+	for _, e := range c.entries() {
+		current[e.key] = e
+	}
+
+	for _, e := range next.entries() {
+		old, ok := current[e.key]
+		if ok && old.value == e.value {
+			continue
+		}
+		if !e.reloadable {
+			log.Printf("Config %s has changed but cannot be reloaded, restart the server to apply it", e.key)
+			continue
+		}
+		log.Printf("Config %s reloaded", e.key)
+	}
+
+	c.dynamic.Store(next.dynamic.Load())
+	return nil
+}
+
+// Watch reloads cfg on SIGHUP or when the file at path changes, until ctx
+// is done.
+func Watch(ctx context.Context, path string, cfg IConfig) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	lastMod := modTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP received, reloading config")
+		case <-ticker.C:
+			mod := modTime(path)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			log.Printf("Config file %s has changed, reloading config", path)
+		}
+
+		if err := cfg.Reload(); err != nil {
+			log.Printf("Reload config failed, keep the current config: %v", err)
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -1,26 +1,113 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/k0msak007/kawaii-shop/config"
 	"github.com/k0msak007/kawaii-shop/modules/servers"
 	"github.com/k0msak007/kawaii-shop/pkg/databases"
 )
 
-func envPath() string {
-	if len(os.Args) == 1 {
+func envPath(args []string) string {
+	if len(args) == 0 {
 		return ".env"
 	} else {
-		return os.Args[1]
+		return args[0]
 	}
 }
 
 func main() {
-	cfg := config.LoadConfig(envPath())
+	// kawaii-shop config check [.env]
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "check" {
+		if err := config.Check(envPath(os.Args[3:]), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// kawaii-shop openapi <output.json> [.env]
+	if len(os.Args) > 2 && os.Args[1] == "openapi" {
+		if err := writeOpenApi(os.Args[2], envPath(os.Args[3:])); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// kawaii-shop files gc [.env]
+	if len(os.Args) > 2 && os.Args[1] == "files" && os.Args[2] == "gc" {
+		if err := collectGarbage(envPath(os.Args[3:])); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	path := envPath(os.Args[1:])
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		log.Fatalf("Load config failed: %v", err)
+	}
+
+	db := databases.DbConnect(cfg.Db())
+	if err := databases.CheckCurrencies(db); err != nil {
+		log.Fatalf("Check currencies failed: %v", err)
+	}
+
+	srv := servers.NewServer(cfg, db)
+	// Hooks run in reverse order, the database is closed after the files
+	// workers and gc of Start and before the storage of NewServer
+	srv.OnShutdown("database", func(ctx context.Context) error {
+		return db.Close()
+	})
+
+	ctx, stopWatch := context.WithCancel(context.Background())
+	go config.Watch(ctx, path, cfg)
+	srv.OnShutdown("config watcher", func(ctx context.Context) error {
+		stopWatch()
+		return nil
+	})
+
+	srv.Start()
+}
+
+func writeOpenApi(output, path string) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Routes are only registered, nothing touches the database
+	return servers.NewServer(cfg, nil).WriteOpenApi(file)
+}
+
+func collectGarbage(path string) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
 
 	db := databases.DbConnect(cfg.Db())
-	defer db.Close() // defer จะทำงานท้ายสุดก่อน return
+	defer db.Close()
+
+	srv := servers.NewServer(cfg, db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	servers.NewServer(cfg, db).Start()
+	removed, err := srv.CollectGarbage(ctx)
+	fmt.Printf("%d files removed\n", removed)
+	return err
 }
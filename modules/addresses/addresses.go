@@ -0,0 +1,69 @@
+package addresses
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrAddressInvalid  = errors.New("address is invalid")
+)
+
+// Address is a shipping address in Thailand. A user with addresses has
+// exactly one default.
+type Address struct {
+	Id          string `db:"id" json:"id"`
+	UserId      string `db:"user_id" json:"user_id"`
+	Label       string `db:"label" json:"label"`
+	Recipient   string `db:"recipient" json:"recipient"`
+	Phone       string `db:"phone" json:"phone"`
+	Line1       string `db:"line1" json:"line1"`
+	Line2       string `db:"line2" json:"line2"`
+	Subdistrict string `db:"subdistrict" json:"subdistrict"`
+	District    string `db:"district" json:"district"`
+	Province    string `db:"province" json:"province"`
+	PostalCode  string `db:"postal_code" json:"postal_code"`
+	IsDefault   bool   `db:"is_default" json:"is_default"`
+	CreatedAt   string `db:"created_at" json:"created_at"`
+	UpdatedAt   string `db:"updated_at" json:"updated_at"`
+}
+
+// AddressReq creates an address or replaces one. The first address of a
+// user is the default, IsDefault makes another one the default.
+type AddressReq struct {
+	// e.g. Home or Office
+	Label       string `json:"label" validate:"max=50"`
+	Recipient   string `json:"recipient" validate:"required,max=255"`
+	Phone       string `json:"phone" validate:"required,max=20"`
+	Line1       string `json:"line1" validate:"required,max=255"`
+	Line2       string `json:"line2" validate:"max=255"`
+	Subdistrict string `json:"subdistrict" validate:"required,max=100"`
+	District    string `json:"district" validate:"required,max=100"`
+	Province    string `json:"province" validate:"required,max=100"`
+	PostalCode  string `json:"postal_code" validate:"required,thpostcode"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+var phoneRe = regexp.MustCompile(`^0[0-9]{8,9}$`)
+
+// NormalizePhone keeps the digits of a Thai phone number, +66 is written
+// as its leading 0
+func NormalizePhone(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '(' || r == ')' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+	if strings.HasPrefix(digits, "+66") {
+		digits = "0" + strings.TrimPrefix(digits, "+66")
+	}
+
+	if !phoneRe.MatchString(digits) {
+		return "", fmt.Errorf("%w, phone must be a Thai number like 0812345678", ErrAddressInvalid)
+	}
+	return digits, nil
+}
@@ -0,0 +1,184 @@
+package addressesHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/addresses"
+	"github.com/k0msak007/kawaii-shop/modules/addresses/addressesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type addressesHandlersErrCode string
+
+const (
+	findAddressesErr  addressesHandlersErrCode = "addresses-001"
+	findOneAddressErr addressesHandlersErrCode = "addresses-002"
+	insertAddressErr  addressesHandlersErrCode = "addresses-003"
+	updateAddressErr  addressesHandlersErrCode = "addresses-004"
+	setDefaultErr     addressesHandlersErrCode = "addresses-005"
+	deleteAddressErr  addressesHandlersErrCode = "addresses-006"
+)
+
+type IAddressesHandler interface {
+	FindAddresses(c *fiber.Ctx) error
+	FindOneAddress(c *fiber.Ctx) error
+	InsertAddress(c *fiber.Ctx) error
+	UpdateAddress(c *fiber.Ctx) error
+	SetDefault(c *fiber.Ctx) error
+	DeleteAddress(c *fiber.Ctx) error
+}
+
+type addressesHandler struct {
+	cfg              config.IConfig
+	addressesUsecase addressesUsecases.IAddressesUsecase
+}
+
+func AddressesHandler(cfg config.IConfig, addressesUsecase addressesUsecases.IAddressesUsecase) IAddressesHandler {
+	return &addressesHandler{
+		cfg:              cfg,
+		addressesUsecase: addressesUsecase,
+	}
+}
+
+func addressesStatus(err error) int {
+	switch {
+	case errors.Is(err, addresses.ErrAddressNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, addresses.ErrAddressInvalid):
+		return fiber.ErrBadRequest.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// addressId is the address_id param, anything but a uuid cannot be an
+// address
+func addressId(c *fiber.Ctx) (string, error) {
+	id := c.Params("address_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", addresses.ErrAddressNotFound
+	}
+	return id, nil
+}
+
+// FindAddresses lists the address book of the user_id param, the default
+// first. ParamsCheck makes it the signed in user.
+func (h *addressesHandler) FindAddresses(c *fiber.Ctx) error {
+	res, err := h.addressesUsecase.FindAddresses(c.Params("user_id"))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(findAddressesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *addressesHandler) FindOneAddress(c *fiber.Ctx) error {
+	id, err := addressId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findOneAddressErr),
+			err.Error(),
+		).Res()
+	}
+
+	address, err := h.addressesUsecase.FindOneAddress(c.Params("user_id"), id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(findOneAddressErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, address).Res()
+}
+
+func (h *addressesHandler) InsertAddress(c *fiber.Ctx) error {
+	req := new(addresses.AddressReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(insertAddressErr), err).Res()
+	}
+
+	address, err := h.addressesUsecase.InsertAddress(c.Params("user_id"), req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(insertAddressErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, address).Res()
+}
+
+func (h *addressesHandler) UpdateAddress(c *fiber.Ctx) error {
+	id, err := addressId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updateAddressErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(addresses.AddressReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateAddressErr), err).Res()
+	}
+
+	address, err := h.addressesUsecase.UpdateAddress(c.Params("user_id"), id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(updateAddressErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, address).Res()
+}
+
+func (h *addressesHandler) SetDefault(c *fiber.Ctx) error {
+	id, err := addressId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(setDefaultErr),
+			err.Error(),
+		).Res()
+	}
+
+	address, err := h.addressesUsecase.SetDefault(c.Params("user_id"), id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(setDefaultErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, address).Res()
+}
+
+func (h *addressesHandler) DeleteAddress(c *fiber.Ctx) error {
+	id, err := addressId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deleteAddressErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.addressesUsecase.DeleteAddress(c.Params("user_id"), id); err != nil {
+		return entities.NewResponse(c).Error(
+			addressesStatus(err),
+			string(deleteAddressErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
@@ -0,0 +1,305 @@
+package addressesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/addresses"
+)
+
+type IAddressesRepository interface {
+	FindAddresses(userId string) ([]*addresses.Address, error)
+	FindOneAddress(userId, addressId string) (*addresses.Address, error)
+	InsertAddress(userId string, req *addresses.AddressReq) (string, error)
+	UpdateAddress(userId, addressId string, req *addresses.AddressReq) error
+	SetDefault(userId, addressId string) error
+	DeleteAddress(userId, addressId string) error
+}
+
+type addressesRepository struct {
+	db *sqlx.DB
+}
+
+func AddressesRepository(db *sqlx.DB) IAddressesRepository {
+	return &addressesRepository{
+		db: db,
+	}
+}
+
+const selectAddress = `
+	SELECT
+		"id",
+		"user_id",
+		"label",
+		"recipient",
+		"phone",
+		"line1",
+		"line2",
+		"subdistrict",
+		"district",
+		"province",
+		"postal_code",
+		"is_default",
+		"created_at",
+		"updated_at"
+	FROM "addresses"`
+
+func (r *addressesRepository) FindAddresses(userId string) ([]*addresses.Address, error) {
+	res := make([]*addresses.Address, 0)
+	query := selectAddress + `
+	WHERE "user_id" = $1
+	ORDER BY "is_default" DESC, "created_at";`
+
+	if err := r.db.Select(&res, query, userId); err != nil {
+		return nil, fmt.Errorf("get addresses failed: %v", err)
+	}
+	return res, nil
+}
+
+func (r *addressesRepository) FindOneAddress(userId, addressId string) (*addresses.Address, error) {
+	address := new(addresses.Address)
+	query := selectAddress + `
+	WHERE "user_id" = $1
+	AND "id" = $2;`
+
+	if err := r.db.Get(address, query, userId, addressId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, addresses.ErrAddressNotFound
+		}
+		return nil, fmt.Errorf("get address failed: %v", err)
+	}
+	return address, nil
+}
+
+// lockUser serializes the changes to the address book of a user, so two
+// requests cannot both leave a default or none
+func lockUser(ctx context.Context, tx *sqlx.Tx, userId string) error {
+	var id string
+	if err := tx.GetContext(ctx, &id, `SELECT "id" FROM "users" WHERE "id" = $1 FOR UPDATE;`, userId); err != nil {
+		return fmt.Errorf("lock user failed: %v", err)
+	}
+	return nil
+}
+
+// unsetDefault clears the default of the user so another address can take
+// it
+func unsetDefault(ctx context.Context, tx *sqlx.Tx, userId string) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE "addresses" SET "is_default" = FALSE WHERE "user_id" = $1 AND "is_default";`, userId); err != nil {
+		return fmt.Errorf("unset default address failed: %v", err)
+	}
+	return nil
+}
+
+// InsertAddress adds an address, the default when asked or when it is the
+// first one
+func (r *addressesRepository) InsertAddress(userId string, req *addresses.AddressReq) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userId); err != nil {
+		return "", err
+	}
+
+	isDefault := req.IsDefault
+	if !isDefault {
+		var count int
+		if err := tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM "addresses" WHERE "user_id" = $1;`, userId); err != nil {
+			return "", fmt.Errorf("count addresses failed: %v", err)
+		}
+		isDefault = count == 0
+	} else if err := unsetDefault(ctx, tx, userId); err != nil {
+		return "", err
+	}
+
+	query := `
+	INSERT INTO "addresses" (
+		"user_id",
+		"label",
+		"recipient",
+		"phone",
+		"line1",
+		"line2",
+		"subdistrict",
+		"district",
+		"province",
+		"postal_code",
+		"is_default"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING "id";`
+
+	var id string
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		userId,
+		req.Label,
+		req.Recipient,
+		req.Phone,
+		req.Line1,
+		req.Line2,
+		req.Subdistrict,
+		req.District,
+		req.Province,
+		req.PostalCode,
+		isDefault,
+	).Scan(&id); err != nil {
+		return "", fmt.Errorf("insert address failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit address failed: %v", err)
+	}
+	return id, nil
+}
+
+// UpdateAddress replaces an address. The default stays the default, only
+// making another address the default moves it.
+func (r *addressesRepository) UpdateAddress(userId, addressId string, req *addresses.AddressReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userId); err != nil {
+		return err
+	}
+	if req.IsDefault {
+		if err := unsetDefault(ctx, tx, userId); err != nil {
+			return err
+		}
+	}
+
+	query := `
+	UPDATE "addresses" SET
+		"label" = $3,
+		"recipient" = $4,
+		"phone" = $5,
+		"line1" = $6,
+		"line2" = $7,
+		"subdistrict" = $8,
+		"district" = $9,
+		"province" = $10,
+		"postal_code" = $11,
+		"is_default" = "is_default" OR $12
+	WHERE "user_id" = $1
+	AND "id" = $2;`
+
+	res, err := tx.ExecContext(
+		ctx,
+		query,
+		userId,
+		addressId,
+		req.Label,
+		req.Recipient,
+		req.Phone,
+		req.Line1,
+		req.Line2,
+		req.Subdistrict,
+		req.District,
+		req.Province,
+		req.PostalCode,
+		req.IsDefault,
+	)
+	if err != nil {
+		return fmt.Errorf("update address failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return addresses.ErrAddressNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit address failed: %v", err)
+	}
+	return nil
+}
+
+func (r *addressesRepository) SetDefault(userId, addressId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userId); err != nil {
+		return err
+	}
+	if err := unsetDefault(ctx, tx, userId); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE "addresses" SET "is_default" = TRUE WHERE "user_id" = $1 AND "id" = $2;`, userId, addressId)
+	if err != nil {
+		return fmt.Errorf("set default address failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return addresses.ErrAddressNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit address failed: %v", err)
+	}
+	return nil
+}
+
+// DeleteAddress removes an address, when it was the default the most
+// recently updated one left takes its place
+func (r *addressesRepository) DeleteAddress(userId, addressId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userId); err != nil {
+		return err
+	}
+
+	var wasDefault bool
+	if err := tx.GetContext(ctx, &wasDefault, `DELETE FROM "addresses" WHERE "user_id" = $1 AND "id" = $2 RETURNING "is_default";`, userId, addressId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return addresses.ErrAddressNotFound
+		}
+		return fmt.Errorf("delete address failed: %v", err)
+	}
+
+	if wasDefault {
+		query := `
+		UPDATE "addresses" SET
+			"is_default" = TRUE
+		WHERE "id" = (
+			SELECT "id" FROM "addresses"
+			WHERE "user_id" = $1
+			ORDER BY "updated_at" DESC, "id"
+			LIMIT 1
+		);`
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			return fmt.Errorf("set default address failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit address failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package addressesUsecases
+
+import (
+	"strings"
+
+	"github.com/k0msak007/kawaii-shop/modules/addresses"
+	"github.com/k0msak007/kawaii-shop/modules/addresses/addressesRepositories"
+)
+
+type IAddressesUsecase interface {
+	FindAddresses(userId string) ([]*addresses.Address, error)
+	FindOneAddress(userId, addressId string) (*addresses.Address, error)
+	InsertAddress(userId string, req *addresses.AddressReq) (*addresses.Address, error)
+	UpdateAddress(userId, addressId string, req *addresses.AddressReq) (*addresses.Address, error)
+	SetDefault(userId, addressId string) (*addresses.Address, error)
+	DeleteAddress(userId, addressId string) error
+}
+
+type addressesUsecase struct {
+	addressesRepository addressesRepositories.IAddressesRepository
+}
+
+func AddressesUsecase(addressesRepository addressesRepositories.IAddressesRepository) IAddressesUsecase {
+	return &addressesUsecase{
+		addressesRepository: addressesRepository,
+	}
+}
+
+// normalize trims the fields and writes the phone as digits
+func normalize(req *addresses.AddressReq) error {
+	for _, f := range []*string{&req.Label, &req.Recipient, &req.Line1, &req.Line2, &req.Subdistrict, &req.District, &req.Province, &req.PostalCode} {
+		*f = strings.TrimSpace(*f)
+	}
+
+	phone, err := addresses.NormalizePhone(req.Phone)
+	if err != nil {
+		return err
+	}
+	req.Phone = phone
+	return nil
+}
+
+func (u *addressesUsecase) FindAddresses(userId string) ([]*addresses.Address, error) {
+	return u.addressesRepository.FindAddresses(userId)
+}
+
+func (u *addressesUsecase) FindOneAddress(userId, addressId string) (*addresses.Address, error) {
+	return u.addressesRepository.FindOneAddress(userId, addressId)
+}
+
+func (u *addressesUsecase) InsertAddress(userId string, req *addresses.AddressReq) (*addresses.Address, error) {
+	if err := normalize(req); err != nil {
+		return nil, err
+	}
+
+	id, err := u.addressesRepository.InsertAddress(userId, req)
+	if err != nil {
+		return nil, err
+	}
+	return u.addressesRepository.FindOneAddress(userId, id)
+}
+
+func (u *addressesUsecase) UpdateAddress(userId, addressId string, req *addresses.AddressReq) (*addresses.Address, error) {
+	if err := normalize(req); err != nil {
+		return nil, err
+	}
+
+	if err := u.addressesRepository.UpdateAddress(userId, addressId, req); err != nil {
+		return nil, err
+	}
+	return u.addressesRepository.FindOneAddress(userId, addressId)
+}
+
+func (u *addressesUsecase) SetDefault(userId, addressId string) (*addresses.Address, error) {
+	if err := u.addressesRepository.SetDefault(userId, addressId); err != nil {
+		return nil, err
+	}
+	return u.addressesRepository.FindOneAddress(userId, addressId)
+}
+
+func (u *addressesUsecase) DeleteAddress(userId, addressId string) error {
+	return u.addressesRepository.DeleteAddress(userId, addressId)
+}
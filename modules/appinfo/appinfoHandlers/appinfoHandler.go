@@ -53,7 +53,7 @@ func (h *appinfoHandler) GenerateApiKey(c *fiber.Ctx) error {
 	return entities.NewResponse(c).Success(
 		fiber.StatusOK,
 		&struct {
-			Key string `json: "key"`
+			Key string `json:"key"`
 		}{
 			Key: apiKey.SignToken(),
 		},
@@ -62,12 +62,8 @@ func (h *appinfoHandler) GenerateApiKey(c *fiber.Ctx) error {
 
 func (h *appinfoHandler) FindCategory(c *fiber.Ctx) error {
 	req := new(appinfo.CategoryFilter)
-	if err := c.QueryParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(findCategoryErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findCategoryErr), err).Res()
 	}
 
 	category, err := h.appinfoUsecase.FindCategory(req)
@@ -84,12 +80,8 @@ func (h *appinfoHandler) FindCategory(c *fiber.Ctx) error {
 
 func (h *appinfoHandler) AddCategory(c *fiber.Ctx) error {
 	req := make([]*appinfo.Category, 0)
-	if err := c.BodyParser(&req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(addCategoryErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseBody(c, &req); err != nil {
+		return entities.NewResponse(c).ParseError(string(addCategoryErr), err).Res()
 	}
 
 	if len(req) == 0 {
@@ -141,7 +133,7 @@ func (h *appinfoHandler) RemoveCategory(c *fiber.Ctx) error {
 	return entities.NewResponse(c).Success(
 		fiber.StatusCreated,
 		&struct {
-			CategoryId int `json: "category_id"`
+			CategoryId int `json:"category_id"`
 		}{
 			CategoryId: categoryIdInt,
 		},
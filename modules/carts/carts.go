@@ -0,0 +1,129 @@
+package carts
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var (
+	ErrCartNotFound = errors.New("cart not found")
+	ErrItemNotFound = errors.New("cart item not found")
+	ErrCartToken    = errors.New("cart token is invalid")
+	ErrOutOfStock   = errors.New("not enough stock")
+)
+
+// Owner is who a cart request is for, the signed in user or else the
+// holder of an anonymous cart token
+type Owner struct {
+	UserId string
+	Token  string
+}
+
+type Cart struct {
+	Id *string `json:"id"`
+	// Token is only sent when an anonymous cart was created, it has to be
+	// sent back in the X-Cart-Token header
+	Token    string  `json:"token,omitempty"`
+	Currency string  `json:"currency"`
+	Items    []*Item `json:"items"`
+	// Subtotal adds up the items that are available
+	Subtotal *kawaiimoney.Money `json:"subtotal"`
+	// Changed is set when any item changed price or availability since it
+	// was added
+	Changed bool `json:"changed"`
+}
+
+type Item struct {
+	Id        string            `json:"id"`
+	ProductId string            `json:"product_id"`
+	VariantId *string           `json:"variant_id"`
+	Title     string            `json:"title"`
+	Sku       string            `json:"sku,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int               `json:"quantity"`
+	// AddedPrice is the unit price when the item was added, UnitPrice the
+	// current one
+	AddedPrice   *kawaiimoney.Money `json:"added_price"`
+	UnitPrice    *kawaiimoney.Money `json:"unit_price"`
+	Total        *kawaiimoney.Money `json:"total"`
+	PriceChanged bool               `json:"price_changed"`
+	Available    bool               `json:"available"`
+	// Notice says what changed, empty when nothing did
+	Notice string `json:"notice,omitempty"`
+}
+
+// ItemRow is an item as stored, UnitPrice in minor units of Currency
+type ItemRow struct {
+	Id        string  `db:"id"`
+	CartId    string  `db:"cart_id"`
+	ProductId string  `db:"product_id"`
+	VariantId *string `db:"variant_id"`
+	Quantity  int     `db:"quantity"`
+	UnitPrice int64   `db:"unit_price"`
+	Currency  string  `db:"currency"`
+}
+
+// ItemReq adds Quantity of a product, or of one of its variants, to the
+// cart
+type ItemReq struct {
+	ProductId string  `json:"product_id" validate:"required"`
+	VariantId *string `json:"variant_id" validate:"uuid"`
+	Quantity  int     `json:"quantity" validate:"required,min=1,max=999"`
+}
+
+type QuantityReq struct {
+	Quantity int `json:"quantity" validate:"required,min=1,max=999"`
+}
+
+// UnitPrice is what quantity of a product, or of its variant, sells for
+// now. It fails when the item cannot be bought.
+func UnitPrice(product *products.Product, variantId *string, quantity int) (*kawaiimoney.Money, *products.Variant, error) {
+	price, v, err := product.UnitPrice(variantId)
+	if err != nil || v == nil {
+		return price, v, err
+	}
+	if v.Stock < quantity {
+		return nil, v, fmt.Errorf("%w, %d left", ErrOutOfStock, v.Stock)
+	}
+	return price, v, nil
+}
+
+// Check fills the current price and availability of an item from product,
+// priced in the currency of the cart. product is nil when it is gone.
+func (i *Item) Check(row *ItemRow, product *products.Product, c *kawaiimoney.Currency) {
+	if added, err := kawaiimoney.Lookup(row.Currency); err == nil {
+		i.AddedPrice = kawaiimoney.New(row.UnitPrice, added)
+	}
+	if product == nil {
+		i.Notice = products.ErrProductNotFound.Error()
+		return
+	}
+	i.Title = product.Title
+
+	price, variant, err := UnitPrice(product, row.VariantId, row.Quantity)
+	if variant != nil {
+		i.Sku = variant.Sku
+		i.Options = variant.Options
+		price = variant.Price
+	}
+	if price == nil {
+		i.Notice = err.Error()
+		return
+	}
+
+	i.UnitPrice = price
+	i.Total = kawaiimoney.New(price.Amount*int64(i.Quantity), c)
+	i.PriceChanged = row.Currency != price.Currency || row.UnitPrice != price.Amount
+	i.Available = err == nil
+	switch {
+	case err != nil:
+		i.Notice = err.Error()
+	case i.PriceChanged && i.AddedPrice != nil:
+		i.Notice = fmt.Sprintf("price changed from %s to %s", i.AddedPrice.Display, price.Display)
+	case i.PriceChanged:
+		i.Notice = fmt.Sprintf("price changed to %s", price.Display)
+	}
+}
@@ -0,0 +1,176 @@
+package cartsHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/carts"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type cartsHandlersErrCode string
+
+const (
+	findCartErr   cartsHandlersErrCode = "carts-001"
+	addItemErr    cartsHandlersErrCode = "carts-002"
+	updateItemErr cartsHandlersErrCode = "carts-003"
+	deleteItemErr cartsHandlersErrCode = "carts-004"
+	clearCartErr  cartsHandlersErrCode = "carts-005"
+)
+
+type ICartsHandler interface {
+	FindCart(c *fiber.Ctx) error
+	AddItem(c *fiber.Ctx) error
+	UpdateItem(c *fiber.Ctx) error
+	DeleteItem(c *fiber.Ctx) error
+	ClearCart(c *fiber.Ctx) error
+}
+
+type cartsHandler struct {
+	cfg          config.IConfig
+	cartsUsecase cartsUsecases.ICartsUsecase
+}
+
+func CartsHandler(cfg config.IConfig, cartsUsecase cartsUsecases.ICartsUsecase) ICartsHandler {
+	return &cartsHandler{
+		cfg:          cfg,
+		cartsUsecase: cartsUsecase,
+	}
+}
+
+func cartsStatus(err error) int {
+	switch {
+	case errors.Is(err, carts.ErrCartNotFound),
+		errors.Is(err, carts.ErrItemNotFound),
+		errors.Is(err, products.ErrProductNotFound),
+		errors.Is(err, products.ErrVariantNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, carts.ErrCartToken):
+		return fiber.ErrUnauthorized.Code
+	case errors.Is(err, products.ErrVariantRequired),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency),
+		errors.Is(err, kawaiimoney.ErrNoRate):
+		return fiber.ErrBadRequest.Code
+	case errors.Is(err, carts.ErrOutOfStock):
+		return fiber.ErrConflict.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// owner is the signed in user when OptionalJwtAuth set one, else the
+// holder of the X-Cart-Token header
+func owner(c *fiber.Ctx) *carts.Owner {
+	userId, _ := c.Locals("userId").(string)
+	return &carts.Owner{
+		UserId: userId,
+		Token:  c.Get("X-Cart-Token"),
+	}
+}
+
+// itemId is the item_id param, anything but a uuid cannot be an item
+func itemId(c *fiber.Ctx) (string, error) {
+	id := c.Params("item_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", carts.ErrItemNotFound
+	}
+	return id, nil
+}
+
+// FindCart re-prices the cart and flags the items whose price or
+// availability changed since they were added
+func (h *cartsHandler) FindCart(c *fiber.Ctx) error {
+	cart, err := h.cartsUsecase.FindCart(owner(c))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			cartsStatus(err),
+			string(findCartErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, cart).Res()
+}
+
+func (h *cartsHandler) AddItem(c *fiber.Ctx) error {
+	req := new(carts.ItemReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(addItemErr), err).Res()
+	}
+
+	cart, err := h.cartsUsecase.AddItem(owner(c), req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			cartsStatus(err),
+			string(addItemErr),
+			err.Error(),
+		).Res()
+	}
+	if cart.Token != "" {
+		c.Set("X-Cart-Token", cart.Token)
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, cart).Res()
+}
+
+func (h *cartsHandler) UpdateItem(c *fiber.Ctx) error {
+	id, err := itemId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updateItemErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(carts.QuantityReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateItemErr), err).Res()
+	}
+
+	cart, err := h.cartsUsecase.UpdateItem(owner(c), id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			cartsStatus(err),
+			string(updateItemErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, cart).Res()
+}
+
+func (h *cartsHandler) DeleteItem(c *fiber.Ctx) error {
+	id, err := itemId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deleteItemErr),
+			err.Error(),
+		).Res()
+	}
+
+	cart, err := h.cartsUsecase.DeleteItem(owner(c), id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			cartsStatus(err),
+			string(deleteItemErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, cart).Res()
+}
+
+func (h *cartsHandler) ClearCart(c *fiber.Ctx) error {
+	cart, err := h.cartsUsecase.ClearCart(owner(c))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			cartsStatus(err),
+			string(clearCartErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, cart).Res()
+}
@@ -0,0 +1,295 @@
+package cartsRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/carts"
+)
+
+type ICartsRepository interface {
+	FindUserCart(userId string) (string, error)
+	FindAnonymousCart(cartId string) (string, error)
+	InsertCart(userId string) (string, error)
+	FindItems(cartId string) ([]*carts.ItemRow, error)
+	FindOneItem(cartId, itemId string) (*carts.ItemRow, error)
+	UpsertItem(req *carts.ItemRow) error
+	UpdateItem(req *carts.ItemRow) error
+	DeleteItem(cartId, itemId string) error
+	ClearCart(cartId string) error
+	MergeCart(cartId, userId string) error
+}
+
+type cartsRepository struct {
+	db *sqlx.DB
+}
+
+func CartsRepository(db *sqlx.DB) ICartsRepository {
+	return &cartsRepository{
+		db: db,
+	}
+}
+
+func (r *cartsRepository) FindUserCart(userId string) (string, error) {
+	var id string
+	if err := r.db.Get(&id, `SELECT "id" FROM "carts" WHERE "user_id" = $1;`, userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", carts.ErrCartNotFound
+		}
+		return "", fmt.Errorf("get cart failed: %v", err)
+	}
+	return id, nil
+}
+
+// FindAnonymousCart checks the cart of a cart token is still there and
+// has not been merged into a user's cart
+func (r *cartsRepository) FindAnonymousCart(cartId string) (string, error) {
+	var id string
+	if err := r.db.Get(&id, `SELECT "id" FROM "carts" WHERE "id" = $1 AND "user_id" IS NULL;`, cartId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", carts.ErrCartNotFound
+		}
+		return "", fmt.Errorf("get cart failed: %v", err)
+	}
+	return id, nil
+}
+
+// InsertCart creates the cart of a user, or an anonymous one when userId
+// is empty. A user has one cart, a second insert returns the first.
+func (r *cartsRepository) InsertCart(userId string) (string, error) {
+	var id string
+	if userId == "" {
+		if err := r.db.Get(&id, `INSERT INTO "carts" DEFAULT VALUES RETURNING "id";`); err != nil {
+			return "", fmt.Errorf("insert cart failed: %v", err)
+		}
+		return id, nil
+	}
+
+	query := `
+	INSERT INTO "carts" ("user_id")
+	VALUES ($1)
+	ON CONFLICT ("user_id") DO UPDATE SET
+		"updated_at" = now()
+	RETURNING "id";`
+
+	if err := r.db.Get(&id, query, userId); err != nil {
+		return "", fmt.Errorf("insert cart failed: %v", err)
+	}
+	return id, nil
+}
+
+func (r *cartsRepository) FindItems(cartId string) ([]*carts.ItemRow, error) {
+	query := `
+	SELECT
+		"id",
+		"cart_id",
+		"product_id",
+		"variant_id",
+		"quantity",
+		"unit_price",
+		"currency"
+	FROM "cart_items"
+	WHERE "cart_id" = $1
+	ORDER BY "created_at", "id";`
+
+	items := make([]*carts.ItemRow, 0)
+	if err := r.db.Select(&items, query, cartId); err != nil {
+		return nil, fmt.Errorf("get cart items failed: %v", err)
+	}
+	return items, nil
+}
+
+func (r *cartsRepository) FindOneItem(cartId, itemId string) (*carts.ItemRow, error) {
+	query := `
+	SELECT
+		"id",
+		"cart_id",
+		"product_id",
+		"variant_id",
+		"quantity",
+		"unit_price",
+		"currency"
+	FROM "cart_items"
+	WHERE "cart_id" = $1
+	AND "id" = $2;`
+
+	item := new(carts.ItemRow)
+	if err := r.db.Get(item, query, cartId, itemId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, carts.ErrItemNotFound
+		}
+		return nil, fmt.Errorf("get cart item failed: %v", err)
+	}
+	return item, nil
+}
+
+// UpsertItem adds an item, or adds its quantity to the line of the same
+// product or variant, up to 999, and sets the price of the line
+func (r *cartsRepository) UpsertItem(req *carts.ItemRow) error {
+	query := `
+	INSERT INTO "cart_items" (
+		"cart_id",
+		"product_id",
+		"variant_id",
+		"quantity",
+		"unit_price",
+		"currency"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT ("cart_id", "product_id") WHERE "variant_id" IS NULL DO UPDATE SET
+		"quantity" = LEAST("cart_items"."quantity" + EXCLUDED."quantity", 999),
+		"unit_price" = EXCLUDED."unit_price",
+		"currency" = EXCLUDED."currency";`
+	if req.VariantId != nil {
+		query = `
+		INSERT INTO "cart_items" (
+			"cart_id",
+			"product_id",
+			"variant_id",
+			"quantity",
+			"unit_price",
+			"currency"
+		)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT ("cart_id", "variant_id") WHERE "variant_id" IS NOT NULL DO UPDATE SET
+			"quantity" = LEAST("cart_items"."quantity" + EXCLUDED."quantity", 999),
+			"unit_price" = EXCLUDED."unit_price",
+			"currency" = EXCLUDED."currency";`
+	}
+
+	if _, err := r.db.Exec(
+		query,
+		req.CartId,
+		req.ProductId,
+		req.VariantId,
+		req.Quantity,
+		req.UnitPrice,
+		req.Currency,
+	); err != nil {
+		return fmt.Errorf("upsert cart item failed: %v", err)
+	}
+	return nil
+}
+
+func (r *cartsRepository) UpdateItem(req *carts.ItemRow) error {
+	query := `
+	UPDATE "cart_items" SET
+		"quantity" = $3,
+		"unit_price" = $4,
+		"currency" = $5
+	WHERE "cart_id" = $1
+	AND "id" = $2;`
+
+	res, err := r.db.Exec(query, req.CartId, req.Id, req.Quantity, req.UnitPrice, req.Currency)
+	if err != nil {
+		return fmt.Errorf("update cart item failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return carts.ErrItemNotFound
+	}
+	return nil
+}
+
+func (r *cartsRepository) DeleteItem(cartId, itemId string) error {
+	res, err := r.db.Exec(`DELETE FROM "cart_items" WHERE "cart_id" = $1 AND "id" = $2;`, cartId, itemId)
+	if err != nil {
+		return fmt.Errorf("delete cart item failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return carts.ErrItemNotFound
+	}
+	return nil
+}
+
+func (r *cartsRepository) ClearCart(cartId string) error {
+	if _, err := r.db.Exec(`DELETE FROM "cart_items" WHERE "cart_id" = $1;`, cartId); err != nil {
+		return fmt.Errorf("clear cart failed: %v", err)
+	}
+	return nil
+}
+
+// MergeCart moves the items of an anonymous cart into the cart of the
+// user and removes the anonymous one. Quantities of lines in both carts
+// add up, the line of the user keeps its price.
+func (r *cartsRepository) MergeCart(cartId, userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the anonymous cart keeps a second sign-in from merging it
+	// twice
+	var from string
+	if err := tx.GetContext(ctx, &from, `SELECT "id" FROM "carts" WHERE "id" = $1 AND "user_id" IS NULL FOR UPDATE;`, cartId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return carts.ErrCartNotFound
+		}
+		return fmt.Errorf("get cart failed: %v", err)
+	}
+
+	var to string
+	query := `
+	INSERT INTO "carts" ("user_id")
+	VALUES ($1)
+	ON CONFLICT ("user_id") DO UPDATE SET
+		"updated_at" = now()
+	RETURNING "id";`
+	if err := tx.GetContext(ctx, &to, query, userId); err != nil {
+		return fmt.Errorf("insert cart failed: %v", err)
+	}
+
+	// Lines of products and lines of variants conflict on their own
+	// indexes
+	lines := []struct {
+		noVariant bool
+		conflict  string
+	}{
+		{noVariant: true, conflict: `("cart_id", "product_id") WHERE "variant_id" IS NULL`},
+		{noVariant: false, conflict: `("cart_id", "variant_id") WHERE "variant_id" IS NOT NULL`},
+	}
+	for _, l := range lines {
+		query := `
+		INSERT INTO "cart_items" (
+			"cart_id",
+			"product_id",
+			"variant_id",
+			"quantity",
+			"unit_price",
+			"currency",
+			"created_at"
+		)
+		SELECT
+			$2,
+			"product_id",
+			"variant_id",
+			"quantity",
+			"unit_price",
+			"currency",
+			"created_at"
+		FROM "cart_items"
+		WHERE "cart_id" = $1
+		AND ("variant_id" IS NULL) = $3
+		ON CONFLICT ` + l.conflict + ` DO UPDATE SET
+			"quantity" = LEAST("cart_items"."quantity" + EXCLUDED."quantity", 999);`
+		if _, err := tx.ExecContext(ctx, query, from, to, l.noVariant); err != nil {
+			return fmt.Errorf("merge cart items failed: %v", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "carts" WHERE "id" = $1;`, from); err != nil {
+		return fmt.Errorf("delete cart failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit cart failed: %v", err)
+	}
+	return nil
+}
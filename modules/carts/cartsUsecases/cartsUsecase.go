@@ -0,0 +1,249 @@
+package cartsUsecases
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/carts"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiauth"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+type ICartsUsecase interface {
+	FindCart(owner *carts.Owner) (*carts.Cart, error)
+	AddItem(owner *carts.Owner, req *carts.ItemReq) (*carts.Cart, error)
+	UpdateItem(owner *carts.Owner, itemId string, req *carts.QuantityReq) (*carts.Cart, error)
+	DeleteItem(owner *carts.Owner, itemId string) (*carts.Cart, error)
+	ClearCart(owner *carts.Owner) (*carts.Cart, error)
+	// MergeCart moves the anonymous cart of token into the cart of the user,
+	// on sign-in
+	MergeCart(userId, token string) error
+}
+
+type cartsUsecase struct {
+	cfg             config.IConfig
+	cartsRepository cartsRepositories.ICartsRepository
+	productsUsecase productsUsecases.IProductsUsecase
+}
+
+func CartsUsecase(cfg config.IConfig, cartsRepository cartsRepositories.ICartsRepository, productsUsecase productsUsecases.IProductsUsecase) ICartsUsecase {
+	return &cartsUsecase{
+		cfg:             cfg,
+		cartsRepository: cartsRepository,
+		productsUsecase: productsUsecase,
+	}
+}
+
+// cartId finds the cart of owner, the user's when signed in. It returns
+// carts.ErrCartNotFound when there is none yet.
+func (u *cartsUsecase) cartId(owner *carts.Owner) (string, error) {
+	if owner.UserId != "" {
+		return u.cartsRepository.FindUserCart(owner.UserId)
+	}
+	if owner.Token == "" {
+		return "", carts.ErrCartNotFound
+	}
+
+	id, err := kawaiiauth.ParseCartToken(u.cfg.Jwt(), owner.Token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", carts.ErrCartToken, err)
+	}
+	return u.cartsRepository.FindAnonymousCart(id)
+}
+
+// price finds the product of an item priced in the currency of the carts
+func (u *cartsUsecase) price(productId string, variantId *string, quantity int) (*kawaiimoney.Money, error) {
+	product, err := u.productsUsecase.FindOneProduct(productId, u.cfg.App().Currency())
+	if err != nil {
+		return nil, err
+	}
+	price, _, err := carts.UnitPrice(product, variantId, quantity)
+	return price, err
+}
+
+// cart reads the items of a cart and checks each one against the current
+// products
+func (u *cartsUsecase) cart(cartId string) (*carts.Cart, error) {
+	c, err := kawaiimoney.Lookup(u.cfg.App().Currency())
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &carts.Cart{
+		Currency: c.Code,
+		Items:    make([]*carts.Item, 0),
+		Subtotal: kawaiimoney.New(0, c),
+	}
+	if cartId == "" {
+		return cart, nil
+	}
+	cart.Id = &cartId
+
+	rows, err := u.cartsRepository.FindItems(cartId)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*products.Product)
+	var subtotal int64
+	for _, row := range rows {
+		product, ok := found[row.ProductId]
+		if !ok {
+			product, err = u.productsUsecase.FindOneProduct(row.ProductId, c.Code)
+			if err != nil && !errors.Is(err, products.ErrProductNotFound) {
+				return nil, err
+			}
+			found[row.ProductId] = product
+		}
+
+		item := &carts.Item{
+			Id:        row.Id,
+			ProductId: row.ProductId,
+			VariantId: row.VariantId,
+			Quantity:  row.Quantity,
+		}
+		item.Check(row, product, c)
+		if item.Available {
+			subtotal += item.Total.Amount
+		}
+		if item.PriceChanged || !item.Available {
+			cart.Changed = true
+		}
+		cart.Items = append(cart.Items, item)
+	}
+	cart.Subtotal = kawaiimoney.New(subtotal, c)
+	return cart, nil
+}
+
+func (u *cartsUsecase) FindCart(owner *carts.Owner) (*carts.Cart, error) {
+	id, err := u.cartId(owner)
+	if err != nil && !errors.Is(err, carts.ErrCartNotFound) {
+		return nil, err
+	}
+	return u.cart(id)
+}
+
+// AddItem adds to the quantity of the line of the product or variant at
+// its current price. An owner without a cart gets a new one, an anonymous
+// owner its token too.
+func (u *cartsUsecase) AddItem(owner *carts.Owner, req *carts.ItemReq) (*carts.Cart, error) {
+	token := ""
+	id, err := u.cartId(owner)
+	if errors.Is(err, carts.ErrCartNotFound) {
+		id, err = u.cartsRepository.InsertCart(owner.UserId)
+		if err == nil && owner.UserId == "" {
+			token = kawaiiauth.SignCartToken(u.cfg.Jwt(), id)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Stock is checked, not held, an order takes it at checkout. The
+	// quantity is added up by the upsert, two requests at once both count.
+	quantity := req.Quantity
+	rows, err := u.cartsRepository.FindItems(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if row.ProductId == req.ProductId && sameVariant(row.VariantId, req.VariantId) {
+			quantity += row.Quantity
+		}
+	}
+	if quantity > 999 {
+		quantity = 999
+	}
+	price, err := u.price(req.ProductId, req.VariantId, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.cartsRepository.UpsertItem(&carts.ItemRow{
+		CartId:    id,
+		ProductId: req.ProductId,
+		VariantId: req.VariantId,
+		Quantity:  req.Quantity,
+		UnitPrice: price.Amount,
+		Currency:  price.Currency,
+	}); err != nil {
+		return nil, err
+	}
+
+	cart, err := u.cart(id)
+	if err != nil {
+		return nil, err
+	}
+	cart.Token = token
+	return cart, nil
+}
+
+func sameVariant(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// UpdateItem sets the quantity of an item, it takes the current price so a
+// flagged change is accepted
+func (u *cartsUsecase) UpdateItem(owner *carts.Owner, itemId string, req *carts.QuantityReq) (*carts.Cart, error) {
+	id, err := u.cartId(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := u.cartsRepository.FindOneItem(id, itemId)
+	if err != nil {
+		return nil, err
+	}
+	price, err := u.price(row.ProductId, row.VariantId, req.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	row.Quantity = req.Quantity
+	row.UnitPrice = price.Amount
+	row.Currency = price.Currency
+	if err := u.cartsRepository.UpdateItem(row); err != nil {
+		return nil, err
+	}
+	return u.cart(id)
+}
+
+func (u *cartsUsecase) DeleteItem(owner *carts.Owner, itemId string) (*carts.Cart, error) {
+	id, err := u.cartId(owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.cartsRepository.DeleteItem(id, itemId); err != nil {
+		return nil, err
+	}
+	return u.cart(id)
+}
+
+func (u *cartsUsecase) ClearCart(owner *carts.Owner) (*carts.Cart, error) {
+	id, err := u.cartId(owner)
+	if errors.Is(err, carts.ErrCartNotFound) {
+		return u.cart("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := u.cartsRepository.ClearCart(id); err != nil {
+		return nil, err
+	}
+	return u.cart(id)
+}
+
+func (u *cartsUsecase) MergeCart(userId, token string) error {
+	id, err := kawaiiauth.ParseCartToken(u.cfg.Jwt(), token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", carts.ErrCartToken, err)
+	}
+	return u.cartsRepository.MergeCart(id, userId)
+}
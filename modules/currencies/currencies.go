@@ -0,0 +1,34 @@
+package currencies
+
+import (
+	"errors"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var (
+	ErrRateNotFound = errors.New("exchange rate not found")
+	ErrRateInvalid  = errors.New("exchange rate is invalid")
+)
+
+// Currencies lists what prices can be shown in, Base is APP_CURRENCY
+type Currencies struct {
+	Base       string                  `json:"base"`
+	Currencies []*kawaiimoney.Currency `json:"currencies"`
+}
+
+// Rate is one Base is Rate Quote, kept as a decimal string so no precision
+// is lost on the way to the database
+type Rate struct {
+	Base      string `db:"base" json:"base"`
+	Quote     string `db:"quote" json:"quote"`
+	Rate      string `db:"rate" json:"rate"`
+	UpdatedAt string `db:"updated_at" json:"updated_at"`
+}
+
+type RateReq struct {
+	Base  string `json:"base" validate:"required"`
+	Quote string `json:"quote" validate:"required"`
+	// Decimal, e.g. "36.125"
+	Rate string `json:"rate" validate:"required,max=40"`
+}
@@ -0,0 +1,95 @@
+package currenciesHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/currencies"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+type currenciesHandlersErrCode string
+
+const (
+	findRatesErr  currenciesHandlersErrCode = "currencies-001"
+	upsertRateErr currenciesHandlersErrCode = "currencies-002"
+	deleteRateErr currenciesHandlersErrCode = "currencies-003"
+)
+
+type ICurrenciesHandler interface {
+	FindCurrencies(c *fiber.Ctx) error
+	FindRates(c *fiber.Ctx) error
+	UpsertRate(c *fiber.Ctx) error
+	DeleteRate(c *fiber.Ctx) error
+}
+
+type currenciesHandler struct {
+	cfg               config.IConfig
+	currenciesUsecase currenciesUsecases.ICurrenciesUsecase
+}
+
+func CurrenciesHandler(cfg config.IConfig, currenciesUsecase currenciesUsecases.ICurrenciesUsecase) ICurrenciesHandler {
+	return &currenciesHandler{
+		cfg:               cfg,
+		currenciesUsecase: currenciesUsecase,
+	}
+}
+
+func currenciesStatus(err error) int {
+	switch {
+	case errors.Is(err, currencies.ErrRateNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, currencies.ErrRateInvalid),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency):
+		return fiber.ErrBadRequest.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+func (h *currenciesHandler) FindCurrencies(c *fiber.Ctx) error {
+	return entities.NewResponse(c).Success(fiber.StatusOK, h.currenciesUsecase.FindCurrencies()).Res()
+}
+
+func (h *currenciesHandler) FindRates(c *fiber.Ctx) error {
+	rates, err := h.currenciesUsecase.FindRates()
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			currenciesStatus(err),
+			string(findRatesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, rates).Res()
+}
+
+func (h *currenciesHandler) UpsertRate(c *fiber.Ctx) error {
+	req := new(currencies.RateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(upsertRateErr), err).Res()
+	}
+
+	rate, err := h.currenciesUsecase.UpsertRate(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			currenciesStatus(err),
+			string(upsertRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, rate).Res()
+}
+
+func (h *currenciesHandler) DeleteRate(c *fiber.Ctx) error {
+	if err := h.currenciesUsecase.DeleteRate(c.Params("base"), c.Params("quote")); err != nil {
+		return entities.NewResponse(c).Error(
+			currenciesStatus(err),
+			string(deleteRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
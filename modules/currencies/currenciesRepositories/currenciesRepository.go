@@ -0,0 +1,75 @@
+package currenciesRepositories
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/currencies"
+)
+
+type ICurrenciesRepository interface {
+	FindRates() ([]*currencies.Rate, error)
+	UpsertRate(req *currencies.RateReq) (*currencies.Rate, error)
+	DeleteRate(base, quote string) error
+}
+
+type currenciesRepository struct {
+	db *sqlx.DB
+}
+
+func CurrenciesRepository(db *sqlx.DB) ICurrenciesRepository {
+	return &currenciesRepository{
+		db: db,
+	}
+}
+
+func (r *currenciesRepository) FindRates() ([]*currencies.Rate, error) {
+	query := `
+	SELECT
+		"base",
+		"quote",
+		"rate"::TEXT AS "rate",
+		"updated_at"
+	FROM "exchange_rates"
+	ORDER BY "base", "quote";`
+
+	rates := make([]*currencies.Rate, 0)
+	if err := r.db.Select(&rates, query); err != nil {
+		return nil, fmt.Errorf("select exchange rates failed: %v", err)
+	}
+	return rates, nil
+}
+
+func (r *currenciesRepository) UpsertRate(req *currencies.RateReq) (*currencies.Rate, error) {
+	query := `
+	INSERT INTO "exchange_rates" (
+		"base",
+		"quote",
+		"rate"
+	)
+	VALUES ($1, $2, $3::NUMERIC)
+	ON CONFLICT ("base", "quote") DO UPDATE SET
+		"rate" = EXCLUDED."rate"
+	RETURNING
+		"base",
+		"quote",
+		"rate"::TEXT AS "rate",
+		"updated_at";`
+
+	rate := new(currencies.Rate)
+	if err := r.db.Get(rate, query, req.Base, req.Quote, req.Rate); err != nil {
+		return nil, fmt.Errorf("upsert exchange rate failed: %v", err)
+	}
+	return rate, nil
+}
+
+func (r *currenciesRepository) DeleteRate(base, quote string) error {
+	res, err := r.db.Exec(`DELETE FROM "exchange_rates" WHERE "base" = $1 AND "quote" = $2;`, base, quote)
+	if err != nil {
+		return fmt.Errorf("delete exchange rate failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return currencies.ErrRateNotFound
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package currenciesUsecases
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/currencies"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesRepositories"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+// maxRate is the largest rate NUMERIC(24, 12) holds
+var maxRate = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(12), nil))
+
+type ICurrenciesUsecase interface {
+	FindCurrencies() *currencies.Currencies
+	FindRates() ([]*currencies.Rate, error)
+	UpsertRate(req *currencies.RateReq) (*currencies.Rate, error)
+	DeleteRate(base, quote string) error
+	// Rates returns every exchange rate to convert prices with
+	Rates() (kawaiimoney.Rates, error)
+}
+
+type currenciesUsecase struct {
+	cfg                  config.IConfig
+	currenciesRepository currenciesRepositories.ICurrenciesRepository
+}
+
+func CurrenciesUsecase(cfg config.IConfig, currenciesRepository currenciesRepositories.ICurrenciesRepository) ICurrenciesUsecase {
+	return &currenciesUsecase{
+		cfg:                  cfg,
+		currenciesRepository: currenciesRepository,
+	}
+}
+
+func (u *currenciesUsecase) FindCurrencies() *currencies.Currencies {
+	list := kawaiimoney.Currencies()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Code < list[j].Code
+	})
+
+	return &currencies.Currencies{
+		Base:       u.cfg.App().Currency(),
+		Currencies: list,
+	}
+}
+
+func (u *currenciesUsecase) FindRates() ([]*currencies.Rate, error) {
+	rates, err := u.currenciesRepository.FindRates()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rates {
+		if rate, err := kawaiimoney.ParseRate(r.Rate); err == nil {
+			r.Rate = kawaiimoney.FormatRate(rate)
+		}
+	}
+	return rates, nil
+}
+
+// UpsertRate sets the rate from base to quote, the codes are stored in
+// upper case and the rate with the scale of the column.
+func (u *currenciesUsecase) UpsertRate(req *currencies.RateReq) (*currencies.Rate, error) {
+	base, err := kawaiimoney.Lookup(req.Base)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := kawaiimoney.Lookup(req.Quote)
+	if err != nil {
+		return nil, err
+	}
+	if base == quote {
+		return nil, fmt.Errorf("%w, base and quote must differ", currencies.ErrRateInvalid)
+	}
+
+	rate, err := kawaiimoney.ParseRate(req.Rate)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", currencies.ErrRateInvalid, err)
+	}
+	formatted := kawaiimoney.FormatRate(rate)
+	if formatted == "0" || rate.Cmp(maxRate) >= 0 {
+		return nil, fmt.Errorf("%w, must be between 0.000000000001 and 999999999999", currencies.ErrRateInvalid)
+	}
+
+	req.Base, req.Quote, req.Rate = base.Code, quote.Code, formatted
+	res, err := u.currenciesRepository.UpsertRate(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Rate = formatted
+	return res, nil
+}
+
+func (u *currenciesUsecase) DeleteRate(base, quote string) error {
+	return u.currenciesRepository.DeleteRate(strings.ToUpper(base), strings.ToUpper(quote))
+}
+
+func (u *currenciesUsecase) Rates() (kawaiimoney.Rates, error) {
+	list, err := u.currenciesRepository.FindRates()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(kawaiimoney.Rates, len(list))
+	for _, r := range list {
+		rate, err := kawaiimoney.ParseRate(r.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("exchange rate %s/%s: %v", r.Base, r.Quote, err)
+		}
+		rates[[2]string{r.Base, r.Quote}] = rate
+	}
+	return rates, nil
+}
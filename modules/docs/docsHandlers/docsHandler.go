@@ -0,0 +1,28 @@
+package docsHandlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+)
+
+type IDocsHandler interface {
+	OpenApi(c *fiber.Ctx) error
+}
+
+type docsHandler struct {
+	cfg     config.IConfig
+	openApi func() map[string]any
+}
+
+func DocsHandler(cfg config.IConfig, openApi func() map[string]any) IDocsHandler {
+	return &docsHandler{
+		cfg:     cfg,
+		openApi: openApi,
+	}
+}
+
+// OpenApi is served as is, not wrapped by entities.Response, so tools can
+// read it directly.
+func (h *docsHandler) OpenApi(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.openApi())
+}
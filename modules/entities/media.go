@@ -1,7 +1,13 @@
 package entities
 
 type Image struct {
-	Id       string `db:"id" json:"id"`
-	FileName string `db:"filename" json:"filename"`
-	Url      string `db:"url" json:"url"`
+	Id       string            `db:"id" json:"id"`
+	FileName string            `db:"filename" json:"filename"`
+	Url      string            `db:"url" json:"url"`
+	Variants map[string]string `json:"variants"`
+	Private  bool              `json:"private"`
+	// Order among the images of the product, from 0
+	Position  int    `db:"position" json:"position"`
+	Alt       string `db:"alt" json:"alt"`
+	IsPrimary bool   `db:"is_primary" json:"is_primary"`
 }
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+// ParseBody parses the request body into out and checks its validate tags,
+// respond to the error with NewResponse(c).ParseError.
+func ParseBody(c *fiber.Ctx, out any) error {
+	if err := c.BodyParser(out); err != nil {
+		return err
+	}
+	return kawaiivalidator.Validate(out)
+}
+
+// ParseQuery is ParseBody for the query string
+func ParseQuery(c *fiber.Ctx, out any) error {
+	if err := c.QueryParser(out); err != nil {
+		return err
+	}
+	return kawaiivalidator.Validate(out)
+}
@@ -1,13 +1,17 @@
 package entities
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/k0msak007/kawaii-shop/pkg/kawaiilogger"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
 )
 
 type IResponse interface {
 	Success(code int, data any) IResponse
 	Error(code int, traceId, msg string) IResponse
+	ParseError(traceId string, err error) IResponse
 	Res() error
 }
 
@@ -20,8 +24,9 @@ type Response struct {
 }
 
 type ErrorResponse struct {
-	TraceId string `json:"trace_id"`
-	Msg     string `json:"message"`
+	TraceId string                        `json:"trace_id"`
+	Msg     string                        `json:"message"`
+	Errors  []*kawaiivalidator.FieldError `json:"errors,omitempty"`
 }
 
 func NewResponse(c *fiber.Ctx) IResponse {
@@ -48,6 +53,32 @@ func (r *Response) Error(code int, traceId, msg string) IResponse {
 	kawaiilogger.InitKawaiiLogger(r.Context, &r.ErrorRes).Print().Save()
 	return r
 }
+
+// ParseError responds to an error of ParseBody or ParseQuery, failed rules
+// are listed field by field with 422, a tag the validator cannot read is
+// an internal error and anything else is a bad request.
+func (r *Response) ParseError(traceId string, err error) IResponse {
+	if errors.Is(err, kawaiivalidator.ErrRule) {
+		return r.Error(fiber.StatusInternalServerError, traceId, err.Error())
+	}
+
+	var verr *kawaiivalidator.ValidationError
+	if !errors.As(err, &verr) {
+		return r.Error(fiber.StatusBadRequest, traceId, err.Error())
+	}
+
+	r.StatusCode = fiber.StatusUnprocessableEntity
+	r.ErrorRes = &ErrorResponse{
+		TraceId: traceId,
+		Msg:     "request is invalid",
+		Errors:  verr.Errors,
+	}
+	r.IsError = true
+
+	kawaiilogger.InitKawaiiLogger(r.Context, &r.ErrorRes).Print().Save()
+	return r
+}
+
 func (r *Response) Res() error {
 	return r.Context.Status(r.StatusCode).JSON(func() any {
 		if r.IsError {
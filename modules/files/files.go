@@ -1,19 +1,148 @@
 package files
 
-import "mime/multipart"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"time"
+)
+
+var (
+	ErrFileNotFound  = errors.New("file not found")
+	ErrFileForbidden = errors.New("file belongs to another user")
+	ErrFileInUse     = errors.New("file is used by product images")
+
+	ErrUploadNotFound   = errors.New("upload not found or expired")
+	ErrUploadInvalid    = errors.New("upload is invalid")
+	ErrUploadOffset     = errors.New("upload offset does not match")
+	ErrUploadTooLarge   = errors.New("upload is larger than its declared size")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrUploadChecksum   = errors.New("upload checksum does not match")
+	ErrUploadMismatch   = errors.New("upload content does not match its content type")
+)
+
+// UploadTypes are the content types of resumable uploads and the extension
+// they are stored with. Images go through the image pipeline, only the
+// formats it decodes are accepted.
+var UploadTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"video/mp4":  "mp4",
+	"video/webm": "webm",
+}
 
 type FileReq struct {
 	File        *multipart.FileHeader `form:"file"`
 	Destination string                `form:"destination"`
 	Extension   string
 	FileName    string
+	Private     bool
+}
+
+// UploadReq is the multipart form of the upload endpoint
+type UploadReq struct {
+	Files       []*multipart.FileHeader `form:"files"`
+	Destination string                  `form:"destination"`
+	// public or private, STORAGE_VISIBILITY when empty
+	Visibility string `form:"visibility" validate:"enum=public|private"`
 }
 
+// FileRes is the result of one uploaded file, Error is set when it could
+// not be stored while others were.
 type FileRes struct {
-	FileName string `json:"filename"`
-	Url      string `json:"url"`
+	Id          string            `json:"id,omitempty"`
+	FileName    string            `json:"filename"`
+	Destination string            `json:"destination"`
+	Private     bool              `json:"private"`
+	Url         string            `json:"url,omitempty"`
+	Variants    map[string]string `json:"variants,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Err         error             `json:"-"`
 }
 
 type DeleteFileReq struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+
+// DeleteFileRes is the result of one file to delete
+type DeleteFileRes struct {
+	Id      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+	Err     error  `json:"-"`
+}
+
+// File is a stored upload, Destination and the values of Variants are
+// storage keys.
+type File struct {
+	Id             string     `db:"id"`
+	OwnerId        string     `db:"owner_id"`
+	Destination    string     `db:"destination"`
+	FileName       string     `db:"filename"`
+	Size           int64      `db:"size"`
+	ContentType    string     `db:"content_type"`
+	Checksum       string     `db:"checksum"`
+	Private        bool       `db:"private"`
+	Variants       Variants   `db:"variants"`
+	RefCount       int        `db:"ref_count"`
+	UnreferencedAt *time.Time `db:"unreferenced_at"`
+	// Seconds since unreferenced_at by the database clock, the column has
+	// no time zone so the app clock cannot be compared with it
+	UnreferencedFor *float64 `db:"unreferenced_for"`
+}
+
+// Variants is a jsonb column of rendition name -> storage key
+type Variants map[string]string
+
+func (v Variants) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+func (v *Variants) Scan(src any) error {
+	var b []byte
+	switch s := src.(type) {
+	case []byte:
+		b = s
+	case string:
+		b = []byte(s)
+	case nil:
+		*v = make(Variants)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into variants", src)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// UploadSessionReq starts a resumable upload, the bytes follow in PATCH
+// requests and the upload is completed once all of them are there.
+type UploadSessionReq struct {
+	FileName    string `json:"filename" validate:"required,max=255"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+	ContentType string `json:"content_type" validate:"required,enum=image/png|image/jpeg|video/mp4|video/webm"`
+	// Hex sha256 of the whole file, checked on completion
+	Checksum    string `json:"checksum" validate:"required,min=64,max=64"`
 	Destination string `json:"destination"`
+	// public or private, STORAGE_VISIBILITY when empty
+	Visibility string `json:"visibility" validate:"enum=public|private"`
+}
+
+// UploadSession is a resumable upload, its bytes are staged on disk under
+// its id until it is completed.
+type UploadSession struct {
+	Id          string    `db:"id" json:"id"`
+	OwnerId     string    `db:"owner_id" json:"-"`
+	Destination string    `db:"destination" json:"destination"`
+	FileName    string    `db:"filename" json:"filename"`
+	Size        int64     `db:"size" json:"size"`
+	Offset      int64     `db:"offset" json:"offset"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Checksum    string    `db:"checksum" json:"checksum"`
+	Private     bool      `db:"private" json:"private"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expires_at"`
 }
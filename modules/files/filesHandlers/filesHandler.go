@@ -1,9 +1,13 @@
 package filesHandlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"math"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,19 +15,42 @@ import (
 	"github.com/k0msak007/kawaii-shop/modules/entities"
 	"github.com/k0msak007/kawaii-shop/modules/files"
 	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiimage"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiscan"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiistorage"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
 	"github.com/k0msak007/kawaii-shop/pkg/utils"
 )
 
 type filesHandlersErrCode string
 
 const (
-	uploadErr filesHandlersErrCode = "files-01"
-	deleteErr filesHandlersErrCode = "files-02"
+	uploadErr         filesHandlersErrCode = "files-01"
+	deleteErr         filesHandlersErrCode = "files-02"
+	serveLocalErr     filesHandlersErrCode = "files-03"
+	createUploadErr   filesHandlersErrCode = "files-04"
+	findUploadErr     filesHandlersErrCode = "files-05"
+	writeUploadErr    filesHandlersErrCode = "files-06"
+	completeUploadErr filesHandlersErrCode = "files-07"
+	abortUploadErr    filesHandlersErrCode = "files-08"
+)
+
+// Headers of the resumable upload protocol, after tus
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+	mimeOffsetStream   = "application/offset+octet-stream"
 )
 
 type IFilesHanlder interface {
 	UploadFiles(c *fiber.Ctx) error
 	DeleteFile(c *fiber.Ctx) error
+	ServeLocal(c *fiber.Ctx) error
+	CreateUpload(c *fiber.Ctx) error
+	FindUpload(c *fiber.Ctx) error
+	WriteUpload(c *fiber.Ctx) error
+	CompleteUpload(c *fiber.Ctx) error
+	AbortUpload(c *fiber.Ctx) error
 }
 
 type filesHandler struct {
@@ -38,20 +65,27 @@ func FileHandler(cfg config.IConfig, filesUsecase filesUsecases.IFilesUsecase) I
 	}
 }
 
-func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
+// ParseUploadForm reads the files and visibility of a multipart upload
+// form, they are stored under destination. The error is the fault of the
+// client.
+func ParseUploadForm(c *fiber.Ctx, cfg config.IConfig, destination string) ([]*files.FileReq, error) {
 	req := make([]*files.FileReq, 0)
 
 	form, err := c.MultipartForm()
 	if err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(uploadErr),
-			err.Error(),
-		).Res()
+		return nil, err
 	}
 
-	filesReq := form.File["files"]
-	destination := c.FormValue("destination")
+	private := cfg.Storage().Private()
+	switch c.FormValue("visibility") {
+	case "":
+	case "public":
+		private = false
+	case "private":
+		private = true
+	default:
+		return nil, errors.New("visibility must be public or private")
+	}
 
 	// Files ext validation
 	extMap := map[string]string{
@@ -60,22 +94,14 @@ func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
 		"jpeg": "jpeg",
 	}
 
-	for _, file := range filesReq {
+	for _, file := range form.File["files"] {
 		ext := strings.TrimPrefix(filepath.Ext(file.Filename), ".")
 		if extMap[ext] != ext || extMap[ext] == "" {
-			return entities.NewResponse(c).Error(
-				fiber.ErrBadRequest.Code,
-				string(uploadErr),
-				"extension is not acceptable",
-			).Res()
+			return nil, errors.New("extension is not acceptable")
 		}
 
-		if file.Size > int64(h.cfg.App().FileLimit()) {
-			return entities.NewResponse(c).Error(
-				fiber.ErrBadRequest.Code,
-				string(uploadErr),
-				fmt.Sprintf("file size must less than %d MiB", int(float64(h.cfg.App().FileLimit())/math.Pow(1024, 2))),
-			).Res()
+		if file.Size > int64(cfg.App().FileLimit()) {
+			return nil, fmt.Errorf("file size must less than %d MiB", int(float64(cfg.App().FileLimit())/math.Pow(1024, 2)))
 		}
 
 		fileName := utils.RandFileName(ext)
@@ -84,38 +110,319 @@ func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
 			Destination: destination + "/" + fileName,
 			FileName:    fileName,
 			Extension:   ext,
+			Private:     private,
 		})
 	}
+	return req, nil
+}
 
-	res, err := h.filesUsecase.UploadToGCP(req)
+func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
+	req, err := ParseUploadForm(c, h.cfg, c.FormValue("destination"))
 	if err != nil {
 		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
+			fiber.ErrBadRequest.Code,
 			string(uploadErr),
 			err.Error(),
 		).Res()
 	}
 
-	return entities.NewResponse(c).Success(fiber.StatusCreated, res).Res()
+	userId := c.Locals("userId").(string)
+
+	res, err := h.filesUsecase.UploadFiles(c.UserContext(), userId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			UploadErrorStatus(err),
+			string(uploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	failed := 0
+	for _, r := range res {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return entities.NewResponse(c).Success(fiber.StatusCreated, res).Res()
+	case failed == len(res):
+		return entities.NewResponse(c).Success(fiber.StatusUnprocessableEntity, res).Res()
+	default:
+		return entities.NewResponse(c).Success(fiber.StatusMultiStatus, res).Res()
+	}
+}
+
+// UploadErrorStatus is the status code of an error of
+// IFilesUsecase.UploadFiles
+func UploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, kawaiiimage.ErrUnsupported),
+		errors.Is(err, kawaiiimage.ErrMismatch),
+		errors.Is(err, kawaiiimage.ErrTooLarge),
+		errors.Is(err, kawaiiscan.ErrInfected),
+		errors.Is(err, kawaiistorage.ErrInvalidKey):
+		return fiber.ErrBadRequest.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
 }
 
 func (h *filesHandler) DeleteFile(c *fiber.Ctx) error {
 	req := make([]*files.DeleteFileReq, 0)
-	if err := c.BodyParser(&req); err != nil {
+	if err := entities.ParseBody(c, &req); err != nil {
+		return entities.NewResponse(c).ParseError(string(deleteErr), err).Res()
+	}
+
+	if len(req) == 0 {
 		return entities.NewResponse(c).Error(
 			fiber.ErrBadRequest.Code,
 			string(deleteErr),
-			err.Error(),
+			"files request are empty",
 		).Res()
 	}
 
-	if err := h.filesUsecase.DeleteFileOnGCP(req); err != nil {
+	userId := c.Locals("userId").(string)
+
+	res := h.filesUsecase.DeleteFiles(c.UserContext(), userId, req)
+
+	// Nothing deleted answers with the error of the first file, some
+	// deleted with the result of each.
+	failed := make([]error, 0)
+	for _, r := range res {
+		if r.Err != nil {
+			failed = append(failed, r.Err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+	case len(res):
 		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
+			deleteStatus(failed[0]),
 			string(deleteErr),
+			failed[0].Error(),
+		).Res()
+	default:
+		return entities.NewResponse(c).Success(fiber.StatusMultiStatus, res).Res()
+	}
+}
+
+func deleteStatus(err error) int {
+	switch {
+	case errors.Is(err, files.ErrFileNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, files.ErrFileForbidden):
+		return fiber.ErrForbidden.Code
+	case errors.Is(err, files.ErrFileInUse):
+		return fiber.ErrConflict.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// ServeLocal sends files of the local storage driver, private files need
+// the signature of their url.
+func (h *filesHandler) ServeLocal(c *fiber.Ctx) error {
+	query := make(url.Values)
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		query.Add(string(k), string(v))
+	})
+
+	path, err := h.filesUsecase.OpenLocal(c.Params("*"), query)
+	if err != nil {
+		switch {
+		case errors.Is(err, kawaiistorage.ErrSignature):
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(serveLocalErr),
+				err.Error(),
+			).Res()
+		case errors.Is(err, kawaiistorage.ErrNotExist),
+			errors.Is(err, kawaiistorage.ErrInvalidKey):
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(serveLocalErr),
+				"file not found",
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(serveLocalErr),
+				err.Error(),
+			).Res()
+		}
+	}
+
+	// Signed urls must not outlive their expiry in a shared cache
+	if c.Query("signature") != "" {
+		c.Set(fiber.HeaderCacheControl, "private, no-store")
+	}
+	return c.SendFile(path)
+}
+
+func uploadSessionStatus(err error) int {
+	switch {
+	case errors.Is(err, files.ErrUploadNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, files.ErrUploadOffset),
+		errors.Is(err, files.ErrUploadIncomplete):
+		return fiber.ErrConflict.Code
+	case errors.Is(err, files.ErrUploadTooLarge):
+		return fiber.ErrRequestEntityTooLarge.Code
+	case errors.Is(err, files.ErrUploadInvalid),
+		errors.Is(err, files.ErrUploadChecksum),
+		errors.Is(err, files.ErrUploadMismatch):
+		return fiber.ErrBadRequest.Code
+	default:
+		return UploadErrorStatus(err)
+	}
+}
+
+// uploadId is the upload_id param, anything but a uuid cannot be a session
+func uploadId(c *fiber.Ctx) (string, error) {
+	id := c.Params("upload_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", files.ErrUploadNotFound
+	}
+	return id, nil
+}
+
+func (h *filesHandler) CreateUpload(c *fiber.Ctx) error {
+	req := new(files.UploadSessionReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(createUploadErr), err).Res()
+	}
+
+	userId := c.Locals("userId").(string)
+
+	session, err := h.filesUsecase.CreateUpload(userId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			uploadSessionStatus(err),
+			string(createUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	c.Location(c.Path() + "/" + session.Id)
+	c.Set(headerUploadOffset, "0")
+	return entities.NewResponse(c).Success(fiber.StatusCreated, session).Res()
+}
+
+func (h *filesHandler) FindUpload(c *fiber.Ctx) error {
+	id, err := uploadId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findUploadErr),
 			err.Error(),
 		).Res()
 	}
 
+	userId := c.Locals("userId").(string)
+
+	session, err := h.filesUsecase.FindUpload(userId, id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			uploadSessionStatus(err),
+			string(findUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	// The offset tells a client where to resume, it must never be cached
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(headerUploadOffset, strconv.FormatInt(session.Offset, 10))
+	c.Set(headerUploadLength, strconv.FormatInt(session.Size, 10))
+	return entities.NewResponse(c).Success(fiber.StatusOK, session).Res()
+}
+
+// WriteUpload appends the body at the Upload-Offset header, a chunk is
+// limited by APP_BODY_LIMIT.
+func (h *filesHandler) WriteUpload(c *fiber.Ctx) error {
+	id, err := uploadId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(writeUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	if !strings.HasPrefix(string(c.Request().Header.ContentType()), mimeOffsetStream) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnsupportedMediaType.Code,
+			string(writeUploadErr),
+			fmt.Sprintf("content type must be %s", mimeOffsetStream),
+		).Res()
+	}
+
+	offset, err := strconv.ParseInt(c.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(writeUploadErr),
+			fmt.Sprintf("%s header must be a positive number", headerUploadOffset),
+		).Res()
+	}
+
+	userId := c.Locals("userId").(string)
+
+	offset, err = h.filesUsecase.WriteUpload(c.UserContext(), userId, id, offset, bytes.NewReader(c.Body()))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			uploadSessionStatus(err),
+			string(writeUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	c.Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+}
+
+func (h *filesHandler) CompleteUpload(c *fiber.Ctx) error {
+	id, err := uploadId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(completeUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	userId := c.Locals("userId").(string)
+
+	res, err := h.filesUsecase.CompleteUpload(c.UserContext(), userId, id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			uploadSessionStatus(err),
+			string(completeUploadErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, res).Res()
+}
+
+func (h *filesHandler) AbortUpload(c *fiber.Ctx) error {
+	id, err := uploadId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(abortUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	userId := c.Locals("userId").(string)
+
+	if err := h.filesUsecase.AbortUpload(c.UserContext(), userId, id); err != nil {
+		return entities.NewResponse(c).Error(
+			uploadSessionStatus(err),
+			string(abortUploadErr),
+			err.Error(),
+		).Res()
+	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
 }
@@ -0,0 +1,340 @@
+package filesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/files"
+)
+
+type IFilesRepository interface {
+	InsertFiles(req []*files.File) error
+	// FindUnreferencedFileIds lists files unreferenced for longer than grace,
+	// but those in skip
+	FindUnreferencedFileIds(grace time.Duration, skip []string, limit int) ([]string, error)
+	DeleteFile(ctx context.Context, fileId string, check func(file *files.File) error) error
+	InsertUploadSession(req *files.UploadSession) error
+	FindUploadSession(sessionId, ownerId string) (*files.UploadSession, error)
+	UpdateUploadOffset(ctx context.Context, sessionId, ownerId string, from, to int64) error
+	CompleteUploadSession(ctx context.Context, sessionId, ownerId string, complete func(session *files.UploadSession) (*files.File, error)) error
+	DeleteUploadSession(ctx context.Context, sessionId, ownerId string) error
+	DeleteExpiredUploadSessions(limit int) ([]string, error)
+}
+
+type filesRepository struct {
+	db *sqlx.DB
+}
+
+func FilesRepository(db *sqlx.DB) IFilesRepository {
+	return &filesRepository{
+		db: db,
+	}
+}
+
+// InsertFiles records the uploads all at once and fills their ids
+func (r *filesRepository) InsertFiles(req []*files.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, f := range req {
+		if err := insertFile(ctx, tx, f); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit files failed: %v", err)
+	}
+	return nil
+}
+
+func insertFile(ctx context.Context, tx *sqlx.Tx, f *files.File) error {
+	query := `
+	INSERT INTO "files" (
+		"owner_id",
+		"destination",
+		"filename",
+		"size",
+		"content_type",
+		"checksum",
+		"private",
+		"variants"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING "id";`
+
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		f.OwnerId,
+		f.Destination,
+		f.FileName,
+		f.Size,
+		f.ContentType,
+		f.Checksum,
+		f.Private,
+		f.Variants,
+	).Scan(&f.Id); err != nil {
+		return fmt.Errorf("insert file %s failed: %v", f.Destination, err)
+	}
+	return nil
+}
+
+func (r *filesRepository) FindUnreferencedFileIds(grace time.Duration, skip []string, limit int) ([]string, error) {
+	if skip == nil {
+		skip = make([]string, 0)
+	}
+
+	query := `
+	SELECT
+		"id"
+	FROM "files"
+	WHERE "ref_count" = 0
+	AND "unreferenced_at" < now() - $1 * INTERVAL '1 second'
+	AND NOT ("id" = ANY($2::uuid[]))
+	ORDER BY "unreferenced_at"
+	LIMIT $3;`
+
+	ids := make([]string, 0)
+	if err := r.db.Select(&ids, query, grace.Seconds(), skip, limit); err != nil {
+		return nil, fmt.Errorf("find unreferenced files failed: %v", err)
+	}
+	return ids, nil
+}
+
+// DeleteFile locks the file row, runs check and deletes the row when check
+// passes. check may delete the stored objects, an image cannot start using
+// the file while it runs.
+func (r *filesRepository) DeleteFile(ctx context.Context, fileId string, check func(file *files.File) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT
+		"id",
+		"owner_id",
+		"destination",
+		"filename",
+		"size",
+		"content_type",
+		"checksum",
+		"private",
+		"variants",
+		"ref_count",
+		"unreferenced_at",
+		EXTRACT(EPOCH FROM now() - "unreferenced_at")::float8 AS "unreferenced_for"
+	FROM "files"
+	WHERE "id" = $1
+	FOR UPDATE;`
+
+	file := new(files.File)
+	if err := tx.GetContext(ctx, file, query, fileId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return files.ErrFileNotFound
+		}
+		return fmt.Errorf("get file failed: %v", err)
+	}
+
+	if err := check(file); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "files" WHERE "id" = $1;`, fileId); err != nil {
+		return fmt.Errorf("delete file failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete file failed: %v", err)
+	}
+	return nil
+}
+
+func (r *filesRepository) InsertUploadSession(req *files.UploadSession) error {
+	query := `
+	INSERT INTO "upload_sessions" (
+		"owner_id",
+		"destination",
+		"filename",
+		"size",
+		"content_type",
+		"checksum",
+		"private",
+		"expires_at"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING "id";`
+
+	if err := r.db.QueryRowx(
+		query,
+		req.OwnerId,
+		req.Destination,
+		req.FileName,
+		req.Size,
+		req.ContentType,
+		req.Checksum,
+		req.Private,
+		req.ExpiresAt,
+	).Scan(&req.Id); err != nil {
+		return fmt.Errorf("insert upload session failed: %v", err)
+	}
+	return nil
+}
+
+const uploadSessionColumns = `
+		"id",
+		"owner_id",
+		"destination",
+		"filename",
+		"size",
+		"offset",
+		"content_type",
+		"checksum",
+		"private",
+		"expires_at"`
+
+func (r *filesRepository) FindUploadSession(sessionId, ownerId string) (*files.UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM "upload_sessions"
+	WHERE "id" = $1
+	AND "owner_id" = $2
+	AND "expires_at" > now();`
+
+	session := new(files.UploadSession)
+	if err := r.db.Get(session, query, sessionId, ownerId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, files.ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("get upload session failed: %v", err)
+	}
+	return session, nil
+}
+
+// lockUploadSession locks a live session of ownerId, it is completed or
+// deleted once.
+func lockUploadSession(ctx context.Context, tx *sqlx.Tx, sessionId, ownerId string) (*files.UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM "upload_sessions"
+	WHERE "id" = $1
+	AND "owner_id" = $2
+	AND "expires_at" > now()
+	FOR UPDATE;`
+
+	session := new(files.UploadSession)
+	if err := tx.GetContext(ctx, session, query, sessionId, ownerId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, files.ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("get upload session failed: %v", err)
+	}
+	return session, nil
+}
+
+// UpdateUploadOffset moves the offset of a live session from from to to.
+// It fails with ErrUploadOffset when the offset is not from anymore.
+func (r *filesRepository) UpdateUploadOffset(ctx context.Context, sessionId, ownerId string, from, to int64) error {
+	query := `
+	UPDATE "upload_sessions" SET
+		"offset" = $4
+	WHERE "id" = $1
+	AND "owner_id" = $2
+	AND "offset" = $3
+	AND "expires_at" > now();`
+
+	res, err := r.db.ExecContext(ctx, query, sessionId, ownerId, from, to)
+	if err != nil {
+		return fmt.Errorf("update upload offset failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		session, err := r.FindUploadSession(sessionId, ownerId)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w, expected %d", files.ErrUploadOffset, session.Offset)
+	}
+	return nil
+}
+
+// CompleteUploadSession locks the session, records the file complete
+// returns and removes the session, all or nothing.
+func (r *filesRepository) CompleteUploadSession(ctx context.Context, sessionId, ownerId string, complete func(session *files.UploadSession) (*files.File, error)) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	session, err := lockUploadSession(ctx, tx, sessionId, ownerId)
+	if err != nil {
+		return err
+	}
+
+	file, err := complete(session)
+	if err != nil {
+		return err
+	}
+	if err := insertFile(ctx, tx, file); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "upload_sessions" WHERE "id" = $1;`, sessionId); err != nil {
+		return fmt.Errorf("delete upload session failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit upload failed: %v", err)
+	}
+	return nil
+}
+
+func (r *filesRepository) DeleteUploadSession(ctx context.Context, sessionId, ownerId string) error {
+	query := `
+	DELETE FROM "upload_sessions"
+	WHERE "id" = $1
+	AND "owner_id" = $2;`
+
+	res, err := r.db.ExecContext(ctx, query, sessionId, ownerId)
+	if err != nil {
+		return fmt.Errorf("delete upload session failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return files.ErrUploadNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredUploadSessions removes up to limit expired sessions and
+// returns their ids, a locked session is left for the next run.
+func (r *filesRepository) DeleteExpiredUploadSessions(limit int) ([]string, error) {
+	query := `
+	DELETE FROM "upload_sessions"
+	WHERE "id" IN (
+		SELECT
+			"id"
+		FROM "upload_sessions"
+		WHERE "expires_at" <= now()
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING "id";`
+
+	ids := make([]string, 0)
+	if err := r.db.Select(&ids, query, limit); err != nil {
+		return nil, fmt.Errorf("delete expired upload sessions failed: %v", err)
+	}
+	return ids, nil
+}
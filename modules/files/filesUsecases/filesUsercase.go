@@ -3,187 +3,907 @@ package filesUsecases
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/storage"
-
 	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
 	"github.com/k0msak007/kawaii-shop/modules/files"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesRepositories"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiimage"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiscan"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiistorage"
+	"github.com/k0msak007/kawaii-shop/pkg/utils"
+	"golang.org/x/sync/errgroup"
 )
 
+// workers counts the uploads and deletes of every files usecase that are
+// still talking to the storage
+var workers sync.WaitGroup
+
+// WaitWorkers waits for the uploads and deletes in progress until ctx is
+// done, the storage is closed after them.
+func WaitWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type IFilesUsecase interface {
-	UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error)
-	DeleteFileOnGCP(req []*files.DeleteFileReq) error
+	UploadFiles(ctx context.Context, ownerId string, req []*files.FileReq) ([]*files.FileRes, error)
+	DeleteFiles(ctx context.Context, userId string, req []*files.DeleteFileReq) []*files.DeleteFileRes
+	RemoveFile(ctx context.Context, fileId string) error
+	CreateUpload(ownerId string, req *files.UploadSessionReq) (*files.UploadSession, error)
+	FindUpload(ownerId, sessionId string) (*files.UploadSession, error)
+	WriteUpload(ctx context.Context, ownerId, sessionId string, offset int64, r io.Reader) (int64, error)
+	CompleteUpload(ctx context.Context, ownerId, sessionId string) (*files.FileRes, error)
+	AbortUpload(ctx context.Context, ownerId, sessionId string) error
+	CollectGarbage(ctx context.Context) (int, error)
+	RunGarbageCollector(ctx context.Context, interval time.Duration)
+	ResolveImages(images []*entities.Image) error
+	OpenLocal(destination string, query url.Values) (string, error)
 }
 
 type filesUsecase struct {
-	cfg config.IConfig
+	cfg             config.IConfig
+	filesRepository filesRepositories.IFilesRepository
+	storage         kawaiistorage.IStorage
+	images          kawaiiimage.IProcessor
+	scanner         kawaiiscan.IScanner
 }
 
-func FileUsecase(cfg config.IConfig) IFilesUsecase {
+func FileUsecase(cfg config.IConfig, filesRepository filesRepositories.IFilesRepository, storage kawaiistorage.IStorage) IFilesUsecase {
+	renditions := make([]*kawaiiimage.Rendition, 0)
+	for _, r := range cfg.Image().Renditions() {
+		renditions = append(renditions, &kawaiiimage.Rendition{
+			Name:  r.Name,
+			Width: r.Width,
+		})
+	}
+
+	opts := &kawaiiimage.Options{
+		Renditions:   renditions,
+		Quality:      cfg.Image().Quality(),
+		MaxDimension: cfg.Image().MaxDimension(),
+		MaxPixels:    int64(cfg.Image().MaxPixels()),
+	}
+	if cfg.Image().WebP() {
+		opts.WebPEncoder = cfg.Image().WebPEncoder()
+	}
+
+	scanner := kawaiiscan.NewNoopScanner()
+	if addr := cfg.Scan().ClamdAddr(); addr != "" {
+		scanner = kawaiiscan.NewClamdScanner(addr, cfg.Scan().Timeout())
+	}
+
 	return &filesUsecase{
-		cfg: cfg,
+		cfg:             cfg,
+		filesRepository: filesRepository,
+		storage:         storage,
+		images:          kawaiiimage.NewProcessor(opts),
+		scanner:         scanner,
 	}
 }
 
-type filesPub struct {
-	bucket      string
+// processed is an upload after the image pipeline, ready to be stored
+// unless err is set
+type processed struct {
+	req    *files.FileReq
+	result *kawaiiimage.Result
+	err    error
+}
+
+func (u *filesUsecase) processImage(ctx context.Context, req *files.FileReq) (*processed, error) {
+	container, err := req.File.Open()
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(container)
+	container.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only real images reach the scanner and the decoder
+	if _, err := u.images.Inspect(b, req.Extension); err != nil {
+		return nil, fmt.Errorf("%s: %w", req.File.Filename, err)
+	}
+	if err := u.scanner.Scan(ctx, bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("scan %s failed: %w", req.File.Filename, err)
+	}
+
+	result, err := u.images.Process(ctx, b, req.Extension)
+	if err != nil {
+		return nil, fmt.Errorf("process %s failed: %w", req.File.Filename, err)
+	}
+	return &processed{
+		req:    req,
+		result: result,
+	}, nil
+}
+
+// processImages strips the metadata and makes the renditions, at most
+// IMAGE_WORKERS images are decoded at the same time. An invalid image only
+// fails its own file, the error is kept in err of its result.
+func (u *filesUsecase) processImages(ctx context.Context, req []*files.FileReq) ([]*processed, error) {
+	res := make([]*processed, len(req))
+
+	var g errgroup.Group
+	g.SetLimit(u.cfg.Image().Workers())
+	for i, r := range req {
+		i, r := i, r
+		g.Go(func() error {
+			p, err := u.processImage(ctx, r)
+			if err != nil {
+				p = &processed{req: r, err: err}
+			}
+			res[i] = p
+			return nil
+		})
+	}
+	g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// uploadJob is one object to store, the original or one of its renditions
+// of the file at index file. url and err are set by storeObject.
+type uploadJob struct {
+	file        int
+	variant     string
 	destination string
-	file        *files.FileRes
+	data        []byte
+	stream      io.Reader // Read instead of data when set
+	contentType string
+	private     bool
+	url         string
+	err         error
 }
 
-func (f *filesPub) makePublic(ctx context.Context, client *storage.Client) error {
-	acl := client.Bucket(f.bucket).Object(f.destination).ACL()
-	if err := acl.Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
-		return fmt.Errorf("ACLHandle.Set: %w", err)
+// variantDestination puts a rendition next to its original,
+// products/abc.jpg becomes products/abc_thumbnail.webp
+func variantDestination(destination, name, ext string) string {
+	base := strings.TrimSuffix(destination, path.Ext(destination))
+	return fmt.Sprintf("%s_%s.%s", base, name, ext)
+}
+
+func (u *filesUsecase) storeObject(ctx context.Context, job *uploadJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var r io.Reader = bytes.NewReader(job.data)
+	if job.stream != nil {
+		r = job.stream
+	}
+	if err := u.storage.Upload(ctx, job.destination, r, job.contentType, job.private); err != nil {
+		return err
+	}
+	fmt.Printf("%v uploaded.\n", job.destination)
+
+	link, err := u.storage.Url(ctx, job.destination, job.private)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Blob %v is now publicly accessible.\n", f.destination)
+	job.url = link
 	return nil
 }
 
-func (u *filesUsecase) uploadWorkers(ctx context.Context, client *storage.Client, jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
-	for job := range jobs {
-		container, err := job.File.Open()
-		if err != nil {
-			errs <- err
-			return
+// storeObjects uploads every job with at most STORAGE_UPLOAD_WORKERS at the
+// same time. A failed job does not stop the others, each keeps its own err.
+func (u *filesUsecase) storeObjects(ctx context.Context, jobs []*uploadJob) {
+	workers.Add(1)
+	defer workers.Done()
+
+	var g errgroup.Group
+	g.SetLimit(u.cfg.Storage().UploadWorkers())
+	for _, job := range jobs {
+		job := job
+		g.Go(func() error {
+			job.err = u.storeObject(ctx, job)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// UploadFiles stores the images of ownerId and records them in the files
+// table. A file is stored with all its renditions or not at all, the
+// result of each file says which, also when every file failed. The
+// returned error is set when the request timed out or the records could
+// not be inserted.
+func (u *filesUsecase) UploadFiles(ctx context.Context, ownerId string, req []*files.FileReq) ([]*files.FileRes, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*60)
+	defer cancel()
+
+	images, err := u.processImages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*files.FileRes, 0, len(images))
+	records := make([]*files.File, 0, len(images))
+	jobs := make([]*uploadJob, 0)
+	for i, img := range images {
+		if img.err != nil {
+			records = append(records, nil)
+			res = append(res, &files.FileRes{
+				FileName:    img.req.FileName,
+				Destination: img.req.Destination,
+				Private:     img.req.Private,
+				Err:         img.err,
+			})
+			continue
 		}
-		b, err := io.ReadAll(container)
-		if err != nil {
-			errs <- err
-			return
+
+		original := img.result.Original
+		checksum := sha256.Sum256(original.Data)
+
+		record := &files.File{
+			OwnerId:     ownerId,
+			Destination: img.req.Destination,
+			FileName:    img.req.FileName,
+			Size:        int64(len(original.Data)),
+			ContentType: original.ContentType,
+			Checksum:    hex.EncodeToString(checksum[:]),
+			Private:     img.req.Private,
+			Variants:    make(files.Variants),
+		}
+		records = append(records, record)
+
+		res = append(res, &files.FileRes{
+			FileName:    img.req.FileName,
+			Destination: img.req.Destination,
+			Private:     img.req.Private,
+			Variants:    make(map[string]string),
+		})
+
+		jobs = append(jobs, &uploadJob{
+			file:        i,
+			destination: img.req.Destination,
+			data:        original.Data,
+			contentType: original.ContentType,
+			private:     img.req.Private,
+		})
+		for _, v := range img.result.Variants {
+			dest := variantDestination(img.req.Destination, v.Name, v.Ext)
+			record.Variants[v.Name] = dest
+
+			jobs = append(jobs, &uploadJob{
+				file:        i,
+				variant:     v.Name,
+				destination: dest,
+				data:        v.Data,
+				contentType: v.ContentType,
+				private:     img.req.Private,
+			})
 		}
+	}
 
-		buf := bytes.NewBuffer(b)
-		// Upload an object with storage.Writer.
-		wc := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination).NewWriter(ctx)
+	u.storeObjects(ctx, jobs)
+
+	for _, job := range jobs {
+		file := res[job.file]
+		switch {
+		case job.err != nil:
+			if file.Err == nil {
+				file.Err = fmt.Errorf("upload %s failed: %w", job.destination, job.err)
+			}
+		case job.variant == "":
+			file.Url = job.url
+		default:
+			file.Variants[job.variant] = job.url
+		}
+	}
 
-		if _, err = io.Copy(wc, buf); err != nil {
-			errs <- fmt.Errorf("io.Copy: %v", err)
-			return
+	// Failed files lose the objects they got, the others are recorded
+	stored := make([]*files.File, 0, len(records))
+	orphans := make([]string, 0)
+	uploaded := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if job.err != nil {
+			continue
 		}
-		// Data can continue to be added to the file until the writer is closed.
-		if err := wc.Close(); err != nil {
-			errs <- fmt.Errorf("Writer.Close: %v", err)
-			return
+		if res[job.file].Err != nil {
+			orphans = append(orphans, job.destination)
+		} else {
+			uploaded = append(uploaded, job.destination)
 		}
-		fmt.Printf("%v uploaded to %v.\n", job.FileName, job.Extension)
+	}
+	for i, file := range res {
+		if file.Err != nil {
+			file.Error = file.Err.Error()
+			file.Url = ""
+			file.Variants = nil
+			continue
+		}
+		stored = append(stored, records[i])
+	}
+	u.removeObjects(orphans)
+
+	if err := ctx.Err(); err != nil {
+		u.removeObjects(uploaded)
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return res, nil
+	}
 
-		newFile := &filesPub{
-			file: &files.FileRes{
-				FileName: job.FileName,
-				Url:      fmt.Sprintf("https://storage.googleapis.com/%s/%s", u.cfg.App().GCPBucket(), job.Destination),
-			},
-			bucket:      u.cfg.App().GCPBucket(),
-			destination: job.Destination,
+	if err := u.filesRepository.InsertFiles(stored); err != nil {
+		u.removeObjects(uploaded)
+		return nil, err
+	}
+	for i, r := range records {
+		if r != nil {
+			res[i].Id = r.Id
 		}
+	}
 
-		if err := newFile.makePublic(ctx, client); err != nil {
-			errs <- err
-			return
+	return res, nil
+}
+
+// removeObjects cleans up after a failed upload, best effort. Objects left
+// behind have no file record and cannot be reached by id. The request may
+// be gone already so it does not share its context.
+func (u *filesUsecase) removeObjects(destinations []string) {
+	if len(destinations) == 0 {
+		return
+	}
+	workers.Add(1)
+	defer workers.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	for _, dest := range destinations {
+		if err := u.storage.Delete(ctx, dest); err != nil && !errors.Is(err, kawaiistorage.ErrNotExist) {
+			fmt.Printf("Remove %v failed: %v\n", dest, err)
 		}
+	}
+}
 
-		errs <- nil
-		results <- newFile.file
+// deleteObjects removes the original and the renditions of file. A missing
+// object is fine, it may have been removed by a run that failed later on.
+func (u *filesUsecase) deleteObjects(ctx context.Context, file *files.File) error {
+	workers.Add(1)
+	defer workers.Done()
 
-		errs <- nil
+	dests := []string{file.Destination}
+	for _, v := range file.Variants {
+		dests = append(dests, v)
+	}
 
+	for _, dest := range dests {
+		if err := u.storage.Delete(ctx, dest); err != nil && !errors.Is(err, kawaiistorage.ErrNotExist) {
+			return err
+		}
+		fmt.Printf("Blob %v deleted.\n", dest)
 	}
+	return nil
+}
 
+func (u *filesUsecase) deleteFile(ctx context.Context, userId, fileId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return u.filesRepository.DeleteFile(ctx, fileId, func(file *files.File) error {
+		if file.OwnerId != userId {
+			return files.ErrFileForbidden
+		}
+		if file.RefCount > 0 {
+			return files.ErrFileInUse
+		}
+		return u.deleteObjects(ctx, file)
+	})
 }
 
-func (u *filesUsecase) UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+// DeleteFiles deletes files of userId by id with at most
+// STORAGE_DELETE_WORKERS at the same time, files used by product images
+// are kept. Every file gets its own result, in the order of req.
+func (u *filesUsecase) DeleteFiles(ctx context.Context, userId string, req []*files.DeleteFileReq) []*files.DeleteFileRes {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*60)
 	defer cancel()
 
-	client, err := storage.NewClient(ctx)
+	res := make([]*files.DeleteFileRes, len(req))
+
+	var g errgroup.Group
+	g.SetLimit(u.cfg.Storage().DeleteWorkers())
+	for i, r := range req {
+		i, r := i, r
+		g.Go(func() error {
+			result := &files.DeleteFileRes{Id: r.Id}
+			if err := u.deleteFile(ctx, userId, r.Id); err != nil {
+				result.Err = err
+				result.Error = err.Error()
+			} else {
+				result.Deleted = true
+			}
+			res[i] = result
+			return nil
+		})
+	}
+	g.Wait()
+
+	return res
+}
+
+// RemoveFile deletes a file no image uses whoever uploaded it, it is
+// called once the last image of the file is gone.
+func (u *filesUsecase) RemoveFile(ctx context.Context, fileId string) error {
+	return u.filesRepository.DeleteFile(ctx, fileId, func(file *files.File) error {
+		if file.RefCount > 0 {
+			return files.ErrFileInUse
+		}
+		return u.deleteObjects(ctx, file)
+	})
+}
+
+// CreateUpload starts a resumable upload of up to STORAGE_UPLOAD_MAX_SIZE
+// bytes. Images go through the image pipeline when they are completed and
+// are capped at the file limit of direct uploads.
+func (u *filesUsecase) CreateUpload(ownerId string, req *files.UploadSessionReq) (*files.UploadSession, error) {
+	if req.Size > int64(u.cfg.Storage().UploadMaxSize()) {
+		return nil, fmt.Errorf("%w, size must be at most %d bytes", files.ErrUploadInvalid, u.cfg.Storage().UploadMaxSize())
+	}
+	if strings.HasPrefix(req.ContentType, "image/") && req.Size > int64(u.cfg.App().FileLimit()) {
+		return nil, fmt.Errorf("%w, image size must be at most %d bytes", files.ErrUploadInvalid, u.cfg.App().FileLimit())
+	}
+
+	checksum := strings.ToLower(req.Checksum)
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return nil, fmt.Errorf("%w, checksum must be a hex sha256", files.ErrUploadInvalid)
+	}
+
+	private := u.cfg.Storage().Private()
+	if req.Visibility != "" {
+		private = req.Visibility == "private"
+	}
+
+	fileName := utils.RandFileName(files.UploadTypes[req.ContentType])
+	session := &files.UploadSession{
+		OwnerId:     ownerId,
+		Destination: req.Destination + "/" + fileName,
+		FileName:    fileName,
+		Size:        req.Size,
+		ContentType: req.ContentType,
+		Checksum:    checksum,
+		Private:     private,
+		ExpiresAt:   time.Now().Add(u.cfg.Storage().UploadTTL()),
+	}
+	if err := u.filesRepository.InsertUploadSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (u *filesUsecase) FindUpload(ownerId, sessionId string) (*files.UploadSession, error) {
+	return u.filesRepository.FindUploadSession(sessionId, ownerId)
+}
+
+// stagingPath is where the bytes of an upload session are kept
+func (u *filesUsecase) stagingPath(sessionId string) string {
+	return filepath.Join(u.cfg.Storage().StagingDir(), sessionId)
+}
+
+// stagingLocks serialize the chunks of an upload session, its staged bytes
+// are on the local disk. A session always takes the same lock.
+var stagingLocks [64]sync.Mutex
+
+func stagingLock(sessionId string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(sessionId))
+	return &stagingLocks[h.Sum32()%uint32(len(stagingLocks))]
+}
+
+// WriteUpload appends the chunk r at offset, which must be the offset of
+// the session, and returns the new offset. The chunk is written without a
+// transaction open, the offset only moves if no other chunk moved it.
+func (u *filesUsecase) WriteUpload(ctx context.Context, ownerId, sessionId string, offset int64, r io.Reader) (int64, error) {
+	lock := stagingLock(sessionId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, err := u.filesRepository.FindUploadSession(sessionId, ownerId)
 	if err != nil {
-		return nil, fmt.Errorf("storage.NewClient: %w", err)
+		return 0, err
+	}
+	if offset != session.Offset {
+		return 0, fmt.Errorf("%w, expected %d", files.ErrUploadOffset, session.Offset)
+	}
+
+	written, err := u.writeStaging(session, offset, r)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := u.filesRepository.UpdateUploadOffset(ctx, sessionId, ownerId, offset, written); err != nil {
+		if errors.Is(err, files.ErrUploadNotFound) {
+			// Aborted or expired meanwhile, nothing reads these bytes
+			os.Remove(u.stagingPath(sessionId))
+		}
+		return 0, err
 	}
-	defer client.Close()
+	return written, nil
+}
 
-	jobCh := make(chan *files.FileReq, len(req))
-	resultsCh := make(chan *files.FileRes, len(req))
-	errsCh := make(chan error, len(req))
+// writeStaging writes r at offset of the staging file of session
+func (u *filesUsecase) writeStaging(session *files.UploadSession, offset int64, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(u.cfg.Storage().StagingDir(), 0755); err != nil {
+		return 0, fmt.Errorf("create staging directory failed: %v", err)
+	}
+	file, err := os.OpenFile(u.stagingPath(session.Id), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("open staging file failed: %v", err)
+	}
+	defer file.Close()
 
-	res := make([]*files.FileRes, 0)
+	// Bytes past the offset are from a chunk that was never committed
+	if err := file.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("truncate staging file failed: %v", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek staging file failed: %v", err)
+	}
 
-	for _, r := range req {
-		jobCh <- r
+	// One byte more than what is left tells an oversized chunk
+	n, err := io.Copy(file, io.LimitReader(r, session.Size-offset+1))
+	if err != nil {
+		return 0, fmt.Errorf("write staging file failed: %v", err)
+	}
+	if offset+n > session.Size {
+		return 0, files.ErrUploadTooLarge
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("write staging file failed: %v", err)
 	}
-	close(jobCh)
+	return offset + n, nil
+}
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.uploadWorkers(ctx, client, jobCh, resultsCh, errsCh)
+// verifyUpload checks the size, checksum and content of a staged upload
+// and scans it.
+func (u *filesUsecase) verifyUpload(ctx context.Context, session *files.UploadSession, file *os.File) error {
+	if session.Offset != session.Size {
+		return fmt.Errorf("%w, %d of %d bytes", files.ErrUploadIncomplete, session.Offset, session.Size)
 	}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
-		if err != nil {
-			return nil, err
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("read staging file failed: %v", err)
+	}
+	if n != session.Size {
+		return fmt.Errorf("%w, %d of %d bytes", files.ErrUploadIncomplete, n, session.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != session.Checksum {
+		return files.ErrUploadChecksum
+	}
+
+	head := make([]byte, 512)
+	read, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read staging file failed: %v", err)
+	}
+	if detected := http.DetectContentType(head[:read]); detected != session.ContentType {
+		return fmt.Errorf("%w, %s is %s", files.ErrUploadMismatch, session.ContentType, detected)
+	}
+
+	if err := u.scanner.Scan(ctx, io.NewSectionReader(file, 0, session.Size)); err != nil {
+		return fmt.Errorf("scan %s failed: %w", session.FileName, err)
+	}
+	return nil
+}
+
+// processUpload runs a staged image through the same pipeline as direct
+// uploads, the dimension limits, the metadata stripping and the
+// renditions. Anything else is stored as it is.
+func (u *filesUsecase) processUpload(ctx context.Context, session *files.UploadSession, file *os.File) ([]*uploadJob, *files.File, error) {
+	record := &files.File{
+		OwnerId:     session.OwnerId,
+		Destination: session.Destination,
+		FileName:    session.FileName,
+		Size:        session.Size,
+		ContentType: session.ContentType,
+		Checksum:    session.Checksum,
+		Private:     session.Private,
+		Variants:    make(files.Variants),
+	}
+
+	// Videos may be as big as STORAGE_UPLOAD_MAX_SIZE, they are streamed
+	if !strings.HasPrefix(session.ContentType, "image/") {
+		return []*uploadJob{{
+			destination: session.Destination,
+			stream:      io.NewSectionReader(file, 0, session.Size),
+			contentType: session.ContentType,
+			private:     session.Private,
+		}}, record, nil
+	}
+
+	// Only the header is read until the image is known to fit the limits
+	if session.Size > int64(u.cfg.App().FileLimit()) {
+		return nil, nil, fmt.Errorf("%w, image size must be at most %d bytes", files.ErrUploadInvalid, u.cfg.App().FileLimit())
+	}
+	ext := files.UploadTypes[session.ContentType]
+	if _, err := u.images.InspectReader(io.NewSectionReader(file, 0, session.Size), ext); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", session.FileName, err)
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, session.Size))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read staging file failed: %v", err)
+	}
+	result, err := u.images.Process(ctx, data, ext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("process %s failed: %w", session.FileName, err)
+	}
+
+	original := result.Original
+	checksum := sha256.Sum256(original.Data)
+	record.Size = int64(len(original.Data))
+	record.ContentType = original.ContentType
+	record.Checksum = hex.EncodeToString(checksum[:])
+
+	jobs := []*uploadJob{{
+		destination: session.Destination,
+		data:        original.Data,
+		contentType: original.ContentType,
+		private:     session.Private,
+	}}
+	for _, v := range result.Variants {
+		dest := variantDestination(session.Destination, v.Name, v.Ext)
+		record.Variants[v.Name] = dest
+
+		jobs = append(jobs, &uploadJob{
+			variant:     v.Name,
+			destination: dest,
+			data:        v.Data,
+			contentType: v.ContentType,
+			private:     session.Private,
+		})
+	}
+	return jobs, record, nil
+}
+
+// CompleteUpload verifies the staged bytes, stores them and records the
+// file. The session is gone once it succeeds. The objects are stored
+// before the session is locked, no transaction waits on the storage.
+func (u *filesUsecase) CompleteUpload(ctx context.Context, ownerId, sessionId string) (*files.FileRes, error) {
+	session, err := u.filesRepository.FindUploadSession(sessionId, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(u.stagingPath(session.Id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w, %d of %d bytes", files.ErrUploadIncomplete, 0, session.Size)
+		}
+		return nil, fmt.Errorf("open staging file failed: %v", err)
+	}
+	defer file.Close()
+
+	// A complete session takes no more chunks, the staged bytes stay as
+	// they are verified
+	if err := u.verifyUpload(ctx, session, file); err != nil {
+		return nil, err
+	}
+	jobs, record, err := u.processUpload(ctx, session, file)
+	if err != nil {
+		return nil, err
+	}
+
+	u.storeObjects(ctx, jobs)
+
+	uploaded := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if job.err == nil {
+			uploaded = append(uploaded, job.destination)
+		}
+	}
+	for _, job := range jobs {
+		if job.err != nil {
+			u.removeObjects(uploaded)
+			return nil, fmt.Errorf("upload %s failed: %w", job.destination, job.err)
+		}
+	}
+	res := &files.FileRes{
+		FileName:    record.FileName,
+		Destination: record.Destination,
+		Private:     record.Private,
+		Url:         jobs[0].url,
+	}
+	if len(jobs) > 1 {
+		res.Variants = make(map[string]string)
+		for _, job := range jobs[1:] {
+			res.Variants[job.variant] = job.url
 		}
+	}
 
-		result := <-resultsCh
-		res = append(res, result)
+	err = u.filesRepository.CompleteUploadSession(ctx, sessionId, ownerId, func(locked *files.UploadSession) (*files.File, error) {
+		if locked.Offset != session.Offset || locked.Destination != session.Destination {
+			return nil, fmt.Errorf("%w, %d of %d bytes", files.ErrUploadIncomplete, locked.Offset, locked.Size)
+		}
+		return record, nil
+	})
+	switch {
+	case errors.Is(err, files.ErrUploadNotFound):
+		// Completed by another request meanwhile, the objects at the
+		// destination are the ones it recorded
+		return nil, err
+	case err != nil:
+		// Stored but not recorded, nothing could ever reach it
+		u.removeObjects(uploaded)
+		return nil, err
 	}
+	os.Remove(u.stagingPath(sessionId))
 
+	res.Id = record.Id
 	return res, nil
 }
 
-// deleteFile removes specified object.
-func (u *filesUsecase) deleteFileWorker(ctx context.Context, client *storage.Client, jobs <-chan *files.DeleteFileReq, errs chan<- error) {
-	for job := range jobs {
-		o := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination)
+// AbortUpload discards an upload session and its staged bytes
+func (u *filesUsecase) AbortUpload(ctx context.Context, ownerId, sessionId string) error {
+	if err := u.filesRepository.DeleteUploadSession(ctx, sessionId, ownerId); err != nil {
+		return err
+	}
+	if err := os.Remove(u.stagingPath(sessionId)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove staging file failed: %v", err)
+	}
+	return nil
+}
 
-		// Optional: set a generation-match precondition to avoid potential race
-		// conditions and data corruptions. The request to delete the file is aborted
-		// if the object's generation number does not match your precondition.
-		attrs, err := o.Attrs(ctx)
-		if err != nil {
-			errs <- fmt.Errorf("object.Attrs: %w", err)
-			return
+// collectUploads removes expired upload sessions and their staged bytes
+func (u *filesUsecase) collectUploads(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
 
-		if err := o.Delete(ctx); err != nil {
-			errs <- fmt.Errorf("Object(%q).Delete: %w", job.Destination, err)
-			return
+		ids, err := u.filesRepository.DeleteExpiredUploadSessions(100)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
 		}
-		fmt.Printf("Blob %v deleted.\n", job.Destination)
 
-		errs <- nil
+		for _, id := range ids {
+			if err := os.Remove(u.stagingPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Remove staging file %s failed: %v", id, err)
+			}
+		}
 	}
 }
 
-func (u *filesUsecase) DeleteFileOnGCP(req []*files.DeleteFileReq) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
-	defer cancel()
-
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return fmt.Errorf("storage.NewClient: %w", err)
+// CollectGarbage removes expired upload sessions and the files no image
+// has used for the grace period, it returns how many files were removed.
+func (u *filesUsecase) CollectGarbage(ctx context.Context) (int, error) {
+	if err := u.collectUploads(ctx); err != nil {
+		return 0, err
 	}
-	defer client.Close()
 
-	jobCh := make(chan *files.DeleteFileReq, len(req))
-	errsCh := make(chan error, len(req))
+	grace := u.cfg.Storage().GcGrace()
 
-	for _, r := range req {
-		jobCh <- r
+	removed := 0
+	// Files found in use are not listed again, the next run looks at them
+	skipped := make([]string, 0)
+	for {
+		ids, err := u.filesRepository.FindUnreferencedFileIds(grace, skipped, 100)
+		if err != nil {
+			return removed, err
+		}
+		if len(ids) == 0 {
+			return removed, nil
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+
+			err := u.filesRepository.DeleteFile(ctx, id, func(file *files.File) error {
+				// Checked again under the lock, an image may have picked
+				// the file up since it was listed. The age is by the
+				// database clock like the listing.
+				if file.RefCount > 0 || file.UnreferencedFor == nil || *file.UnreferencedFor < grace.Seconds() {
+					return files.ErrFileInUse
+				}
+				return u.deleteObjects(ctx, file)
+			})
+			switch {
+			case err == nil:
+				removed++
+			case errors.Is(err, files.ErrFileInUse):
+				skipped = append(skipped, id)
+			case errors.Is(err, files.ErrFileNotFound):
+			default:
+				// The next batch would list the same file again
+				return removed, fmt.Errorf("collect file %s failed: %v", id, err)
+			}
+		}
 	}
-	close(jobCh)
+}
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.deleteFileWorker(ctx, client, jobCh, errsCh)
+// RunGarbageCollector calls CollectGarbage every interval until ctx is done
+func (u *filesUsecase) RunGarbageCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := u.CollectGarbage(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Collect garbage files failed: %v", err)
+			}
+			if removed > 0 {
+				log.Printf("Collected %d garbage files", removed)
+			}
+		}
 	}
+}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
-		return err
+// ResolveImages turns the stored keys of images into urls, signed ones
+// for private images. They are made per request so a signed url handed
+// out is always fresh.
+func (u *filesUsecase) ResolveImages(images []*entities.Image) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	for _, img := range images {
+		if kawaiistorage.IsKey(img.Url) {
+			link, err := u.storage.Url(ctx, img.Url, img.Private)
+			if err != nil {
+				return err
+			}
+			img.Url = link
+		}
+
+		for name, v := range img.Variants {
+			if !kawaiistorage.IsKey(v) {
+				continue
+			}
+			link, err := u.storage.Url(ctx, v, img.Private)
+			if err != nil {
+				return err
+			}
+			img.Variants[name] = link
+		}
 	}
 	return nil
 }
+
+// OpenLocal returns the file behind a url of the local driver
+func (u *filesUsecase) OpenLocal(destination string, query url.Values) (string, error) {
+	local, ok := u.storage.(kawaiistorage.ILocalStorage)
+	if !ok {
+		return "", kawaiistorage.ErrNotExist
+	}
+	return local.Open(destination, query)
+}
@@ -0,0 +1,496 @@
+package filesUsecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/files"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiimage"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiistorage"
+)
+
+// testEnv is the least config LoadConfig accepts, the files usecase only
+// reads the image and storage parts of it
+const testEnv = `APP_HOST=127.0.0.1
+APP_PORT=3000
+APP_NAME=kawaii-shop
+APP_VERSION=v0.1.0
+APP_READ_TIMEOUT=60
+APP_WRITE_TIMEOUT=60
+APP_BODY_LIMIT=10490000
+APP_FILE_LIMIT=2097000
+APP_GCP_BUCKET=bucket
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_PROTOCOL=tcp
+DB_USERNAME=kawaii
+DB_PASSWORD=123456
+DB_DATABASE=kawaii_db_test
+DB_SSL_MODE=disable
+DB_MAX_CONNECTIONS=25
+JWT_ADMIN_KEY=adminkeyadminkeyadminkeyadminkey12
+JWT_SECRET_KEY=secretkeysecretkeysecretkeysecret12
+JWT_API_KEY=apikeyapikeyapikeyapikeyapikeyapi12
+JWT_ACCESS_EXPIRES=86400
+JWT_REFRESH_EXPIRES=604800
+IMAGE_RENDITIONS=thumbnail:16,medium:32
+STORAGE_UPLOAD_WORKERS=3
+STORAGE_DELETE_WORKERS=3
+PAYMENT_PROVIDER=mock
+`
+
+// fakeStorage keeps objects in memory. fail picks the uploads that fail
+// and block holds every upload until it is closed or its context is done.
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    func(destination string) error
+	block   chan struct{}
+	started chan struct{}
+	once    sync.Once
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		objects: make(map[string][]byte),
+		started: make(chan struct{}),
+	}
+}
+
+func (s *fakeStorage) Upload(ctx context.Context, destination string, r io.Reader, contentType string, private bool) error {
+	s.once.Do(func() { close(s.started) })
+
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.fail != nil {
+		if err := s.fail(destination); err != nil {
+			return err
+		}
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[destination] = b
+	return nil
+}
+
+func (s *fakeStorage) Delete(ctx context.Context, destination string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[destination]; !ok {
+		return kawaiistorage.ErrNotExist
+	}
+	delete(s.objects, destination)
+	return nil
+}
+
+func (s *fakeStorage) Url(ctx context.Context, destination string, private bool) (string, error) {
+	return "mem://" + destination, nil
+}
+
+func (s *fakeStorage) Close() error { return nil }
+
+func (s *fakeStorage) has(destination string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[destination]
+	return ok
+}
+
+func (s *fakeStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+// fakeRepository keeps the files table in memory, only the methods the
+// upload and delete paths use are there
+type fakeRepository struct {
+	unusedRepository
+	mu    sync.Mutex
+	files map[string]*files.File
+	next  int
+}
+
+// unusedRepository fills the methods of the interface the tests do
+// not call, calling one panics
+type unusedRepository interface {
+	FindUnreferencedFileIds(grace time.Duration, skip []string, limit int) ([]string, error)
+	InsertUploadSession(req *files.UploadSession) error
+	FindUploadSession(sessionId, ownerId string) (*files.UploadSession, error)
+	UpdateUploadOffset(ctx context.Context, sessionId, ownerId string, from, to int64) error
+	CompleteUploadSession(ctx context.Context, sessionId, ownerId string, complete func(session *files.UploadSession) (*files.File, error)) error
+	DeleteUploadSession(ctx context.Context, sessionId, ownerId string) error
+	DeleteExpiredUploadSessions(limit int) ([]string, error)
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		files: make(map[string]*files.File),
+	}
+}
+
+func (r *fakeRepository) InsertFiles(req []*files.File) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range req {
+		r.next++
+		f.Id = fmt.Sprintf("file-%d", r.next)
+		r.files[f.Id] = f
+	}
+	return nil
+}
+
+func (r *fakeRepository) DeleteFile(ctx context.Context, fileId string, check func(file *files.File) error) error {
+	r.mu.Lock()
+	file, ok := r.files[fileId]
+	r.mu.Unlock()
+	if !ok {
+		return files.ErrFileNotFound
+	}
+
+	if err := check(file); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.files, fileId)
+	return nil
+}
+
+func newTestUsecase(t *testing.T, storage kawaiistorage.IStorage, repository *fakeRepository) IFilesUsecase {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(testEnv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("load config failed: %v", err)
+	}
+	return FileUsecase(cfg, repository, storage)
+}
+
+// pngReqs makes n upload requests of small png images
+func pngReqs(t *testing.T, n int) []*files.FileReq {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		img.Set(i, i, color.RGBA{R: 255, A: 255})
+
+		part, err := w.CreateFormFile("files", fmt.Sprintf("image-%d.png", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(part, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+
+	req := make([]*files.FileReq, 0, n)
+	for i, fh := range form.File["files"] {
+		name := fmt.Sprintf("file-%d.png", i)
+		req = append(req, &files.FileReq{
+			File:        fh,
+			Destination: "products/" + name,
+			FileName:    name,
+			Extension:   "png",
+		})
+	}
+	return req
+}
+
+func TestUploadFiles(t *testing.T) {
+	storage := newFakeStorage()
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	res, err := u.UploadFiles(context.Background(), "owner", pngReqs(t, 8))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if len(res) != 8 {
+		t.Fatalf("got %d results, want 8", len(res))
+	}
+
+	for i, r := range res {
+		dest := fmt.Sprintf("products/file-%d.png", i)
+		if r.Err != nil || r.Id == "" {
+			t.Errorf("file %d: id %q, err %v", i, r.Id, r.Err)
+		}
+		if r.Destination != dest || r.Url != "mem://"+dest {
+			t.Errorf("file %d: destination %q url %q", i, r.Destination, r.Url)
+		}
+		for _, name := range []string{"thumbnail", "medium"} {
+			v := variantDestination(dest, name, "png")
+			if r.Variants[name] != "mem://"+v || !storage.has(v) {
+				t.Errorf("file %d: rendition %s is %q", i, name, r.Variants[name])
+			}
+		}
+	}
+	if n := storage.count(); n != 24 {
+		t.Errorf("got %d stored objects, want 24", n)
+	}
+	if n := len(repository.files); n != 8 {
+		t.Errorf("got %d file records, want 8", n)
+	}
+}
+
+func TestUploadFilesPartialFailure(t *testing.T) {
+	errBroken := errors.New("bucket is broken")
+
+	storage := newFakeStorage()
+	storage.fail = func(destination string) error {
+		if strings.HasPrefix(destination, "products/file-1_") {
+			return errBroken
+		}
+		return nil
+	}
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	res, err := u.UploadFiles(context.Background(), "owner", pngReqs(t, 3))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	failed := res[1]
+	if !errors.Is(failed.Err, errBroken) || failed.Error == "" {
+		t.Errorf("file 1: err %v, error %q", failed.Err, failed.Error)
+	}
+	if failed.Id != "" || failed.Url != "" || failed.Variants != nil {
+		t.Errorf("file 1: id %q url %q variants %v", failed.Id, failed.Url, failed.Variants)
+	}
+	// A file is stored with its renditions or not at all
+	if storage.has("products/file-1.png") {
+		t.Error("original of the failed file is left in storage")
+	}
+
+	for _, i := range []int{0, 2} {
+		if res[i].Err != nil || res[i].Id == "" {
+			t.Errorf("file %d: id %q, err %v", i, res[i].Id, res[i].Err)
+		}
+	}
+	if n := storage.count(); n != 6 {
+		t.Errorf("got %d stored objects, want 6", n)
+	}
+	if n := len(repository.files); n != 2 {
+		t.Errorf("got %d file records, want 2", n)
+	}
+}
+
+func TestUploadFilesEveryFileFails(t *testing.T) {
+	errBroken := errors.New("bucket is broken")
+
+	storage := newFakeStorage()
+	storage.fail = func(destination string) error {
+		return errBroken
+	}
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	res, err := u.UploadFiles(context.Background(), "owner", pngReqs(t, 3))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("got %d results, want 3", len(res))
+	}
+	for i, r := range res {
+		if !errors.Is(r.Err, errBroken) || r.Error == "" || r.Id != "" {
+			t.Errorf("file %d: id %q, err %v, error %q", i, r.Id, r.Err, r.Error)
+		}
+	}
+	if n := len(repository.files); n != 0 {
+		t.Errorf("got %d file records, want 0", n)
+	}
+}
+
+func TestUploadFilesInvalidImage(t *testing.T) {
+	storage := newFakeStorage()
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	req := pngReqs(t, 3)
+	req[1].Extension = "jpg"
+
+	res, err := u.UploadFiles(context.Background(), "owner", req)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	failed := res[1]
+	if !errors.Is(failed.Err, kawaiiimage.ErrMismatch) || failed.Error == "" {
+		t.Errorf("file 1: err %v, error %q", failed.Err, failed.Error)
+	}
+	if failed.Id != "" || failed.Url != "" || storage.has("products/file-1.png") {
+		t.Errorf("file 1: id %q url %q is stored", failed.Id, failed.Url)
+	}
+	for _, i := range []int{0, 2} {
+		if res[i].Err != nil || res[i].Id == "" {
+			t.Errorf("file %d: id %q, err %v", i, res[i].Id, res[i].Err)
+		}
+	}
+	if n := len(repository.files); n != 2 {
+		t.Errorf("got %d file records, want 2", n)
+	}
+}
+
+func TestUploadFilesCancelled(t *testing.T) {
+	storage := newFakeStorage()
+	storage.block = make(chan struct{})
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-storage.started
+		cancel()
+	}()
+
+	res, err := u.UploadFiles(ctx, "owner", pngReqs(t, 4))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got res %v, err %v, want context.Canceled", res, err)
+	}
+	if n := storage.count(); n != 0 {
+		t.Errorf("got %d stored objects, want 0", n)
+	}
+	if n := len(repository.files); n != 0 {
+		t.Errorf("got %d file records, want 0", n)
+	}
+}
+
+// storedFile puts a file of ownerId in both fakes
+func storedFile(storage *fakeStorage, repository *fakeRepository, id, ownerId string, refCount int) {
+	dest := "products/" + id + ".png"
+	thumb := variantDestination(dest, "thumbnail", "png")
+
+	storage.objects[dest] = []byte("original")
+	storage.objects[thumb] = []byte("thumbnail")
+	repository.files[id] = &files.File{
+		Id:          id,
+		OwnerId:     ownerId,
+		Destination: dest,
+		Variants:    files.Variants{"thumbnail": thumb},
+		RefCount:    refCount,
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	storage := newFakeStorage()
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	storedFile(storage, repository, "mine", "owner", 0)
+	storedFile(storage, repository, "used", "owner", 1)
+	storedFile(storage, repository, "theirs", "other", 0)
+	for i := 0; i < 10; i++ {
+		storedFile(storage, repository, fmt.Sprintf("bulk-%d", i), "owner", 0)
+	}
+
+	req := []*files.DeleteFileReq{{Id: "mine"}, {Id: "used"}, {Id: "theirs"}, {Id: "missing"}}
+	for i := 0; i < 10; i++ {
+		req = append(req, &files.DeleteFileReq{Id: fmt.Sprintf("bulk-%d", i)})
+	}
+
+	res := u.DeleteFiles(context.Background(), "owner", req)
+	if len(res) != len(req) {
+		t.Fatalf("got %d results, want %d", len(res), len(req))
+	}
+	for i, r := range res {
+		if r.Id != req[i].Id {
+			t.Fatalf("result %d is of %s, want %s", i, r.Id, req[i].Id)
+		}
+	}
+
+	want := map[string]error{
+		"used":    files.ErrFileInUse,
+		"theirs":  files.ErrFileForbidden,
+		"missing": files.ErrFileNotFound,
+	}
+	for _, r := range res {
+		if err, ok := want[r.Id]; ok {
+			if r.Deleted || !errors.Is(r.Err, err) || r.Error != err.Error() {
+				t.Errorf("%s: deleted %v, err %v, want %v", r.Id, r.Deleted, r.Err, err)
+			}
+			continue
+		}
+		if !r.Deleted || r.Err != nil {
+			t.Errorf("%s: deleted %v, err %v", r.Id, r.Deleted, r.Err)
+		}
+		if storage.has("products/" + r.Id + ".png") {
+			t.Errorf("%s: original is left in storage", r.Id)
+		}
+	}
+
+	// The used and the other user's files keep their objects
+	if n := storage.count(); n != 4 {
+		t.Errorf("got %d stored objects, want 4", n)
+	}
+}
+
+func TestDeleteFilesCancelled(t *testing.T) {
+	storage := newFakeStorage()
+	repository := newFakeRepository()
+	u := newTestUsecase(t, storage, repository)
+
+	req := make([]*files.DeleteFileReq, 0)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("file-%d", i)
+		storedFile(storage, repository, id, "owner", 0)
+		req = append(req, &files.DeleteFileReq{Id: id})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, r := range u.DeleteFiles(ctx, "owner", req) {
+		if r.Deleted || !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("%s: deleted %v, err %v, want context.Canceled", r.Id, r.Deleted, r.Err)
+		}
+	}
+	if n := storage.count(); n != 10 {
+		t.Errorf("got %d stored objects, want 10", n)
+	}
+}
@@ -4,3 +4,12 @@ type Role struct {
 	Id    int    `db:"id" json:"id"`
 	Title string `db:"title" json:"title"`
 }
+
+type IdempotencyKey struct {
+	Key         string `db:"key"`
+	Fingerprint string `db:"fingerprint"`
+	StatusCode  int    `db:"status_code"`
+	ContentType string `db:"content_type"`
+	Response    []byte `db:"response"`
+	Completed   bool   `db:"completed"`
+}
@@ -1,15 +1,30 @@
 package middlewaresHandlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/k0msak007/kawaii-shop/config"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/middlewares"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresUsecases"
 	"github.com/k0msak007/kawaii-shop/pkg/kawaiiauth"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiconn"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiilimiter"
 	"github.com/k0msak007/kawaii-shop/pkg/utils"
 )
 
@@ -21,39 +36,130 @@ const (
 	paramsCheckErr middlewaresHandlersError = "router-003"
 	authorizeErr   middlewaresHandlersError = "router-004"
 	apiKeyErr      middlewaresHandlersError = "router-005"
+	rateLimitErr   middlewaresHandlersError = "router-006"
+	idempotencyErr middlewaresHandlersError = "router-007"
 )
 
 type IMiddlewaresHandler interface {
 	Cors() fiber.Handler
+	SecurityHeaders() fiber.Handler
+	RealIP() fiber.Handler
 	RouterCheck() fiber.Handler
 	Logger() fiber.Handler
 	JwtAuth() fiber.Handler
+	OptionalJwtAuth() fiber.Handler
 	ParamsCheck() fiber.Handler
 	Authorize(expectRoleId ...int) fiber.Handler
 	ApiKeyAuth() fiber.Handler
+	RateLimit(policy string) fiber.Handler
+	Idempotency() fiber.Handler
+	RequestContext() fiber.Handler
 }
 
 type middlewaresHandler struct {
 	cfg                 config.IConfig
 	middlewaresUsecases middlewaresUsecases.IMiddlewaresUsecases
+	limiter             kawaiilimiter.IStore
+	idempotencyLocks    *keyLocker
 }
 
-func MiddlewaresHandler(cfg config.IConfig, middlewaresUsecases middlewaresUsecases.IMiddlewaresUsecases) IMiddlewaresHandler {
+func MiddlewaresHandler(cfg config.IConfig, middlewaresUsecases middlewaresUsecases.IMiddlewaresUsecases, limiter kawaiilimiter.IStore) IMiddlewaresHandler {
 	return &middlewaresHandler{
 		cfg:                 cfg,
 		middlewaresUsecases: middlewaresUsecases,
+		limiter:             limiter,
+		idempotencyLocks:    &keyLocker{locks: make(map[string]*keyLock)},
 	}
 }
 
+type corsHandler struct {
+	allowOrigins string
+	handler      fiber.Handler
+}
+
 func (h *middlewaresHandler) Cors() fiber.Handler {
-	return cors.New(cors.Config{
-		Next:          cors.ConfigDefault.Next,
-		AllowOrigins:  "*",
-		AllowHeaders:  "",
-		AllowMethods:  "GET, POST, HEAD, PUT, DELETE, PATCH",
-		ExposeHeaders: "",
-		MaxAge:        0,
-	})
+	// Allowed origins can be reloaded, the cors handler is rebuilt when
+	// they change.
+	current := new(atomic.Pointer[corsHandler])
+
+	return func(c *fiber.Ctx) error {
+		allowOrigins := h.cfg.Cors().AllowOrigins()
+
+		ch := current.Load()
+		if ch == nil || ch.allowOrigins != allowOrigins {
+			ch = &corsHandler{
+				allowOrigins: allowOrigins,
+				handler: cors.New(cors.Config{
+					Next:             cors.ConfigDefault.Next,
+					AllowOrigins:     allowOrigins,
+					AllowHeaders:     h.cfg.Cors().AllowHeaders(),
+					AllowMethods:     h.cfg.Cors().AllowMethods(),
+					AllowCredentials: h.cfg.Cors().AllowCredentials(),
+					ExposeHeaders:    h.cfg.Cors().ExposeHeaders(),
+					MaxAge:           int(h.cfg.Cors().MaxAge().Seconds()),
+				}),
+			}
+			current.Store(ch)
+		}
+		return ch.handler(c)
+	}
+}
+
+func (h *middlewaresHandler) SecurityHeaders() fiber.Handler {
+	hsts := ""
+	if h.cfg.Security().HSTSMaxAge() > 0 {
+		hsts = fmt.Sprintf("max-age=%d; includeSubDomains", int(h.cfg.Security().HSTSMaxAge().Seconds()))
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		c.Set(fiber.HeaderXFrameOptions, h.cfg.Security().FrameOptions())
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+		if hsts != "" {
+			c.Set(fiber.HeaderStrictTransportSecurity, hsts)
+		}
+
+		err := c.Next()
+
+		// JSON is never rendered, nothing is allowed to load from it
+		if strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+			c.Set(fiber.HeaderContentSecurityPolicy, h.cfg.Security().ContentSecurityPolicy())
+		}
+		return err
+	}
+}
+
+// RealIP resolves the client ip once, X-Forwarded-For is only believed when
+// the request comes through a trusted proxy.
+func (h *middlewaresHandler) RealIP() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("clientIp", utils.ClientIP(
+			c.Context().RemoteIP(),
+			c.Get(fiber.HeaderXForwardedFor),
+			h.cfg.App().TrustedProxies(),
+		))
+		return c.Next()
+	}
+}
+
+// disconnectInterval is how often RequestContext looks for a client that
+// went away
+const disconnectInterval = 500 * time.Millisecond
+
+// RequestContext sets the user context of the request, it is done when the
+// client disconnects, APP_WRITE_TIMEOUT passes or the server shuts down.
+// Long running handlers pass c.UserContext() on to stop their work.
+func (h *middlewaresHandler) RequestContext() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.Context(), h.cfg.App().WriteTimeOut())
+		defer cancel()
+
+		ctx, stop := kawaiiconn.WithDisconnect(ctx, c.Context().Conn(), disconnectInterval)
+		defer stop()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
 }
 
 func (h *middlewaresHandler) RouterCheck() fiber.Handler {
@@ -68,7 +174,7 @@ func (h *middlewaresHandler) RouterCheck() fiber.Handler {
 
 func (h *middlewaresHandler) Logger() fiber.Handler {
 	return logger.New(logger.Config{
-		Format:     "${time} [${ip}] ${status} - ${method} ${path} \n",
+		Format:     "${time} [${locals:clientIp}] ${status} - ${method} ${path} \n",
 		TimeFormat: "02/01/2006",
 		TimeZone:   "Asia/Bangkok",
 	})
@@ -103,6 +209,18 @@ func (h *middlewaresHandler) JwtAuth() fiber.Handler {
 	}
 }
 
+// OptionalJwtAuth lets requests without an Authorization header through
+// anonymously, a header that is sent must hold a valid token
+func (h *middlewaresHandler) OptionalJwtAuth() fiber.Handler {
+	auth := h.JwtAuth()
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+		return auth(c)
+	}
+}
+
 func (h *middlewaresHandler) ParamsCheck() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userId := c.Locals("userId").(string)
@@ -173,3 +291,254 @@ func (h *middlewaresHandler) ApiKeyAuth() fiber.Handler {
 		return c.Next()
 	}
 }
+
+func (h *middlewaresHandler) RateLimit(policy string) fiber.Handler {
+	p := h.cfg.RateLimit().Policy(policy)
+	if p == nil {
+		log.Printf("rate limit policy %q is not configured, skipped", policy)
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	bucket := &kawaiilimiter.Bucket{
+		Limit:  p.Limit,
+		Period: p.Period,
+		Burst:  p.Burst,
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := fmt.Sprintf("%s:%s", p.Name, rateLimitKey(c, p.KeyBy))
+
+		result, err := h.limiter.Take(key, bucket)
+		if err != nil {
+			// Do not lock everyone out when the store is down
+			log.Printf("rate limit store failed: %v", err)
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds()))))
+
+		if !result.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			return entities.NewResponse(c).Error(
+				fiber.ErrTooManyRequests.Code,
+				string(rateLimitErr),
+				"too many requests",
+			).Res()
+		}
+		return c.Next()
+	}
+}
+
+// rateLimitKey falls back to the client ip when the request does not carry
+// the expected identity, e.g. a user policy in front of JwtAuth.
+func rateLimitKey(c *fiber.Ctx, keyBy string) string {
+	switch keyBy {
+	case "user":
+		if userId, ok := c.Locals("userId").(string); ok && userId != "" {
+			return "user:" + userId
+		}
+	case "apikey":
+		if key := c.Get("X-Api-Key"); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			return "apikey:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return "ip:" + utils.RealIP(c)
+}
+
+func (h *middlewaresHandler) Idempotency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		idempotencyKey := c.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			return c.Next()
+		}
+		if len(idempotencyKey) > 255 {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(idempotencyErr),
+				"Idempotency-Key must not be longer than 255 characters",
+			).Res()
+		}
+
+		// The same key from another client or on another route is another key.
+		// Signed out clients are told apart by api key since their ip may
+		// change between retries.
+		client := rateLimitKey(c, "user")
+		if _, ok := c.Locals("userId").(string); !ok {
+			client = rateLimitKey(c, "apikey")
+		}
+		key := sha256Hex(client, c.Method(), c.Path(), idempotencyKey)
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(idempotencyErr),
+				err.Error(),
+			).Res()
+		}
+
+		// Requests carrying the same key run one at a time on this instance,
+		// other instances see the unfinished record and get a conflict.
+		unlock := h.idempotencyLocks.lock(key)
+		defer unlock()
+
+		inserted, err := h.middlewaresUsecases.InsertIdempotencyKey(key, fingerprint, h.cfg.App().IdempotencyTTL())
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(idempotencyErr),
+				err.Error(),
+			).Res()
+		}
+
+		if !inserted {
+			record, err := h.middlewaresUsecases.FindIdempotencyKey(key)
+			if err != nil {
+				return entities.NewResponse(c).Error(
+					fiber.ErrInternalServerError.Code,
+					string(idempotencyErr),
+					err.Error(),
+				).Res()
+			}
+
+			if record.Fingerprint != fingerprint {
+				return entities.NewResponse(c).Error(
+					fiber.ErrConflict.Code,
+					string(idempotencyErr),
+					"Idempotency-Key has been used with another request",
+				).Res()
+			}
+			if !record.Completed {
+				return entities.NewResponse(c).Error(
+					fiber.ErrConflict.Code,
+					string(idempotencyErr),
+					"request with this Idempotency-Key is still in progress",
+				).Res()
+			}
+
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, record.ContentType)
+			return c.Status(record.StatusCode).Send(record.Response)
+		}
+
+		if err := c.Next(); err != nil {
+			h.releaseIdempotencyKey(key)
+			return err
+		}
+
+		// Server errors are not stored so the client can retry
+		if c.Response().StatusCode() >= fiber.StatusInternalServerError {
+			h.releaseIdempotencyKey(key)
+			return nil
+		}
+
+		if err := h.middlewaresUsecases.UpdateIdempotencyKey(&middlewares.IdempotencyKey{
+			Key:         key,
+			StatusCode:  c.Response().StatusCode(),
+			ContentType: string(c.Response().Header.ContentType()),
+			Response:    append([]byte(nil), c.Response().Body()...),
+		}); err != nil {
+			log.Printf("save idempotent response failed: %v", err)
+			h.releaseIdempotencyKey(key)
+		}
+		return nil
+	}
+}
+
+func (h *middlewaresHandler) releaseIdempotencyKey(key string) {
+	if err := h.middlewaresUsecases.DeleteIdempotencyKey(key); err != nil {
+		log.Printf("release idempotency key failed: %v", err)
+	}
+}
+
+// requestFingerprint hashes the method, path and body of the request.
+// Multipart bodies are hashed field by field because a retry may come with
+// another boundary.
+func requestFingerprint(c *fiber.Ctx) (string, error) {
+	if !strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		return sha256Hex(c.Method(), c.Path(), string(c.Body())), nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{c.Method(), c.Path()}
+	for _, k := range sortedKeys(form.Value) {
+		parts = append(parts, k, strings.Join(form.Value[k], ","))
+	}
+	for _, k := range sortedKeys(form.File) {
+		for _, f := range form.File[k] {
+			file, err := f.Open()
+			if err != nil {
+				return "", err
+			}
+
+			hash := sha256.New()
+			_, err = io.Copy(hash, file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, k, f.Filename, hex.EncodeToString(hash.Sum(nil)))
+		}
+	}
+	return sha256Hex(parts...), nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sha256Hex(parts ...string) string {
+	hash := sha256.New()
+	for _, p := range parts {
+		hash.Write([]byte(p))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// keyLocker hands out one mutex per key and forgets it once nobody waits.
+type keyLocker struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+func (l *keyLocker) lock(key string) func() {
+	l.mu.Lock()
+	k, ok := l.locks[key]
+	if !ok {
+		k = new(keyLock)
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+
+	k.mu.Lock()
+	return func() {
+		k.mu.Unlock()
+
+		l.mu.Lock()
+		k.refs--
+		if k.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -1,7 +1,10 @@
 package middlewaresRepositories
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares"
@@ -10,6 +13,10 @@ import (
 type IMiddlewaresRepository interface {
 	FindAccessToken(userId, accessToken string) bool
 	FindRole() ([]*middlewares.Role, error)
+	InsertIdempotencyKey(key, fingerprint string, ttl time.Duration) (bool, error)
+	FindIdempotencyKey(key string) (*middlewares.IdempotencyKey, error)
+	UpdateIdempotencyKey(req *middlewares.IdempotencyKey) error
+	DeleteIdempotencyKey(key string) error
 }
 
 type middlewaresRepository struct {
@@ -53,3 +60,81 @@ func (r *middlewaresRepository) FindRole() ([]*middlewares.Role, error) {
 
 	return roles, nil
 }
+
+// InsertIdempotencyKey claims the key, an expired key can be claimed again.
+// It returns false when the key is already held by another request.
+func (r *middlewaresRepository) InsertIdempotencyKey(key, fingerprint string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO "idempotency_keys" (
+			"key",
+			"fingerprint",
+			"expires_at"
+		)
+		VALUES ($1, $2, now() + $3 * INTERVAL '1 second')
+		ON CONFLICT ("key") DO UPDATE SET
+			"fingerprint" = EXCLUDED."fingerprint",
+			"status_code" = NULL,
+			"content_type" = NULL,
+			"response" = NULL,
+			"completed" = FALSE,
+			"expires_at" = EXCLUDED."expires_at"
+		WHERE "idempotency_keys"."expires_at" < now()
+		RETURNING "key";`
+
+	var inserted string
+	if err := r.db.QueryRowContext(ctx, query, key, fingerprint, ttl.Seconds()).Scan(&inserted); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("insert idempotency key failed: %v", err)
+	}
+	return true, nil
+}
+
+func (r *middlewaresRepository) FindIdempotencyKey(key string) (*middlewares.IdempotencyKey, error) {
+	query := `
+	SELECT
+		"key",
+		"fingerprint",
+		COALESCE("status_code", 0) AS "status_code",
+		COALESCE("content_type", '') AS "content_type",
+		COALESCE("response", ''::BYTEA) AS "response",
+		"completed"
+	FROM "idempotency_keys"
+	WHERE "key" = $1;`
+
+	record := new(middlewares.IdempotencyKey)
+	if err := r.db.Get(record, query, key); err != nil {
+		return nil, fmt.Errorf("get idempotency key failed: %v", err)
+	}
+	return record, nil
+}
+
+func (r *middlewaresRepository) UpdateIdempotencyKey(req *middlewares.IdempotencyKey) error {
+	query := `
+	UPDATE "idempotency_keys" SET
+		"status_code" = :status_code,
+		"content_type" = :content_type,
+		"response" = :response,
+		"completed" = TRUE
+	WHERE "key" = :key;`
+
+	if _, err := r.db.NamedExecContext(context.Background(), query, req); err != nil {
+		return fmt.Errorf("update idempotency key failed: %v", err)
+	}
+	return nil
+}
+
+func (r *middlewaresRepository) DeleteIdempotencyKey(key string) error {
+	query := `
+	DELETE FROM "idempotency_keys"
+	WHERE "key" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, key); err != nil {
+		return fmt.Errorf("delete idempotency key failed: %v", err)
+	}
+	return nil
+}
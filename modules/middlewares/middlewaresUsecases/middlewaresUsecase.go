@@ -1,6 +1,8 @@
 package middlewaresUsecases
 
 import (
+	"time"
+
 	"github.com/k0msak007/kawaii-shop/modules/middlewares"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresRepositories"
 )
@@ -8,6 +10,10 @@ import (
 type IMiddlewaresUsecases interface {
 	FindAccessToken(userId, access_token string) bool
 	FindRole() ([]*middlewares.Role, error)
+	InsertIdempotencyKey(key, fingerprint string, ttl time.Duration) (bool, error)
+	FindIdempotencyKey(key string) (*middlewares.IdempotencyKey, error)
+	UpdateIdempotencyKey(req *middlewares.IdempotencyKey) error
+	DeleteIdempotencyKey(key string) error
 }
 
 type middlewaresUsecases struct {
@@ -32,3 +38,32 @@ func (u *middlewaresUsecases) FindRole() ([]*middlewares.Role, error) {
 
 	return roles, nil
 }
+
+func (u *middlewaresUsecases) InsertIdempotencyKey(key, fingerprint string, ttl time.Duration) (bool, error) {
+	return u.middlewaresRepository.InsertIdempotencyKey(key, fingerprint, ttl)
+}
+
+func (u *middlewaresUsecases) FindIdempotencyKey(key string) (*middlewares.IdempotencyKey, error) {
+	record, err := u.middlewaresRepository.FindIdempotencyKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (u *middlewaresUsecases) UpdateIdempotencyKey(req *middlewares.IdempotencyKey) error {
+	if err := u.middlewaresRepository.UpdateIdempotencyKey(req); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u *middlewaresUsecases) DeleteIdempotencyKey(key string) error {
+	if err := u.middlewaresRepository.DeleteIdempotencyKey(key); err != nil {
+		return err
+	}
+
+	return nil
+}
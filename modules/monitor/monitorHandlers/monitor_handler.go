@@ -5,19 +5,29 @@ import (
 	"github.com/k0msak007/kawaii-shop/config"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
 	"github.com/k0msak007/kawaii-shop/modules/monitor"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiishutdown"
+)
+
+type monitorHandlersErrCode string
+
+const (
+	readinessCheckErr monitorHandlersErrCode = "monitor-001"
 )
 
 type IMonitorHandler interface {
 	HealthCheck(c *fiber.Ctx) error
+	ReadinessCheck(c *fiber.Ctx) error
 }
 
 type monitorHandler struct {
-	cfg config.IConfig
+	cfg      config.IConfig
+	shutdown kawaiishutdown.IShutdown
 }
 
-func MonitorHandler(cfg config.IConfig) IMonitorHandler {
+func MonitorHandler(cfg config.IConfig, shutdown kawaiishutdown.IShutdown) IMonitorHandler {
 	return &monitorHandler{
-		cfg: cfg,
+		cfg:      cfg,
+		shutdown: shutdown,
 	}
 }
 
@@ -29,3 +39,20 @@ func (h *monitorHandler) HealthCheck(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
 }
+
+func (h *monitorHandler) ReadinessCheck(c *fiber.Ctx) error {
+	if h.shutdown.Draining() {
+		return entities.NewResponse(c).Error(
+			fiber.ErrServiceUnavailable.Code,
+			string(readinessCheckErr),
+			"server is shutting down",
+		).Res()
+	}
+
+	res := monitor.Monitor{
+		Name:    h.cfg.App().Name(),
+		Version: h.cfg.App().Version(),
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
@@ -0,0 +1,174 @@
+package payments
+
+import (
+	"errors"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+)
+
+var (
+	ErrPaymentNotFound  = errors.New("payment not found")
+	ErrPaymentInvalid   = errors.New("payment is invalid")
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrOrderNotPayable  = errors.New("only waiting orders can be paid")
+	ErrOrderAmount      = errors.New("order amount is invalid")
+	ErrPaymentExists    = errors.New("order has a payment in progress or paid")
+	ErrAmountInvalid    = errors.New("amount is more than what is left")
+	ErrProviderMismatch = errors.New("webhook is not for the configured provider")
+)
+
+// StatusRefunded is a payment refunded in full, on top of the statuses of
+// kawaiipayment
+const StatusRefunded kawaiipayment.Status = "refunded"
+
+// Kinds of ledger entries
+const (
+	EntryAuthorization = "authorization"
+	EntryCapture       = "capture"
+	EntryRefund        = "refund"
+	EntryFailure       = "failure"
+	EntryCancel        = "cancel"
+)
+
+type Payment struct {
+	Id      string `json:"id"`
+	OrderId string `json:"order_id"`
+	// UserId owns the order
+	UserId      string               `json:"user_id"`
+	Provider    string               `json:"provider"`
+	ProviderRef string               `json:"provider_ref"`
+	Method      string               `json:"method"`
+	Status      kawaiipayment.Status `json:"status"`
+	Amount      *kawaiimoney.Money   `json:"amount"`
+	// Discount is what the coupon took off the order, Amount is what is
+	// left to pay
+	Discount     *kawaiimoney.Money `json:"discount"`
+	RedemptionId *string            `json:"redemption_id"`
+	Captured     *kawaiimoney.Money `json:"captured"`
+	Refunded     *kawaiimoney.Money `json:"refunded"`
+	QrCodeUrl    *string            `json:"qr_code_url"`
+	ExpiresAt    *time.Time         `json:"expires_at"`
+	CreatedAt    string             `json:"created_at"`
+	UpdatedAt    string             `json:"updated_at"`
+	Ledger       []*Entry           `json:"ledger"`
+}
+
+// Format fills the decimal and display forms of the amounts
+func (p *Payment) Format() {
+	c, err := kawaiimoney.Lookup(p.Amount.Currency)
+	if err != nil {
+		return
+	}
+	for _, m := range []**kawaiimoney.Money{&p.Amount, &p.Discount, &p.Captured, &p.Refunded} {
+		if *m != nil {
+			*m = kawaiimoney.New((*m).Amount, c)
+		}
+	}
+	for _, e := range p.Ledger {
+		e.Amount = kawaiimoney.New(e.Amount.Amount, c)
+	}
+}
+
+// Open tells a payment blocks another one of its order
+func (p *Payment) Open() bool {
+	return p.Status != kawaiipayment.StatusFailed && p.Status != kawaiipayment.StatusCanceled
+}
+
+// Entry is a line of the ledger of a payment. Captures are positive and
+// refunds negative, authorizations and failures do not move money.
+// Reference is the provider id of what it records.
+type Entry struct {
+	Id        string             `json:"id"`
+	Kind      string             `json:"kind"`
+	Amount    *kawaiimoney.Money `json:"amount"`
+	Reference string             `json:"reference"`
+	EventId   *string            `json:"event_id"`
+	CreatedAt string             `json:"created_at"`
+}
+
+// PaymentReq pays an order of the signed in user
+type PaymentReq struct {
+	OrderId string `json:"order_id" validate:"required"`
+	Method  string `json:"method" validate:"required,enum=promptpay|card"`
+	// Token of the card from the client library of the provider
+	Token string `json:"token" validate:"max=255"`
+	// Capture a card payment at once, true when empty
+	Capture *bool `json:"capture"`
+	// Coupon to take off the order, a use of it is held while the payment
+	// is open and given back when it fails or is canceled
+	Coupon string `json:"coupon" validate:"max=50"`
+}
+
+// AmountReq captures or refunds Amount minor units, all that is left when
+// zero
+type AmountReq struct {
+	Amount int64 `json:"amount" validate:"min=0"`
+}
+
+type PaymentFilter struct {
+	OrderId string `query:"order_id" validate:"required"`
+}
+
+// SimulateReq plays the payer of a pending payment with the mock provider
+type SimulateReq struct {
+	Status string `json:"status" validate:"required,enum=succeeded|failed"`
+}
+
+// WebhookRes acknowledges a webhook, Duplicate when it was processed before
+type WebhookRes struct {
+	EventId   string `json:"event_id"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// Entries are the ledger lines an event records, inserting one twice is a
+// no-op so an event reported by the api and again by a webhook counts once
+func Entries(e *kawaiipayment.Event) []*EntryRow {
+	switch e.Type {
+	case kawaiipayment.EventIntent:
+		switch e.Status {
+		case kawaiipayment.StatusAuthorized:
+			return []*EntryRow{{Kind: EntryAuthorization, Amount: e.Amount, Reference: e.IntentId}}
+		case kawaiipayment.StatusSucceeded:
+			return []*EntryRow{{Kind: EntryCapture, Amount: e.Amount, Reference: e.IntentId}}
+		case kawaiipayment.StatusFailed:
+			return []*EntryRow{{Kind: EntryFailure, Reference: e.IntentId}}
+		case kawaiipayment.StatusCanceled:
+			return []*EntryRow{{Kind: EntryCancel, Reference: e.IntentId}}
+		}
+	case kawaiipayment.EventRefund:
+		return []*EntryRow{{Kind: EntryRefund, Amount: -e.Amount, Reference: e.RefundId}}
+	}
+	return nil
+}
+
+// EntryRow is an entry to insert, in the currency of its payment
+type EntryRow struct {
+	Kind      string
+	Amount    int64
+	Reference string
+}
+
+var statusRank = map[kawaiipayment.Status]int{
+	kawaiipayment.StatusPending:    0,
+	kawaiipayment.StatusAuthorized: 1,
+	kawaiipayment.StatusSucceeded:  2,
+	kawaiipayment.StatusFailed:     2,
+	kawaiipayment.StatusCanceled:   2,
+	StatusRefunded:                 3,
+}
+
+// NextStatus is the status of a payment after an event. Webhooks come in
+// any order, a payment only moves forward so a late pending cannot undo a
+// success. It is refunded once refunds add up to the captured amount.
+func NextStatus(current kawaiipayment.Status, e *kawaiipayment.Event, captured, refunded int64) kawaiipayment.Status {
+	next := current
+	if e.Type == kawaiipayment.EventIntent && statusRank[e.Status] > statusRank[current] {
+		next = e.Status
+	}
+	if captured > 0 && refunded >= captured {
+		next = StatusRefunded
+	}
+	return next
+}
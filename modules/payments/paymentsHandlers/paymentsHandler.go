@@ -0,0 +1,260 @@
+package paymentsHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/payments"
+	"github.com/k0msak007/kawaii-shop/modules/payments/paymentsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type paymentsHandlersErrCode string
+
+const (
+	createPaymentErr   paymentsHandlersErrCode = "payments-001"
+	findPaymentsErr    paymentsHandlersErrCode = "payments-002"
+	findOnePaymentErr  paymentsHandlersErrCode = "payments-003"
+	capturePaymentErr  paymentsHandlersErrCode = "payments-004"
+	refundPaymentErr   paymentsHandlersErrCode = "payments-005"
+	webhookErr         paymentsHandlersErrCode = "payments-006"
+	simulatePaymentErr paymentsHandlersErrCode = "payments-007"
+)
+
+type IPaymentsHandler interface {
+	CreatePayment(c *fiber.Ctx) error
+	FindPayments(c *fiber.Ctx) error
+	FindOnePayment(c *fiber.Ctx) error
+	CapturePayment(c *fiber.Ctx) error
+	RefundPayment(c *fiber.Ctx) error
+	Webhook(c *fiber.Ctx) error
+	SimulatePayment(c *fiber.Ctx) error
+}
+
+type paymentsHandler struct {
+	cfg             config.IConfig
+	paymentsUsecase paymentsUsecases.IPaymentsUsecase
+}
+
+func PaymentsHandler(cfg config.IConfig, paymentsUsecase paymentsUsecases.IPaymentsUsecase) IPaymentsHandler {
+	return &paymentsHandler{
+		cfg:             cfg,
+		paymentsUsecase: paymentsUsecase,
+	}
+}
+
+func paymentsStatus(err error) int {
+	switch {
+	case errors.Is(err, payments.ErrPaymentNotFound),
+		errors.Is(err, payments.ErrOrderNotFound),
+		errors.Is(err, payments.ErrProviderMismatch),
+		errors.Is(err, promotions.ErrPromotionNotFound),
+		errors.Is(err, kawaiipayment.ErrNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, kawaiipayment.ErrSignature):
+		return fiber.ErrUnauthorized.Code
+	case errors.Is(err, payments.ErrPaymentInvalid),
+		errors.Is(err, payments.ErrAmountInvalid),
+		errors.Is(err, payments.ErrOrderAmount),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency):
+		return fiber.ErrBadRequest.Code
+	case errors.Is(err, payments.ErrOrderNotPayable),
+		errors.Is(err, payments.ErrPaymentExists),
+		errors.Is(err, promotions.ErrNotApplicable),
+		errors.Is(err, kawaiipayment.ErrInvalidState):
+		return fiber.ErrConflict.Code
+	case errors.Is(err, kawaiipayment.ErrProvider):
+		return fiber.ErrBadGateway.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// paymentId is the payment_id param, anything but a uuid cannot be a
+// payment
+func paymentId(c *fiber.Ctx) (string, error) {
+	id := c.Params("payment_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", payments.ErrPaymentNotFound
+	}
+	return id, nil
+}
+
+// viewer is the signed in user and whether they are an admin, who sees
+// the payments of every order
+func viewer(c *fiber.Ctx) (string, bool) {
+	userId := c.Locals("userId").(string)
+	roleId, _ := c.Locals("userRoleId").(int)
+	return userId, roleId == 2
+}
+
+// parseAmount reads the amount of a capture or refund, an empty body
+// takes all that is left
+func parseAmount(c *fiber.Ctx) (*payments.AmountReq, error) {
+	req := new(payments.AmountReq)
+	if len(c.Body()) == 0 {
+		return req, nil
+	}
+	if err := entities.ParseBody(c, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (h *paymentsHandler) CreatePayment(c *fiber.Ctx) error {
+	req := new(payments.PaymentReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(createPaymentErr), err).Res()
+	}
+
+	payment, err := h.paymentsUsecase.CreatePayment(c.UserContext(), c.Locals("userId").(string), req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(createPaymentErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, payment).Res()
+}
+
+func (h *paymentsHandler) FindPayments(c *fiber.Ctx) error {
+	req := new(payments.PaymentFilter)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findPaymentsErr), err).Res()
+	}
+
+	userId, admin := viewer(c)
+	res, err := h.paymentsUsecase.FindPayments(userId, admin, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(findPaymentsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *paymentsHandler) FindOnePayment(c *fiber.Ctx) error {
+	id, err := paymentId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findOnePaymentErr),
+			err.Error(),
+		).Res()
+	}
+
+	userId, admin := viewer(c)
+	payment, err := h.paymentsUsecase.FindOnePayment(userId, admin, id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(findOnePaymentErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, payment).Res()
+}
+
+func (h *paymentsHandler) CapturePayment(c *fiber.Ctx) error {
+	id, err := paymentId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(capturePaymentErr),
+			err.Error(),
+		).Res()
+	}
+
+	req, err := parseAmount(c)
+	if err != nil {
+		return entities.NewResponse(c).ParseError(string(capturePaymentErr), err).Res()
+	}
+
+	payment, err := h.paymentsUsecase.Capture(c.UserContext(), id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(capturePaymentErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, payment).Res()
+}
+
+func (h *paymentsHandler) RefundPayment(c *fiber.Ctx) error {
+	id, err := paymentId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(refundPaymentErr),
+			err.Error(),
+		).Res()
+	}
+
+	req, err := parseAmount(c)
+	if err != nil {
+		return entities.NewResponse(c).ParseError(string(refundPaymentErr), err).Res()
+	}
+
+	payment, err := h.paymentsUsecase.Refund(c.UserContext(), id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(refundPaymentErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, payment).Res()
+}
+
+// Webhook is called by the provider, the signature of the raw body is its
+// only authentication. Anything but 2xx makes the provider retry, so a
+// duplicate is acknowledged as well.
+func (h *paymentsHandler) Webhook(c *fiber.Ctx) error {
+	res, err := h.paymentsUsecase.HandleWebhook(c.UserContext(), c.Params("provider"), c.Body(), func(key string) string {
+		return c.Get(key)
+	})
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(webhookErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *paymentsHandler) SimulatePayment(c *fiber.Ctx) error {
+	id, err := paymentId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(simulatePaymentErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(payments.SimulateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(simulatePaymentErr), err).Res()
+	}
+
+	userId, admin := viewer(c)
+	payment, err := h.paymentsUsecase.Simulate(c.UserContext(), userId, admin, id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			paymentsStatus(err),
+			string(simulatePaymentErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, payment).Res()
+}
@@ -0,0 +1,386 @@
+package paymentsRepositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/payments"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+)
+
+type IPaymentsRepository interface {
+	FindOrderAmount(orderId, userId string) (int64, string, error)
+	FindOrderLines(orderId string) ([]*promotions.Line, error)
+	FindOnePayment(paymentId string) (*payments.Payment, error)
+	FindPayments(orderId string) ([]*payments.Payment, error)
+	InsertPayment(ctx context.Context, req *payments.Payment, intent *kawaiipayment.Event) (string, error)
+	// Apply records what the provider answered an api call with
+	Apply(ctx context.Context, paymentId string, e *kawaiipayment.Event) error
+	// ApplyEvent records a webhook event once, it tells when the event was
+	// processed before
+	ApplyEvent(ctx context.Context, provider string, e *kawaiipayment.Event, payload []byte) (bool, error)
+}
+
+type paymentsRepository struct {
+	db *sqlx.DB
+}
+
+func PaymentsRepository(db *sqlx.DB) IPaymentsRepository {
+	return &paymentsRepository{
+		db: db,
+	}
+}
+
+// linePrice is the unit price of a line of products_orders in minor units.
+// Lines ordered before prices had currencies hold major units of THB, as
+// migration 000010 took the catalog to be, see lineCurrency.
+const linePrice = `CASE
+	WHEN jsonb_typeof("po"."product"->'price') = 'object' THEN ("po"."product"->'price'->>'amount')::BIGINT
+	ELSE ROUND(("po"."product"->>'price')::NUMERIC * 100)::BIGINT
+END`
+
+// lineCurrency is the currency of linePrice
+const lineCurrency = `COALESCE("po"."product"->'price'->>'currency', 'THB')`
+
+// FindOrderAmount adds up the products of a waiting order of the user
+func (r *paymentsRepository) FindOrderAmount(orderId, userId string) (int64, string, error) {
+	query := `
+	SELECT
+		"o"."status",
+		COALESCE(SUM("po"."qty" * ` + linePrice + `), 0) AS "amount",
+		COUNT(DISTINCT ` + lineCurrency + `) AS "currencies",
+		COALESCE(MIN(` + lineCurrency + `), 'THB') AS "currency"
+	FROM "orders" "o"
+		LEFT JOIN "products_orders" "po" ON "po"."order_id" = "o"."id"
+	WHERE "o"."id" = $1
+	AND "o"."user_id" = $2
+	GROUP BY "o"."id";`
+
+	var order struct {
+		Status     string `db:"status"`
+		Amount     int64  `db:"amount"`
+		Currencies int    `db:"currencies"`
+		Currency   string `db:"currency"`
+	}
+	if err := r.db.Get(&order, query, orderId, userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", payments.ErrOrderNotFound
+		}
+		return 0, "", fmt.Errorf("get order amount failed: %v", err)
+	}
+
+	if order.Status != "waiting" {
+		return 0, "", payments.ErrOrderNotPayable
+	}
+	if order.Amount <= 0 || order.Currencies > 1 {
+		return 0, "", payments.ErrOrderAmount
+	}
+	return order.Amount, order.Currency, nil
+}
+
+// FindOrderLines prices the lines of an order as FindOrderAmount adds them
+// up, for a coupon to be evaluated against
+func (r *paymentsRepository) FindOrderLines(orderId string) ([]*promotions.Line, error) {
+	query := `
+	SELECT
+		COALESCE("po"."product"->>'id', '') AS "product_id",
+		COALESCE(("po"."product"->'category'->>'id')::INT, 0) AS "category_id",
+		"po"."qty",
+		` + linePrice + ` AS "unit_price"
+	FROM "products_orders" "po"
+	WHERE "po"."order_id" = $1
+	ORDER BY "po"."id";`
+
+	rows, err := r.db.Queryx(query, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("get order lines failed: %v", err)
+	}
+	defer rows.Close()
+
+	lines := make([]*promotions.Line, 0)
+	for rows.Next() {
+		line := new(promotions.Line)
+		if err := rows.Scan(&line.ProductId, &line.CategoryId, &line.Quantity, &line.UnitPrice); err != nil {
+			return nil, fmt.Errorf("scan order line failed: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get order lines failed: %v", err)
+	}
+	return lines, nil
+}
+
+// findPayments returns the payments matching where with their ledger,
+// args are its placeholders
+func (r *paymentsRepository) findPayments(where string, args ...any) ([]*payments.Payment, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY "t"."created_at")), '[]'::json)
+	FROM (
+		SELECT
+			"p"."id",
+			"p"."order_id",
+			"o"."user_id",
+			"p"."provider",
+			"p"."provider_ref",
+			"p"."method",
+			"p"."status",
+			jsonb_build_object('amount', "p"."amount", 'currency', "p"."currency") AS "amount",
+			jsonb_build_object('amount', "p"."discount", 'currency', "p"."currency") AS "discount",
+			"p"."redemption_id",
+			jsonb_build_object('amount', COALESCE((
+				SELECT SUM("l"."amount") FROM "payment_ledger" "l" WHERE "l"."payment_id" = "p"."id" AND "l"."kind" = 'capture'
+			), 0), 'currency', "p"."currency") AS "captured",
+			jsonb_build_object('amount', COALESCE(-(
+				SELECT SUM("l"."amount") FROM "payment_ledger" "l" WHERE "l"."payment_id" = "p"."id" AND "l"."kind" = 'refund'
+			), 0), 'currency', "p"."currency") AS "refunded",
+			"p"."qr_code_url",
+			"p"."expires_at",
+			"p"."created_at",
+			"p"."updated_at",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("lt" ORDER BY "lt"."created_at", "lt"."id")), '[]'::json)
+				FROM (
+					SELECT
+						"l"."id",
+						"l"."kind",
+						jsonb_build_object('amount', "l"."amount", 'currency', "l"."currency") AS "amount",
+						"l"."reference",
+						"l"."event_id",
+						"l"."created_at"
+					FROM "payment_ledger" "l"
+					WHERE "l"."payment_id" = "p"."id"
+				) AS "lt"
+			) AS "ledger"
+		FROM "payments" "p"
+			JOIN "orders" "o" ON "o"."id" = "p"."order_id"
+		WHERE 1 = 1
+		` + where + `
+	) AS "t";`
+
+	bytes := make([]byte, 0)
+	res := make([]*payments.Payment, 0)
+
+	if err := r.db.Get(&bytes, query, args...); err != nil {
+		return nil, fmt.Errorf("get payments failed: %v", err)
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, fmt.Errorf("unmarshal payments failed: %v", err)
+	}
+	return res, nil
+}
+
+func (r *paymentsRepository) FindOnePayment(paymentId string) (*payments.Payment, error) {
+	res, err := r.findPayments(` AND "p"."id" = $1`, paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, payments.ErrPaymentNotFound
+	}
+	return res[0], nil
+}
+
+func (r *paymentsRepository) FindPayments(orderId string) ([]*payments.Payment, error) {
+	return r.findPayments(` AND "p"."order_id" = $1`, orderId)
+}
+
+func (r *paymentsRepository) InsertPayment(ctx context.Context, req *payments.Payment, intent *kawaiipayment.Event) (string, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO "payments" (
+		"order_id",
+		"provider",
+		"provider_ref",
+		"method",
+		"status",
+		"amount",
+		"currency",
+		"qr_code_url",
+		"expires_at",
+		"redemption_id",
+		"discount"
+	)
+	VALUES ($1, $2, $3, $4, 'pending', $5, $6, $7, $8, $9, $10)
+	RETURNING "id";`
+
+	var discount int64
+	if req.Discount != nil {
+		discount = req.Discount.Amount
+	}
+
+	var id string
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		req.OrderId,
+		req.Provider,
+		req.ProviderRef,
+		req.Method,
+		req.Amount.Amount,
+		req.Amount.Currency,
+		req.QrCodeUrl,
+		req.ExpiresAt,
+		req.RedemptionId,
+		discount,
+	).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "payments_order_open_key" {
+			return "", payments.ErrPaymentExists
+		}
+		return "", fmt.Errorf("insert payment failed: %v", err)
+	}
+
+	if err := apply(ctx, tx, id, intent, nil); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit payment failed: %v", err)
+	}
+	return id, nil
+}
+
+func (r *paymentsRepository) Apply(ctx context.Context, paymentId string, e *kawaiipayment.Event) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := apply(ctx, tx, paymentId, e, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit payment failed: %v", err)
+	}
+	return nil
+}
+
+func (r *paymentsRepository) ApplyEvent(ctx context.Context, provider string, e *kawaiipayment.Event, payload []byte) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO "payment_events" (
+		"provider",
+		"event_id",
+		"type",
+		"payload"
+	)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT ("provider", "event_id") DO NOTHING;`
+
+	res, err := tx.ExecContext(ctx, query, provider, e.Id, e.Type, payload)
+	if err != nil {
+		return false, fmt.Errorf("insert payment event failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return true, nil
+	}
+
+	// Events of intents made elsewhere are kept but change nothing
+	var paymentId string
+	if err := tx.GetContext(ctx, &paymentId, `SELECT "id" FROM "payments" WHERE "provider" = $1 AND "provider_ref" = $2;`, provider, e.IntentId); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("get payment failed: %v", err)
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, `UPDATE "payment_events" SET "payment_id" = $3 WHERE "provider" = $1 AND "event_id" = $2;`, provider, e.Id, paymentId); err != nil {
+			return false, fmt.Errorf("update payment event failed: %v", err)
+		}
+		if err := apply(ctx, tx, paymentId, e, &e.Id); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit payment event failed: %v", err)
+	}
+	return false, nil
+}
+
+// apply records the ledger entries of an event and moves the payment to
+// its next status. The payment row is locked so concurrent events see
+// each other's entries.
+func apply(ctx context.Context, tx *sqlx.Tx, paymentId string, e *kawaiipayment.Event, eventId *string) error {
+	var p struct {
+		OrderId  string `db:"order_id"`
+		Status   string `db:"status"`
+		Currency string `db:"currency"`
+	}
+	if err := tx.GetContext(ctx, &p, `SELECT "order_id", "status", "currency" FROM "payments" WHERE "id" = $1 FOR UPDATE;`, paymentId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return payments.ErrPaymentNotFound
+		}
+		return fmt.Errorf("get payment failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "payment_ledger" (
+		"payment_id",
+		"order_id",
+		"kind",
+		"amount",
+		"currency",
+		"reference",
+		"event_id"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT ("payment_id", "kind", "reference") DO NOTHING;`
+
+	for _, entry := range payments.Entries(e) {
+		if _, err := tx.ExecContext(ctx, query, paymentId, p.OrderId, entry.Kind, entry.Amount, p.Currency, entry.Reference, eventId); err != nil {
+			return fmt.Errorf("insert payment ledger failed: %v", err)
+		}
+	}
+
+	var sums struct {
+		Captured int64 `db:"captured"`
+		Refunded int64 `db:"refunded"`
+	}
+	sumQuery := `
+	SELECT
+		COALESCE(SUM("amount") FILTER (WHERE "kind" = 'capture'), 0) AS "captured",
+		COALESCE(-SUM("amount") FILTER (WHERE "kind" = 'refund'), 0) AS "refunded"
+	FROM "payment_ledger"
+	WHERE "payment_id" = $1;`
+	if err := tx.GetContext(ctx, &sums, sumQuery, paymentId); err != nil {
+		return fmt.Errorf("get payment ledger failed: %v", err)
+	}
+
+	current := kawaiipayment.Status(p.Status)
+	next := payments.NextStatus(current, e, sums.Captured, sums.Refunded)
+	if next == current {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE "payments" SET "status" = $2 WHERE "id" = $1;`, paymentId, next); err != nil {
+		return fmt.Errorf("update payment failed: %v", err)
+	}
+
+	// The coupon of a payment that will not be made is given back, the
+	// redemption_id of the payment is set null with it
+	if next == kawaiipayment.StatusFailed || next == kawaiipayment.StatusCanceled {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "promotion_redemptions" WHERE "id" = (SELECT "redemption_id" FROM "payments" WHERE "id" = $1);`, paymentId); err != nil {
+			return fmt.Errorf("release payment coupon failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package paymentsUsecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/payments"
+	"github.com/k0msak007/kawaii-shop/modules/payments/paymentsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+)
+
+type IPaymentsUsecase interface {
+	CreatePayment(ctx context.Context, userId string, req *payments.PaymentReq) (*payments.Payment, error)
+	// FindOnePayment and FindPayments only show the payments of the orders
+	// of userId unless admin
+	FindOnePayment(userId string, admin bool, paymentId string) (*payments.Payment, error)
+	FindPayments(userId string, admin bool, req *payments.PaymentFilter) ([]*payments.Payment, error)
+	Capture(ctx context.Context, paymentId string, req *payments.AmountReq) (*payments.Payment, error)
+	Refund(ctx context.Context, paymentId string, req *payments.AmountReq) (*payments.Payment, error)
+	// HandleWebhook verifies and records a webhook of provider once
+	HandleWebhook(ctx context.Context, provider string, body []byte, header func(key string) string) (*payments.WebhookRes, error)
+	// Simulate plays the payer of a pending payment, only with the mock
+	// provider
+	Simulate(ctx context.Context, userId string, admin bool, paymentId string, req *payments.SimulateReq) (*payments.Payment, error)
+}
+
+type paymentsUsecase struct {
+	cfg                config.IConfig
+	paymentsRepository paymentsRepositories.IPaymentsRepository
+	promotionsUsecase  promotionsUsecases.IPromotionsUsecase
+	provider           kawaiipayment.IProvider
+}
+
+func PaymentsUsecase(cfg config.IConfig, paymentsRepository paymentsRepositories.IPaymentsRepository, promotionsUsecase promotionsUsecases.IPromotionsUsecase, provider kawaiipayment.IProvider) IPaymentsUsecase {
+	return &paymentsUsecase{
+		cfg:                cfg,
+		paymentsRepository: paymentsRepository,
+		promotionsUsecase:  promotionsUsecase,
+		provider:           provider,
+	}
+}
+
+// intentEvent is what the provider answered about an intent, recorded as
+// its webhook would be
+func intentEvent(intent *kawaiipayment.Intent) *kawaiipayment.Event {
+	return &kawaiipayment.Event{
+		Type:     kawaiipayment.EventIntent,
+		IntentId: intent.Id,
+		Status:   intent.Status,
+		Amount:   intent.Amount,
+	}
+}
+
+func (u *paymentsUsecase) CreatePayment(ctx context.Context, userId string, req *payments.PaymentReq) (*payments.Payment, error) {
+	if req.Method == kawaiipayment.MethodCard && req.Token == "" {
+		return nil, fmt.Errorf("%w, token is required for card", payments.ErrPaymentInvalid)
+	}
+
+	amount, currency, err := u.paymentsRepository.FindOrderAmount(req.OrderId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before the provider is asked so a paid order makes no
+	// intent, the insert checks again
+	existing, err := u.paymentsRepository.FindPayments(req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range existing {
+		if p.Open() {
+			return nil, payments.ErrPaymentExists
+		}
+	}
+
+	// The coupon is redeemed before the provider is asked so its limits
+	// hold however many payments race for the last use, it is given back
+	// if the payment is not made
+	var redemption *promotions.Redemption
+	if req.Coupon != "" {
+		if redemption, err = u.redeem(ctx, userId, req, amount, currency); err != nil {
+			return nil, err
+		}
+		amount -= redemption.Discount
+	}
+
+	id, err := u.insertPayment(ctx, req, amount, currency, redemption)
+	if err != nil {
+		if redemption != nil {
+			if err := u.promotionsUsecase.Release(context.WithoutCancel(ctx), redemption.Id); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	return u.findOnePayment(id)
+}
+
+// redeem holds a use of the coupon of req for the order, the discount is
+// taken off the lines as they were ordered
+func (u *paymentsUsecase) redeem(ctx context.Context, userId string, req *payments.PaymentReq, amount int64, currency string) (*promotions.Redemption, error) {
+	lines, err := u.paymentsRepository.FindOrderLines(req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := u.promotionsUsecase.EvaluateCoupon(userId, req.Coupon, &promotions.Cart{
+		Currency: currency,
+		Lines:    lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !e.Applied {
+		for _, r := range e.Reasons {
+			if !r.Passed {
+				return nil, fmt.Errorf("%w, %s", promotions.ErrNotApplicable, r.Message)
+			}
+		}
+		return nil, promotions.ErrNotApplicable
+	}
+	// A payment moves money, an order the coupon pays for in full has
+	// nothing to pay
+	if e.Discount.Amount >= amount {
+		return nil, fmt.Errorf("%w, the coupon covers the whole order", payments.ErrOrderAmount)
+	}
+
+	redemption := &promotions.Redemption{
+		PromotionId: e.PromotionId,
+		UserId:      userId,
+		OrderId:     &req.OrderId,
+		Discount:    e.Discount.Amount,
+		Currency:    currency,
+	}
+	if err := u.promotionsUsecase.Redeem(ctx, redemption); err != nil {
+		return nil, err
+	}
+	return redemption, nil
+}
+
+// insertPayment asks the provider for an intent of amount and records the
+// payment with it
+func (u *paymentsUsecase) insertPayment(ctx context.Context, req *payments.PaymentReq, amount int64, currency string, redemption *promotions.Redemption) (string, error) {
+	capture := true
+	if req.Capture != nil {
+		capture = *req.Capture
+	}
+	intent, err := u.provider.CreateIntent(ctx, &kawaiipayment.IntentReq{
+		Amount:    amount,
+		Currency:  currency,
+		Method:    req.Method,
+		Reference: req.OrderId,
+		Token:     req.Token,
+		Capture:   capture,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payment := &payments.Payment{
+		OrderId:     req.OrderId,
+		Provider:    u.provider.Name(),
+		ProviderRef: intent.Id,
+		Method:      req.Method,
+		Amount:      &kawaiimoney.Money{Amount: amount, Currency: currency},
+		ExpiresAt:   intent.ExpiresAt,
+	}
+	if intent.QrCodeUrl != "" {
+		payment.QrCodeUrl = &intent.QrCodeUrl
+	}
+	if redemption != nil {
+		payment.Discount = &kawaiimoney.Money{Amount: redemption.Discount, Currency: currency}
+		payment.RedemptionId = &redemption.Id
+	}
+	return u.paymentsRepository.InsertPayment(ctx, payment, intentEvent(intent))
+}
+
+func (u *paymentsUsecase) findOnePayment(paymentId string) (*payments.Payment, error) {
+	payment, err := u.paymentsRepository.FindOnePayment(paymentId)
+	if err != nil {
+		return nil, err
+	}
+	payment.Format()
+	return payment, nil
+}
+
+func (u *paymentsUsecase) FindOnePayment(userId string, admin bool, paymentId string) (*payments.Payment, error) {
+	payment, err := u.findOnePayment(paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if !admin && payment.UserId != userId {
+		return nil, payments.ErrPaymentNotFound
+	}
+	return payment, nil
+}
+
+func (u *paymentsUsecase) FindPayments(userId string, admin bool, req *payments.PaymentFilter) ([]*payments.Payment, error) {
+	found, err := u.paymentsRepository.FindPayments(req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*payments.Payment, 0, len(found))
+	for _, p := range found {
+		if !admin && p.UserId != userId {
+			continue
+		}
+		p.Format()
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+func (u *paymentsUsecase) Capture(ctx context.Context, paymentId string, req *payments.AmountReq) (*payments.Payment, error) {
+	payment, err := u.paymentsRepository.FindOnePayment(paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != kawaiipayment.StatusAuthorized {
+		return nil, fmt.Errorf("%w: payment is %s", kawaiipayment.ErrInvalidState, payment.Status)
+	}
+	if req.Amount > payment.Amount.Amount {
+		return nil, payments.ErrAmountInvalid
+	}
+
+	intent, err := u.provider.Capture(ctx, payment.ProviderRef, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.paymentsRepository.Apply(ctx, paymentId, intentEvent(intent)); err != nil {
+		return nil, err
+	}
+	return u.findOnePayment(paymentId)
+}
+
+func (u *paymentsUsecase) Refund(ctx context.Context, paymentId string, req *payments.AmountReq) (*payments.Payment, error) {
+	payment, err := u.paymentsRepository.FindOnePayment(paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	left := payment.Captured.Amount - payment.Refunded.Amount
+	amount := req.Amount
+	if amount == 0 {
+		amount = left
+	}
+	if left <= 0 || amount > left {
+		return nil, payments.ErrAmountInvalid
+	}
+
+	refund, err := u.provider.Refund(ctx, payment.ProviderRef, amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.paymentsRepository.Apply(ctx, paymentId, &kawaiipayment.Event{
+		Type:     kawaiipayment.EventRefund,
+		IntentId: payment.ProviderRef,
+		Amount:   refund.Amount,
+		RefundId: refund.Id,
+	}); err != nil {
+		return nil, err
+	}
+	return u.findOnePayment(paymentId)
+}
+
+func (u *paymentsUsecase) HandleWebhook(ctx context.Context, provider string, body []byte, header func(key string) string) (*payments.WebhookRes, error) {
+	if provider != u.provider.Name() {
+		return nil, payments.ErrProviderMismatch
+	}
+
+	e, err := u.provider.VerifyWebhook(body, header)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicate, err := u.paymentsRepository.ApplyEvent(ctx, provider, e, body)
+	if err != nil {
+		return nil, err
+	}
+	return &payments.WebhookRes{
+		EventId:   e.Id,
+		Duplicate: duplicate,
+	}, nil
+}
+
+func (u *paymentsUsecase) Simulate(ctx context.Context, userId string, admin bool, paymentId string, req *payments.SimulateReq) (*payments.Payment, error) {
+	mock, ok := u.provider.(kawaiipayment.IMockProvider)
+	if !ok {
+		return nil, payments.ErrProviderMismatch
+	}
+
+	payment, err := u.FindOnePayment(userId, admin, paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	body, signature, err := mock.Simulate(payment.ProviderRef, kawaiipayment.Status(req.Status))
+	if err != nil {
+		// Intents are in memory, a restart forgets them
+		if errors.Is(err, kawaiipayment.ErrNotFound) {
+			return nil, fmt.Errorf("%w, the mock provider restarted since it was made", kawaiipayment.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	// Goes through the webhook as the provider would send it
+	header := func(key string) string {
+		if key == kawaiipayment.MockSignatureHeader {
+			return signature
+		}
+		return ""
+	}
+	if _, err := u.HandleWebhook(ctx, mock.Name(), body, header); err != nil {
+		return nil, err
+	}
+	return u.findOnePayment(paymentId)
+}
@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+)
+
+func TestNextStatus(t *testing.T) {
+	intent := func(status kawaiipayment.Status) *kawaiipayment.Event {
+		return &kawaiipayment.Event{Type: kawaiipayment.EventIntent, Status: status}
+	}
+	refund := &kawaiipayment.Event{Type: kawaiipayment.EventRefund, Amount: 500}
+
+	tests := []struct {
+		name     string
+		current  kawaiipayment.Status
+		event    *kawaiipayment.Event
+		captured int64
+		refunded int64
+		want     kawaiipayment.Status
+	}{
+		{"authorized", kawaiipayment.StatusPending, intent(kawaiipayment.StatusAuthorized), 0, 0, kawaiipayment.StatusAuthorized},
+		{"captured", kawaiipayment.StatusAuthorized, intent(kawaiipayment.StatusSucceeded), 1000, 0, kawaiipayment.StatusSucceeded},
+		{"failed", kawaiipayment.StatusPending, intent(kawaiipayment.StatusFailed), 0, 0, kawaiipayment.StatusFailed},
+		{"late pending", kawaiipayment.StatusSucceeded, intent(kawaiipayment.StatusPending), 1000, 0, kawaiipayment.StatusSucceeded},
+		{"late authorized", kawaiipayment.StatusSucceeded, intent(kawaiipayment.StatusAuthorized), 1000, 0, kawaiipayment.StatusSucceeded},
+		{"no failure after success", kawaiipayment.StatusSucceeded, intent(kawaiipayment.StatusFailed), 1000, 0, kawaiipayment.StatusSucceeded},
+		{"partly refunded", kawaiipayment.StatusSucceeded, refund, 1000, 500, kawaiipayment.StatusSucceeded},
+		{"fully refunded", kawaiipayment.StatusSucceeded, refund, 1000, 1000, StatusRefunded},
+		{"stays refunded", StatusRefunded, intent(kawaiipayment.StatusSucceeded), 1000, 1000, StatusRefunded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextStatus(tt.current, tt.event, tt.captured, tt.refunded); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntries(t *testing.T) {
+	intent := func(status kawaiipayment.Status) *kawaiipayment.Event {
+		return &kawaiipayment.Event{Type: kawaiipayment.EventIntent, IntentId: "pi_1", Status: status, Amount: 1000}
+	}
+
+	tests := []struct {
+		name  string
+		event *kawaiipayment.Event
+		want  []*EntryRow
+	}{
+		{"pending", intent(kawaiipayment.StatusPending), nil},
+		{"authorized", intent(kawaiipayment.StatusAuthorized), []*EntryRow{{Kind: EntryAuthorization, Amount: 1000, Reference: "pi_1"}}},
+		{"succeeded", intent(kawaiipayment.StatusSucceeded), []*EntryRow{{Kind: EntryCapture, Amount: 1000, Reference: "pi_1"}}},
+		{"failed", intent(kawaiipayment.StatusFailed), []*EntryRow{{Kind: EntryFailure, Reference: "pi_1"}}},
+		{"canceled", intent(kawaiipayment.StatusCanceled), []*EntryRow{{Kind: EntryCancel, Reference: "pi_1"}}},
+		{
+			"refund",
+			&kawaiipayment.Event{Type: kawaiipayment.EventRefund, IntentId: "pi_1", RefundId: "re_1", Amount: 400},
+			[]*EntryRow{{Kind: EntryRefund, Amount: -400, Reference: "re_1"}},
+		},
+		{"unknown", &kawaiipayment.Event{Type: "dispute"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Entries(tt.event); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,544 @@
 package products
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
 	"github.com/k0msak007/kawaii-shop/modules/appinfo"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrImageNotFound   = errors.New("image not found")
+	ErrImageOrder      = errors.New("image ids must list every image of the product once")
+
+	ErrOptionsInvalid   = errors.New("options are invalid")
+	ErrVariantNotFound  = errors.New("variant not found")
+	ErrVariantRequired  = errors.New("product has variants, pick one")
+	ErrVariantOptions   = errors.New("variant options do not match the product options")
+	ErrVariantDuplicate = errors.New("a variant with these options exists")
+	ErrVariantImage     = errors.New("variant image is not an image of the product")
+	ErrSkuTaken         = errors.New("sku has been used")
+
+	ErrPriceNotFound  = errors.New("price not found")
+	ErrPriceCurrency  = errors.New("price list currency must differ from the product currency")
+	ErrCurrencyPriced = errors.New("currency of a product with variant or price list prices cannot change")
+
+	ErrCatalogInvalid = errors.New("catalog is invalid")
 )
 
 type Product struct {
-	Id          string            `json:"id"`
-	Title       string            `json:"title"`
-	Description string            `json:"description"`
-	Category    *appinfo.Category `json:"category"`
-	CreatedAt   string            `json:"created_at"`
-	UpdatedAt   string            `json:"updated_at"`
-	Price       float64           `json:"price"`
-	Image       []*entities.Image `json:"images"`
+	Id          string             `json:"id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Category    *appinfo.Category  `json:"category"`
+	CreatedAt   string             `json:"created_at"`
+	UpdatedAt   string             `json:"updated_at"`
+	Price       *kawaiimoney.Money `json:"price"`
+	// Weight in grams, shipping rates are picked by it
+	Weight   int               `json:"weight"`
+	Image    []*entities.Image `json:"images"`
+	Options  []*Option         `json:"options"`
+	Variants []*Variant        `json:"variants"`
+	// Prices is the price list, fixed prices in other currencies
+	Prices []*Price `json:"prices"`
+	// Lowest and highest price of the variants, the price of the product
+	// when it has none
+	MinPrice *kawaiimoney.Money `json:"min_price"`
+	MaxPrice *kawaiimoney.Money `json:"max_price"`
+	// Average and number of the approved reviews, 0 without any
+	RatingAvg   float64 `json:"rating_avg"`
+	RatingCount int     `json:"rating_count"`
+	// Favorited tells whether the signed in user wishlisted the product,
+	// left out for anonymous requests
+	Favorited *bool `json:"favorited,omitempty"`
+}
+
+// Price is a fixed price of the product, or of one variant, in a currency
+type Price struct {
+	VariantId *string            `json:"variant_id"`
+	Price     *kawaiimoney.Money `json:"price"`
+}
+
+// Option is an axis the variants of a product differ in
+type Option struct {
+	Name   string   `json:"name" validate:"required,max=50"`
+	Values []string `json:"values" validate:"required"`
+}
+
+type Variant struct {
+	Id      string            `json:"id"`
+	Sku     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	// Price is what the variant sells for, the override or the price of
+	// the product
+	Price *kawaiimoney.Money `json:"price"`
+	// PriceOverride is in the currency of the product
+	PriceOverride *kawaiimoney.Money `json:"price_override"`
+	Stock         int                `json:"stock"`
+	ImageId       *string            `json:"image_id"`
+}
+
+// OptionsReq replaces the option axes of a product
+type OptionsReq struct {
+	Options []*Option `json:"options"`
+}
+
+// VariantReq creates a variant or replaces one, Options holds one value of
+// every option of the product.
+type VariantReq struct {
+	Sku     string            `json:"sku" validate:"required,max=64"`
+	Options map[string]string `json:"options"`
+	// Minor units in the currency of the product, empty uses the price of
+	// the product
+	Price   *int64  `json:"price" validate:"min=0"`
+	Stock   int     `json:"stock" validate:"min=0"`
+	ImageId *string `json:"image_id" validate:"uuid"`
+}
+
+// CheckOptions tells whether values picks exactly one allowed value of
+// every option.
+func CheckOptions(options []*Option, values map[string]string) error {
+	if len(values) != len(options) {
+		return fmt.Errorf("%w, one value of every option is required", ErrVariantOptions)
+	}
+	for _, o := range options {
+		v, ok := values[o.Name]
+		if !ok {
+			return fmt.Errorf("%w, %s is missing", ErrVariantOptions, o.Name)
+		}
+		if !slices.Contains(o.Values, v) {
+			return fmt.Errorf("%w, %s must be one of %s", ErrVariantOptions, o.Name, strings.Join(o.Values, ", "))
+		}
+	}
+	return nil
 }
 
 type ProductFilter struct {
 	Id     string `query:"id"`
 	Search string `query:"search"`
+	// Products whose variant price range overlaps min_price to max_price,
+	// in major units of currency
+	MinPrice *float64 `query:"min_price" validate:"min=0"`
+	MaxPrice *float64 `query:"max_price" validate:"min=0"`
+	// Products whose approved reviews average at least min_rating
+	MinRating *float64 `query:"min_rating" validate:"min=1,max=5"`
+	// ISO 4217 code to price the products in, their own currency when empty
+	Currency string `query:"currency"`
+	// The price range in minor units of APP_CURRENCY, set by the usecase
+	MinAmount *int64 `query:"-"`
+	MaxAmount *int64 `query:"-"`
+	// Minor units of APP_CURRENCY one minor unit of each currency is worth,
+	// set with the price range. Products priced in a currency without a
+	// rate do not match it.
+	UnitRates map[string]string `query:"-"`
+	// Only these products, set by modules listing products of their own
+	Ids []string `query:"-"`
+	// The signed in user, set by the handler to mark the favorites
+	UserId string `query:"-"`
 	*entities.PaginationReq
 	*entities.SortReq
 }
+
+// PriceQuery picks the currency of the prices of one product
+type PriceQuery struct {
+	// ISO 4217 code, the currency of the product when empty
+	Currency string `query:"currency"`
+}
+
+// WeightReq sets the shipping weight of a product, in grams
+type WeightReq struct {
+	Weight int `json:"weight" validate:"min=0,max=1000000"`
+}
+
+// PriceReq sets the price list entry of the product, or of one variant,
+// in a currency
+type PriceReq struct {
+	VariantId *string `json:"variant_id" validate:"uuid"`
+	Currency  string  `json:"currency" validate:"required"`
+	// Minor units of currency
+	Price int64 `json:"price" validate:"min=0"`
+}
+
+// Reprice sets the prices of the product in currency c. A price list entry
+// for c wins, otherwise the price is converted with rates from the
+// currency of the product. A variant without either falls back to the
+// price of the product in c.
+func (p *Product) Reprice(c *kawaiimoney.Currency, rates kawaiimoney.Rates) error {
+	// Price list entries in c by variant id, "" for the product
+	list := make(map[string]int64)
+	for _, e := range p.Prices {
+		cur, err := kawaiimoney.Lookup(e.Price.Currency)
+		if err != nil {
+			return err
+		}
+		e.Price = kawaiimoney.New(e.Price.Amount, cur)
+
+		if cur == c {
+			key := ""
+			if e.VariantId != nil {
+				key = *e.VariantId
+			}
+			list[key] = e.Price.Amount
+		}
+	}
+
+	var (
+		price *kawaiimoney.Money
+		err   error
+	)
+	if amount, ok := list[""]; ok {
+		price = kawaiimoney.New(amount, c)
+	} else if price, err = convert(p.Price, c, rates); err != nil {
+		return err
+	}
+	p.Price, p.MinPrice, p.MaxPrice = price, price, price
+
+	for i, v := range p.Variants {
+		switch amount, ok := list[v.Id]; {
+		case ok:
+			v.Price = kawaiimoney.New(amount, c)
+		case v.PriceOverride != nil:
+			if v.Price, err = convert(v.PriceOverride, c, rates); err != nil {
+				return err
+			}
+		default:
+			v.Price = price
+		}
+
+		if v.PriceOverride != nil {
+			cur, err := kawaiimoney.Lookup(v.PriceOverride.Currency)
+			if err != nil {
+				return err
+			}
+			v.PriceOverride = kawaiimoney.New(v.PriceOverride.Amount, cur)
+		}
+
+		if i == 0 || v.Price.Amount < p.MinPrice.Amount {
+			p.MinPrice = v.Price
+		}
+		if i == 0 || v.Price.Amount > p.MaxPrice.Amount {
+			p.MaxPrice = v.Price
+		}
+	}
+	return nil
+}
+
+// UnitPrice is the price of the product, or of its variant, as Reprice
+// set it. A product with variants is only sold as one of them.
+func (p *Product) UnitPrice(variantId *string) (*kawaiimoney.Money, *Variant, error) {
+	if variantId == nil {
+		if len(p.Variants) > 0 {
+			return nil, nil, ErrVariantRequired
+		}
+		return p.Price, nil, nil
+	}
+
+	for _, v := range p.Variants {
+		if v.Id == *variantId {
+			return v.Price, v, nil
+		}
+	}
+	return nil, nil, ErrVariantNotFound
+}
+
+// convert returns m in c at the rate from the currency of m
+func convert(m *kawaiimoney.Money, c *kawaiimoney.Currency, rates kawaiimoney.Rates) (*kawaiimoney.Money, error) {
+	from, err := kawaiimoney.Lookup(m.Currency)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := rates.Rate(from.Code, c.Code)
+	if err != nil {
+		return nil, err
+	}
+	return kawaiimoney.New(kawaiimoney.Convert(m.Amount, from, c, rate), c), nil
+}
+
+// ImageUploadReq is the multipart form of the image upload endpoint
+type ImageUploadReq struct {
+	Files []*multipart.FileHeader `form:"files"`
+	Alt   []string                `form:"alt"`
+	// public or private, STORAGE_VISIBILITY when empty
+	Visibility string `form:"visibility" validate:"enum=public|private"`
+}
+
+// ImageUpdateReq changes an image of a product, fields left out are kept
+type ImageUpdateReq struct {
+	Alt *string `json:"alt" validate:"max=255"`
+	// Setting true makes the other images of the product not primary
+	IsPrimary *bool `json:"is_primary"`
+}
+
+// ImageOrderReq lists every image of a product in its new order
+type ImageOrderReq struct {
+	ImageIds []string `json:"image_ids" validate:"required"`
+}
+
+// CatalogColumns is the CSV header of the bulk import and export, the json
+// names of CatalogRow
+var CatalogColumns = []string{"id", "title", "description", "price", "currency", "category", "images"}
+
+// catalogImageSep separates the image urls of a CSV cell
+const catalogImageSep = "|"
+
+// CatalogRow is a product of the bulk import and export
+type CatalogRow struct {
+	// Empty adds a product, the id of a product replaces its fields
+	Id          string `json:"id"`
+	Title       string `json:"title" validate:"required,max=255"`
+	Description string `json:"description"`
+	// Major units of currency, e.g. 150.00
+	Price json.Number `json:"price" validate:"required"`
+	// ISO 4217 code, APP_CURRENCY when empty
+	Currency string `json:"currency"`
+	// Title of a category, empty keeps the category of a product
+	Category string `json:"category"`
+	// Urls of the images in order, empty keeps the images of a product
+	Images []string `json:"images"`
+}
+
+// ImportQuery picks whether the import writes the products
+type ImportQuery struct {
+	// Validate the rows and count the changes without writing them
+	DryRun bool `query:"dry_run"`
+}
+
+type ImportResult struct {
+	DryRun  bool `json:"dry_run"`
+	Rows    int  `json:"rows"`
+	Created int  `json:"created"`
+	Updated int  `json:"updated"`
+}
+
+// ExportQuery picks the format of the export
+type ExportQuery struct {
+	// csv or json, csv when empty
+	Format string `query:"format" validate:"enum=csv|json"`
+}
+
+// ImportProduct is a validated CatalogRow, the price in minor units. Nil
+// CategoryId and Images keep those of the product.
+type ImportProduct struct {
+	Id          string
+	Title       string
+	Description string
+	Price       int64
+	Currency    string
+	CategoryId  *int
+	Images      []*ImportImage
+}
+
+// ImportImage is an image of an imported product, Id keeps an image the
+// product has with its file, an url without one is linked
+type ImportImage struct {
+	Id  *string
+	Url string
+}
+
+// DecodeCatalog reads the rows of a CSV or JSON import, JSON being an
+// array of CatalogRow. The CSV header names the columns, title and price
+// are required and the others may be left out.
+func DecodeCatalog(contentType string, r io.Reader) ([]*CatalogRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case fiber.MIMEApplicationJSON:
+		rows := make([]*CatalogRow, 0)
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrCatalogInvalid, err)
+		}
+		return rows, nil
+	case "text/csv":
+		return decodeCatalogCsv(r)
+	default:
+		return nil, fmt.Errorf("%w, content type must be text/csv or application/json", ErrCatalogInvalid)
+	}
+}
+
+func decodeCatalogCsv(r io.Reader) ([]*CatalogRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w, read header: %v", ErrCatalogInvalid, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		// Spreadsheets may start the file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(CatalogColumns, name) {
+			return nil, fmt.Errorf("%w, unknown column %q", ErrCatalogInvalid, name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w, column %q is repeated", ErrCatalogInvalid, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"title", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w, column %q is required", ErrCatalogInvalid, name)
+		}
+	}
+
+	rows := make([]*CatalogRow, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrCatalogInvalid, err)
+		}
+		if len(record) > 0 && record[0] == CatalogErrorMarker {
+			return nil, fmt.Errorf("%w, the export it comes from failed part way", ErrCatalogInvalid)
+		}
+
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		row := &CatalogRow{
+			Id:          cell("id"),
+			Title:       cell("title"),
+			Description: cell("description"),
+			Price:       json.Number(strings.TrimSpace(cell("price"))),
+			Currency:    cell("currency"),
+			Category:    cell("category"),
+		}
+		for _, u := range strings.Split(cell("images"), catalogImageSep) {
+			if u = strings.TrimSpace(u); u != "" {
+				row.Images = append(row.Images, u)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// CatalogErrorMarker starts the last record of an export that failed part
+// way, the rows before it are not the whole catalog. The response is sent
+// already by then, its status is 200.
+const CatalogErrorMarker = "#error"
+
+// catalogAbortMessage is what an export that failed tells its reader, the
+// cause is logged on the server
+const catalogAbortMessage = "export failed, the catalog is incomplete"
+
+// ICatalogWriter writes the rows of an export one at a time
+type ICatalogWriter interface {
+	Write(row *CatalogRow) error
+	// Close ends the export and flushes it
+	Close() error
+	// Abort ends an export that failed with a CatalogErrorMarker record,
+	// the file does not import. A csv marker has fewer columns than the
+	// header and json is left without its closing bracket.
+	Abort() error
+}
+
+// NewCatalogWriter writes an export as csv or json to w
+func NewCatalogWriter(format string, w io.Writer) ICatalogWriter {
+	if format == "json" {
+		return &jsonCatalogWriter{w: w}
+	}
+	return &csvCatalogWriter{w: csv.NewWriter(w)}
+}
+
+type csvCatalogWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvCatalogWriter) Write(row *CatalogRow) error {
+	if !c.header {
+		if err := c.w.Write(CatalogColumns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	return c.w.Write([]string{
+		row.Id,
+		row.Title,
+		row.Description,
+		string(row.Price),
+		row.Currency,
+		row.Category,
+		strings.Join(row.Images, catalogImageSep),
+	})
+}
+
+func (c *csvCatalogWriter) Close() error {
+	if !c.header {
+		if err := c.w.Write(CatalogColumns); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvCatalogWriter) Abort() error {
+	if err := c.w.Write([]string{CatalogErrorMarker, catalogAbortMessage}); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonCatalogWriter struct {
+	w    io.Writer
+	rows int
+}
+
+func (j *jsonCatalogWriter) Write(row *CatalogRow) error {
+	sep := ","
+	if j.rows == 0 {
+		sep = "["
+	}
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	if _, err := j.w.Write(b); err != nil {
+		return err
+	}
+	j.rows++
+	return nil
+}
+
+func (j *jsonCatalogWriter) Close() error {
+	end := "]"
+	if j.rows == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func (j *jsonCatalogWriter) Abort() error {
+	sep := ","
+	if j.rows == 0 {
+		sep = "["
+	}
+	b, err := json.Marshal(map[string]string{CatalogErrorMarker: catalogAbortMessage})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, sep+string(b))
+	return err
+}
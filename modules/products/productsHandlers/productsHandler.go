@@ -1,15 +1,25 @@
 package productsHandlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/k0msak007/kawaii-shop/config"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesHandlers"
 	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/products"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
 )
 
 type productsHandlersCodeErr string
@@ -17,11 +27,39 @@ type productsHandlersCodeErr string
 const (
 	findOneProductErr productsHandlersCodeErr = "products-001"
 	findProductErr    productsHandlersCodeErr = "products-002"
+	findImagesErr     productsHandlersCodeErr = "products-003"
+	addImagesErr      productsHandlersCodeErr = "products-004"
+	updateImageErr    productsHandlersCodeErr = "products-005"
+	reorderImagesErr  productsHandlersCodeErr = "products-006"
+	deleteImageErr    productsHandlersCodeErr = "products-007"
+	replaceOptionsErr productsHandlersCodeErr = "products-008"
+	insertVariantErr  productsHandlersCodeErr = "products-009"
+	updateVariantErr  productsHandlersCodeErr = "products-010"
+	deleteVariantErr  productsHandlersCodeErr = "products-011"
+	upsertPriceErr    productsHandlersCodeErr = "products-012"
+	deletePriceErr    productsHandlersCodeErr = "products-013"
+	updateWeightErr   productsHandlersCodeErr = "products-014"
+	importProductsErr productsHandlersCodeErr = "products-015"
+	exportProductsErr productsHandlersCodeErr = "products-016"
 )
 
 type IProductsHandler interface {
 	FindOneProduct(c *fiber.Ctx) error
 	FindProduct(c *fiber.Ctx) error
+	FindImages(c *fiber.Ctx) error
+	AddImages(c *fiber.Ctx) error
+	UpdateImage(c *fiber.Ctx) error
+	ReorderImages(c *fiber.Ctx) error
+	DeleteImage(c *fiber.Ctx) error
+	ReplaceOptions(c *fiber.Ctx) error
+	InsertVariant(c *fiber.Ctx) error
+	UpdateVariant(c *fiber.Ctx) error
+	DeleteVariant(c *fiber.Ctx) error
+	UpsertPrice(c *fiber.Ctx) error
+	DeletePrice(c *fiber.Ctx) error
+	UpdateWeight(c *fiber.Ctx) error
+	ImportProducts(c *fiber.Ctx) error
+	ExportProducts(c *fiber.Ctx) error
 }
 
 type productsHandler struct {
@@ -41,14 +79,29 @@ func ProductsHandler(cfg config.IConfig, productsUsecase productsUsecases.IProdu
 func (h *productsHandler) FindOneProduct(c *fiber.Ctx) error {
 	productId := strings.Trim(c.Params("product_id"), " ")
 
-	product, err := h.productsUsecase.FindOneProduct(productId)
+	req := new(products.PriceQuery)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findOneProductErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.FindOneProduct(productId, req.Currency)
 	if err != nil {
 		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
+			productsStatus(err),
 			string(findOneProductErr),
 			err.Error(),
 		).Res()
 	}
+	// A signed in user sees whether the product is a favorite
+	if userId, ok := c.Locals("userId").(string); ok {
+		if err := h.productsUsecase.MarkFavorited(userId, product); err != nil {
+			return entities.NewResponse(c).Error(
+				productsStatus(err),
+				string(findOneProductErr),
+				err.Error(),
+			).Res()
+		}
+	}
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
 }
@@ -59,12 +112,8 @@ func (h *productsHandler) FindProduct(c *fiber.Ctx) error {
 		SortReq:       &entities.SortReq{},
 	}
 
-	if err := c.QueryParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(findProductErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findProductErr), err).Res()
 	}
 
 	fmt.Println(&req)
@@ -81,7 +130,414 @@ func (h *productsHandler) FindProduct(c *fiber.Ctx) error {
 	if req.Sort == "" {
 		req.Sort = "ASC"
 	}
+	req.UserId, _ = c.Locals("userId").(string)
 
-	products := h.productsUsecase.FindProduct(req)
+	products, err := h.productsUsecase.FindProduct(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(findProductErr),
+			err.Error(),
+		).Res()
+	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, products).Res()
 }
+
+func productsStatus(err error) int {
+	switch {
+	case errors.Is(err, products.ErrProductNotFound),
+		errors.Is(err, products.ErrImageNotFound),
+		errors.Is(err, products.ErrVariantNotFound),
+		errors.Is(err, products.ErrPriceNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, products.ErrImageOrder),
+		errors.Is(err, products.ErrOptionsInvalid),
+		errors.Is(err, products.ErrVariantOptions),
+		errors.Is(err, products.ErrVariantImage),
+		errors.Is(err, products.ErrPriceCurrency),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency),
+		errors.Is(err, kawaiimoney.ErrNoRate),
+		errors.Is(err, kawaiimoney.ErrInvalidAmount):
+		return fiber.ErrBadRequest.Code
+	case errors.Is(err, products.ErrSkuTaken),
+		errors.Is(err, products.ErrVariantDuplicate),
+		errors.Is(err, products.ErrCurrencyPriced):
+		return fiber.ErrConflict.Code
+	default:
+		return filesHandlers.UploadErrorStatus(err)
+	}
+}
+
+// imageId is the image_id param, anything but a uuid cannot be an image
+func imageId(c *fiber.Ctx) (string, error) {
+	id := c.Params("image_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", products.ErrImageNotFound
+	}
+	return id, nil
+}
+
+func (h *productsHandler) FindImages(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	images, err := h.productsUsecase.FindImages(productId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(findImagesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+// AddImages uploads the files of a multipart form as images of the
+// product, the alt values are the alt texts of the files in order.
+func (h *productsHandler) AddImages(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req, err := filesHandlers.ParseUploadForm(c, h.cfg, "products/"+productId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(addImagesErr),
+			err.Error(),
+		).Res()
+	}
+	if len(req) == 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(addImagesErr),
+			"files request are empty",
+		).Res()
+	}
+
+	form, _ := c.MultipartForm()
+	alts := make([]string, len(req))
+	for i, alt := range form.Value["alt"] {
+		if i >= len(alts) {
+			break
+		}
+		if utf8.RuneCountInString(alt) > 255 {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(addImagesErr),
+				"alt must be at most 255 characters",
+			).Res()
+		}
+		alts[i] = alt
+	}
+
+	userId := c.Locals("userId").(string)
+
+	images, err := h.productsUsecase.AddImages(c.UserContext(), userId, productId, req, alts)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(addImagesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, images).Res()
+}
+
+func (h *productsHandler) UpdateImage(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+	id, err := imageId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updateImageErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(products.ImageUpdateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateImageErr), err).Res()
+	}
+
+	images, err := h.productsUsecase.UpdateImage(productId, id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(updateImageErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+func (h *productsHandler) ReorderImages(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.ImageOrderReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(reorderImagesErr), err).Res()
+	}
+
+	images, err := h.productsUsecase.ReorderImages(productId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(reorderImagesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, images).Res()
+}
+
+func (h *productsHandler) DeleteImage(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+	id, err := imageId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deleteImageErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.productsUsecase.DeleteImage(c.UserContext(), productId, id); err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(deleteImageErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// variantId is the variant_id param, anything but a uuid cannot be a variant
+func variantId(c *fiber.Ctx) (string, error) {
+	id := c.Params("variant_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", products.ErrVariantNotFound
+	}
+	return id, nil
+}
+
+func (h *productsHandler) ReplaceOptions(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.OptionsReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(replaceOptionsErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.ReplaceOptions(productId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(replaceOptionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *productsHandler) InsertVariant(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.VariantReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(insertVariantErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.InsertVariant(productId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(insertVariantErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, product).Res()
+}
+
+func (h *productsHandler) UpdateVariant(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+	id, err := variantId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updateVariantErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(products.VariantReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateVariantErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.UpdateVariant(productId, id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(updateVariantErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *productsHandler) DeleteVariant(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+	id, err := variantId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deleteVariantErr),
+			err.Error(),
+		).Res()
+	}
+
+	product, err := h.productsUsecase.DeleteVariant(productId, id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(deleteVariantErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *productsHandler) UpsertPrice(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.PriceReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(upsertPriceErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.UpsertPrice(productId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(upsertPriceErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+// DeletePrice removes the price list entry in the currency param, of the
+// variant in the variant_id query when it is set
+func (h *productsHandler) DeletePrice(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	var variantId *string
+	if id := c.Query("variant_id"); id != "" {
+		if !kawaiivalidator.IsUUID(id) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(deletePriceErr),
+				products.ErrPriceNotFound.Error(),
+			).Res()
+		}
+		variantId = &id
+	}
+
+	product, err := h.productsUsecase.DeletePrice(productId, variantId, c.Params("currency"))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(deletePriceErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *productsHandler) UpdateWeight(c *fiber.Ctx) error {
+	productId := strings.Trim(c.Params("product_id"), " ")
+
+	req := new(products.WeightReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateWeightErr), err).Res()
+	}
+
+	product, err := h.productsUsecase.UpdateWeight(productId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(updateWeightErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+// ImportProducts upserts the products of a csv or json catalog, rows with
+// an id update that product and the others are added. Nothing is written
+// unless every row is valid.
+func (h *productsHandler) ImportProducts(c *fiber.Ctx) error {
+	req := new(products.ImportQuery)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(importProductsErr), err).Res()
+	}
+
+	rows, err := products.DecodeCatalog(c.Get(fiber.HeaderContentType), bytes.NewReader(c.Body()))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(importProductsErr),
+			err.Error(),
+		).Res()
+	}
+
+	res, err := h.productsUsecase.ImportProducts(c.UserContext(), rows, req.DryRun)
+	if err != nil {
+		var verr *kawaiivalidator.ValidationError
+		if errors.As(err, &verr) {
+			return entities.NewResponse(c).ParseError(string(importProductsErr), err).Res()
+		}
+		return entities.NewResponse(c).Error(
+			productsStatus(err),
+			string(importProductsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+// exportTimeout bounds the query of an export, a client reading slowly
+// holds a database connection until then
+const exportTimeout = time.Minute * 10
+
+// ExportProducts streams the catalog as csv or json, the rows are written
+// as they are read so the response has no length
+func (h *productsHandler) ExportProducts(c *fiber.Ctx) error {
+	req := new(products.ExportQuery)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(exportProductsErr), err).Res()
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if req.Format == "json" {
+		contentType = fiber.MIMEApplicationJSONCharsetUTF8
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="products.%s"`, req.Format))
+
+	// The writer runs after the handler returns, the request context is
+	// done by then. The status is sent before the first row, an export that
+	// fails later ends with a products.CatalogErrorMarker record.
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		if err := h.productsUsecase.ExportProducts(ctx, req.Format, w); err != nil {
+			log.Printf("%s: export products failed: %v", exportProductsErr, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("%s: flush export failed: %v", exportProductsErr, err)
+		}
+	})
+	return nil
+}
@@ -57,7 +57,10 @@ func (b *findProductBuilder) initQuery() {
 			"p"."id",
 			"p"."title",
 			"p"."description",
-			"p"."price",
+			jsonb_build_object('amount', "p"."price", 'currency', "p"."currency") AS "price",
+			"p"."weight",
+			"rv"."rating_avg",
+			"rv"."rating_count",
 			(
 				SELECT
 					to_jsonb("ct")
@@ -74,17 +77,78 @@ func (b *findProductBuilder) initQuery() {
 			"p"."updated_at",
 			(
 				SELECT
-					COALESCE(array_to_json(array_agg("it")), '[]'::json)
+					COALESCE(array_to_json(array_agg("it" ORDER BY "it"."position")), '[]'::json)
 				FROM (
 					SELECT
 						"i"."id",
 						"i"."filename",
-						"i"."url"
+						"i"."url",
+						"i"."variants",
+						"i"."private",
+						"i"."position",
+						"i"."alt",
+						"i"."is_primary"
 					FROM "images" "i"
 					WHERE "i"."product_id" = "p"."id"
 				) AS "it"
-			) AS "images"
+			) AS "images",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("ot" ORDER BY "ot"."position")), '[]'::json)
+				FROM (
+					SELECT
+						"o"."name",
+						"o"."values",
+						"o"."position"
+					FROM "product_options" "o"
+					WHERE "o"."product_id" = "p"."id"
+				) AS "ot"
+			) AS "options",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("vt" ORDER BY "vt"."position")), '[]'::json)
+				FROM (
+					SELECT
+						"v"."id",
+						"v"."sku",
+						"v"."options",
+						CASE WHEN "v"."price" IS NULL THEN NULL
+						ELSE jsonb_build_object('amount', "v"."price", 'currency', "p"."currency")
+						END AS "price_override",
+						"v"."stock",
+						"v"."image_id",
+						"v"."position"
+					FROM "product_variants" "v"
+					WHERE "v"."product_id" = "p"."id"
+				) AS "vt"
+			) AS "variants",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("pt" ORDER BY "pt"."variant_id" NULLS FIRST, "pt"."price"->>'currency')), '[]'::json)
+				FROM (
+					SELECT
+						"pp"."variant_id",
+						jsonb_build_object('amount', "pp"."price", 'currency', "pp"."currency") AS "price"
+					FROM "product_prices" "pp"
+					WHERE "pp"."product_id" = "p"."id"
+				) AS "pt"
+			) AS "prices"
 		FROM "products" "p"
+			LEFT JOIN LATERAL (
+				SELECT
+					MIN(COALESCE("v"."price", "p"."price")) AS "min_price",
+					MAX(COALESCE("v"."price", "p"."price")) AS "max_price"
+				FROM "product_variants" "v"
+				WHERE "v"."product_id" = "p"."id"
+			) AS "pr" ON TRUE
+			LEFT JOIN LATERAL (
+				SELECT
+					COALESCE(ROUND(AVG("r"."rating"), 2), 0)::float AS "rating_avg",
+					COUNT(*) AS "rating_count"
+				FROM "reviews" "r"
+				WHERE "r"."product_id" = "p"."id"
+				AND "r"."status" = 'approved'
+			) AS "rv" ON TRUE
 		WHERE 1 = 1
 	`
 }
@@ -94,6 +158,21 @@ func (b *findProductBuilder) countQuery() {
 		SELECT
 			COUNT(*) AS "count"
 		FROM "products" "p"
+			LEFT JOIN LATERAL (
+				SELECT
+					MIN(COALESCE("v"."price", "p"."price")) AS "min_price",
+					MAX(COALESCE("v"."price", "p"."price")) AS "max_price"
+				FROM "product_variants" "v"
+				WHERE "v"."product_id" = "p"."id"
+			) AS "pr" ON TRUE
+			LEFT JOIN LATERAL (
+				SELECT
+					COALESCE(ROUND(AVG("r"."rating"), 2), 0)::float AS "rating_avg",
+					COUNT(*) AS "rating_count"
+				FROM "reviews" "r"
+				WHERE "r"."product_id" = "p"."id"
+				AND "r"."status" = 'approved'
+			) AS "rv" ON TRUE
 		WHERE 1 = 1
 	`
 }
@@ -110,6 +189,14 @@ func (b *findProductBuilder) whereQuery() {
 		`)
 	}
 
+	if len(b.req.Ids) > 0 {
+		b.values = append(b.values, b.req.Ids)
+
+		queryWhereStack = append(queryWhereStack, `
+			AND "p"."id" = ANY(?::varchar[])
+		`)
+	}
+
 	if b.req.Search != "" {
 		b.values = append(b.values, "%"+strings.ToLower(b.req.Search)+"%", strings.ToLower(b.req.Search)+"%")
 		queryWhereStack = append(queryWhereStack, `
@@ -117,16 +204,52 @@ func (b *findProductBuilder) whereQuery() {
 		`)
 	}
 
-	for i := range queryWhereStack {
-		if i != len(queryWhereStack)-1 {
-			queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(i+1), 1)
-		} else {
-			queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(i+1), 1)
-			queryWhere = strings.Replace(queryWhere, "?", "$"+strconv.Itoa(i+2), 1)
+	// Price ranges of the variants overlapping the filter match, compared
+	// in the minor units of APP_CURRENCY. A product in a currency without
+	// a unit rate is NULL and never matches.
+	if b.req.MinAmount != nil || b.req.MaxAmount != nil {
+		codes := make([]string, 0, len(b.req.UnitRates))
+		rates := make([]string, 0, len(b.req.UnitRates))
+		for code, rate := range b.req.UnitRates {
+			codes = append(codes, code)
+			rates = append(rates, rate)
+		}
+		unitRate := `(
+				SELECT "ur"."rate"
+				FROM unnest(?::varchar[], ?::numeric[]) AS "ur" ("currency", "rate")
+				WHERE "ur"."currency" = "p"."currency"
+			)`
+
+		if b.req.MinAmount != nil {
+			b.values = append(b.values, codes, rates, *b.req.MinAmount)
+			queryWhereStack = append(queryWhereStack, `
+			AND COALESCE("pr"."max_price", "p"."price") * `+unitRate+` >= ?
+		`)
+		}
+		if b.req.MaxAmount != nil {
+			b.values = append(b.values, codes, rates, *b.req.MaxAmount)
+			queryWhereStack = append(queryWhereStack, `
+			AND COALESCE("pr"."min_price", "p"."price") * `+unitRate+` <= ?
+		`)
 		}
 	}
-	b.lastStackIndex = len(b.values)
 
+	// Products without approved reviews rate 0 and never match
+	if b.req.MinRating != nil {
+		b.values = append(b.values, *b.req.MinRating)
+		queryWhereStack = append(queryWhereStack, `
+			AND "rv"."rating_avg" >= ?
+		`)
+	}
+
+	// Placeholders are numbered by value, a condition may take several
+	for _, q := range queryWhereStack {
+		for strings.Contains(q, "?") {
+			b.lastStackIndex++
+			q = strings.Replace(q, "?", "$"+strconv.Itoa(b.lastStackIndex), 1)
+		}
+		queryWhere += q
+	}
 	b.query += queryWhere
 }
 
@@ -0,0 +1,289 @@
+package productsPatterns
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+)
+
+// UpsertProducts writes a batch of the import in tx, a handful of
+// statements for the whole batch. Products without an id are added and
+// their ids filled in.
+func UpsertProducts(ctx context.Context, tx *sqlx.Tx, batch []*products.ImportProduct) error {
+	if err := updateProducts(ctx, tx, batch); err != nil {
+		return err
+	}
+	if err := insertProducts(ctx, tx, batch); err != nil {
+		return err
+	}
+	if err := replaceCategories(ctx, tx, batch); err != nil {
+		return err
+	}
+	return replaceImages(ctx, tx, batch)
+}
+
+func updateProducts(ctx context.Context, tx *sqlx.Tx, batch []*products.ImportProduct) error {
+	var (
+		ids, titles, descriptions, currencies []string
+		prices                                []int64
+	)
+	for _, p := range batch {
+		if p.Id == "" {
+			continue
+		}
+		ids = append(ids, p.Id)
+		titles = append(titles, p.Title)
+		descriptions = append(descriptions, p.Description)
+		prices = append(prices, p.Price)
+		currencies = append(currencies, p.Currency)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Variant overrides are in the currency of the product and price lists
+	// are against it. Checked by the validation, unless prices were added
+	// since, the lock keeps new ones out until the import is done.
+	priced := make([]string, 0)
+	if err := tx.SelectContext(ctx, &priced, `
+	SELECT
+		"p"."id"
+	FROM "products" "p"
+		JOIN unnest($1::varchar[], $2::varchar[]) AS "r" ("id", "currency") ON "r"."id" = "p"."id"
+	WHERE "p"."currency" <> "r"."currency"
+	AND (
+		EXISTS (SELECT 1 FROM "product_variants" "v" WHERE "v"."product_id" = "p"."id" AND "v"."price" IS NOT NULL)
+		OR EXISTS (SELECT 1 FROM "product_prices" "pp" WHERE "pp"."product_id" = "p"."id")
+	)
+	FOR UPDATE OF "p";`, ids, currencies); err != nil {
+		return fmt.Errorf("check product currencies failed: %v", err)
+	}
+	if len(priced) > 0 {
+		return fmt.Errorf("%w: %s", products.ErrCurrencyPriced, priced[0])
+	}
+
+	query := `
+	UPDATE "products" "p" SET
+		"title" = "r"."title",
+		"description" = "r"."description",
+		"price" = "r"."price",
+		"currency" = "r"."currency"
+	FROM unnest($1::varchar[], $2::varchar[], $3::varchar[], $4::bigint[], $5::varchar[])
+		AS "r" ("id", "title", "description", "price", "currency")
+	WHERE "p"."id" = "r"."id";`
+
+	res, err := tx.ExecContext(ctx, query, ids, titles, descriptions, prices, currencies)
+	if err != nil {
+		return fmt.Errorf("update products failed: %v", err)
+	}
+	// Checked by the validation, unless a product was deleted since
+	if n, _ := res.RowsAffected(); int(n) != len(ids) {
+		return products.ErrProductNotFound
+	}
+	return nil
+}
+
+func insertProducts(ctx context.Context, tx *sqlx.Tx, batch []*products.ImportProduct) error {
+	var (
+		added                            []*products.ImportProduct
+		titles, descriptions, currencies []string
+		prices                           []int64
+	)
+	for _, p := range batch {
+		if p.Id != "" {
+			continue
+		}
+		added = append(added, p)
+		titles = append(titles, p.Title)
+		descriptions = append(descriptions, p.Description)
+		prices = append(prices, p.Price)
+		currencies = append(currencies, p.Currency)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	// Each id is drawn with the default of the column next to the row
+	// number, "n" tells which id went to which row
+	query := `
+	WITH "r" AS (
+		SELECT
+			CONCAT('P', LPAD(NEXTVAL('products_id_seq')::TEXT, 6, '0')) AS "id",
+			"r"."title",
+			"r"."description",
+			"r"."price",
+			"r"."currency",
+			"r"."n"
+		FROM unnest($1::varchar[], $2::varchar[], $3::bigint[], $4::varchar[])
+			WITH ORDINALITY AS "r" ("title", "description", "price", "currency", "n")
+	), "i" AS (
+		INSERT INTO "products" (
+			"id",
+			"title",
+			"description",
+			"price",
+			"currency"
+		)
+		SELECT
+			"r"."id",
+			"r"."title",
+			"r"."description",
+			"r"."price",
+			"r"."currency"
+		FROM "r"
+		RETURNING "id"
+	)
+	SELECT
+		"r"."n",
+		"i"."id"
+	FROM "i"
+		JOIN "r" ON "r"."id" = "i"."id";`
+
+	inserted := make([]struct {
+		N  int    `db:"n"`
+		Id string `db:"id"`
+	}, 0, len(added))
+	if err := tx.SelectContext(ctx, &inserted, query, titles, descriptions, prices, currencies); err != nil {
+		return fmt.Errorf("insert products failed: %v", err)
+	}
+	if len(inserted) != len(added) {
+		return fmt.Errorf("insert products failed: %d of %d added", len(inserted), len(added))
+	}
+	for _, r := range inserted {
+		added[r.N-1].Id = r.Id
+	}
+	return nil
+}
+
+func replaceCategories(ctx context.Context, tx *sqlx.Tx, batch []*products.ImportProduct) error {
+	var (
+		ids         []string
+		categoryIds []int64
+	)
+	for _, p := range batch {
+		if p.CategoryId == nil {
+			continue
+		}
+		ids = append(ids, p.Id)
+		categoryIds = append(categoryIds, int64(*p.CategoryId))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "products_categories" WHERE "product_id" = ANY($1);`, ids); err != nil {
+		return fmt.Errorf("delete product categories failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "products_categories" (
+		"product_id",
+		"category_id"
+	)
+	SELECT
+		"r"."product_id",
+		"r"."category_id"
+	FROM unnest($1::varchar[], $2::int[]) AS "r" ("product_id", "category_id");`
+
+	if _, err := tx.ExecContext(ctx, query, ids, categoryIds); err != nil {
+		return fmt.Errorf("insert product categories failed: %v", err)
+	}
+	return nil
+}
+
+// replaceImages makes the images of the products those listed. Images kept
+// move to their new position, the others are removed and their files left
+// to the garbage collector, new urls are linked without a file. The first
+// image is the primary one.
+func replaceImages(ctx context.Context, tx *sqlx.Tx, batch []*products.ImportProduct) error {
+	// Empty arrays rather than NULL, "id" = ANY(NULL) matches nothing and
+	// NOT of it keeps every image
+	var (
+		productIds       = make([]string, 0)
+		keptIds          = make([]string, 0)
+		keptPositions    = make([]int64, 0)
+		linkedProductIds = make([]string, 0)
+		linkedUrls       = make([]string, 0)
+		linkedNames      = make([]string, 0)
+		linkedPositions  = make([]int64, 0)
+	)
+	for _, p := range batch {
+		if p.Images == nil {
+			continue
+		}
+		productIds = append(productIds, p.Id)
+		for i, img := range p.Images {
+			if img.Id != nil {
+				keptIds = append(keptIds, *img.Id)
+				keptPositions = append(keptPositions, int64(i))
+				continue
+			}
+			linkedProductIds = append(linkedProductIds, p.Id)
+			linkedUrls = append(linkedUrls, img.Url)
+			linkedNames = append(linkedNames, filename(img.Url))
+			linkedPositions = append(linkedPositions, int64(i))
+		}
+	}
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	DELETE FROM "images"
+	WHERE "product_id" = ANY($1)
+	AND NOT ("id" = ANY($2::uuid[]));`, productIds, keptIds); err != nil {
+		return fmt.Errorf("delete images failed: %v", err)
+	}
+
+	// Cleared first, a product has one primary image at any time
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE "images" SET
+		"is_primary" = FALSE
+	WHERE "product_id" = ANY($1)
+	AND "is_primary";`, productIds); err != nil {
+		return fmt.Errorf("clear primary images failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE "images" "i" SET
+		"position" = "r"."position",
+		"is_primary" = "r"."position" = 0
+	FROM unnest($1::uuid[], $2::int[]) AS "r" ("id", "position")
+	WHERE "i"."id" = "r"."id";`, keptIds, keptPositions); err != nil {
+		return fmt.Errorf("move images failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	INSERT INTO "images" (
+		"filename",
+		"url",
+		"product_id",
+		"position",
+		"is_primary"
+	)
+	SELECT
+		"r"."filename",
+		"r"."url",
+		"r"."product_id",
+		"r"."position",
+		"r"."position" = 0
+	FROM unnest($1::varchar[], $2::varchar[], $3::varchar[], $4::int[])
+		AS "r" ("filename", "url", "product_id", "position");`,
+		linkedNames, linkedUrls, linkedProductIds, linkedPositions); err != nil {
+		return fmt.Errorf("insert images failed: %v", err)
+	}
+	return nil
+}
+
+// filename is the last segment of the path of a linked image url
+func filename(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Path == "" {
+		return "image"
+	}
+	return path.Base(u.Path)
+}
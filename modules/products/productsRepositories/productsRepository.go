@@ -1,20 +1,56 @@
 package productsRepositories
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	"github.com/k0msak007/kawaii-shop/config"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
 	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/products"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsPatterns"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
 )
 
 type IProductRepository interface {
 	FindOneProduct(productId string) (*products.Product, error)
 	FindProduct(req *products.ProductFilter) ([]*products.Product, int)
+	FindImages(productId string) ([]*entities.Image, error)
+	InsertImages(productId string, fileIds, alts []string) error
+	UpdateImage(productId, imageId string, req *products.ImageUpdateReq) error
+	ReorderImages(productId string, imageIds []string) error
+	DeleteImage(productId, imageId string) (string, error)
+	ReplaceOptions(productId string, options []*products.Option) error
+	InsertVariant(productId string, req *products.VariantReq) error
+	UpdateVariant(productId, variantId string, req *products.VariantReq) error
+	DeleteVariant(productId, variantId string) error
+	UpsertPrice(productId string, req *products.PriceReq) error
+	DeletePrice(productId string, variantId *string, currency string) error
+	UpdateWeight(productId string, weight int) error
+	// FindFavorited returns which of productIds the user wishlisted
+	FindFavorited(userId string, productIds []string) ([]string, error)
+	// FindProductIds returns which of productIds are products
+	FindProductIds(productIds []string) ([]string, error)
+	// FindPricedProducts returns the currency of those of productIds with
+	// variant price overrides or price list entries, by product id
+	FindPricedProducts(productIds []string) (map[string]string, error)
+	// FindCategoryIds returns the ids of the categories by lower case title
+	FindCategoryIds(titles []string) (map[string]int, error)
+	// FindProductImages returns the images of the products in order, the
+	// urls as stored
+	FindProductImages(productIds []string) (map[string][]*entities.Image, error)
+	// ImportProducts upserts the products batch by batch in one
+	// transaction, all of them or none
+	ImportProducts(ctx context.Context, req []*products.ImportProduct, batchSize int) error
+	// ExportProducts calls fn with every product by id as it is read, the
+	// image urls as stored
+	ExportProducts(ctx context.Context, fn func(row *products.CatalogRow, images []*entities.Image) error) error
 }
 
 type productRepository struct {
@@ -40,7 +76,10 @@ func (r *productRepository) FindOneProduct(productId string) (*products.Product,
 				"p"."id",
 				"p"."title",
 				"p"."description",
-				"p"."price",
+				jsonb_build_object('amount', "p"."price", 'currency', "p"."currency") AS "price",
+				"p"."weight",
+				"rv"."rating_avg",
+				"rv"."rating_count",
 				(
 					SELECT
 						to_jsonb("ct")
@@ -57,17 +96,71 @@ func (r *productRepository) FindOneProduct(productId string) (*products.Product,
 				"p"."updated_at",
 				(
 					SELECT
-						COALESCE(array_to_json(array_agg("it")), '[]'::json)
+						COALESCE(array_to_json(array_agg("it" ORDER BY "it"."position")), '[]'::json)
 					FROM (
 						SELECT
 							"i"."id",
 							"i"."filename",
-							"i"."url"
+							"i"."url",
+							"i"."variants",
+							"i"."private",
+							"i"."position",
+							"i"."alt",
+							"i"."is_primary"
 						FROM "images" "i"
 						WHERE "i"."product_id" = "p"."id"
 					) AS "it"
-				) AS "images"
+				) AS "images",
+				(
+					SELECT
+						COALESCE(array_to_json(array_agg("ot" ORDER BY "ot"."position")), '[]'::json)
+					FROM (
+						SELECT
+							"o"."name",
+							"o"."values",
+							"o"."position"
+						FROM "product_options" "o"
+						WHERE "o"."product_id" = "p"."id"
+					) AS "ot"
+				) AS "options",
+				(
+					SELECT
+						COALESCE(array_to_json(array_agg("vt" ORDER BY "vt"."position")), '[]'::json)
+					FROM (
+						SELECT
+							"v"."id",
+							"v"."sku",
+							"v"."options",
+							CASE WHEN "v"."price" IS NULL THEN NULL
+							ELSE jsonb_build_object('amount', "v"."price", 'currency', "p"."currency")
+							END AS "price_override",
+							"v"."stock",
+							"v"."image_id",
+							"v"."position"
+						FROM "product_variants" "v"
+						WHERE "v"."product_id" = "p"."id"
+					) AS "vt"
+				) AS "variants",
+				(
+					SELECT
+						COALESCE(array_to_json(array_agg("pt" ORDER BY "pt"."variant_id" NULLS FIRST, "pt"."price"->>'currency')), '[]'::json)
+					FROM (
+						SELECT
+							"pp"."variant_id",
+							jsonb_build_object('amount', "pp"."price", 'currency', "pp"."currency") AS "price"
+						FROM "product_prices" "pp"
+						WHERE "pp"."product_id" = "p"."id"
+					) AS "pt"
+				) AS "prices"
 			FROM "products" "p"
+				LEFT JOIN LATERAL (
+					SELECT
+						COALESCE(ROUND(AVG("r"."rating"), 2), 0)::float AS "rating_avg",
+						COUNT(*) AS "rating_count"
+					FROM "reviews" "r"
+					WHERE "r"."product_id" = "p"."id"
+					AND "r"."status" = 'approved'
+				) AS "rv" ON TRUE
 			WHERE "p"."id" = $1
 			LIMIT 1
 		) AS "t"
@@ -79,6 +172,9 @@ func (r *productRepository) FindOneProduct(productId string) (*products.Product,
 	}
 
 	if err := r.db.Get(&productBytes, query, productId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, products.ErrProductNotFound
+		}
 		return nil, fmt.Errorf("get product failed: %v", err)
 	}
 
@@ -100,3 +196,802 @@ func (r *productRepository) FindProduct(req *products.ProductFilter) ([]*product
 
 	return result, count
 }
+
+func (r *productRepository) FindImages(productId string) ([]*entities.Image, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("it" ORDER BY "it"."position")), '[]'::json)
+	FROM (
+		SELECT
+			"i"."id",
+			"i"."filename",
+			"i"."url",
+			"i"."variants",
+			"i"."private",
+			"i"."position",
+			"i"."alt",
+			"i"."is_primary"
+		FROM "images" "i"
+		WHERE "i"."product_id" = $1
+	) AS "it";`
+
+	imagesBytes := make([]byte, 0)
+	images := make([]*entities.Image, 0)
+
+	if err := r.db.Get(&imagesBytes, query, productId); err != nil {
+		return nil, fmt.Errorf("get images failed: %v", err)
+	}
+	if err := json.Unmarshal(imagesBytes, &images); err != nil {
+		return nil, fmt.Errorf("unmarshal images failed: %v", err)
+	}
+	return images, nil
+}
+
+// lockProduct serializes changes to the images of a product, positions and
+// the primary image are read and written by one transaction at a time.
+func lockProduct(ctx context.Context, tx *sqlx.Tx, productId string) error {
+	var id string
+	if err := tx.GetContext(ctx, &id, `SELECT "id" FROM "products" WHERE "id" = $1 FOR UPDATE;`, productId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return products.ErrProductNotFound
+		}
+		return fmt.Errorf("lock product failed: %v", err)
+	}
+	return nil
+}
+
+// InsertImages adds the files as images after the existing ones, the first
+// becomes primary when the product has none. alts[i] belongs to fileIds[i].
+func (r *productRepository) InsertImages(productId string, fileIds, alts []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+
+	var (
+		position   int
+		hasPrimary bool
+	)
+	if err := tx.QueryRowxContext(ctx, `
+	SELECT
+		COALESCE(MAX("position") + 1, 0),
+		COALESCE(bool_or("is_primary"), FALSE)
+	FROM "images"
+	WHERE "product_id" = $1;`, productId).Scan(&position, &hasPrimary); err != nil {
+		return fmt.Errorf("get image positions failed: %v", err)
+	}
+
+	// The keys are copied from the file, the urls are made at read time
+	query := `
+	INSERT INTO "images" (
+		"filename",
+		"url",
+		"variants",
+		"private",
+		"file_id",
+		"product_id",
+		"position",
+		"alt",
+		"is_primary"
+	)
+	SELECT
+		"f"."filename",
+		"f"."destination",
+		"f"."variants",
+		"f"."private",
+		"f"."id",
+		$2,
+		$3,
+		$4,
+		$5
+	FROM "files" "f"
+	WHERE "f"."id" = $1;`
+
+	for i, fileId := range fileIds {
+		res, err := tx.ExecContext(ctx, query, fileId, productId, position+i, alts[i], !hasPrimary && i == 0)
+		if err != nil {
+			return fmt.Errorf("insert image failed: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("insert image failed: file %s not found", fileId)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit images failed: %v", err)
+	}
+	return nil
+}
+
+func (r *productRepository) UpdateImage(productId, imageId string, req *products.ImageUpdateReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+
+	// Only one primary image per product
+	if req.IsPrimary != nil && *req.IsPrimary {
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE "images" SET
+			"is_primary" = FALSE
+		WHERE "product_id" = $1
+		AND "id" <> $2
+		AND "is_primary";`, productId, imageId); err != nil {
+			return fmt.Errorf("update primary image failed: %v", err)
+		}
+	}
+
+	query := `
+	UPDATE "images" SET
+		"alt" = COALESCE($3, "alt"),
+		"is_primary" = COALESCE($4, "is_primary")
+	WHERE "product_id" = $1
+	AND "id" = $2;`
+
+	res, err := tx.ExecContext(ctx, query, productId, imageId, req.Alt, req.IsPrimary)
+	if err != nil {
+		return fmt.Errorf("update image failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return products.ErrImageNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit image failed: %v", err)
+	}
+	return nil
+}
+
+// ReorderImages sets the positions from the order of imageIds, which must
+// hold every image of the product.
+func (r *productRepository) ReorderImages(productId string, imageIds []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0)
+	if err := tx.SelectContext(ctx, &ids, `SELECT "id" FROM "images" WHERE "product_id" = $1;`, productId); err != nil {
+		return fmt.Errorf("get images failed: %v", err)
+	}
+
+	if len(ids) != len(imageIds) {
+		return products.ErrImageOrder
+	}
+	current := make(map[string]bool)
+	for _, id := range ids {
+		current[id] = true
+	}
+	for _, id := range imageIds {
+		if !current[id] {
+			return products.ErrImageOrder
+		}
+		// Listed twice
+		delete(current, id)
+	}
+
+	for position, id := range imageIds {
+		if _, err := tx.ExecContext(ctx, `UPDATE "images" SET "position" = $2 WHERE "id" = $1;`, id, position); err != nil {
+			return fmt.Errorf("update image position failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit image positions failed: %v", err)
+	}
+	return nil
+}
+
+// DeleteImage removes the image row, closes the gap in the positions and
+// promotes the first image when the primary one is gone. It returns the
+// file of the image, empty for images older than the files table.
+func (r *productRepository) DeleteImage(productId, imageId string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return "", err
+	}
+
+	var (
+		fileId    sql.NullString
+		position  int
+		isPrimary bool
+	)
+	if err := tx.QueryRowxContext(ctx, `
+	DELETE FROM "images"
+	WHERE "product_id" = $1
+	AND "id" = $2
+	RETURNING "file_id", "position", "is_primary";`, productId, imageId).Scan(&fileId, &position, &isPrimary); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", products.ErrImageNotFound
+		}
+		return "", fmt.Errorf("delete image failed: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE "images" SET
+		"position" = "position" - 1
+	WHERE "product_id" = $1
+	AND "position" > $2;`, productId, position); err != nil {
+		return "", fmt.Errorf("update image positions failed: %v", err)
+	}
+
+	if isPrimary {
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE "images" SET
+			"is_primary" = TRUE
+		WHERE "id" = (
+			SELECT
+				"id"
+			FROM "images"
+			WHERE "product_id" = $1
+			ORDER BY "position"
+			LIMIT 1
+		);`, productId); err != nil {
+			return "", fmt.Errorf("update primary image failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit delete image failed: %v", err)
+	}
+	return fileId.String, nil
+}
+
+func findOptions(ctx context.Context, tx *sqlx.Tx, productId string) ([]*products.Option, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("ot" ORDER BY "ot"."position")), '[]'::json)
+	FROM (
+		SELECT
+			"o"."name",
+			"o"."values",
+			"o"."position"
+		FROM "product_options" "o"
+		WHERE "o"."product_id" = $1
+	) AS "ot";`
+
+	optionsBytes := make([]byte, 0)
+	options := make([]*products.Option, 0)
+
+	if err := tx.GetContext(ctx, &optionsBytes, query, productId); err != nil {
+		return nil, fmt.Errorf("get options failed: %v", err)
+	}
+	if err := json.Unmarshal(optionsBytes, &options); err != nil {
+		return nil, fmt.Errorf("unmarshal options failed: %v", err)
+	}
+	return options, nil
+}
+
+// ReplaceOptions sets the option axes of a product, every variant must
+// still pick one value of each.
+func (r *productRepository) ReplaceOptions(productId string, options []*products.Option) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+
+	variants := make([]*struct {
+		Sku     string `db:"sku"`
+		Options []byte `db:"options"`
+	}, 0)
+	if err := tx.SelectContext(ctx, &variants, `SELECT "sku", "options" FROM "product_variants" WHERE "product_id" = $1;`, productId); err != nil {
+		return fmt.Errorf("get variants failed: %v", err)
+	}
+
+	for _, v := range variants {
+		values := make(map[string]string)
+		if err := json.Unmarshal(v.Options, &values); err != nil {
+			return fmt.Errorf("unmarshal variant options failed: %v", err)
+		}
+		if err := products.CheckOptions(options, values); err != nil {
+			return fmt.Errorf("%w of variant %s", err, v.Sku)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "product_options" WHERE "product_id" = $1;`, productId); err != nil {
+		return fmt.Errorf("delete options failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "product_options" (
+		"product_id",
+		"name",
+		"values",
+		"position"
+	)
+	VALUES ($1, $2, $3, $4);`
+
+	for i, o := range options {
+		values, err := json.Marshal(o.Values)
+		if err != nil {
+			return fmt.Errorf("marshal option values failed: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, query, productId, o.Name, values, i); err != nil {
+			return fmt.Errorf("insert option failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit options failed: %v", err)
+	}
+	return nil
+}
+
+// checkVariant validates req against the options and images of the
+// product, within the transaction holding the product lock.
+func checkVariant(ctx context.Context, tx *sqlx.Tx, productId string, req *products.VariantReq) error {
+	options, err := findOptions(ctx, tx, productId)
+	if err != nil {
+		return err
+	}
+	if err := products.CheckOptions(options, req.Options); err != nil {
+		return err
+	}
+
+	if req.ImageId != nil {
+		var n int
+		if err := tx.GetContext(ctx, &n, `SELECT COUNT(*) FROM "images" WHERE "id" = $1 AND "product_id" = $2;`, *req.ImageId, productId); err != nil {
+			return fmt.Errorf("get variant image failed: %v", err)
+		}
+		if n == 0 {
+			return products.ErrVariantImage
+		}
+	}
+	return nil
+}
+
+// variantError tells the unique constraints of a variant apart
+func variantError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "product_variants_sku_key":
+			return products.ErrSkuTaken
+		case "product_variants_product_id_options_key":
+			return products.ErrVariantDuplicate
+		}
+	}
+	return err
+}
+
+func (r *productRepository) InsertVariant(productId string, req *products.VariantReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+	if err := checkVariant(ctx, tx, productId, req); err != nil {
+		return err
+	}
+
+	options, err := json.Marshal(req.Options)
+	if err != nil {
+		return fmt.Errorf("marshal variant options failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "product_variants" (
+		"product_id",
+		"sku",
+		"options",
+		"price",
+		"stock",
+		"image_id",
+		"position"
+	)
+	SELECT
+		$1, $2, $3, $4, $5, $6,
+		COALESCE(MAX("position") + 1, 0)
+	FROM "product_variants"
+	WHERE "product_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, query, productId, req.Sku, options, req.Price, req.Stock, req.ImageId); err != nil {
+		return fmt.Errorf("insert variant failed: %w", variantError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit variant failed: %v", err)
+	}
+	return nil
+}
+
+func (r *productRepository) UpdateVariant(productId, variantId string, req *products.VariantReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProduct(ctx, tx, productId); err != nil {
+		return err
+	}
+	if err := checkVariant(ctx, tx, productId, req); err != nil {
+		return err
+	}
+
+	options, err := json.Marshal(req.Options)
+	if err != nil {
+		return fmt.Errorf("marshal variant options failed: %v", err)
+	}
+
+	query := `
+	UPDATE "product_variants" SET
+		"sku" = $3,
+		"options" = $4,
+		"price" = $5,
+		"stock" = $6,
+		"image_id" = $7
+	WHERE "product_id" = $1
+	AND "id" = $2;`
+
+	res, err := tx.ExecContext(ctx, query, productId, variantId, req.Sku, options, req.Price, req.Stock, req.ImageId)
+	if err != nil {
+		return fmt.Errorf("update variant failed: %w", variantError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return products.ErrVariantNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit variant failed: %v", err)
+	}
+	return nil
+}
+
+func (r *productRepository) DeleteVariant(productId, variantId string) error {
+	res, err := r.db.Exec(`DELETE FROM "product_variants" WHERE "product_id" = $1 AND "id" = $2;`, productId, variantId)
+	if err != nil {
+		return fmt.Errorf("delete variant failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return products.ErrVariantNotFound
+	}
+	return nil
+}
+
+// UpsertPrice sets a price list entry, the currency of the product itself
+// is priced by the product and its variants.
+func (r *productRepository) UpsertPrice(productId string, req *products.PriceReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	var currency string
+	if err := tx.GetContext(ctx, &currency, `SELECT "currency" FROM "products" WHERE "id" = $1 FOR UPDATE;`, productId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return products.ErrProductNotFound
+		}
+		return fmt.Errorf("lock product failed: %v", err)
+	}
+	if currency == req.Currency {
+		return products.ErrPriceCurrency
+	}
+
+	query := `
+	INSERT INTO "product_prices" (
+		"product_id",
+		"currency",
+		"price"
+	)
+	VALUES ($1, $2, $3)
+	ON CONFLICT ("product_id", "currency") WHERE "variant_id" IS NULL DO UPDATE SET
+		"price" = EXCLUDED."price";`
+	args := []any{productId, req.Currency, req.Price}
+
+	if req.VariantId != nil {
+		var n int
+		if err := tx.GetContext(ctx, &n, `SELECT COUNT(*) FROM "product_variants" WHERE "id" = $1 AND "product_id" = $2;`, *req.VariantId, productId); err != nil {
+			return fmt.Errorf("get variant failed: %v", err)
+		}
+		if n == 0 {
+			return products.ErrVariantNotFound
+		}
+
+		query = `
+		INSERT INTO "product_prices" (
+			"product_id",
+			"currency",
+			"price",
+			"variant_id"
+		)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("variant_id", "currency") WHERE "variant_id" IS NOT NULL DO UPDATE SET
+			"price" = EXCLUDED."price";`
+		args = append(args, *req.VariantId)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("upsert price failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit price failed: %v", err)
+	}
+	return nil
+}
+
+// DeletePrice removes a price list entry of the product, or of the variant
+// when variantId is set
+func (r *productRepository) DeletePrice(productId string, variantId *string, currency string) error {
+	query := `
+	DELETE FROM "product_prices"
+	WHERE "product_id" = $1
+	AND "currency" = $2
+	AND "variant_id" IS NOT DISTINCT FROM $3;`
+
+	res, err := r.db.Exec(query, productId, currency, variantId)
+	if err != nil {
+		return fmt.Errorf("delete price failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return products.ErrPriceNotFound
+	}
+	return nil
+}
+
+func (r *productRepository) UpdateWeight(productId string, weight int) error {
+	query := `
+	UPDATE "products" SET
+		"weight" = $2
+	WHERE "id" = $1;`
+
+	res, err := r.db.Exec(query, productId, weight)
+	if err != nil {
+		return fmt.Errorf("update weight failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return products.ErrProductNotFound
+	}
+	return nil
+}
+
+func (r *productRepository) FindFavorited(userId string, productIds []string) ([]string, error) {
+	query := `
+	SELECT
+		"product_id"
+	FROM "wishlists"
+	WHERE "user_id" = $1
+	AND "product_id" = ANY($2);`
+
+	ids := make([]string, 0)
+	if err := r.db.Select(&ids, query, userId, productIds); err != nil {
+		return nil, fmt.Errorf("get favorited products failed: %v", err)
+	}
+	return ids, nil
+}
+
+func (r *productRepository) FindProductIds(productIds []string) ([]string, error) {
+	ids := make([]string, 0)
+	if err := r.db.Select(&ids, `SELECT "id" FROM "products" WHERE "id" = ANY($1);`, productIds); err != nil {
+		return nil, fmt.Errorf("get product ids failed: %v", err)
+	}
+	return ids, nil
+}
+
+func (r *productRepository) FindPricedProducts(productIds []string) (map[string]string, error) {
+	query := `
+	SELECT
+		"p"."id",
+		TRIM("p"."currency") AS "currency"
+	FROM "products" "p"
+	WHERE "p"."id" = ANY($1)
+	AND (
+		EXISTS (SELECT 1 FROM "product_variants" "v" WHERE "v"."product_id" = "p"."id" AND "v"."price" IS NOT NULL)
+		OR EXISTS (SELECT 1 FROM "product_prices" "pp" WHERE "pp"."product_id" = "p"."id")
+	);`
+
+	rows := make([]struct {
+		Id       string `db:"id"`
+		Currency string `db:"currency"`
+	}, 0)
+	if err := r.db.Select(&rows, query, productIds); err != nil {
+		return nil, fmt.Errorf("get priced products failed: %v", err)
+	}
+
+	res := make(map[string]string, len(rows))
+	for _, p := range rows {
+		res[p.Id] = p.Currency
+	}
+	return res, nil
+}
+
+func (r *productRepository) FindCategoryIds(titles []string) (map[string]int, error) {
+	query := `
+	SELECT
+		"id",
+		LOWER("title") AS "title"
+	FROM "categories"
+	WHERE LOWER("title") = ANY($1);`
+
+	rows := make([]struct {
+		Id    int    `db:"id"`
+		Title string `db:"title"`
+	}, 0)
+	if err := r.db.Select(&rows, query, titles); err != nil {
+		return nil, fmt.Errorf("get categories failed: %v", err)
+	}
+
+	res := make(map[string]int, len(rows))
+	for _, c := range rows {
+		res[c.Title] = c.Id
+	}
+	return res, nil
+}
+
+func (r *productRepository) FindProductImages(productIds []string) (map[string][]*entities.Image, error) {
+	query := `
+	SELECT
+		"product_id",
+		COALESCE(array_to_json(array_agg(json_build_object(
+			'id', "id",
+			'filename', "filename",
+			'url', "url",
+			'variants', "variants",
+			'private', "private",
+			'position', "position",
+			'alt', "alt",
+			'is_primary', "is_primary"
+		) ORDER BY "position")), '[]'::json) AS "images"
+	FROM "images"
+	WHERE "product_id" = ANY($1)
+	GROUP BY "product_id";`
+
+	rows := make([]struct {
+		ProductId string `db:"product_id"`
+		Images    []byte `db:"images"`
+	}, 0)
+	if err := r.db.Select(&rows, query, productIds); err != nil {
+		return nil, fmt.Errorf("get product images failed: %v", err)
+	}
+
+	res := make(map[string][]*entities.Image, len(rows))
+	for _, row := range rows {
+		images := make([]*entities.Image, 0)
+		if err := json.Unmarshal(row.Images, &images); err != nil {
+			return nil, fmt.Errorf("unmarshal product images failed: %v", err)
+		}
+		res[row.ProductId] = images
+	}
+	return res, nil
+}
+
+func (r *productRepository) ImportProducts(ctx context.Context, req []*products.ImportProduct, batchSize int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(req); start += batchSize {
+		end := min(start+batchSize, len(req))
+		if err := productsPatterns.UpsertProducts(ctx, tx, req[start:end]); err != nil {
+			return fmt.Errorf("import rows %d to %d: %w", start+1, end, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit import failed: %v", err)
+	}
+	return nil
+}
+
+func (r *productRepository) ExportProducts(ctx context.Context, fn func(row *products.CatalogRow, images []*entities.Image) error) error {
+	query := `
+	SELECT
+		"p"."id",
+		"p"."title",
+		"p"."description",
+		"p"."price",
+		"p"."currency",
+		COALESCE((
+			SELECT
+				"c"."title"
+			FROM "categories" "c"
+				JOIN "products_categories" "pc" ON "pc"."category_id" = "c"."id"
+			WHERE "pc"."product_id" = "p"."id"
+			LIMIT 1
+		), '') AS "category",
+		(
+			SELECT
+				COALESCE(array_to_json(array_agg(json_build_object(
+					'url', "i"."url",
+					'variants', "i"."variants",
+					'private', "i"."private"
+				) ORDER BY "i"."position")), '[]'::json)
+			FROM "images" "i"
+			WHERE "i"."product_id" = "p"."id"
+		) AS "images"
+	FROM "products" "p"
+	ORDER BY "p"."id";`
+
+	// Rows are read from the connection one by one, the catalog is never
+	// held in memory at once
+	rows, err := r.db.QueryxContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("export products failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row     products.CatalogRow
+			price   int64
+			imgJson []byte
+		)
+		if err := rows.Scan(&row.Id, &row.Title, &row.Description, &price, &row.Currency, &row.Category, &imgJson); err != nil {
+			return fmt.Errorf("scan product failed: %v", err)
+		}
+		c, err := kawaiimoney.Lookup(row.Currency)
+		if err != nil {
+			return fmt.Errorf("product %s: %w", row.Id, err)
+		}
+		row.Price = json.Number(kawaiimoney.Decimal(price, c))
+
+		images := make([]*entities.Image, 0)
+		if err := json.Unmarshal(imgJson, &images); err != nil {
+			return fmt.Errorf("unmarshal images of %s failed: %v", row.Id, err)
+		}
+		if err := fn(&row, images); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("export products failed: %v", err)
+	}
+	return nil
+}
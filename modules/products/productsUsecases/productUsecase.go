@@ -1,40 +1,234 @@
 package productsUsecases
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/files"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/products"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsRepositories"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
 )
 
 type IProductsUsecase interface {
-	FindOneProduct(productId string) (*products.Product, error)
-	FindProduct(req *products.ProductFilter) *entities.PaginateRes
+	FindOneProduct(productId, currency string) (*products.Product, error)
+	FindProduct(req *products.ProductFilter) (*entities.PaginateRes, error)
+	// MarkFavorited sets whether the user wishlisted each of ps
+	MarkFavorited(userId string, ps ...*products.Product) error
+	FindImages(productId string) ([]*entities.Image, error)
+	AddImages(ctx context.Context, userId, productId string, req []*files.FileReq, alts []string) ([]*entities.Image, error)
+	UpdateImage(productId, imageId string, req *products.ImageUpdateReq) ([]*entities.Image, error)
+	ReorderImages(productId string, req *products.ImageOrderReq) ([]*entities.Image, error)
+	DeleteImage(ctx context.Context, productId, imageId string) error
+	ReplaceOptions(productId string, req *products.OptionsReq) (*products.Product, error)
+	InsertVariant(productId string, req *products.VariantReq) (*products.Product, error)
+	UpdateVariant(productId, variantId string, req *products.VariantReq) (*products.Product, error)
+	DeleteVariant(productId, variantId string) (*products.Product, error)
+	UpsertPrice(productId string, req *products.PriceReq) (*products.Product, error)
+	DeletePrice(productId string, variantId *string, currency string) (*products.Product, error)
+	UpdateWeight(productId string, req *products.WeightReq) (*products.Product, error)
+	// ImportProducts validates every row first, any invalid row fails the
+	// import with a kawaiivalidator.ValidationError listing them all
+	ImportProducts(ctx context.Context, rows []*products.CatalogRow, dryRun bool) (*products.ImportResult, error)
+	// ExportProducts writes the catalog to w as csv or json while reading it.
+	// A failure part way ends w with a products.CatalogErrorMarker record.
+	ExportProducts(ctx context.Context, format string, w io.Writer) error
 }
 
 type productsUsecase struct {
+	cfg                config.IConfig
 	productsRepository productsRepositories.IProductRepository
+	filesUsecase       filesUsecases.IFilesUsecase
+	currenciesUsecase  currenciesUsecases.ICurrenciesUsecase
 }
 
-func ProductsUsecase(productsRepository productsRepositories.IProductRepository) IProductsUsecase {
+func ProductsUsecase(cfg config.IConfig, productsRepository productsRepositories.IProductRepository, filesUsecase filesUsecases.IFilesUsecase, currenciesUsecase currenciesUsecases.ICurrenciesUsecase) IProductsUsecase {
 	return &productsUsecase{
+		cfg:                cfg,
 		productsRepository: productsRepository,
+		filesUsecase:       filesUsecase,
+		currenciesUsecase:  currenciesUsecase,
 	}
 }
 
-func (u *productsUsecase) FindOneProduct(productId string) (*products.Product, error) {
+// pricing resolves the currency of a request, nil keeps every product in
+// its own currency and needs no exchange rates.
+func (u *productsUsecase) pricing(currency string) (*kawaiimoney.Currency, kawaiimoney.Rates, error) {
+	if currency == "" {
+		return nil, nil, nil
+	}
+
+	c, err := kawaiimoney.Lookup(currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	rates, err := u.currenciesUsecase.Rates()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, rates, nil
+}
+
+func reprice(p *products.Product, c *kawaiimoney.Currency, rates kawaiimoney.Rates) error {
+	if c == nil {
+		own, err := kawaiimoney.Lookup(p.Price.Currency)
+		if err != nil {
+			return err
+		}
+		c = own
+	}
+	return p.Reprice(c, rates)
+}
+
+// amount turns a price filter in major units of c into minor units of
+// APP_CURRENCY, the prices of the products are turned into it by unitRates
+func (u *productsUsecase) amount(price *float64, c *kawaiimoney.Currency, rates kawaiimoney.Rates) (*int64, error) {
+	if price == nil {
+		return nil, nil
+	}
+
+	base, err := kawaiimoney.Lookup(u.cfg.App().Currency())
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		c = base
+	}
+
+	minor, err := kawaiimoney.Parse(strconv.FormatFloat(*price, 'f', -1, 64), c)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := rates.Rate(c.Code, base.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := kawaiimoney.Convert(minor, c, base, rate)
+	return &amount, nil
+}
+
+// unitRates turns the minor units of every currency with a rate into minor
+// units of APP_CURRENCY, as decimals for the database
+func (u *productsUsecase) unitRates(rates kawaiimoney.Rates) (map[string]string, error) {
+	base, err := kawaiimoney.Lookup(u.cfg.App().Currency())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	for _, c := range kawaiimoney.Currencies() {
+		rate, err := rates.Rate(c.Code, base.Code)
+		if errors.Is(err, kawaiimoney.ErrNoRate) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[c.Code] = kawaiimoney.UnitRate(c, base, rate).FloatString(12)
+	}
+	return res, nil
+}
+
+// FindOneProduct returns the product priced in currency, its own currency
+// when currency is empty
+func (u *productsUsecase) FindOneProduct(productId, currency string) (*products.Product, error) {
+	c, rates, err := u.pricing(currency)
+	if err != nil {
+		return nil, err
+	}
+
 	product, err := u.productsRepository.FindOneProduct(productId)
 	if err != nil {
 		return nil, err
 	}
+	if err := reprice(product, c, rates); err != nil {
+		return nil, err
+	}
+
+	if err := u.filesUsecase.ResolveImages(product.Image); err != nil {
+		return nil, err
+	}
 	return product, nil
 }
 
-func (u *productsUsecase) FindProduct(req *products.ProductFilter) *entities.PaginateRes {
+func (u *productsUsecase) MarkFavorited(userId string, ps ...*products.Product) error {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(ps))
+	for _, p := range ps {
+		ids = append(ids, p.Id)
+	}
+	favorited, err := u.productsRepository.FindFavorited(userId, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ps {
+		f := slices.Contains(favorited, p.Id)
+		p.Favorited = &f
+	}
+	return nil
+}
+
+func (u *productsUsecase) FindProduct(req *products.ProductFilter) (*entities.PaginateRes, error) {
+	c, rates, err := u.pricing(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if req.MinPrice != nil || req.MaxPrice != nil {
+		// Products are compared in APP_CURRENCY whatever they are priced in
+		if rates == nil {
+			if rates, err = u.currenciesUsecase.Rates(); err != nil {
+				return nil, err
+			}
+		}
+		if req.UnitRates, err = u.unitRates(rates); err != nil {
+			return nil, err
+		}
+	}
+	if req.MinAmount, err = u.amount(req.MinPrice, c, rates); err != nil {
+		return nil, err
+	}
+	if req.MaxAmount, err = u.amount(req.MaxPrice, c, rates); err != nil {
+		return nil, err
+	}
+
 	products, count := u.productsRepository.FindProduct(req)
 
+	for _, p := range products {
+		if err := reprice(p, c, rates); err != nil {
+			return nil, fmt.Errorf("price %s: %w", p.Id, err)
+		}
+
+		if err := u.filesUsecase.ResolveImages(p.Image); err != nil {
+			// The listing is still useful without the images
+			log.Printf("resolve images of %s failed: %v", p.Id, err)
+			p.Image = make([]*entities.Image, 0)
+		}
+	}
+
+	if req.UserId != "" {
+		if err := u.MarkFavorited(req.UserId, products...); err != nil {
+			return nil, err
+		}
+	}
+
 	fmt.Println(products)
 
 	return &entities.PaginateRes{
@@ -43,5 +237,421 @@ func (u *productsUsecase) FindProduct(req *products.ProductFilter) *entities.Pag
 		Limit:     req.Limit,
 		TotalItem: count,
 		TotalPage: int(math.Ceil(float64(count) / float64(req.Limit))),
+	}, nil
+}
+
+// FindImages returns the images of a product in order
+func (u *productsUsecase) FindImages(productId string) ([]*entities.Image, error) {
+	images, err := u.productsRepository.FindImages(productId)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.filesUsecase.ResolveImages(images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// AddImages uploads the files and appends them to the images of the
+// product, alts[i] is the alt text of req[i]. Either every file becomes an
+// image or none does.
+func (u *productsUsecase) AddImages(ctx context.Context, userId, productId string, req []*files.FileReq, alts []string) ([]*entities.Image, error) {
+	if _, err := u.productsRepository.FindOneProduct(productId); err != nil {
+		return nil, err
+	}
+
+	res, err := u.filesUsecase.UploadFiles(ctx, userId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	fileIds := make([]string, 0, len(res))
+	var failed error
+	for _, f := range res {
+		if f.Err != nil {
+			if failed == nil {
+				failed = f.Err
+			}
+			continue
+		}
+		fileIds = append(fileIds, f.Id)
+	}
+
+	if failed == nil {
+		failed = u.productsRepository.InsertImages(productId, fileIds, alts)
+	}
+	if failed != nil {
+		u.discardFiles(ctx, userId, fileIds)
+		return nil, failed
+	}
+
+	return u.FindImages(productId)
+}
+
+// discardFiles deletes files uploaded for images that were not added
+func (u *productsUsecase) discardFiles(ctx context.Context, userId string, fileIds []string) {
+	req := make([]*files.DeleteFileReq, 0, len(fileIds))
+	for _, id := range fileIds {
+		req = append(req, &files.DeleteFileReq{Id: id})
+	}
+
+	for _, r := range u.filesUsecase.DeleteFiles(context.WithoutCancel(ctx), userId, req) {
+		if r.Err != nil {
+			// Unreferenced, the garbage collector gets it later
+			log.Printf("discard file %s failed: %v", r.Id, r.Err)
+		}
+	}
+}
+
+func (u *productsUsecase) UpdateImage(productId, imageId string, req *products.ImageUpdateReq) ([]*entities.Image, error) {
+	if err := u.productsRepository.UpdateImage(productId, imageId, req); err != nil {
+		return nil, err
+	}
+	return u.FindImages(productId)
+}
+
+func (u *productsUsecase) ReorderImages(productId string, req *products.ImageOrderReq) ([]*entities.Image, error) {
+	for _, id := range req.ImageIds {
+		if !kawaiivalidator.IsUUID(id) {
+			return nil, products.ErrImageOrder
+		}
+	}
+
+	if err := u.productsRepository.ReorderImages(productId, req.ImageIds); err != nil {
+		return nil, err
+	}
+	return u.FindImages(productId)
+}
+
+// DeleteImage removes the image and its stored objects. The objects are
+// kept while another image uses the same file.
+func (u *productsUsecase) DeleteImage(ctx context.Context, productId, imageId string) error {
+	fileId, err := u.productsRepository.DeleteImage(productId, imageId)
+	if err != nil {
+		return err
+	}
+	// Images from before the files table have no file, their objects are
+	// not tracked and stay where they are.
+	if fileId == "" {
+		return nil
+	}
+
+	if err := u.filesUsecase.RemoveFile(ctx, fileId); err != nil {
+		switch {
+		case errors.Is(err, files.ErrFileInUse), errors.Is(err, files.ErrFileNotFound):
+		default:
+			// The image is gone already, the garbage collector removes
+			// the unreferenced file after the grace period.
+			log.Printf("remove file %s of image %s failed: %v", fileId, imageId, err)
+		}
+	}
+	return nil
+}
+
+// ReplaceOptions sets the option axes of a product, names and the values
+// of one option are unique.
+func (u *productsUsecase) ReplaceOptions(productId string, req *products.OptionsReq) (*products.Product, error) {
+	names := make(map[string]bool)
+	for _, o := range req.Options {
+		if names[o.Name] {
+			return nil, fmt.Errorf("%w, %s is listed twice", products.ErrOptionsInvalid, o.Name)
+		}
+		names[o.Name] = true
+
+		values := make(map[string]bool)
+		for _, v := range o.Values {
+			if v == "" || values[v] {
+				return nil, fmt.Errorf("%w, values of %s must be unique and not empty", products.ErrOptionsInvalid, o.Name)
+			}
+			values[v] = true
+		}
+	}
+
+	if err := u.productsRepository.ReplaceOptions(productId, req.Options); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) InsertVariant(productId string, req *products.VariantReq) (*products.Product, error) {
+	if err := u.productsRepository.InsertVariant(productId, req); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) UpdateVariant(productId, variantId string, req *products.VariantReq) (*products.Product, error) {
+	if err := u.productsRepository.UpdateVariant(productId, variantId, req); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) DeleteVariant(productId, variantId string) (*products.Product, error) {
+	if err := u.productsRepository.DeleteVariant(productId, variantId); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) UpsertPrice(productId string, req *products.PriceReq) (*products.Product, error) {
+	c, err := kawaiimoney.Lookup(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	req.Currency = c.Code
+
+	if err := u.productsRepository.UpsertPrice(productId, req); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) DeletePrice(productId string, variantId *string, currency string) (*products.Product, error) {
+	c, err := kawaiimoney.Lookup(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.productsRepository.DeletePrice(productId, variantId, c.Code); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+func (u *productsUsecase) UpdateWeight(productId string, req *products.WeightReq) (*products.Product, error) {
+	if err := u.productsRepository.UpdateWeight(productId, req.Weight); err != nil {
+		return nil, err
+	}
+	return u.FindOneProduct(productId, "")
+}
+
+// importBatchSize is how many rows of an import are written together
+const importBatchSize = 500
+
+// catalogErrors collects the row errors of an import, rows count from 1
+type catalogErrors []*kawaiivalidator.FieldError
+
+func (e *catalogErrors) add(row int, field, rule, msg string) {
+	*e = append(*e, &kawaiivalidator.FieldError{
+		Field:   fmt.Sprintf("rows[%d].%s", row, field),
+		Rule:    rule,
+		Message: fmt.Sprintf("row %d: %s", row, msg),
+	})
+}
+
+// linkable tells an image url of an import is an absolute http(s) url
+func linkable(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// withoutQuery drops the query of a url, signed urls change every time
+// they are made
+func withoutQuery(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+func (u *productsUsecase) ImportProducts(ctx context.Context, rows []*products.CatalogRow, dryRun bool) (*products.ImportResult, error) {
+	errs := make(catalogErrors, 0)
+	req := make([]*products.ImportProduct, len(rows))
+
+	ids := make([]string, 0)
+	seen := make(map[string]int)
+	titles := make([]string, 0)
+	for i, row := range rows {
+		n := i + 1
+		row.Id = strings.TrimSpace(row.Id)
+		row.Title = strings.TrimSpace(row.Title)
+		row.Description = strings.TrimSpace(row.Description)
+		row.Currency = strings.TrimSpace(row.Currency)
+		row.Category = strings.TrimSpace(row.Category)
+
+		p := &products.ImportProduct{
+			Id:          row.Id,
+			Title:       row.Title,
+			Description: row.Description,
+		}
+		req[i] = p
+
+		var verr *kawaiivalidator.ValidationError
+		if err := kawaiivalidator.Validate(row); errors.As(err, &verr) {
+			for _, f := range verr.Errors {
+				errs.add(n, f.Field, f.Rule, f.Message)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		if row.Id != "" {
+			if first, ok := seen[row.Id]; ok {
+				errs.add(n, "id", "unique", fmt.Sprintf("product %s is in row %d already", row.Id, first))
+			} else {
+				seen[row.Id] = n
+				ids = append(ids, row.Id)
+			}
+		}
+
+		if row.Currency == "" {
+			row.Currency = u.cfg.App().Currency()
+		}
+		c, err := kawaiimoney.Lookup(row.Currency)
+		if err != nil {
+			errs.add(n, "currency", "currency", err.Error())
+		} else if row.Price != "" {
+			p.Currency = c.Code
+			if p.Price, err = kawaiimoney.Parse(string(row.Price), c); err != nil {
+				errs.add(n, "price", "amount", err.Error())
+			} else if p.Price < 0 {
+				errs.add(n, "price", "min", "price must be at least 0")
+			}
+		}
+
+		if row.Category != "" {
+			titles = append(titles, strings.ToLower(row.Category))
+		}
+
+		listed := make(map[string]bool)
+		for _, link := range row.Images {
+			link = strings.TrimSpace(link)
+			switch {
+			case !linkable(link):
+				errs.add(n, "images", "url", fmt.Sprintf("image %q must be an http or https url", link))
+			case listed[link]:
+				errs.add(n, "images", "unique", fmt.Sprintf("image %q is listed twice", link))
+			default:
+				listed[link] = true
+				p.Images = append(p.Images, &products.ImportImage{Url: link})
+			}
+		}
+	}
+
+	found, err := u.productsRepository.FindProductIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	categories, err := u.productsRepository.FindCategoryIds(titles)
+	if err != nil {
+		return nil, err
+	}
+	images, err := u.productsRepository.FindProductImages(found)
+	if err != nil {
+		return nil, err
+	}
+	priced, err := u.productsRepository.FindPricedProducts(found)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &products.ImportResult{
+		DryRun: dryRun,
+		Rows:   len(rows),
+	}
+	for i, row := range rows {
+		n, p := i+1, req[i]
+
+		if row.Category != "" {
+			id, ok := categories[strings.ToLower(row.Category)]
+			if !ok {
+				errs.add(n, "category", "exists", fmt.Sprintf("category %q not found", row.Category))
+			}
+			p.CategoryId = &id
+		}
+
+		if p.Id == "" {
+			res.Created++
+			continue
+		}
+		if !exists[p.Id] {
+			errs.add(n, "id", "exists", fmt.Sprintf("product %s not found", p.Id))
+			continue
+		}
+		res.Updated++
+
+		if current, ok := priced[p.Id]; ok && p.Currency != "" && p.Currency != current {
+			errs.add(n, "currency", "currency", fmt.Sprintf("product %s has variant or price list prices against %s, its currency cannot change", p.Id, current))
+		}
+
+		if err := u.matchImages(p, images[p.Id]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, &kawaiivalidator.ValidationError{Errors: errs}
+	}
+	if dryRun {
+		return res, nil
+	}
+
+	if err := u.productsRepository.ImportProducts(ctx, req, importBatchSize); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// matchImages keeps the images of the product listed in the import, by
+// the url stored or the url it is served at. The rest of the urls are
+// linked.
+func (u *productsUsecase) matchImages(p *products.ImportProduct, current []*entities.Image) error {
+	if p.Images == nil || len(current) == 0 {
+		return nil
+	}
+
+	byUrl := make(map[string]string)
+	for _, img := range current {
+		byUrl[img.Url] = img.Id
+	}
+	if err := u.filesUsecase.ResolveImages(current); err != nil {
+		return err
+	}
+	for _, img := range current {
+		byUrl[withoutQuery(img.Url)] = img.Id
+	}
+
+	kept := make(map[string]bool)
+	for _, img := range p.Images {
+		id, ok := byUrl[img.Url]
+		if !ok {
+			id, ok = byUrl[withoutQuery(img.Url)]
+		}
+		// A product image listed under two urls is kept once
+		if ok && !kept[id] {
+			kept[id] = true
+			img.Id = &id
+		}
+	}
+	return nil
+}
+
+func (u *productsUsecase) ExportProducts(ctx context.Context, format string, w io.Writer) error {
+	writer := products.NewCatalogWriter(format, w)
+
+	if err := u.productsRepository.ExportProducts(ctx, func(row *products.CatalogRow, images []*entities.Image) error {
+		if err := u.filesUsecase.ResolveImages(images); err != nil {
+			return fmt.Errorf("resolve images of %s: %w", row.Id, err)
+		}
+		row.Images = make([]string, 0, len(images))
+		for _, img := range images {
+			row.Images = append(row.Images, img.Url)
+		}
+		return writer.Write(row)
+	}); err != nil {
+		// The rows written cannot be taken back, the reader is told they
+		// are not all
+		if abortErr := writer.Abort(); abortErr != nil {
+			log.Printf("abort export failed: %v", abortErr)
+		}
+		return err
 	}
+	return writer.Close()
 }
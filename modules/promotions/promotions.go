@@ -0,0 +1,400 @@
+package promotions
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var (
+	ErrPromotionNotFound = errors.New("promotion not found")
+	ErrPromotionInvalid  = errors.New("promotion is invalid")
+	ErrCodeTaken         = errors.New("coupon code has been used")
+	ErrNotApplicable     = errors.New("coupon does not apply")
+	ErrPromotionRedeemed = errors.New("promotion has been redeemed, deactivate it instead")
+)
+
+const (
+	// KindPercentage takes Value basis points off, 1250 is 12.5%
+	KindPercentage = "percentage"
+	// KindFixed takes Value minor units of Currency off
+	KindFixed = "fixed"
+)
+
+// Rules of a promotion in the order they are checked
+const (
+	RuleActive       = "active"
+	RuleWindow       = "validity_window"
+	RuleUsageLimit   = "usage_limit"
+	RulePerUserLimit = "per_user_limit"
+	RuleCurrency     = "currency"
+	RuleRestriction  = "restriction"
+	RuleMinSpend     = "min_spend"
+)
+
+type Promotion struct {
+	Id       string `json:"id"`
+	Code     string `json:"code"`
+	Title    string `json:"title"`
+	Kind     string `json:"kind"`
+	Value    int64  `json:"value"`
+	Currency string `json:"currency"`
+	// Minor units of Currency the eligible lines must add up to
+	MinSpend int64 `json:"min_spend"`
+	// Redemptions allowed in total and per user, unlimited when empty
+	UsageLimit   *int       `json:"usage_limit"`
+	PerUserLimit *int       `json:"per_user_limit"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	Active       bool       `json:"active"`
+	// Higher priorities apply first when coupons are combined
+	Priority int `json:"priority"`
+	// Only lines of these products or categories are discounted, every
+	// line when both are empty
+	ProductIds  []string `json:"product_ids"`
+	CategoryIds []int    `json:"category_ids"`
+	UsageCount  int      `json:"usage_count"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+// PromotionReq creates a promotion or replaces one
+type PromotionReq struct {
+	Code     string `json:"code" validate:"required,max=50"`
+	Title    string `json:"title" validate:"max=255"`
+	Kind     string `json:"kind" validate:"required,enum=percentage|fixed"`
+	Value    int64  `json:"value" validate:"required,min=1"`
+	Currency string `json:"currency"`
+	MinSpend int64  `json:"min_spend" validate:"min=0"`
+	// Empty is unlimited
+	UsageLimit   *int       `json:"usage_limit" validate:"min=1"`
+	PerUserLimit *int       `json:"per_user_limit" validate:"min=1"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	// True when empty
+	Active      *bool    `json:"active"`
+	Priority    int      `json:"priority"`
+	ProductIds  []string `json:"product_ids"`
+	CategoryIds []int    `json:"category_ids"`
+}
+
+type PromotionFilter struct {
+	Code   string `query:"code"`
+	Active *bool  `query:"active"`
+}
+
+// ValidateReq asks what a coupon takes off the lines, they are priced from
+// the products in Currency
+type ValidateReq struct {
+	Code string `json:"code" validate:"required,max=50"`
+	// ISO 4217 code, APP_CURRENCY when empty
+	Currency string     `json:"currency"`
+	Lines    []*LineReq `json:"lines" validate:"required"`
+}
+
+type LineReq struct {
+	ProductId string  `json:"product_id" validate:"required"`
+	VariantId *string `json:"variant_id" validate:"uuid"`
+	Quantity  int     `json:"quantity" validate:"required,min=1"`
+}
+
+// Cart is what the rules are evaluated against, prices are minor units of
+// Currency
+type Cart struct {
+	Currency string
+	Lines    []*Line
+}
+
+type Line struct {
+	ProductId  string
+	VariantId  *string
+	CategoryId int
+	Quantity   int
+	UnitPrice  int64
+}
+
+func (l *Line) total() int64 {
+	return l.UnitPrice * int64(l.Quantity)
+}
+
+// Usage is how often a promotion has been redeemed, in total and by the
+// user the cart belongs to
+type Usage struct {
+	Total int
+	User  int
+}
+
+type Reason struct {
+	Rule    string `json:"rule"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+type LineDiscount struct {
+	// Line is the index of the line in the cart
+	Line      int                `json:"line"`
+	ProductId string             `json:"product_id"`
+	VariantId *string            `json:"variant_id"`
+	Eligible  bool               `json:"eligible"`
+	Discount  *kawaiimoney.Money `json:"discount"`
+	Reason    string             `json:"reason"`
+}
+
+// Evaluation is the outcome of one promotion, Reasons holds every rule
+// whether it passed or not
+type Evaluation struct {
+	PromotionId string             `json:"promotion_id"`
+	Code        string             `json:"code"`
+	Applied     bool               `json:"applied"`
+	Reasons     []*Reason          `json:"reasons"`
+	Lines       []*LineDiscount    `json:"lines"`
+	Discount    *kawaiimoney.Money `json:"discount"`
+}
+
+// Redemption records one use of a promotion
+type Redemption struct {
+	Id          string  `db:"id" json:"id"`
+	PromotionId string  `db:"promotion_id" json:"promotion_id"`
+	UserId      string  `db:"user_id" json:"user_id"`
+	OrderId     *string `db:"order_id" json:"order_id"`
+	Discount    int64   `db:"discount" json:"discount"`
+	Currency    string  `db:"currency" json:"currency"`
+}
+
+// Evaluate checks the rules of the promotions against cart at now. The
+// promotions apply by priority, highest first, then by code, each one
+// discounting what the ones before left of a line, so a cart always gets
+// the same result. usage is keyed by promotion id.
+func Evaluate(promotions []*Promotion, cart *Cart, usage map[string]*Usage, now time.Time, rates kawaiimoney.Rates) ([]*Evaluation, error) {
+	c, err := kawaiimoney.Lookup(cart.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := slices.Clone(promotions)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority > ordered[j].Priority
+		}
+		return ordered[i].Code < ordered[j].Code
+	})
+
+	remaining := make([]int64, len(cart.Lines))
+	for i, l := range cart.Lines {
+		remaining[i] = l.total()
+	}
+
+	res := make([]*Evaluation, 0, len(ordered))
+	for _, p := range ordered {
+		u := usage[p.Id]
+		if u == nil {
+			u = new(Usage)
+		}
+
+		e, err := evaluate(p, cart, remaining, u, now, c, rates)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func evaluate(p *Promotion, cart *Cart, remaining []int64, u *Usage, now time.Time, c *kawaiimoney.Currency, rates kawaiimoney.Rates) (*Evaluation, error) {
+	e := &Evaluation{
+		PromotionId: p.Id,
+		Code:        p.Code,
+		Reasons:     make([]*Reason, 0),
+		Lines:       make([]*LineDiscount, 0, len(cart.Lines)),
+		Discount:    kawaiimoney.New(0, c),
+	}
+	check := func(rule string, passed bool, format string, args ...any) {
+		e.Reasons = append(e.Reasons, &Reason{
+			Rule:    rule,
+			Passed:  passed,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if p.Active {
+		check(RuleActive, true, "promotion is active")
+	} else {
+		check(RuleActive, false, "promotion is not active")
+	}
+
+	switch {
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		check(RuleWindow, false, "promotion starts at %s", p.StartsAt.Format(time.RFC3339))
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		check(RuleWindow, false, "promotion ended at %s", p.EndsAt.Format(time.RFC3339))
+	default:
+		check(RuleWindow, true, "promotion is running")
+	}
+
+	if p.UsageLimit != nil && u.Total >= *p.UsageLimit {
+		check(RuleUsageLimit, false, "promotion has been used %d of %d times", u.Total, *p.UsageLimit)
+	} else {
+		check(RuleUsageLimit, true, "promotion has uses left")
+	}
+	if p.PerUserLimit != nil && u.User >= *p.PerUserLimit {
+		check(RulePerUserLimit, false, "you have used the promotion %d of %d times", u.User, *p.PerUserLimit)
+	} else {
+		check(RulePerUserLimit, true, "you have uses left")
+	}
+
+	// Fixed amounts and the minimum spend are in the currency of the
+	// promotion, the cart may be in another one
+	value, minSpend := p.Value, p.MinSpend
+	if from, err := kawaiimoney.Lookup(p.Currency); err != nil {
+		return nil, err
+	} else if rate, err := rates.Rate(from.Code, c.Code); err != nil {
+		check(RuleCurrency, false, "promotion amounts in %s cannot be converted to %s", from.Code, c.Code)
+	} else {
+		if p.Kind == KindFixed {
+			value = kawaiimoney.Convert(p.Value, from, c, rate)
+		}
+		minSpend = kawaiimoney.Convert(p.MinSpend, from, c, rate)
+		check(RuleCurrency, true, "amounts are in %s", c.Code)
+	}
+
+	var (
+		eligible = make([]bool, len(cart.Lines))
+		subtotal int64
+		found    bool
+	)
+	for i, l := range cart.Lines {
+		line := &LineDiscount{
+			Line:      i,
+			ProductId: l.ProductId,
+			VariantId: l.VariantId,
+			Discount:  kawaiimoney.New(0, c),
+		}
+		switch {
+		case len(p.ProductIds) == 0 && len(p.CategoryIds) == 0:
+			line.Eligible, line.Reason = true, "every product is included"
+		case slices.Contains(p.ProductIds, l.ProductId):
+			line.Eligible, line.Reason = true, "product is included"
+		case slices.Contains(p.CategoryIds, l.CategoryId):
+			line.Eligible, line.Reason = true, "category is included"
+		default:
+			line.Reason = "product and category are not included"
+		}
+		if line.Eligible {
+			eligible[i] = true
+			subtotal += l.total()
+			found = true
+		}
+		e.Lines = append(e.Lines, line)
+	}
+
+	if found {
+		check(RuleRestriction, true, "cart has eligible products")
+	} else {
+		check(RuleRestriction, false, "no product of the cart is eligible")
+	}
+
+	if subtotal >= minSpend {
+		check(RuleMinSpend, true, "eligible products add up to %s", kawaiimoney.Format(subtotal, c))
+	} else {
+		check(RuleMinSpend, false, "eligible products add up to %s of %s", kawaiimoney.Format(subtotal, c), kawaiimoney.Format(minSpend, c))
+	}
+
+	e.Applied = true
+	for _, r := range e.Reasons {
+		e.Applied = e.Applied && r.Passed
+	}
+	if !e.Applied {
+		for _, line := range e.Lines {
+			if line.Eligible {
+				line.Reason = "promotion does not apply"
+			}
+		}
+		return e, nil
+	}
+
+	var discounts []int64
+	switch p.Kind {
+	case KindPercentage:
+		discounts = percentage(remaining, eligible, p.Value, c)
+	case KindFixed:
+		discounts = spread(remaining, eligible, value)
+	default:
+		return nil, fmt.Errorf("%w, kind %s", ErrPromotionInvalid, p.Kind)
+	}
+
+	var total int64
+	for i, d := range discounts {
+		remaining[i] -= d
+		total += d
+		e.Lines[i].Discount = kawaiimoney.New(d, c)
+	}
+	e.Discount = kawaiimoney.New(total, c)
+	return e, nil
+}
+
+// percentage takes basisPoints of every eligible line, rounded half away
+// from zero line by line
+func percentage(remaining []int64, eligible []bool, basisPoints int64, c *kawaiimoney.Currency) []int64 {
+	rate := big.NewRat(basisPoints, 10000)
+	res := make([]int64, len(remaining))
+	for i, r := range remaining {
+		if eligible[i] {
+			res[i] = kawaiimoney.Convert(r, c, c, rate)
+		}
+	}
+	return res
+}
+
+// spread divides amount over the eligible lines by their share of the
+// remaining total. The units left by rounding down go to the largest
+// remainders, earlier lines first on a tie.
+func spread(remaining []int64, eligible []bool, amount int64) []int64 {
+	res := make([]int64, len(remaining))
+
+	var total int64
+	for i, r := range remaining {
+		if eligible[i] {
+			total += r
+		}
+	}
+	if total <= 0 || amount <= 0 {
+		return res
+	}
+	if amount > total {
+		amount = total
+	}
+
+	type share struct {
+		line      int
+		remainder *big.Int
+	}
+	shares := make([]*share, 0)
+	left := amount
+	for i, r := range remaining {
+		if !eligible[i] || r <= 0 {
+			continue
+		}
+		q, m := new(big.Int).QuoRem(
+			new(big.Int).Mul(big.NewInt(amount), big.NewInt(r)),
+			big.NewInt(total),
+			new(big.Int),
+		)
+		res[i] = q.Int64()
+		left -= res[i]
+		shares = append(shares, &share{line: i, remainder: m})
+	}
+
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].remainder.Cmp(shares[j].remainder) > 0
+	})
+	for i := 0; left > 0; i++ {
+		res[shares[i%len(shares)].line]++
+		left--
+	}
+	return res
+}
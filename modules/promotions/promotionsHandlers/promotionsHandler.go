@@ -0,0 +1,198 @@
+package promotionsHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type promotionsHandlersErrCode string
+
+const (
+	findPromotionsErr   promotionsHandlersErrCode = "promotions-001"
+	findOnePromotionErr promotionsHandlersErrCode = "promotions-002"
+	insertPromotionErr  promotionsHandlersErrCode = "promotions-003"
+	updatePromotionErr  promotionsHandlersErrCode = "promotions-004"
+	deletePromotionErr  promotionsHandlersErrCode = "promotions-005"
+	validateCouponErr   promotionsHandlersErrCode = "promotions-006"
+)
+
+type IPromotionsHandler interface {
+	FindPromotions(c *fiber.Ctx) error
+	FindOnePromotion(c *fiber.Ctx) error
+	InsertPromotion(c *fiber.Ctx) error
+	UpdatePromotion(c *fiber.Ctx) error
+	DeletePromotion(c *fiber.Ctx) error
+	ValidateCoupon(c *fiber.Ctx) error
+}
+
+type promotionsHandler struct {
+	cfg               config.IConfig
+	promotionsUsecase promotionsUsecases.IPromotionsUsecase
+}
+
+func PromotionsHandler(cfg config.IConfig, promotionsUsecase promotionsUsecases.IPromotionsUsecase) IPromotionsHandler {
+	return &promotionsHandler{
+		cfg:               cfg,
+		promotionsUsecase: promotionsUsecase,
+	}
+}
+
+func promotionsStatus(err error) int {
+	switch {
+	case errors.Is(err, promotions.ErrPromotionNotFound),
+		errors.Is(err, products.ErrProductNotFound),
+		errors.Is(err, products.ErrVariantNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, promotions.ErrPromotionInvalid),
+		errors.Is(err, products.ErrVariantRequired),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency),
+		errors.Is(err, kawaiimoney.ErrNoRate):
+		return fiber.ErrBadRequest.Code
+	case errors.Is(err, promotions.ErrCodeTaken),
+		errors.Is(err, promotions.ErrPromotionRedeemed),
+		errors.Is(err, promotions.ErrNotApplicable):
+		return fiber.ErrConflict.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// promotionId is the promotion_id param, anything but a uuid cannot be a
+// promotion
+func promotionId(c *fiber.Ctx) (string, error) {
+	id := c.Params("promotion_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", promotions.ErrPromotionNotFound
+	}
+	return id, nil
+}
+
+func (h *promotionsHandler) FindPromotions(c *fiber.Ctx) error {
+	req := new(promotions.PromotionFilter)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findPromotionsErr), err).Res()
+	}
+
+	res, err := h.promotionsUsecase.FindPromotions(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(findPromotionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *promotionsHandler) FindOnePromotion(c *fiber.Ctx) error {
+	id, err := promotionId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findOnePromotionErr),
+			err.Error(),
+		).Res()
+	}
+
+	promotion, err := h.promotionsUsecase.FindOnePromotion(id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(findOnePromotionErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, promotion).Res()
+}
+
+func (h *promotionsHandler) InsertPromotion(c *fiber.Ctx) error {
+	req := new(promotions.PromotionReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(insertPromotionErr), err).Res()
+	}
+
+	promotion, err := h.promotionsUsecase.InsertPromotion(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(insertPromotionErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, promotion).Res()
+}
+
+func (h *promotionsHandler) UpdatePromotion(c *fiber.Ctx) error {
+	id, err := promotionId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updatePromotionErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(promotions.PromotionReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updatePromotionErr), err).Res()
+	}
+
+	promotion, err := h.promotionsUsecase.UpdatePromotion(id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(updatePromotionErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, promotion).Res()
+}
+
+func (h *promotionsHandler) DeletePromotion(c *fiber.Ctx) error {
+	id, err := promotionId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deletePromotionErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.promotionsUsecase.DeletePromotion(id); err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(deletePromotionErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// ValidateCoupon tells the signed in user what a coupon takes off the
+// lines and why each rule passed or failed
+func (h *promotionsHandler) ValidateCoupon(c *fiber.Ctx) error {
+	req := new(promotions.ValidateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(validateCouponErr), err).Res()
+	}
+
+	userId := c.Locals("userId").(string)
+
+	res, err := h.promotionsUsecase.ValidateCoupon(userId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			promotionsStatus(err),
+			string(validateCouponErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
@@ -0,0 +1,386 @@
+package promotionsRepositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+)
+
+type IPromotionsRepository interface {
+	FindPromotions(req *promotions.PromotionFilter) ([]*promotions.Promotion, error)
+	FindOnePromotion(promotionId string) (*promotions.Promotion, error)
+	FindPromotionByCode(code string) (*promotions.Promotion, error)
+	InsertPromotion(req *promotions.PromotionReq) (string, error)
+	UpdatePromotion(promotionId string, req *promotions.PromotionReq) error
+	DeletePromotion(promotionId string) error
+	FindUsage(promotionId, userId string) (*promotions.Usage, error)
+	Redeem(ctx context.Context, req *promotions.Redemption) error
+	Release(ctx context.Context, redemptionId string) error
+}
+
+type promotionsRepository struct {
+	db *sqlx.DB
+}
+
+func PromotionsRepository(db *sqlx.DB) IPromotionsRepository {
+	return &promotionsRepository{
+		db: db,
+	}
+}
+
+// findPromotions returns the promotions matching where, args are its
+// placeholders
+func (r *promotionsRepository) findPromotions(where string, args ...any) ([]*promotions.Promotion, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY "t"."priority" DESC, "t"."code")), '[]'::json)
+	FROM (
+		SELECT
+			"p"."id",
+			"p"."code",
+			"p"."title",
+			"p"."kind",
+			"p"."value",
+			"p"."currency",
+			"p"."min_spend",
+			"p"."usage_limit",
+			"p"."per_user_limit",
+			"p"."starts_at",
+			"p"."ends_at",
+			"p"."active",
+			"p"."priority",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("pp"."product_id" ORDER BY "pp"."product_id")), '[]'::json)
+				FROM "promotion_products" "pp"
+				WHERE "pp"."promotion_id" = "p"."id"
+			) AS "product_ids",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("pc"."category_id" ORDER BY "pc"."category_id")), '[]'::json)
+				FROM "promotion_categories" "pc"
+				WHERE "pc"."promotion_id" = "p"."id"
+			) AS "category_ids",
+			(
+				SELECT
+					COUNT(*)
+				FROM "promotion_redemptions" "r"
+				WHERE "r"."promotion_id" = "p"."id"
+			) AS "usage_count",
+			"p"."created_at",
+			"p"."updated_at"
+		FROM "promotions" "p"
+		WHERE 1 = 1
+		` + where + `
+	) AS "t";`
+
+	bytes := make([]byte, 0)
+	res := make([]*promotions.Promotion, 0)
+
+	if err := r.db.Get(&bytes, query, args...); err != nil {
+		return nil, fmt.Errorf("get promotions failed: %v", err)
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, fmt.Errorf("unmarshal promotions failed: %v", err)
+	}
+	return res, nil
+}
+
+// likeEscaper makes a code match itself in a LIKE pattern, backslash is
+// the default escape character of postgres
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *promotionsRepository) FindPromotions(req *promotions.PromotionFilter) ([]*promotions.Promotion, error) {
+	where := ""
+	args := make([]any, 0)
+
+	if req.Code != "" {
+		args = append(args, "%"+likeEscaper.Replace(req.Code)+"%")
+		where += fmt.Sprintf(` AND "p"."code" ILIKE $%d`, len(args))
+	}
+	if req.Active != nil {
+		args = append(args, *req.Active)
+		where += fmt.Sprintf(` AND "p"."active" = $%d`, len(args))
+	}
+	return r.findPromotions(where, args...)
+}
+
+func (r *promotionsRepository) FindOnePromotion(promotionId string) (*promotions.Promotion, error) {
+	res, err := r.findPromotions(` AND "p"."id" = $1`, promotionId)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, promotions.ErrPromotionNotFound
+	}
+	return res[0], nil
+}
+
+func (r *promotionsRepository) FindPromotionByCode(code string) (*promotions.Promotion, error) {
+	res, err := r.findPromotions(` AND "p"."code" = $1`, code)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, promotions.ErrPromotionNotFound
+	}
+	return res[0], nil
+}
+
+// promotionError tells the constraints a promotion can break apart
+func promotionError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505" && pgErr.ConstraintName == "promotions_code_key":
+			return promotions.ErrCodeTaken
+		case pgErr.Code == "23503" && pgErr.TableName == "promotion_products":
+			return fmt.Errorf("%w, product not found", promotions.ErrPromotionInvalid)
+		case pgErr.Code == "23503" && pgErr.TableName == "promotion_categories":
+			return fmt.Errorf("%w, category not found", promotions.ErrPromotionInvalid)
+		case pgErr.Code == "23503" && pgErr.TableName == "promotion_redemptions":
+			return promotions.ErrPromotionRedeemed
+		}
+	}
+	return err
+}
+
+// insertRestrictions links the products and categories of req
+func insertRestrictions(ctx context.Context, tx *sqlx.Tx, promotionId string, req *promotions.PromotionReq) error {
+	for _, id := range req.ProductIds {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "promotion_products" ("promotion_id", "product_id") VALUES ($1, $2) ON CONFLICT DO NOTHING;`, promotionId, id); err != nil {
+			return fmt.Errorf("insert promotion product failed: %w", promotionError(err))
+		}
+	}
+	for _, id := range req.CategoryIds {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "promotion_categories" ("promotion_id", "category_id") VALUES ($1, $2) ON CONFLICT DO NOTHING;`, promotionId, id); err != nil {
+			return fmt.Errorf("insert promotion category failed: %w", promotionError(err))
+		}
+	}
+	return nil
+}
+
+func (r *promotionsRepository) InsertPromotion(req *promotions.PromotionReq) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO "promotions" (
+		"code",
+		"title",
+		"kind",
+		"value",
+		"currency",
+		"min_spend",
+		"usage_limit",
+		"per_user_limit",
+		"starts_at",
+		"ends_at",
+		"active",
+		"priority"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING "id";`
+
+	var id string
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		req.Code,
+		req.Title,
+		req.Kind,
+		req.Value,
+		req.Currency,
+		req.MinSpend,
+		req.UsageLimit,
+		req.PerUserLimit,
+		req.StartsAt,
+		req.EndsAt,
+		*req.Active,
+		req.Priority,
+	).Scan(&id); err != nil {
+		return "", fmt.Errorf("insert promotion failed: %w", promotionError(err))
+	}
+
+	if err := insertRestrictions(ctx, tx, id, req); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit promotion failed: %v", err)
+	}
+	return id, nil
+}
+
+// UpdatePromotion replaces every field and restriction of the promotion
+func (r *promotionsRepository) UpdatePromotion(promotionId string, req *promotions.PromotionReq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE "promotions" SET
+		"code" = $2,
+		"title" = $3,
+		"kind" = $4,
+		"value" = $5,
+		"currency" = $6,
+		"min_spend" = $7,
+		"usage_limit" = $8,
+		"per_user_limit" = $9,
+		"starts_at" = $10,
+		"ends_at" = $11,
+		"active" = $12,
+		"priority" = $13
+	WHERE "id" = $1;`
+
+	res, err := tx.ExecContext(
+		ctx,
+		query,
+		promotionId,
+		req.Code,
+		req.Title,
+		req.Kind,
+		req.Value,
+		req.Currency,
+		req.MinSpend,
+		req.UsageLimit,
+		req.PerUserLimit,
+		req.StartsAt,
+		req.EndsAt,
+		*req.Active,
+		req.Priority,
+	)
+	if err != nil {
+		return fmt.Errorf("update promotion failed: %w", promotionError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return promotions.ErrPromotionNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "promotion_products" WHERE "promotion_id" = $1;`, promotionId); err != nil {
+		return fmt.Errorf("delete promotion products failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "promotion_categories" WHERE "promotion_id" = $1;`, promotionId); err != nil {
+		return fmt.Errorf("delete promotion categories failed: %v", err)
+	}
+	if err := insertRestrictions(ctx, tx, promotionId, req); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit promotion failed: %v", err)
+	}
+	return nil
+}
+
+// DeletePromotion removes a promotion nobody has redeemed, a redeemed one
+// is kept for its history and can be deactivated instead
+func (r *promotionsRepository) DeletePromotion(promotionId string) error {
+	res, err := r.db.Exec(`DELETE FROM "promotions" WHERE "id" = $1;`, promotionId)
+	if err != nil {
+		return fmt.Errorf("delete promotion failed: %w", promotionError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return promotions.ErrPromotionNotFound
+	}
+	return nil
+}
+
+func (r *promotionsRepository) FindUsage(promotionId, userId string) (*promotions.Usage, error) {
+	query := `
+	SELECT
+		COUNT(*),
+		COUNT(*) FILTER (WHERE "user_id" = $2)
+	FROM "promotion_redemptions"
+	WHERE "promotion_id" = $1;`
+
+	usage := new(promotions.Usage)
+	if err := r.db.QueryRowx(query, promotionId, userId).Scan(&usage.Total, &usage.User); err != nil {
+		return nil, fmt.Errorf("get promotion usage failed: %v", err)
+	}
+	return usage, nil
+}
+
+// Redeem records a use of the promotion. The promotion row is locked so
+// two checkouts cannot both take the last use.
+func (r *promotionsRepository) Redeem(ctx context.Context, req *promotions.Redemption) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	var limits struct {
+		UsageLimit   sql.NullInt64 `db:"usage_limit"`
+		PerUserLimit sql.NullInt64 `db:"per_user_limit"`
+	}
+	if err := tx.GetContext(ctx, &limits, `SELECT "usage_limit", "per_user_limit" FROM "promotions" WHERE "id" = $1 FOR UPDATE;`, req.PromotionId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return promotions.ErrPromotionNotFound
+		}
+		return fmt.Errorf("lock promotion failed: %v", err)
+	}
+
+	var total, user int64
+	if err := tx.QueryRowxContext(ctx, `
+	SELECT
+		COUNT(*),
+		COUNT(*) FILTER (WHERE "user_id" = $2)
+	FROM "promotion_redemptions"
+	WHERE "promotion_id" = $1;`, req.PromotionId, req.UserId).Scan(&total, &user); err != nil {
+		return fmt.Errorf("get promotion usage failed: %v", err)
+	}
+	if limits.UsageLimit.Valid && total >= limits.UsageLimit.Int64 {
+		return fmt.Errorf("%w, usage limit reached", promotions.ErrNotApplicable)
+	}
+	if limits.PerUserLimit.Valid && user >= limits.PerUserLimit.Int64 {
+		return fmt.Errorf("%w, per user limit reached", promotions.ErrNotApplicable)
+	}
+
+	query := `
+	INSERT INTO "promotion_redemptions" (
+		"promotion_id",
+		"user_id",
+		"order_id",
+		"discount",
+		"currency"
+	)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING "id";`
+
+	if err := tx.QueryRowxContext(ctx, query, req.PromotionId, req.UserId, req.OrderId, req.Discount, req.Currency).Scan(&req.Id); err != nil {
+		return fmt.Errorf("insert redemption failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit redemption failed: %v", err)
+	}
+	return nil
+}
+
+func (r *promotionsRepository) Release(ctx context.Context, redemptionId string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM "promotion_redemptions" WHERE "id" = $1;`, redemptionId); err != nil {
+		return fmt.Errorf("delete redemption failed: %v", err)
+	}
+	return nil
+}
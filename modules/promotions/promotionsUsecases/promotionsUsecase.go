@@ -0,0 +1,203 @@
+package promotionsUsecases
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsRepositories"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var codeRe = regexp.MustCompile(`^[A-Z0-9_-]+$`)
+
+type IPromotionsUsecase interface {
+	FindPromotions(req *promotions.PromotionFilter) ([]*promotions.Promotion, error)
+	FindOnePromotion(promotionId string) (*promotions.Promotion, error)
+	InsertPromotion(req *promotions.PromotionReq) (*promotions.Promotion, error)
+	UpdatePromotion(promotionId string, req *promotions.PromotionReq) (*promotions.Promotion, error)
+	DeletePromotion(promotionId string) error
+	ValidateCoupon(userId string, req *promotions.ValidateReq) (*promotions.Evaluation, error)
+	// EvaluateCoupon tells what the coupon takes off a cart priced by the
+	// caller
+	EvaluateCoupon(userId, code string, cart *promotions.Cart) (*promotions.Evaluation, error)
+	// Redeem counts a use of the promotion against its limits, at checkout
+	Redeem(ctx context.Context, req *promotions.Redemption) error
+	// Release gives back a use that was redeemed for a checkout that fell
+	// through
+	Release(ctx context.Context, redemptionId string) error
+}
+
+type promotionsUsecase struct {
+	cfg                  config.IConfig
+	promotionsRepository promotionsRepositories.IPromotionsRepository
+	productsUsecase      productsUsecases.IProductsUsecase
+	currenciesUsecase    currenciesUsecases.ICurrenciesUsecase
+}
+
+func PromotionsUsecase(cfg config.IConfig, promotionsRepository promotionsRepositories.IPromotionsRepository, productsUsecase productsUsecases.IProductsUsecase, currenciesUsecase currenciesUsecases.ICurrenciesUsecase) IPromotionsUsecase {
+	return &promotionsUsecase{
+		cfg:                  cfg,
+		promotionsRepository: promotionsRepository,
+		productsUsecase:      productsUsecase,
+		currenciesUsecase:    currenciesUsecase,
+	}
+}
+
+func (u *promotionsUsecase) FindPromotions(req *promotions.PromotionFilter) ([]*promotions.Promotion, error) {
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	return u.promotionsRepository.FindPromotions(req)
+}
+
+func (u *promotionsUsecase) FindOnePromotion(promotionId string) (*promotions.Promotion, error) {
+	return u.promotionsRepository.FindOnePromotion(promotionId)
+}
+
+// normalize checks what the validate tags cannot and fills the defaults,
+// codes are stored in upper case
+func (u *promotionsUsecase) normalize(req *promotions.PromotionReq) error {
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	if !codeRe.MatchString(req.Code) {
+		return fmt.Errorf("%w, code may only hold letters, digits, - and _", promotions.ErrPromotionInvalid)
+	}
+
+	if req.Currency == "" {
+		req.Currency = u.cfg.App().Currency()
+	}
+	c, err := kawaiimoney.Lookup(req.Currency)
+	if err != nil {
+		return fmt.Errorf("%w, %v", promotions.ErrPromotionInvalid, err)
+	}
+	req.Currency = c.Code
+
+	if req.Kind == promotions.KindPercentage && req.Value > 10000 {
+		return fmt.Errorf("%w, a percentage is at most 10000 basis points", promotions.ErrPromotionInvalid)
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return fmt.Errorf("%w, ends_at must be after starts_at", promotions.ErrPromotionInvalid)
+	}
+	for _, id := range req.ProductIds {
+		if id == "" {
+			return fmt.Errorf("%w, product ids must not be empty", promotions.ErrPromotionInvalid)
+		}
+	}
+
+	if req.Active == nil {
+		active := true
+		req.Active = &active
+	}
+	return nil
+}
+
+func (u *promotionsUsecase) InsertPromotion(req *promotions.PromotionReq) (*promotions.Promotion, error) {
+	if err := u.normalize(req); err != nil {
+		return nil, err
+	}
+
+	id, err := u.promotionsRepository.InsertPromotion(req)
+	if err != nil {
+		return nil, err
+	}
+	return u.promotionsRepository.FindOnePromotion(id)
+}
+
+func (u *promotionsUsecase) UpdatePromotion(promotionId string, req *promotions.PromotionReq) (*promotions.Promotion, error) {
+	if err := u.normalize(req); err != nil {
+		return nil, err
+	}
+
+	if err := u.promotionsRepository.UpdatePromotion(promotionId, req); err != nil {
+		return nil, err
+	}
+	return u.promotionsRepository.FindOnePromotion(promotionId)
+}
+
+func (u *promotionsUsecase) DeletePromotion(promotionId string) error {
+	return u.promotionsRepository.DeletePromotion(promotionId)
+}
+
+// ValidateCoupon prices the lines from the products and tells what the
+// coupon takes off them for userId, nothing is redeemed.
+func (u *promotionsUsecase) ValidateCoupon(userId string, req *promotions.ValidateReq) (*promotions.Evaluation, error) {
+	if req.Currency == "" {
+		req.Currency = u.cfg.App().Currency()
+	}
+	cart := &promotions.Cart{
+		Currency: strings.ToUpper(req.Currency),
+		Lines:    make([]*promotions.Line, 0, len(req.Lines)),
+	}
+	for _, l := range req.Lines {
+		line, err := u.line(l, cart.Currency)
+		if err != nil {
+			return nil, err
+		}
+		cart.Lines = append(cart.Lines, line)
+	}
+	return u.EvaluateCoupon(userId, req.Code, cart)
+}
+
+func (u *promotionsUsecase) EvaluateCoupon(userId, code string, cart *promotions.Cart) (*promotions.Evaluation, error) {
+	promotion, err := u.promotionsRepository.FindPromotionByCode(strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := u.promotionsRepository.FindUsage(promotion.Id, userId)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := u.currenciesUsecase.Rates()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := promotions.Evaluate(
+		[]*promotions.Promotion{promotion},
+		cart,
+		map[string]*promotions.Usage{promotion.Id: usage},
+		time.Now(),
+		rates,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// line prices a line of a coupon check in currency
+func (u *promotionsUsecase) line(req *promotions.LineReq, currency string) (*promotions.Line, error) {
+	product, err := u.productsUsecase.FindOneProduct(req.ProductId, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	price, _, err := product.UnitPrice(req.VariantId)
+	if err != nil {
+		return nil, err
+	}
+
+	line := &promotions.Line{
+		ProductId: product.Id,
+		VariantId: req.VariantId,
+		Quantity:  req.Quantity,
+		UnitPrice: price.Amount,
+	}
+	if product.Category != nil {
+		line.CategoryId = product.Category.Id
+	}
+	return line, nil
+}
+
+func (u *promotionsUsecase) Redeem(ctx context.Context, req *promotions.Redemption) error {
+	return u.promotionsRepository.Redeem(ctx, req)
+}
+
+func (u *promotionsUsecase) Release(ctx context.Context, redemptionId string) error {
+	return u.promotionsRepository.Release(ctx, redemptionId)
+}
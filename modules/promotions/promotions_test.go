@@ -0,0 +1,214 @@
+package promotions
+
+import (
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+func TestSpread(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining []int64
+		eligible  []bool
+		amount    int64
+		want      []int64
+	}{
+		{"even", []int64{100, 300}, []bool{true, true}, 40, []int64{10, 30}},
+		{"tie goes to the earlier line", []int64{100, 100, 100}, []bool{true, true, true}, 100, []int64{34, 33, 33}},
+		{"largest remainder", []int64{100, 200}, []bool{true, true}, 10, []int64{3, 7}},
+		{"ineligible line", []int64{100, 100}, []bool{true, false}, 50, []int64{50, 0}},
+		{"empty line", []int64{0, 100}, []bool{true, true}, 10, []int64{0, 10}},
+		{"capped at the total", []int64{30, 20}, []bool{true, true}, 100, []int64{30, 20}},
+		{"nothing eligible", []int64{30, 20}, []bool{false, false}, 10, []int64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spread(tt.remaining, tt.eligible, tt.amount); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentage(t *testing.T) {
+	thb, _ := kawaiimoney.Lookup("THB")
+
+	tests := []struct {
+		name        string
+		remaining   []int64
+		eligible    []bool
+		basisPoints int64
+		want        []int64
+	}{
+		{"rounded half away from zero", []int64{999, 1001, 4}, []bool{true, true, true}, 1250, []int64{125, 125, 1}},
+		{"ineligible line", []int64{1000, 1000}, []bool{false, true}, 1000, []int64{0, 100}},
+		{"whole line", []int64{1000}, []bool{true}, 10000, []int64{1000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentage(tt.remaining, tt.eligible, tt.basisPoints, thb); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	limit := 1
+
+	rates := kawaiimoney.Rates{
+		{"USD", "THB"}: big.NewRat(35, 1),
+	}
+	cart := func(currency string, lines ...*Line) *Cart {
+		return &Cart{Currency: currency, Lines: lines}
+	}
+	line := &Line{ProductId: "p1", CategoryId: 1, Quantity: 2, UnitPrice: 5000}
+
+	// result is what one evaluation should come to, failed are the rules
+	// that did not pass
+	type result struct {
+		code     string
+		applied  bool
+		discount int64
+		failed   []string
+	}
+
+	tests := []struct {
+		name       string
+		promotions []*Promotion
+		cart       *Cart
+		usage      map[string]*Usage
+		want       []result
+	}{
+		{
+			name: "higher priority first",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true},
+				{Id: "2", Code: "B", Kind: KindFixed, Value: 1000, Currency: "THB", Active: true, Priority: 1},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "B", applied: true, discount: 1000},
+				{code: "A", applied: true, discount: 900},
+			},
+		},
+		{
+			name: "same priority by code",
+			promotions: []*Promotion{
+				{Id: "1", Code: "B", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true},
+				{Id: "2", Code: "A", Kind: KindFixed, Value: 1000, Currency: "THB", Active: true},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "A", applied: true, discount: 1000},
+				{code: "B", applied: true, discount: 900},
+			},
+		},
+		{
+			name: "fixed amount spread with its remainder",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindFixed, Value: 100, Currency: "THB", Active: true},
+			},
+			cart: cart("THB",
+				&Line{ProductId: "p1", Quantity: 1, UnitPrice: 100},
+				&Line{ProductId: "p2", Quantity: 1, UnitPrice: 100},
+				&Line{ProductId: "p3", Quantity: 1, UnitPrice: 100},
+			),
+			want: []result{
+				{code: "A", applied: true, discount: 100},
+			},
+		},
+		{
+			name: "fixed amount converted",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindFixed, Value: 100, Currency: "USD", Active: true},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "A", applied: true, discount: 3500},
+			},
+		},
+		{
+			name: "min spend converted",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindPercentage, Value: 1000, Currency: "USD", MinSpend: 500, Active: true},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "A", failed: []string{RuleMinSpend}},
+			},
+		},
+		{
+			name: "no rate",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindFixed, Value: 100, Currency: "EUR", Active: true},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "A", failed: []string{RuleCurrency}},
+			},
+		},
+		{
+			name: "product restriction",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true, ProductIds: []string{"p2"}},
+				{Id: "2", Code: "B", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true, CategoryIds: []int{1}},
+			},
+			cart: cart("THB", line),
+			want: []result{
+				{code: "A", failed: []string{RuleRestriction}},
+				{code: "B", applied: true, discount: 1000},
+			},
+		},
+		{
+			name: "inactive, not started and used up",
+			promotions: []*Promotion{
+				{Id: "1", Code: "A", Kind: KindPercentage, Value: 1000, Currency: "THB"},
+				{Id: "2", Code: "B", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true, StartsAt: &later},
+				{Id: "3", Code: "C", Kind: KindPercentage, Value: 1000, Currency: "THB", Active: true, UsageLimit: &limit, PerUserLimit: &limit},
+			},
+			cart:  cart("THB", line),
+			usage: map[string]*Usage{"3": {Total: 1, User: 1}},
+			want: []result{
+				{code: "A", failed: []string{RuleActive}},
+				{code: "B", failed: []string{RuleWindow}},
+				{code: "C", failed: []string{RuleUsageLimit, RulePerUserLimit}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Evaluate(tt.promotions, tt.cart, tt.usage, now, rates)
+			if err != nil {
+				t.Fatalf("evaluate failed: %v", err)
+			}
+			if len(res) != len(tt.want) {
+				t.Fatalf("got %d evaluations, want %d", len(res), len(tt.want))
+			}
+
+			for i, e := range res {
+				var failed []string
+				for _, r := range e.Reasons {
+					if !r.Passed {
+						failed = append(failed, r.Rule)
+					}
+				}
+				got := result{code: e.Code, applied: e.Applied, discount: e.Discount.Amount, failed: failed}
+
+				want := tt.want[i]
+				if got.code != want.code || got.applied != want.applied || got.discount != want.discount || !slices.Equal(got.failed, want.failed) {
+					t.Errorf("evaluation %d: got %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package reviews
+
+import (
+	"errors"
+	"mime/multipart"
+
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+)
+
+var (
+	ErrReviewNotFound = errors.New("review not found")
+	ErrNotPurchased   = errors.New("only customers who bought the product may review it")
+	ErrReviewExists   = errors.New("the product has been reviewed")
+	ErrReviewImage    = errors.New("a review takes at most one image")
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Review is the rating of a product by a customer who bought it. Only
+// approved reviews are listed with the product and count in its rating.
+type Review struct {
+	Id        string          `json:"id"`
+	ProductId string          `json:"product_id"`
+	UserId    string          `json:"user_id"`
+	Username  string          `json:"username"`
+	Rating    int             `json:"rating"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	Image     *entities.Image `json:"image"`
+	Status    string          `json:"status"`
+	// Reason the review was rejected
+	Reason      string  `json:"reason"`
+	ModeratedBy *string `json:"moderated_by"`
+	ModeratedAt *string `json:"moderated_at"`
+	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at"`
+}
+
+// ReviewReq reviews a product, as JSON or as a multipart form with at most
+// one image in files
+type ReviewReq struct {
+	Rating int    `json:"rating" form:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title" form:"title" validate:"required,max=100"`
+	Body   string `json:"body" form:"body" validate:"max=2000"`
+	// png or jpg, multipart only
+	Files []*multipart.FileHeader `json:"-" form:"files"`
+	// public or private, STORAGE_VISIBILITY when empty
+	Visibility string `json:"-" form:"visibility" validate:"enum=public|private"`
+}
+
+// RejectReq tells the customer why the review is not listed
+type RejectReq struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+type ReviewFilter struct {
+	// Set by the handler from the route
+	ProductId string `query:"-"`
+	UserId    string `query:"-"`
+	// pending, approved or rejected
+	Status string `query:"status" validate:"enum=pending|approved|rejected"`
+	*entities.PaginationReq
+}
@@ -0,0 +1,269 @@
+package reviewsHandlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/files"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/reviews"
+	"github.com/k0msak007/kawaii-shop/modules/reviews/reviewsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type reviewsHandlersErrCode string
+
+const (
+	findProductReviewsErr reviewsHandlersErrCode = "reviews-001"
+	findUserReviewsErr    reviewsHandlersErrCode = "reviews-002"
+	insertReviewErr       reviewsHandlersErrCode = "reviews-003"
+	findReviewsErr        reviewsHandlersErrCode = "reviews-004"
+	findOneReviewErr      reviewsHandlersErrCode = "reviews-005"
+	approveReviewErr      reviewsHandlersErrCode = "reviews-006"
+	rejectReviewErr       reviewsHandlersErrCode = "reviews-007"
+)
+
+type IReviewsHandler interface {
+	FindProductReviews(c *fiber.Ctx) error
+	FindUserReviews(c *fiber.Ctx) error
+	InsertReview(c *fiber.Ctx) error
+	FindReviews(c *fiber.Ctx) error
+	FindOneReview(c *fiber.Ctx) error
+	ApproveReview(c *fiber.Ctx) error
+	RejectReview(c *fiber.Ctx) error
+}
+
+type reviewsHandler struct {
+	cfg            config.IConfig
+	reviewsUsecase reviewsUsecases.IReviewsUsecase
+}
+
+func ReviewsHandler(cfg config.IConfig, reviewsUsecase reviewsUsecases.IReviewsUsecase) IReviewsHandler {
+	return &reviewsHandler{
+		cfg:            cfg,
+		reviewsUsecase: reviewsUsecase,
+	}
+}
+
+func reviewsStatus(err error) int {
+	switch {
+	case errors.Is(err, reviews.ErrReviewNotFound),
+		errors.Is(err, products.ErrProductNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, reviews.ErrNotPurchased):
+		return fiber.ErrForbidden.Code
+	case errors.Is(err, reviews.ErrReviewExists):
+		return fiber.ErrConflict.Code
+	case errors.Is(err, reviews.ErrReviewImage):
+		return fiber.ErrBadRequest.Code
+	default:
+		return filesHandlers.UploadErrorStatus(err)
+	}
+}
+
+// reviewId is the review_id param, anything but a uuid cannot be a review
+func reviewId(c *fiber.Ctx) (string, error) {
+	id := c.Params("review_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", reviews.ErrReviewNotFound
+	}
+	return id, nil
+}
+
+// parseFilter reads the status and page of a review list
+func parseFilter(c *fiber.Ctx) (*reviews.ReviewFilter, error) {
+	req := &reviews.ReviewFilter{
+		PaginationReq: &entities.PaginationReq{},
+	}
+	if err := entities.ParseQuery(c, req); err != nil {
+		return nil, err
+	}
+
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 5 {
+		req.Limit = 5
+	}
+	return req, nil
+}
+
+// FindProductReviews lists the approved reviews of a product, newest first
+func (h *reviewsHandler) FindProductReviews(c *fiber.Ctx) error {
+	req, err := parseFilter(c)
+	if err != nil {
+		return entities.NewResponse(c).ParseError(string(findProductReviewsErr), err).Res()
+	}
+	req.ProductId = strings.TrimSpace(c.Params("product_id"))
+	req.Status = reviews.StatusApproved
+
+	res, err := h.reviewsUsecase.FindReviews(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(findProductReviewsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+// FindUserReviews lists the reviews of the user_id param in every status,
+// with the reasons of the rejected ones. ParamsCheck makes it the signed in
+// user.
+func (h *reviewsHandler) FindUserReviews(c *fiber.Ctx) error {
+	req, err := parseFilter(c)
+	if err != nil {
+		return entities.NewResponse(c).ParseError(string(findUserReviewsErr), err).Res()
+	}
+	req.UserId = c.Params("user_id")
+
+	res, err := h.reviewsUsecase.FindReviews(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(findUserReviewsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+// InsertReview reviews a product as the signed in user, a multipart form
+// may carry one image in files
+func (h *reviewsHandler) InsertReview(c *fiber.Ctx) error {
+	productId := strings.TrimSpace(c.Params("product_id"))
+
+	req := new(reviews.ReviewReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(insertReviewErr), err).Res()
+	}
+
+	var image *files.FileReq
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		upload, err := filesHandlers.ParseUploadForm(c, h.cfg, "reviews/"+productId)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(insertReviewErr),
+				err.Error(),
+			).Res()
+		}
+		if len(upload) > 1 {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(insertReviewErr),
+				reviews.ErrReviewImage.Error(),
+			).Res()
+		}
+		if len(upload) == 1 {
+			image = upload[0]
+		}
+	}
+
+	userId := c.Locals("userId").(string)
+
+	review, err := h.reviewsUsecase.InsertReview(c.UserContext(), userId, productId, req, image)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(insertReviewErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, review).Res()
+}
+
+// FindReviews is the moderation queue, the pending reviews oldest first
+// unless another status is asked for
+func (h *reviewsHandler) FindReviews(c *fiber.Ctx) error {
+	req, err := parseFilter(c)
+	if err != nil {
+		return entities.NewResponse(c).ParseError(string(findReviewsErr), err).Res()
+	}
+	if req.Status == "" {
+		req.Status = reviews.StatusPending
+	}
+
+	res, err := h.reviewsUsecase.FindReviews(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(findReviewsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *reviewsHandler) FindOneReview(c *fiber.Ctx) error {
+	id, err := reviewId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findOneReviewErr),
+			err.Error(),
+		).Res()
+	}
+
+	review, err := h.reviewsUsecase.FindOneReview(id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(findOneReviewErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, review).Res()
+}
+
+func (h *reviewsHandler) ApproveReview(c *fiber.Ctx) error {
+	id, err := reviewId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(approveReviewErr),
+			err.Error(),
+		).Res()
+	}
+
+	review, err := h.reviewsUsecase.ApproveReview(c.Locals("userId").(string), id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(approveReviewErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, review).Res()
+}
+
+func (h *reviewsHandler) RejectReview(c *fiber.Ctx) error {
+	id, err := reviewId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(rejectReviewErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(reviews.RejectReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(rejectReviewErr), err).Res()
+	}
+
+	review, err := h.reviewsUsecase.RejectReview(c.Locals("userId").(string), id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			reviewsStatus(err),
+			string(rejectReviewErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, review).Res()
+}
@@ -0,0 +1,236 @@
+package reviewsRepositories
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/reviews"
+)
+
+type IReviewsRepository interface {
+	FindReviews(req *reviews.ReviewFilter) ([]*reviews.Review, int, error)
+	FindOneReview(reviewId string) (*reviews.Review, error)
+	// FindReviewableOrder is the latest completed order of the user with
+	// the product, when the user has not reviewed it yet
+	FindReviewableOrder(userId, productId string) (string, error)
+	InsertReview(userId, productId, orderId string, fileId *string, req *reviews.ReviewReq) (string, error)
+	ModerateReview(reviewId, moderatorId, status, reason string) error
+}
+
+type reviewsRepository struct {
+	db *sqlx.DB
+}
+
+func ReviewsRepository(db *sqlx.DB) IReviewsRepository {
+	return &reviewsRepository{
+		db: db,
+	}
+}
+
+// findReviews returns the reviews matching where, args are its
+// placeholders. The image keys are copied from the file, the urls are made
+// at read time.
+func (r *reviewsRepository) findReviews(where, order string, args ...any) ([]*reviews.Review, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY ` + order + `)), '[]'::json)
+	FROM (
+		SELECT
+			"r"."id",
+			"r"."product_id",
+			"r"."user_id",
+			"u"."username",
+			"r"."rating",
+			"r"."title",
+			"r"."body",
+			CASE WHEN "f"."id" IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', "f"."id",
+				'filename', "f"."filename",
+				'url', "f"."destination",
+				'variants', "f"."variants",
+				'private', "f"."private"
+			)
+			END AS "image",
+			"r"."status",
+			"r"."reason",
+			"r"."moderated_by",
+			"r"."moderated_at",
+			"r"."created_at",
+			"r"."updated_at"
+		FROM "reviews" "r"
+			LEFT JOIN "users" "u" ON "u"."id" = "r"."user_id"
+			LEFT JOIN "files" "f" ON "f"."id" = "r"."file_id"
+		WHERE 1 = 1
+		` + where + `
+	) AS "t";`
+
+	bytes := make([]byte, 0)
+	res := make([]*reviews.Review, 0)
+
+	if err := r.db.Get(&bytes, query, args...); err != nil {
+		return nil, fmt.Errorf("get reviews failed: %v", err)
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, fmt.Errorf("unmarshal reviews failed: %v", err)
+	}
+	return res, nil
+}
+
+// FindReviews returns a page of the reviews and how many match. The
+// pending queue is oldest first, other lists newest first.
+func (r *reviewsRepository) FindReviews(req *reviews.ReviewFilter) ([]*reviews.Review, int, error) {
+	where := ""
+	args := make([]any, 0)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where += fmt.Sprintf(cond, len(args))
+	}
+	if req.ProductId != "" {
+		add(` AND "r"."product_id" = $%d`, req.ProductId)
+	}
+	if req.UserId != "" {
+		add(` AND "r"."user_id" = $%d`, req.UserId)
+	}
+	if req.Status != "" {
+		add(` AND "r"."status" = $%d`, req.Status)
+	}
+
+	var count int
+	if err := r.db.Get(&count, `SELECT COUNT(*) FROM "reviews" "r" WHERE 1 = 1`+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count reviews failed: %v", err)
+	}
+
+	order := `"t"."created_at" DESC, "t"."id"`
+	page := ` ORDER BY "r"."created_at" DESC, "r"."id"`
+	if req.Status == reviews.StatusPending {
+		order = `"t"."created_at", "t"."id"`
+		page = ` ORDER BY "r"."created_at", "r"."id"`
+	}
+	page += ` OFFSET $` + strconv.Itoa(len(args)+1) + ` LIMIT $` + strconv.Itoa(len(args)+2)
+	args = append(args, (req.Page-1)*req.Limit, req.Limit)
+
+	res, err := r.findReviews(where+page, order, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res, count, nil
+}
+
+func (r *reviewsRepository) FindOneReview(reviewId string) (*reviews.Review, error) {
+	res, err := r.findReviews(` AND "r"."id" = $1`, `"t"."id"`, reviewId)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, reviews.ErrReviewNotFound
+	}
+	return res[0], nil
+}
+
+func (r *reviewsRepository) FindReviewableOrder(userId, productId string) (string, error) {
+	query := `
+	SELECT
+		EXISTS (SELECT 1 FROM "products" WHERE "id" = $2),
+		EXISTS (SELECT 1 FROM "reviews" WHERE "user_id" = $1 AND "product_id" = $2),
+		(
+			SELECT
+				"o"."id"
+			FROM "orders" "o"
+				JOIN "products_orders" "po" ON "po"."order_id" = "o"."id"
+			WHERE "o"."user_id" = $1
+			AND "o"."status" = 'completed'
+			AND "po"."product"->>'id' = $2
+			ORDER BY "o"."created_at" DESC
+			LIMIT 1
+		);`
+
+	var (
+		found    bool
+		reviewed bool
+		orderId  *string
+	)
+	if err := r.db.QueryRowx(query, userId, productId).Scan(&found, &reviewed, &orderId); err != nil {
+		return "", fmt.Errorf("get purchase failed: %v", err)
+	}
+	switch {
+	case !found:
+		return "", products.ErrProductNotFound
+	case reviewed:
+		return "", reviews.ErrReviewExists
+	case orderId == nil:
+		return "", reviews.ErrNotPurchased
+	}
+	return *orderId, nil
+}
+
+func (r *reviewsRepository) InsertReview(userId, productId, orderId string, fileId *string, req *reviews.ReviewReq) (string, error) {
+	query := `
+	INSERT INTO "reviews" (
+		"product_id",
+		"user_id",
+		"order_id",
+		"rating",
+		"title",
+		"body",
+		"file_id"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING "id";`
+
+	var id string
+	if err := r.db.QueryRowx(
+		query,
+		productId,
+		userId,
+		orderId,
+		req.Rating,
+		req.Title,
+		req.Body,
+		fileId,
+	).Scan(&id); err != nil {
+		return "", reviewError(err)
+	}
+	return id, nil
+}
+
+// ModerateReview approves or rejects a review, an approved review may be
+// rejected later and the other way around
+func (r *reviewsRepository) ModerateReview(reviewId, moderatorId, status, reason string) error {
+	query := `
+	UPDATE "reviews" SET
+		"status" = $2,
+		"reason" = $3,
+		"moderated_by" = $4,
+		"moderated_at" = now()
+	WHERE "id" = $1;`
+
+	res, err := r.db.Exec(query, reviewId, status, reason, moderatorId)
+	if err != nil {
+		return fmt.Errorf("moderate review failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return reviews.ErrReviewNotFound
+	}
+	return nil
+}
+
+// reviewError tells the constraints a review can break apart, a customer
+// reviewing twice at once hits the unique key
+func reviewError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505" && pgErr.ConstraintName == "reviews_product_id_user_id_key":
+			return reviews.ErrReviewExists
+		case pgErr.Code == "23503" && pgErr.ConstraintName == "reviews_product_id_fkey":
+			return products.ErrProductNotFound
+		}
+	}
+	return fmt.Errorf("insert review failed: %v", err)
+}
@@ -0,0 +1,134 @@
+package reviewsUsecases
+
+import (
+	"context"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/files"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/reviews"
+	"github.com/k0msak007/kawaii-shop/modules/reviews/reviewsRepositories"
+)
+
+type IReviewsUsecase interface {
+	FindReviews(req *reviews.ReviewFilter) (*entities.PaginateRes, error)
+	FindOneReview(reviewId string) (*reviews.Review, error)
+	// InsertReview reviews a product the user bought, image is the
+	// uploaded image or nil. The review waits for moderation.
+	InsertReview(ctx context.Context, userId, productId string, req *reviews.ReviewReq, image *files.FileReq) (*reviews.Review, error)
+	ApproveReview(moderatorId, reviewId string) (*reviews.Review, error)
+	RejectReview(moderatorId, reviewId string, req *reviews.RejectReq) (*reviews.Review, error)
+}
+
+type reviewsUsecase struct {
+	reviewsRepository reviewsRepositories.IReviewsRepository
+	filesUsecase      filesUsecases.IFilesUsecase
+}
+
+func ReviewsUsecase(reviewsRepository reviewsRepositories.IReviewsRepository, filesUsecase filesUsecases.IFilesUsecase) IReviewsUsecase {
+	return &reviewsUsecase{
+		reviewsRepository: reviewsRepository,
+		filesUsecase:      filesUsecase,
+	}
+}
+
+// resolve turns the image keys of the reviews into urls
+func (u *reviewsUsecase) resolve(res ...*reviews.Review) {
+	for _, r := range res {
+		if r.Image == nil {
+			continue
+		}
+		if err := u.filesUsecase.ResolveImages([]*entities.Image{r.Image}); err != nil {
+			// The review is still useful without its image
+			log.Printf("resolve image of review %s failed: %v", r.Id, err)
+			r.Image = nil
+		}
+	}
+}
+
+func (u *reviewsUsecase) FindReviews(req *reviews.ReviewFilter) (*entities.PaginateRes, error) {
+	res, count, err := u.reviewsRepository.FindReviews(req)
+	if err != nil {
+		return nil, err
+	}
+	u.resolve(res...)
+
+	return &entities.PaginateRes{
+		Data:      res,
+		Page:      req.Page,
+		Limit:     req.Limit,
+		TotalItem: count,
+		TotalPage: int(math.Ceil(float64(count) / float64(req.Limit))),
+	}, nil
+}
+
+func (u *reviewsUsecase) FindOneReview(reviewId string) (*reviews.Review, error) {
+	review, err := u.reviewsRepository.FindOneReview(reviewId)
+	if err != nil {
+		return nil, err
+	}
+	u.resolve(review)
+	return review, nil
+}
+
+func (u *reviewsUsecase) InsertReview(ctx context.Context, userId, productId string, req *reviews.ReviewReq, image *files.FileReq) (*reviews.Review, error) {
+	req.Title = strings.TrimSpace(req.Title)
+	req.Body = strings.TrimSpace(req.Body)
+
+	// Checked before the upload, the unique key catches a review racing
+	// this one
+	orderId, err := u.reviewsRepository.FindReviewableOrder(userId, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileId *string
+	if image != nil {
+		res, err := u.filesUsecase.UploadFiles(ctx, userId, []*files.FileReq{image})
+		if err != nil {
+			return nil, err
+		}
+		if res[0].Err != nil {
+			return nil, res[0].Err
+		}
+		fileId = &res[0].Id
+	}
+
+	id, err := u.reviewsRepository.InsertReview(userId, productId, orderId, fileId, req)
+	if err != nil {
+		if fileId != nil {
+			u.discardFile(ctx, userId, *fileId)
+		}
+		return nil, err
+	}
+	return u.FindOneReview(id)
+}
+
+// discardFile deletes the image of a review that was not added
+func (u *reviewsUsecase) discardFile(ctx context.Context, userId, fileId string) {
+	req := []*files.DeleteFileReq{{Id: fileId}}
+	for _, r := range u.filesUsecase.DeleteFiles(context.WithoutCancel(ctx), userId, req) {
+		if r.Err != nil {
+			// Unreferenced, the garbage collector gets it later
+			log.Printf("discard file %s failed: %v", r.Id, r.Err)
+		}
+	}
+}
+
+func (u *reviewsUsecase) ApproveReview(moderatorId, reviewId string) (*reviews.Review, error) {
+	if err := u.reviewsRepository.ModerateReview(reviewId, moderatorId, reviews.StatusApproved, ""); err != nil {
+		return nil, err
+	}
+	return u.FindOneReview(reviewId)
+}
+
+func (u *reviewsUsecase) RejectReview(moderatorId, reviewId string, req *reviews.RejectReq) (*reviews.Review, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if err := u.reviewsRepository.ModerateReview(reviewId, moderatorId, reviews.StatusRejected, reason); err != nil {
+		return nil, err
+	}
+	return u.FindOneReview(reviewId)
+}
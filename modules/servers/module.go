@@ -2,40 +2,98 @@ package servers
 
 import (
 	"github.com/gofiber/fiber/v2"
+	fibermonitor "github.com/gofiber/fiber/v2/middleware/monitor"
+	"github.com/k0msak007/kawaii-shop/modules/addresses"
+	"github.com/k0msak007/kawaii-shop/modules/addresses/addressesHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/addresses/addressesRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/addresses/addressesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/appinfo"
 	"github.com/k0msak007/kawaii-shop/modules/appinfo/appinfoHandlers"
 	"github.com/k0msak007/kawaii-shop/modules/appinfo/appinfoRepositories"
 	"github.com/k0msak007/kawaii-shop/modules/appinfo/appinfoUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/carts"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/currencies"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/docs/docsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/files"
 	"github.com/k0msak007/kawaii-shop/modules/files/filesHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesRepositories"
 	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresHandlers"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresRepositories"
 	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/monitor"
 	"github.com/k0msak007/kawaii-shop/modules/monitor/monitorHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/payments"
+	"github.com/k0msak007/kawaii-shop/modules/payments/paymentsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/payments/paymentsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/payments/paymentsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/products"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsHandlers"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsRepositories"
 	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/promotions"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/promotions/promotionsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/reviews"
+	"github.com/k0msak007/kawaii-shop/modules/reviews/reviewsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/reviews/reviewsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/reviews/reviewsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/shipping"
+	"github.com/k0msak007/kawaii-shop/modules/shipping/shippingHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/shipping/shippingRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/shipping/shippingUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/users"
 	"github.com/k0msak007/kawaii-shop/modules/users/usersHandlers"
 	"github.com/k0msak007/kawaii-shop/modules/users/usersRepositories"
 	"github.com/k0msak007/kawaii-shop/modules/users/usersUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists/wishlistsHandlers"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists/wishlistsRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists/wishlistsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiilimiter"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiopenapi"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiistorage"
 )
 
 type IModuleFactory interface {
 	MonitorModule()
+	MetricsModule()
 	UsersModule()
 	AppinfoModule()
+	CurrenciesModule()
 	FilesModule()
 	ProductsModule()
+	PromotionsModule()
+	CartsModule()
+	PaymentsModule()
+	AddressesModule()
+	ShippingModule()
+	ReviewsModule()
+	WishlistsModule()
+	DocsModule()
 }
 
 type moduleFactory struct {
 	r   fiber.Router
+	a   fiber.Router
 	s   *server
 	mid middlewaresHandlers.IMiddlewaresHandler
 }
 
-func InitModule(r fiber.Router, s *server, mid middlewaresHandlers.IMiddlewaresHandler) IModuleFactory {
+// InitModule mounts the public routes of the modules on r and the ones
+// that need an admin on a, which is r when there is no admin listener
+func InitModule(r, a fiber.Router, s *server, mid middlewaresHandlers.IMiddlewaresHandler) IModuleFactory {
 	return &moduleFactory{
 		r:   r,
+		a:   a,
 		s:   s,
 		mid: mid,
 	}
@@ -45,30 +103,153 @@ func InitMiddlewares(s *server) middlewaresHandlers.IMiddlewaresHandler {
 	repository := middlewaresRepositories.MiddlewaresRepository(s.db)
 	usecases := middlewaresUsecases.MiddlewaresUsecases(repository)
 
-	return middlewaresHandlers.MiddlewaresHandler(s.cfg, usecases)
+	return middlewaresHandlers.MiddlewaresHandler(s.cfg, usecases, kawaiilimiter.NewMemoryStore())
+}
+
+// group makes the group of prefix on the public router and on the admin
+// one, they are the same group when there is no admin listener so its
+// handlers run once
+func (m *moduleFactory) group(prefix string, handlers ...fiber.Handler) (fiber.Router, fiber.Router) {
+	router := m.r.Group(prefix, handlers...)
+	if m.a == m.r {
+		return router, router
+	}
+	return router, m.a.Group(prefix, handlers...)
+}
+
+// doc describes a route of router in the OpenAPI document
+func (m *moduleFactory) doc(router fiber.Router, method, path string, op *kawaiiopenapi.Operation) {
+	m.s.docs.Add(method, prefix(router)+path, op)
+}
+
+// adminDoc describes a route of the admin router in the OpenAPI document
+// of the admin listener
+func (m *moduleFactory) adminDoc(router fiber.Router, method, path string, op *kawaiiopenapi.Operation) {
+	m.s.adminDocs.Add(method, prefix(router)+path, op)
+}
+
+func prefix(router fiber.Router) string {
+	if g, ok := router.(*fiber.Group); ok {
+		return g.Prefix
+	}
+	return ""
+}
+
+func (m *moduleFactory) currencies() currenciesUsecases.ICurrenciesUsecase {
+	return currenciesUsecases.CurrenciesUsecase(m.s.cfg, currenciesRepositories.CurrenciesRepository(m.s.db))
+}
+
+func (m *moduleFactory) products() productsUsecases.IProductsUsecase {
+	files := m.s.files()
+	repository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, files)
+	return productsUsecases.ProductsUsecase(m.s.cfg, repository, files, m.currencies())
+}
+
+func (m *moduleFactory) carts() cartsUsecases.ICartsUsecase {
+	return cartsUsecases.CartsUsecase(m.s.cfg, cartsRepositories.CartsRepository(m.s.db), m.products())
+}
+
+func (m *moduleFactory) promotions() promotionsUsecases.IPromotionsUsecase {
+	return promotionsUsecases.PromotionsUsecase(m.s.cfg, promotionsRepositories.PromotionsRepository(m.s.db), m.products(), m.currencies())
 }
 
 func (m *moduleFactory) MonitorModule() {
-	handler := monitorHandlers.MonitorHandler(m.s.cfg)
+	handler := monitorHandlers.MonitorHandler(m.s.cfg, m.s.shutdown)
 
 	m.r.Get("/", handler.HealthCheck)
+	m.r.Get("/ready", handler.ReadinessCheck)
+
+	health := &kawaiiopenapi.Operation{
+		Summary:  "Health check",
+		Tags:     []string{"monitor"},
+		Response: &monitor.Monitor{},
+	}
+	ready := &kawaiiopenapi.Operation{
+		Summary:  "Readiness check, 503 while the server is shutting down",
+		Tags:     []string{"monitor"},
+		Response: &monitor.Monitor{},
+	}
+	m.doc(m.r, fiber.MethodGet, "/", health)
+	m.doc(m.r, fiber.MethodGet, "/ready", ready)
+
+	// The admin listener is checked on its own
+	if m.a != m.r {
+		m.a.Get("/", handler.HealthCheck)
+		m.a.Get("/ready", handler.ReadinessCheck)
+
+		m.adminDoc(m.a, fiber.MethodGet, "/", health)
+		m.adminDoc(m.a, fiber.MethodGet, "/ready", ready)
+	}
+}
+
+// MetricsModule is only served by the admin listener
+func (m *moduleFactory) MetricsModule() {
+	if m.a == m.r {
+		return
+	}
+	m.a.Get("/metrics", fibermonitor.New(fibermonitor.Config{
+		APIOnly: true,
+	}))
 }
 
 func (m *moduleFactory) UsersModule() {
 	repository := usersRepositories.UsersRepository(m.s.db)
 	usecases := usersUsecases.UsersUsecase(m.s.cfg, repository)
-	handler := usersHandlers.UsersHandler(m.s.cfg, usecases)
+	handler := usersHandlers.UsersHandler(m.s.cfg, usecases, m.carts())
 
-	router := m.r.Group("/users")
+	router, admin := m.group("/users")
 
-	router.Post("/signup", m.mid.ApiKeyAuth(), handler.SignUpCustomer)
-	router.Post("/signin", handler.SignIn)
+	router.Post("/signup", m.mid.RateLimit("auth"), m.mid.ApiKeyAuth(), m.mid.Idempotency(), handler.SignUpCustomer)
+	router.Post("/signin", m.mid.RateLimit("auth"), handler.SignIn)
 	router.Post("/refresh", m.mid.ApiKeyAuth(), handler.RefressPassport)
 	router.Post("/signout", m.mid.ApiKeyAuth(), handler.SignOut)
-	router.Post("/signup-admin", m.mid.JwtAuth(), m.mid.Authorize(2), handler.SignUpAdmin)
+	admin.Post("/signup-admin", m.mid.JwtAuth(), m.mid.Authorize(2), handler.SignUpAdmin)
 
 	router.Get("/:user_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.GetUserProfile)
-	router.Get("/admin/secret", m.mid.JwtAuth(), m.mid.Authorize(2), handler.GenerateAdminToken)
+	admin.Get("/admin/secret", m.mid.JwtAuth(), m.mid.Authorize(2), handler.GenerateAdminToken)
+
+	m.doc(router, fiber.MethodPost, "/signup", &kawaiiopenapi.Operation{
+		Summary:  "Sign up a customer",
+		Auth:     kawaiiopenapi.ApiKey,
+		Body:     &users.UserRegisterReq{},
+		Response: &users.UserPassport{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(router, fiber.MethodPost, "/signin", &kawaiiopenapi.Operation{
+		Summary:  "Sign in, the anonymous cart of the X-Cart-Token header is merged into the user's cart",
+		Body:     &users.UserCredential{},
+		Response: &users.UserPassport{},
+	})
+	m.doc(router, fiber.MethodPost, "/refresh", &kawaiiopenapi.Operation{
+		Summary:  "Refresh a passport",
+		Auth:     kawaiiopenapi.ApiKey,
+		Body:     &users.UserRefreshCredentail{},
+		Response: &users.UserPassport{},
+	})
+	m.doc(router, fiber.MethodPost, "/signout", &kawaiiopenapi.Operation{
+		Summary: "Sign out",
+		Auth:    kawaiiopenapi.ApiKey,
+		Body:    &users.UserRemoveCredential{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/signup-admin", &kawaiiopenapi.Operation{
+		Summary:  "Sign up an admin",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &users.UserRegisterReq{},
+		Response: &users.UserPassport{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(router, fiber.MethodGet, "/:user_id", &kawaiiopenapi.Operation{
+		Summary:  "Get the profile of the signed in user",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: &users.User{},
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/admin/secret", &kawaiiopenapi.Operation{
+		Summary: "Generate an admin token",
+		Auth:    kawaiiopenapi.Admin,
+		Response: &struct {
+			Token string `json:"token"`
+		}{},
+	})
 }
 
 func (m *moduleFactory) AppinfoModule() {
@@ -76,35 +257,645 @@ func (m *moduleFactory) AppinfoModule() {
 	usecases := appinfoUsecases.AppinfoUsecase(repository)
 	handler := appinfoHandlers.AppinfoHandler(m.s.cfg, usecases)
 
-	router := m.r.Group("/appinfo")
+	router, admin := m.group("/appinfo", m.mid.RateLimit("appinfo"))
 
-	router.Post("/categories", m.mid.JwtAuth(), m.mid.Authorize(2), handler.AddCategory)
+	admin.Post("/categories", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), handler.AddCategory)
 
 	router.Get("/categories", m.mid.ApiKeyAuth(), handler.FindCategory)
-	router.Get("/apikey", m.mid.JwtAuth(), m.mid.Authorize(2), handler.GenerateApiKey)
+	admin.Get("/apikey", m.mid.JwtAuth(), m.mid.Authorize(2), handler.GenerateApiKey)
+
+	admin.Delete("/:category_id/categories", m.mid.JwtAuth(), m.mid.Authorize(2), handler.RemoveCategory)
 
-	router.Delete("/:category_id/categories", m.mid.JwtAuth(), m.mid.Authorize(2), handler.RemoveCategory)
+	m.adminDoc(admin, fiber.MethodPost, "/categories", &kawaiiopenapi.Operation{
+		Summary:  "Add categories",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     []*appinfo.Category{},
+		Response: []*appinfo.Category{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(router, fiber.MethodGet, "/categories", &kawaiiopenapi.Operation{
+		Summary:  "Find categories",
+		Auth:     kawaiiopenapi.ApiKey,
+		Query:    &appinfo.CategoryFilter{},
+		Response: []*appinfo.Category{},
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/apikey", &kawaiiopenapi.Operation{
+		Summary: "Generate an api key",
+		Auth:    kawaiiopenapi.Admin,
+		Response: &struct {
+			Key string `json:"key"`
+		}{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/:category_id/categories", &kawaiiopenapi.Operation{
+		Summary: "Remove a category",
+		Auth:    kawaiiopenapi.Admin,
+		Response: &struct {
+			CategoryId int `json:"category_id"`
+		}{},
+		Status: fiber.StatusCreated,
+	})
+}
+
+func (m *moduleFactory) CurrenciesModule() {
+	handler := currenciesHandlers.CurrenciesHandler(m.s.cfg, m.currencies())
+
+	router, admin := m.group("/currencies", m.mid.RateLimit("appinfo"))
+
+	router.Get("/", m.mid.ApiKeyAuth(), handler.FindCurrencies)
+	router.Get("/rates", m.mid.ApiKeyAuth(), handler.FindRates)
+	admin.Put("/rates", m.mid.JwtAuth(), m.mid.Authorize(2), handler.UpsertRate)
+	admin.Delete("/rates/:base/:quote", m.mid.JwtAuth(), m.mid.Authorize(2), handler.DeleteRate)
+
+	m.doc(router, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:  "Find the currencies prices can be shown in",
+		Auth:     kawaiiopenapi.ApiKey,
+		Response: &currencies.Currencies{},
+	})
+	m.doc(router, fiber.MethodGet, "/rates", &kawaiiopenapi.Operation{
+		Summary:  "Find the exchange rates",
+		Auth:     kawaiiopenapi.ApiKey,
+		Response: []*currencies.Rate{},
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/rates", &kawaiiopenapi.Operation{
+		Summary:  "Set the exchange rate from base to quote",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &currencies.RateReq{},
+		Response: &currencies.Rate{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/rates/:base/:quote", &kawaiiopenapi.Operation{
+		Summary: "Remove an exchange rate",
+		Auth:    kawaiiopenapi.Admin,
+	})
 }
 
 func (m *moduleFactory) FilesModule() {
-	usecases := filesUsecases.FileUsecase(m.s.cfg)
+	repository := filesRepositories.FilesRepository(m.s.db)
+	usecases := filesUsecases.FileUsecase(m.s.cfg, repository, m.s.storage)
 	handler := filesHandlers.FileHandler(m.s.cfg, usecases)
 
-	router := m.r.Group("/files")
+	router, admin := m.group("/files")
 
-	router.Post("/upload", m.mid.JwtAuth(), m.mid.Authorize(2), handler.UploadFiles)
-	router.Patch("/delete", m.mid.JwtAuth(), m.mid.Authorize(2), handler.DeleteFile)
+	// The local driver has no server of its own
+	if _, ok := m.s.storage.(kawaiistorage.ILocalStorage); ok {
+		router.Get("/local/*", handler.ServeLocal)
+	}
+
+	admin.Post("/upload", m.mid.JwtAuth(), m.mid.RateLimit("files"), m.mid.Authorize(2), m.mid.Idempotency(), m.mid.RequestContext(), handler.UploadFiles)
+	admin.Patch("/delete", m.mid.JwtAuth(), m.mid.RateLimit("files"), m.mid.Authorize(2), m.mid.RequestContext(), handler.DeleteFile)
+
+	// Resumable uploads
+	admin.Post("/uploads", m.mid.JwtAuth(), m.mid.RateLimit("files"), m.mid.Authorize(2), m.mid.Idempotency(), handler.CreateUpload)
+	admin.Get("/uploads/:upload_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindUpload)
+	admin.Patch("/uploads/:upload_id", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.RequestContext(), handler.WriteUpload)
+	admin.Post("/uploads/:upload_id/complete", m.mid.JwtAuth(), m.mid.RateLimit("files"), m.mid.Authorize(2), m.mid.RequestContext(), handler.CompleteUpload)
+	admin.Delete("/uploads/:upload_id", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.RequestContext(), handler.AbortUpload)
+
+	m.adminDoc(admin, fiber.MethodPost, "/upload", &kawaiiopenapi.Operation{
+		Summary:  "Upload png, jpg or jpeg files",
+		Auth:     kawaiiopenapi.Admin,
+		Form:     &files.UploadReq{},
+		Response: []*files.FileRes{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodPatch, "/delete", &kawaiiopenapi.Operation{
+		Summary:  "Delete files by id, files used by product images are kept",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     []*files.DeleteFileReq{},
+		Response: []*files.DeleteFileRes{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/uploads", &kawaiiopenapi.Operation{
+		Summary:  "Start a resumable upload",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &files.UploadSessionReq{},
+		Response: &files.UploadSession{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/uploads/:upload_id", &kawaiiopenapi.Operation{
+		Summary:  "Find a resumable upload, Upload-Offset tells where to resume",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &files.UploadSession{},
+	})
+	m.adminDoc(admin, fiber.MethodPatch, "/uploads/:upload_id", &kawaiiopenapi.Operation{
+		Summary: "Append an application/offset+octet-stream chunk at the Upload-Offset header",
+		Auth:    kawaiiopenapi.Admin,
+		Status:  fiber.StatusNoContent,
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/uploads/:upload_id/complete", &kawaiiopenapi.Operation{
+		Summary:  "Verify the size and checksum of a resumable upload and register the file",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &files.FileRes{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/uploads/:upload_id", &kawaiiopenapi.Operation{
+		Summary: "Abort a resumable upload",
+		Auth:    kawaiiopenapi.Admin,
+	})
 }
 
 func (m *moduleFactory) ProductsModule() {
-	filesUsecases := filesUsecases.FileUsecase(m.s.cfg)
+	productsHandler := productsHandlers.ProductsHandler(m.s.cfg, m.products(), m.s.files())
+
+	router, admin := m.group("/products", m.mid.RateLimit("products"))
+
+	router.Get("/", m.mid.ApiKeyAuth(), m.mid.OptionalJwtAuth(), productsHandler.FindProduct)
+	admin.Post("/import", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.RequestContext(), productsHandler.ImportProducts)
+	admin.Get("/export", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.ExportProducts)
+	router.Get("/:product_id", m.mid.ApiKeyAuth(), m.mid.OptionalJwtAuth(), productsHandler.FindOneProduct)
+
+	// Images
+	router.Get("/:product_id/images", m.mid.ApiKeyAuth(), productsHandler.FindImages)
+	admin.Post("/:product_id/images", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), m.mid.RequestContext(), productsHandler.AddImages)
+	admin.Put("/:product_id/images/order", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.ReorderImages)
+	admin.Patch("/:product_id/images/:image_id", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.UpdateImage)
+	admin.Delete("/:product_id/images/:image_id", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.RequestContext(), productsHandler.DeleteImage)
+
+	// Variants
+	admin.Put("/:product_id/options", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.ReplaceOptions)
+	admin.Post("/:product_id/variants", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), productsHandler.InsertVariant)
+	admin.Put("/:product_id/variants/:variant_id", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.UpdateVariant)
+	admin.Delete("/:product_id/variants/:variant_id", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.DeleteVariant)
+
+	// Price list
+	admin.Put("/:product_id/prices", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.UpsertPrice)
+	admin.Delete("/:product_id/prices/:currency", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.DeletePrice)
+	admin.Put("/:product_id/weight", m.mid.JwtAuth(), m.mid.Authorize(2), productsHandler.UpdateWeight)
+
+	m.doc(router, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:   "Find products",
+		Auth:      kawaiiopenapi.ApiKeyUser,
+		Query:     &products.ProductFilter{},
+		Response:  &products.Product{},
+		Paginated: true,
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/import", &kawaiiopenapi.Operation{
+		Summary:  "Import products from a text/csv or application/json catalog, rows with an id update that product",
+		Auth:     kawaiiopenapi.Admin,
+		Query:    &products.ImportQuery{},
+		Body:     []*products.CatalogRow{},
+		Response: &products.ImportResult{},
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/export", &kawaiiopenapi.Operation{
+		Summary: "Export the catalog as a csv or json file, one that failed part way ends with an #error record",
+		Auth:    kawaiiopenapi.Admin,
+		Query:   &products.ExportQuery{},
+	})
+	m.doc(router, fiber.MethodGet, "/:product_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one product",
+		Auth:     kawaiiopenapi.ApiKeyUser,
+		Query:    &products.PriceQuery{},
+		Response: &products.Product{},
+	})
+	m.doc(router, fiber.MethodGet, "/:product_id/images", &kawaiiopenapi.Operation{
+		Summary:  "Find the images of a product in order",
+		Auth:     kawaiiopenapi.ApiKey,
+		Response: []*entities.Image{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:product_id/images", &kawaiiopenapi.Operation{
+		Summary:  "Upload images of a product, one alt value per file",
+		Auth:     kawaiiopenapi.Admin,
+		Form:     &products.ImageUploadReq{},
+		Response: []*entities.Image{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:product_id/images/order", &kawaiiopenapi.Operation{
+		Summary:  "Reorder the images of a product",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.ImageOrderReq{},
+		Response: []*entities.Image{},
+	})
+	m.adminDoc(admin, fiber.MethodPatch, "/:product_id/images/:image_id", &kawaiiopenapi.Operation{
+		Summary:  "Change the alt text or the primary image",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.ImageUpdateReq{},
+		Response: []*entities.Image{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/:product_id/images/:image_id", &kawaiiopenapi.Operation{
+		Summary: "Remove an image and its stored file",
+		Auth:    kawaiiopenapi.Admin,
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:product_id/options", &kawaiiopenapi.Operation{
+		Summary:  "Replace the option axes of a product",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.OptionsReq{},
+		Response: &products.Product{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:product_id/variants", &kawaiiopenapi.Operation{
+		Summary:  "Add a variant with one value of every option",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.VariantReq{},
+		Response: &products.Product{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:product_id/variants/:variant_id", &kawaiiopenapi.Operation{
+		Summary:  "Replace a variant",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.VariantReq{},
+		Response: &products.Product{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/:product_id/variants/:variant_id", &kawaiiopenapi.Operation{
+		Summary:  "Remove a variant",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &products.Product{},
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:product_id/prices", &kawaiiopenapi.Operation{
+		Summary:  "Set the fixed price of a product or variant in a currency",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.PriceReq{},
+		Response: &products.Product{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/:product_id/prices/:currency", &kawaiiopenapi.Operation{
+		Summary:  "Remove a fixed price, of the variant in the variant_id query when set",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &products.Product{},
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:product_id/weight", &kawaiiopenapi.Operation{
+		Summary:  "Set the shipping weight of a product in grams",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &products.WeightReq{},
+		Response: &products.Product{},
+	})
+}
+
+func (m *moduleFactory) PromotionsModule() {
+	handler := promotionsHandlers.PromotionsHandler(m.s.cfg, m.promotions())
 
-	productsRepository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, filesUsecases)
-	productsUsecases := productsUsecases.ProductsUsecase(productsRepository)
-	productsHandler := productsHandlers.ProductsHandler(m.s.cfg, productsUsecases, filesUsecases)
+	router, admin := m.group("/promotions", m.mid.RateLimit("promotions"))
 
-	router := m.r.Group("/products")
+	router.Post("/validate", m.mid.JwtAuth(), handler.ValidateCoupon)
 
-	router.Get("/", m.mid.ApiKeyAuth(), productsHandler.FindProduct)
-	router.Get("/:product_id", m.mid.ApiKeyAuth(), productsHandler.FindOneProduct)
+	admin.Get("/", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindPromotions)
+	admin.Post("/", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), handler.InsertPromotion)
+	admin.Get("/:promotion_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindOnePromotion)
+	admin.Put("/:promotion_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.UpdatePromotion)
+	admin.Delete("/:promotion_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.DeletePromotion)
+
+	m.doc(router, fiber.MethodPost, "/validate", &kawaiiopenapi.Operation{
+		Summary:  "Tell what a coupon takes off the lines and why each rule passed or failed",
+		Auth:     kawaiiopenapi.Bearer,
+		Body:     &promotions.ValidateReq{},
+		Response: &promotions.Evaluation{},
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:  "Find promotions",
+		Auth:     kawaiiopenapi.Admin,
+		Query:    &promotions.PromotionFilter{},
+		Response: []*promotions.Promotion{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/", &kawaiiopenapi.Operation{
+		Summary:  "Add a percentage or fixed coupon",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &promotions.PromotionReq{},
+		Response: &promotions.Promotion{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/:promotion_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one promotion",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &promotions.Promotion{},
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/:promotion_id", &kawaiiopenapi.Operation{
+		Summary:  "Replace a promotion",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &promotions.PromotionReq{},
+		Response: &promotions.Promotion{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/:promotion_id", &kawaiiopenapi.Operation{
+		Summary: "Remove a promotion nobody has redeemed",
+		Auth:    kawaiiopenapi.Admin,
+	})
+}
+
+func (m *moduleFactory) CartsModule() {
+	handler := cartsHandlers.CartsHandler(m.s.cfg, m.carts())
+
+	router := m.r.Group("/carts", m.mid.RateLimit("carts"), m.mid.OptionalJwtAuth())
+
+	router.Get("/", handler.FindCart)
+	router.Delete("/", handler.ClearCart)
+	router.Post("/items", handler.AddItem)
+	router.Patch("/items/:item_id", handler.UpdateItem)
+	router.Delete("/items/:item_id", handler.DeleteItem)
+
+	m.doc(router, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:  "Find the cart re-priced, flagging items whose price or availability changed",
+		Auth:     kawaiiopenapi.Cart,
+		Response: &carts.Cart{},
+	})
+	m.doc(router, fiber.MethodDelete, "/", &kawaiiopenapi.Operation{
+		Summary:  "Remove every item of the cart",
+		Auth:     kawaiiopenapi.Cart,
+		Response: &carts.Cart{},
+	})
+	m.doc(router, fiber.MethodPost, "/items", &kawaiiopenapi.Operation{
+		Summary:  "Add a product or variant, an anonymous cart is created with its token when there is none",
+		Auth:     kawaiiopenapi.Cart,
+		Body:     &carts.ItemReq{},
+		Response: &carts.Cart{},
+	})
+	m.doc(router, fiber.MethodPatch, "/items/:item_id", &kawaiiopenapi.Operation{
+		Summary:  "Set the quantity of an item at its current price",
+		Auth:     kawaiiopenapi.Cart,
+		Body:     &carts.QuantityReq{},
+		Response: &carts.Cart{},
+	})
+	m.doc(router, fiber.MethodDelete, "/items/:item_id", &kawaiiopenapi.Operation{
+		Summary:  "Remove an item",
+		Auth:     kawaiiopenapi.Cart,
+		Response: &carts.Cart{},
+	})
+}
+
+func (m *moduleFactory) PaymentsModule() {
+	repository := paymentsRepositories.PaymentsRepository(m.s.db)
+	usecases := paymentsUsecases.PaymentsUsecase(m.s.cfg, repository, m.promotions(), m.s.payment)
+	handler := paymentsHandlers.PaymentsHandler(m.s.cfg, usecases)
+
+	router, admin := m.group("/payments", m.mid.RateLimit("payments"))
+
+	// Signed by the provider, before /:payment_id
+	router.Post("/webhooks/:provider", handler.Webhook)
+
+	router.Post("/", m.mid.JwtAuth(), m.mid.Idempotency(), handler.CreatePayment)
+	router.Get("/", m.mid.JwtAuth(), handler.FindPayments)
+	router.Get("/:payment_id", m.mid.JwtAuth(), handler.FindOnePayment)
+	admin.Post("/:payment_id/capture", m.mid.JwtAuth(), m.mid.Authorize(2), handler.CapturePayment)
+	admin.Post("/:payment_id/refunds", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), handler.RefundPayment)
+
+	m.doc(router, fiber.MethodPost, "/webhooks/:provider", &kawaiiopenapi.Operation{
+		Summary:  "Receive a signed event of the payment provider, each event is processed once",
+		Response: &payments.WebhookRes{},
+	})
+	m.doc(router, fiber.MethodPost, "/", &kawaiiopenapi.Operation{
+		Summary:  "Pay a waiting order by PromptPay QR or card, a coupon counts against its usage limits while the payment is open",
+		Auth:     kawaiiopenapi.Bearer,
+		Body:     &payments.PaymentReq{},
+		Response: &payments.Payment{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(router, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:  "Find the payments of an order with their ledger",
+		Auth:     kawaiiopenapi.Bearer,
+		Query:    &payments.PaymentFilter{},
+		Response: []*payments.Payment{},
+	})
+	m.doc(router, fiber.MethodGet, "/:payment_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one payment with its ledger",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: &payments.Payment{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:payment_id/capture", &kawaiiopenapi.Operation{
+		Summary:  "Capture an authorized card payment, all of it without an amount",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &payments.AmountReq{},
+		Response: &payments.Payment{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:payment_id/refunds", &kawaiiopenapi.Operation{
+		Summary:  "Refund a captured payment, what is left without an amount",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &payments.AmountReq{},
+		Response: &payments.Payment{},
+		Status:   fiber.StatusCreated,
+	})
+
+	// The mock provider has no payer, simulate plays one in development
+	if m.s.cfg.Payment().Simulate() {
+		admin.Post("/:payment_id/simulate", m.mid.JwtAuth(), m.mid.Authorize(2), handler.SimulatePayment)
+
+		m.adminDoc(admin, fiber.MethodPost, "/:payment_id/simulate", &kawaiiopenapi.Operation{
+			Summary:  "Pay or fail a pending payment of the mock provider through its webhook",
+			Auth:     kawaiiopenapi.Admin,
+			Body:     &payments.SimulateReq{},
+			Response: &payments.Payment{},
+		})
+	}
+}
+
+// AddressesModule is the address book of a user, ParamsCheck keeps it to
+// the signed in user
+func (m *moduleFactory) AddressesModule() {
+	repository := addressesRepositories.AddressesRepository(m.s.db)
+	usecases := addressesUsecases.AddressesUsecase(repository)
+	handler := addressesHandlers.AddressesHandler(m.s.cfg, usecases)
+
+	router := m.r.Group("/users/:user_id/addresses")
+
+	router.Get("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindAddresses)
+	router.Post("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), m.mid.Idempotency(), handler.InsertAddress)
+	router.Get("/:address_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindOneAddress)
+	router.Put("/:address_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.UpdateAddress)
+	router.Delete("/:address_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.DeleteAddress)
+	router.Post("/:address_id/default", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.SetDefault)
+
+	m.doc(router, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:  "Find the addresses of the user, the default first",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: []*addresses.Address{},
+	})
+	m.doc(router, fiber.MethodPost, "/", &kawaiiopenapi.Operation{
+		Summary:  "Add an address, the first one is the default",
+		Auth:     kawaiiopenapi.Bearer,
+		Body:     &addresses.AddressReq{},
+		Response: &addresses.Address{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(router, fiber.MethodGet, "/:address_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one address",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: &addresses.Address{},
+	})
+	m.doc(router, fiber.MethodPut, "/:address_id", &kawaiiopenapi.Operation{
+		Summary:  "Replace an address, is_default makes it the default",
+		Auth:     kawaiiopenapi.Bearer,
+		Body:     &addresses.AddressReq{},
+		Response: &addresses.Address{},
+	})
+	m.doc(router, fiber.MethodDelete, "/:address_id", &kawaiiopenapi.Operation{
+		Summary: "Remove an address, another one becomes the default when it was",
+		Auth:    kawaiiopenapi.Bearer,
+	})
+	m.doc(router, fiber.MethodPost, "/:address_id/default", &kawaiiopenapi.Operation{
+		Summary:  "Make an address the default",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: &addresses.Address{},
+	})
+}
+
+func (m *moduleFactory) ShippingModule() {
+	repository := shippingRepositories.ShippingRepository(m.s.db)
+	usecases := shippingUsecases.ShippingUsecase(m.s.cfg, repository, m.products(), m.currencies())
+	handler := shippingHandlers.ShippingHandler(m.s.cfg, usecases)
+
+	router, admin := m.group("/shipping", m.mid.RateLimit("shipping"))
+
+	router.Post("/quote", m.mid.ApiKeyAuth(), handler.Quote)
+
+	admin.Get("/rates", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindRates)
+	admin.Post("/rates", m.mid.JwtAuth(), m.mid.Authorize(2), m.mid.Idempotency(), handler.InsertRate)
+	admin.Get("/rates/:rate_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindOneRate)
+	admin.Put("/rates/:rate_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.UpdateRate)
+	admin.Delete("/rates/:rate_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.DeleteRate)
+
+	m.doc(router, fiber.MethodPost, "/quote", &kawaiiopenapi.Operation{
+		Summary:  "Price the shipping of the lines with every active rate that covers their weight",
+		Auth:     kawaiiopenapi.ApiKey,
+		Body:     &shipping.QuoteReq{},
+		Response: &shipping.Quote{},
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/rates", &kawaiiopenapi.Operation{
+		Summary:  "Find shipping rates",
+		Auth:     kawaiiopenapi.Admin,
+		Query:    &shipping.RateFilter{},
+		Response: []*shipping.Rate{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/rates", &kawaiiopenapi.Operation{
+		Summary:  "Add a flat, weight tier or free over threshold rate",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &shipping.RateReq{},
+		Response: &shipping.Rate{},
+		Status:   fiber.StatusCreated,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/rates/:rate_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one shipping rate",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &shipping.Rate{},
+	})
+	m.adminDoc(admin, fiber.MethodPut, "/rates/:rate_id", &kawaiiopenapi.Operation{
+		Summary:  "Replace a shipping rate",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &shipping.RateReq{},
+		Response: &shipping.Rate{},
+	})
+	m.adminDoc(admin, fiber.MethodDelete, "/rates/:rate_id", &kawaiiopenapi.Operation{
+		Summary: "Remove a shipping rate",
+		Auth:    kawaiiopenapi.Admin,
+	})
+}
+
+func (m *moduleFactory) ReviewsModule() {
+	repository := reviewsRepositories.ReviewsRepository(m.s.db)
+	usecases := reviewsUsecases.ReviewsUsecase(repository, m.s.files())
+	handler := reviewsHandlers.ReviewsHandler(m.s.cfg, usecases)
+
+	productRouter := m.r.Group("/products/:product_id/reviews", m.mid.RateLimit("reviews"))
+
+	productRouter.Get("/", m.mid.ApiKeyAuth(), handler.FindProductReviews)
+	productRouter.Post("/", m.mid.JwtAuth(), m.mid.Idempotency(), m.mid.RequestContext(), handler.InsertReview)
+
+	userRouter := m.r.Group("/users/:user_id/reviews")
+
+	userRouter.Get("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindUserReviews)
+
+	admin := m.a.Group("/reviews", m.mid.RateLimit("reviews"))
+
+	admin.Get("/", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindReviews)
+	admin.Get("/:review_id", m.mid.JwtAuth(), m.mid.Authorize(2), handler.FindOneReview)
+	admin.Post("/:review_id/approve", m.mid.JwtAuth(), m.mid.Authorize(2), handler.ApproveReview)
+	admin.Post("/:review_id/reject", m.mid.JwtAuth(), m.mid.Authorize(2), handler.RejectReview)
+
+	m.doc(productRouter, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:   "Find the approved reviews of a product, newest first",
+		Auth:      kawaiiopenapi.ApiKey,
+		Query:     &reviews.ReviewFilter{},
+		Response:  &reviews.Review{},
+		Paginated: true,
+	})
+	m.doc(productRouter, fiber.MethodPost, "/", &kawaiiopenapi.Operation{
+		Summary:  "Review a product bought in a completed order, once. Takes JSON or a form with one image.",
+		Auth:     kawaiiopenapi.Bearer,
+		Form:     &reviews.ReviewReq{},
+		Response: &reviews.Review{},
+		Status:   fiber.StatusCreated,
+	})
+	m.doc(userRouter, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:   "Find the reviews of the user in every status",
+		Auth:      kawaiiopenapi.Bearer,
+		Query:     &reviews.ReviewFilter{},
+		Response:  &reviews.Review{},
+		Paginated: true,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:   "Moderation queue, the pending reviews oldest first unless another status is asked for",
+		Auth:      kawaiiopenapi.Admin,
+		Query:     &reviews.ReviewFilter{},
+		Response:  &reviews.Review{},
+		Paginated: true,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/:review_id", &kawaiiopenapi.Operation{
+		Summary:  "Find one review",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &reviews.Review{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:review_id/approve", &kawaiiopenapi.Operation{
+		Summary:  "Approve a review, it is listed and counts in the rating of the product",
+		Auth:     kawaiiopenapi.Admin,
+		Response: &reviews.Review{},
+	})
+	m.adminDoc(admin, fiber.MethodPost, "/:review_id/reject", &kawaiiopenapi.Operation{
+		Summary:  "Reject a review with the reason shown to its author",
+		Auth:     kawaiiopenapi.Admin,
+		Body:     &reviews.RejectReq{},
+		Response: &reviews.Review{},
+	})
+}
+
+func (m *moduleFactory) WishlistsModule() {
+	repository := wishlistsRepositories.WishlistsRepository(m.s.db)
+	usecases := wishlistsUsecases.WishlistsUsecase(repository, m.products())
+	handler := wishlistsHandlers.WishlistsHandler(m.s.cfg, usecases)
+
+	userRouter := m.r.Group("/users/:user_id/wishlist")
+
+	userRouter.Get("/", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindWishlist)
+	userRouter.Get("/notifications", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.FindNotifications)
+	userRouter.Post("/notifications/:notification_id/read", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.ReadNotification)
+	userRouter.Put("/:product_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.AddFavorite)
+	userRouter.Delete("/:product_id", m.mid.JwtAuth(), m.mid.ParamsCheck(), handler.RemoveFavorite)
+
+	admin := m.a.Group("/wishlists")
+
+	admin.Get("/favorites", m.mid.JwtAuth(), m.mid.Authorize(2), handler.CountFavorites)
+
+	m.doc(userRouter, fiber.MethodGet, "/", &kawaiiopenapi.Operation{
+		Summary:   "Find the wishlisted products of the user, the latest first",
+		Auth:      kawaiiopenapi.Bearer,
+		Query:     &wishlists.WishlistFilter{},
+		Response:  &products.Product{},
+		Paginated: true,
+	})
+	m.doc(userRouter, fiber.MethodGet, "/notifications", &kawaiiopenapi.Operation{
+		Summary:   "Find the price drops and restocks of the wishlisted products, the latest first",
+		Auth:      kawaiiopenapi.Bearer,
+		Query:     &wishlists.NotificationFilter{},
+		Response:  &wishlists.Notification{},
+		Paginated: true,
+	})
+	m.doc(userRouter, fiber.MethodPost, "/notifications/:notification_id/read", &kawaiiopenapi.Operation{
+		Summary: "Mark a notification read",
+		Auth:    kawaiiopenapi.Bearer,
+	})
+	m.doc(userRouter, fiber.MethodPut, "/:product_id", &kawaiiopenapi.Operation{
+		Summary:  "Wishlist a product, a wishlisted product is left as is",
+		Auth:     kawaiiopenapi.Bearer,
+		Response: &products.Product{},
+	})
+	m.doc(userRouter, fiber.MethodDelete, "/:product_id", &kawaiiopenapi.Operation{
+		Summary: "Remove a product from the wishlist",
+		Auth:    kawaiiopenapi.Bearer,
+	})
+	m.adminDoc(admin, fiber.MethodGet, "/favorites", &kawaiiopenapi.Operation{
+		Summary:   "Count the users who wishlisted each product, the most favorited first",
+		Auth:      kawaiiopenapi.Admin,
+		Query:     &wishlists.FavoritesFilter{},
+		Response:  &wishlists.Favorites{},
+		Paginated: true,
+	})
+}
+
+func (m *moduleFactory) DocsModule() {
+	handler := docsHandlers.DocsHandler(m.s.cfg, m.s.OpenApi)
+
+	m.r.Get("/openapi.json", handler.OpenApi)
+	if m.a != m.r {
+		m.a.Get("/openapi.json", docsHandlers.DocsHandler(m.s.cfg, m.s.AdminOpenApi).OpenApi)
+	}
 }
@@ -1,70 +1,326 @@
 package servers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesRepositories"
+	"github.com/k0msak007/kawaii-shop/modules/files/filesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/middlewares/middlewaresHandlers"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiiopenapi"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiipayment"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiishutdown"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiistorage"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiitls"
 )
 
 type IServer interface {
 	Start()
+	OnShutdown(name string, hook func(ctx context.Context) error)
+	WriteOpenApi(w io.Writer) error
+	CollectGarbage(ctx context.Context) (int, error)
 }
 
 type server struct {
-	app *fiber.App
-	cfg config.IConfig
-	db  *sqlx.DB
+	app      *fiber.App
+	admin    *fiber.App
+	cfg      config.IConfig
+	db       *sqlx.DB
+	shutdown kawaiishutdown.IShutdown
+	docs     kawaiiopenapi.IRegistry
+	// adminDocs describes the admin listener, docs when there is none
+	adminDocs kawaiiopenapi.IRegistry
+	storage   kawaiistorage.IStorage
+	payment   kawaiipayment.IProvider
 }
 
 func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
-	return &server{
-		cfg: cfg,
-		db:  db,
+	docs := kawaiiopenapi.NewRegistry()
+	adminDocs := docs
+	if cfg.Admin().Enabled() {
+		adminDocs = kawaiiopenapi.NewRegistry()
+	}
+
+	s := &server{
+		cfg:       cfg,
+		db:        db,
+		shutdown:  kawaiishutdown.NewShutdown(),
+		docs:      docs,
+		adminDocs: adminDocs,
+		storage:   newStorage(cfg),
+		payment:   newPayment(cfg),
 		app: fiber.New(fiber.Config{
-			AppName:      cfg.App().Name(),
-			BodyLimit:    cfg.App().BodyLimit(),
-			ReadTimeout:  cfg.App().ReadTimeOut(),
-			WriteTimeout: cfg.App().WriteTimeOut(),
-			JSONEncoder:  json.Marshal,
-			JSONDecoder:  json.Unmarshal,
+			AppName:        cfg.App().Name(),
+			BodyLimit:      cfg.App().BodyLimit(),
+			ReadTimeout:    cfg.App().ReadTimeOut(),
+			WriteTimeout:   cfg.App().WriteTimeOut(),
+			IdleTimeout:    cfg.App().IdleTimeout(),
+			Concurrency:    cfg.App().Concurrency(),
+			ReadBufferSize: cfg.App().ReadBufferSize(),
+			JSONEncoder:    json.Marshal,
+			JSONDecoder:    json.Unmarshal,
+			// Protocol and hostname headers are only believed from trusted proxies
+			EnableTrustedProxyCheck: true,
+			TrustedProxies:          trustedProxies(cfg),
+		}),
+		admin: fiber.New(fiber.Config{
+			AppName:                 cfg.App().Name() + " admin",
+			ReadTimeout:             cfg.App().ReadTimeOut(),
+			WriteTimeout:            cfg.App().WriteTimeOut(),
+			IdleTimeout:             cfg.App().IdleTimeout(),
+			JSONEncoder:             json.Marshal,
+			JSONDecoder:             json.Unmarshal,
+			DisableStartupMessage:   true,
+			EnableTrustedProxyCheck: true,
+			TrustedProxies:          trustedProxies(cfg),
 		}),
 	}
+
+	s.OnShutdown("storage", func(ctx context.Context) error {
+		return s.storage.Close()
+	})
+	return s
 }
 
-func (s *server) Start() {
+func newStorage(cfg config.IConfig) kawaiistorage.IStorage {
+	if cfg.Storage().Driver() == "local" {
+		return kawaiistorage.NewLocalStorage(
+			cfg.Storage().LocalDir(),
+			cfg.Storage().LocalUrl(),
+			[]byte(cfg.Storage().SigningKey()),
+			cfg.Storage().SignedUrlTTL(),
+		)
+	}
+	return kawaiistorage.NewGcsStorage(cfg.App().GCPBucket(), cfg.Storage().SignedUrlTTL())
+}
+
+func newPayment(cfg config.IConfig) kawaiipayment.IProvider {
+	if cfg.Payment().Provider() == "mock" {
+		secret := []byte(cfg.Payment().WebhookSecret())
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				log.Fatalf("generate mock payment secret failed: %v", err)
+			}
+			log.Println("payments: PAYMENT_WEBHOOK_SECRET is empty, mock webhooks only verify through simulate")
+		}
+		return kawaiipayment.NewMockProvider(secret, cfg.Payment().WebhookTolerance())
+	}
+
+	provider, err := kawaiipayment.NewOmiseProvider(
+		cfg.Payment().OmiseApiUrl(),
+		cfg.Payment().OmiseSecretKey(),
+		cfg.Payment().OmiseWebhookSecret(),
+		cfg.Payment().WebhookTolerance(),
+	)
+	if err != nil {
+		log.Fatalf("payments: %v", err)
+	}
+	return provider
+}
+
+func trustedProxies(cfg config.IConfig) []string {
+	proxies := make([]string, 0)
+	for _, p := range cfg.App().TrustedProxies() {
+		proxies = append(proxies, p.String())
+	}
+	return proxies
+}
+
+// routes registers the middlewares and modules of the public api
+func (s *server) routes() middlewaresHandlers.IMiddlewaresHandler {
 	// Middlewares
 	middlewares := InitMiddlewares(s)
+	s.app.Use(middlewares.RealIP())
 	s.app.Use(middlewares.Logger())
+	s.app.Use(middlewares.SecurityHeaders())
 	s.app.Use(middlewares.Cors())
 
 	// Modules
 	// http://localhost:3000/v1
 	v1 := s.app.Group("/v1")
-	module := InitModule(v1, s, middlewares)
+
+	// Admin routes and metrics on their own port, on the app when there is
+	// no admin listener
+	// http://localhost:3001/v1
+	admin := v1
+	if s.cfg.Admin().Enabled() {
+		s.admin.Use(middlewares.RealIP())
+		s.admin.Use(middlewares.Logger())
+		s.admin.Use(middlewares.SecurityHeaders())
+		admin = s.admin.Group("/v1")
+	}
+	module := InitModule(v1, admin, s, middlewares)
 
 	module.MonitorModule()
+	module.MetricsModule()
 	module.UsersModule()
 	module.AppinfoModule()
+	module.CurrenciesModule()
 	module.FilesModule()
 	module.ProductsModule()
+	module.PromotionsModule()
+	module.CartsModule()
+	module.PaymentsModule()
+	module.AddressesModule()
+	module.ShippingModule()
+	module.ReviewsModule()
+	module.WishlistsModule()
+	module.DocsModule()
 
 	s.app.Use(middlewares.RouterCheck())
+	if s.cfg.Admin().Enabled() {
+		s.admin.Use(middlewares.RouterCheck())
+	}
+
+	return middlewares
+}
+
+func (s *server) OpenApi() map[string]any {
+	return s.docs.Build(s.cfg.App().Name(), s.cfg.App().Version(), s.app.GetRoutes(true))
+}
+
+// AdminOpenApi describes the routes of the admin listener
+func (s *server) AdminOpenApi() map[string]any {
+	return s.adminDocs.Build(s.cfg.App().Name()+" admin", s.cfg.App().Version(), s.admin.GetRoutes(true))
+}
+
+// WriteOpenApi registers the routes without listening and writes the
+// OpenAPI document.
+func (s *server) WriteOpenApi(w io.Writer) error {
+	s.routes()
+	for _, docs := range []kawaiiopenapi.IRegistry{s.docs, s.adminDocs} {
+		if err := docs.Check(); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.OpenApi())
+}
+
+// CollectGarbage removes stored files no product image has used for the
+// grace period once and returns how many were removed.
+func (s *server) CollectGarbage(ctx context.Context) (int, error) {
+	return s.files().CollectGarbage(ctx)
+}
+
+func (s *server) files() filesUsecases.IFilesUsecase {
+	return filesUsecases.FileUsecase(s.cfg, filesRepositories.FilesRepository(s.db), s.storage)
+}
+
+func (s *server) Start() {
+	s.routes()
+	for _, docs := range []kawaiiopenapi.IRegistry{s.docs, s.adminDocs} {
+		if err := docs.Check(); err != nil {
+			log.Fatalf("Request rules are invalid: %v", err)
+		}
+	}
+
+	// Uploads and deletes finish before the database and the storage close
+	s.OnShutdown("files workers", filesUsecases.WaitWorkers)
+
+	// Files garbage collector
+	if interval := s.cfg.Storage().GcInterval(); interval > 0 {
+		ctx, stopGc := context.WithCancel(context.Background())
+		gcDone := make(chan struct{})
+		go func() {
+			defer close(gcDone)
+			s.files().RunGarbageCollector(ctx, interval)
+		}()
+		s.OnShutdown("files gc", func(ctx context.Context) error {
+			stopGc()
+			select {
+			case <-gcDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
+
+	// Admin listener
+	if s.cfg.Admin().Enabled() {
+		go func() {
+			log.Printf("Admin server starting on %v", s.cfg.Admin().Url())
+			if err := listen(s.admin, s.cfg.Admin().Url(), s.cfg.Admin().TLSCertFile(), s.cfg.Admin().TLSKeyFile(), s.cfg.Admin().ClientCAFile()); err != nil {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
 
 	// Graceful shutdown
+	done := make(chan struct{})
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		_ = <-c
-		log.Printf("Server shutting down...")
-		_ = s.app.Shutdown()
+		sig := <-c
+		log.Printf("Server shutting down on %v...", sig)
+		s.gracefulShutdown()
+		close(done)
 	}()
 
 	// Listen to host:port
 	log.Printf("Server starting on %v", s.cfg.App().Url())
-	s.app.Listen(s.cfg.App().Url())
+	if err := listen(s.app, s.cfg.App().Url(), s.cfg.App().TLSCertFile(), s.cfg.App().TLSKeyFile(), ""); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	<-done
+}
+
+func (s *server) OnShutdown(name string, hook func(ctx context.Context) error) {
+	s.shutdown.Register(name, hook)
+}
+
+// gracefulShutdown reports not ready, waits for in-flight requests up to
+// the shutdown timeout then runs the shutdown hooks.
+func (s *server) gracefulShutdown() {
+	s.shutdown.Drain()
+	time.Sleep(s.cfg.App().ShutdownDelay())
+
+	if err := s.app.ShutdownWithTimeout(s.cfg.App().ShutdownTimeout()); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	if s.cfg.Admin().Enabled() {
+		if err := s.admin.ShutdownWithTimeout(s.cfg.App().ShutdownTimeout()); err != nil {
+			log.Printf("Admin server shutdown failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.App().ShutdownTimeout())
+	defer cancel()
+	s.shutdown.Run(ctx)
+	log.Printf("Server stopped")
+}
+
+// listen serves plain HTTP unless a key pair is given.
+func listen(app *fiber.App, addr, certFile, keyFile, clientCAFile string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if certFile != "" {
+		tlsCfg, err := kawaiitls.ServerConfig(certFile, keyFile, clientCAFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+
+	return app.Listener(ln)
 }
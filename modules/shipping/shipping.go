@@ -0,0 +1,90 @@
+package shipping
+
+import (
+	"errors"
+
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiishipping"
+)
+
+var (
+	ErrRateNotFound = errors.New("shipping rate not found")
+	ErrRateInvalid  = errors.New("shipping rate is invalid")
+)
+
+// Rate is a shipping option configured by an admin, amounts are minor
+// units of Currency
+type Rate struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Strategy string `json:"strategy"`
+	Currency string `json:"currency"`
+	// Amount is charged by flat, and by free_over below Threshold when it
+	// has no tiers
+	Amount    int64                  `json:"amount"`
+	Threshold *int64                 `json:"threshold"`
+	Tiers     []*kawaiishipping.Tier `json:"tiers"`
+	Active    bool                   `json:"active"`
+	// Lower positions are listed first among options of the same price
+	Position  int    `json:"position"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Calculator prices parcels with the strategy of the rate
+func (r *Rate) Calculator() (kawaiishipping.ICalculator, error) {
+	threshold := int64(0)
+	if r.Threshold != nil {
+		threshold = *r.Threshold
+	}
+	return kawaiishipping.New(r.Strategy, r.Amount, threshold, r.Tiers)
+}
+
+// RateReq creates a rate or replaces one
+type RateReq struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Strategy string `json:"strategy" validate:"required,enum=flat|weight_tier|free_over"`
+	// ISO 4217 code, APP_CURRENCY when empty
+	Currency  string                 `json:"currency"`
+	Amount    int64                  `json:"amount" validate:"min=0"`
+	Threshold *int64                 `json:"threshold" validate:"min=1"`
+	Tiers     []*kawaiishipping.Tier `json:"tiers"`
+	// True when empty
+	Active   *bool `json:"active"`
+	Position int   `json:"position"`
+}
+
+type RateFilter struct {
+	Active *bool `query:"active"`
+}
+
+// QuoteReq asks what shipping the lines costs with every active rate, they
+// are priced and weighed from the products
+type QuoteReq struct {
+	// ISO 4217 code, APP_CURRENCY when empty
+	Currency string     `json:"currency"`
+	Lines    []*LineReq `json:"lines" validate:"required"`
+}
+
+type LineReq struct {
+	ProductId string  `json:"product_id" validate:"required"`
+	VariantId *string `json:"variant_id" validate:"uuid"`
+	Quantity  int     `json:"quantity" validate:"required,min=1"`
+}
+
+type Quote struct {
+	Currency string `json:"currency"`
+	// Weight of the lines in grams
+	Weight   int                `json:"weight"`
+	Subtotal *kawaiimoney.Money `json:"subtotal"`
+	// Options are the rates that ship the lines, cheapest first
+	Options []*Option `json:"options"`
+}
+
+type Option struct {
+	RateId   string             `json:"rate_id"`
+	Name     string             `json:"name"`
+	Strategy string             `json:"strategy"`
+	Amount   *kawaiimoney.Money `json:"amount"`
+	Free     bool               `json:"free"`
+}
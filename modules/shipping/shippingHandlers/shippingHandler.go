@@ -0,0 +1,191 @@
+package shippingHandlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/shipping"
+	"github.com/k0msak007/kawaii-shop/modules/shipping/shippingUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiishipping"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type shippingHandlersErrCode string
+
+const (
+	findRatesErr   shippingHandlersErrCode = "shipping-001"
+	findOneRateErr shippingHandlersErrCode = "shipping-002"
+	insertRateErr  shippingHandlersErrCode = "shipping-003"
+	updateRateErr  shippingHandlersErrCode = "shipping-004"
+	deleteRateErr  shippingHandlersErrCode = "shipping-005"
+	quoteErr       shippingHandlersErrCode = "shipping-006"
+)
+
+type IShippingHandler interface {
+	FindRates(c *fiber.Ctx) error
+	FindOneRate(c *fiber.Ctx) error
+	InsertRate(c *fiber.Ctx) error
+	UpdateRate(c *fiber.Ctx) error
+	DeleteRate(c *fiber.Ctx) error
+	Quote(c *fiber.Ctx) error
+}
+
+type shippingHandler struct {
+	cfg             config.IConfig
+	shippingUsecase shippingUsecases.IShippingUsecase
+}
+
+func ShippingHandler(cfg config.IConfig, shippingUsecase shippingUsecases.IShippingUsecase) IShippingHandler {
+	return &shippingHandler{
+		cfg:             cfg,
+		shippingUsecase: shippingUsecase,
+	}
+}
+
+func shippingStatus(err error) int {
+	switch {
+	case errors.Is(err, shipping.ErrRateNotFound),
+		errors.Is(err, products.ErrProductNotFound),
+		errors.Is(err, products.ErrVariantNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, shipping.ErrRateInvalid),
+		errors.Is(err, kawaiishipping.ErrInvalid),
+		errors.Is(err, products.ErrVariantRequired),
+		errors.Is(err, kawaiimoney.ErrUnknownCurrency),
+		errors.Is(err, kawaiimoney.ErrNoRate):
+		return fiber.ErrBadRequest.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// rateId is the rate_id param, anything but a uuid cannot be a rate
+func rateId(c *fiber.Ctx) (string, error) {
+	id := c.Params("rate_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return "", shipping.ErrRateNotFound
+	}
+	return id, nil
+}
+
+func (h *shippingHandler) FindRates(c *fiber.Ctx) error {
+	req := new(shipping.RateFilter)
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findRatesErr), err).Res()
+	}
+
+	res, err := h.shippingUsecase.FindRates(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(findRatesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *shippingHandler) FindOneRate(c *fiber.Ctx) error {
+	id, err := rateId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findOneRateErr),
+			err.Error(),
+		).Res()
+	}
+
+	rate, err := h.shippingUsecase.FindOneRate(id)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(findOneRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, rate).Res()
+}
+
+func (h *shippingHandler) InsertRate(c *fiber.Ctx) error {
+	req := new(shipping.RateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(insertRateErr), err).Res()
+	}
+
+	rate, err := h.shippingUsecase.InsertRate(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(insertRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, rate).Res()
+}
+
+func (h *shippingHandler) UpdateRate(c *fiber.Ctx) error {
+	id, err := rateId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(updateRateErr),
+			err.Error(),
+		).Res()
+	}
+
+	req := new(shipping.RateReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(updateRateErr), err).Res()
+	}
+
+	rate, err := h.shippingUsecase.UpdateRate(id, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(updateRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, rate).Res()
+}
+
+func (h *shippingHandler) DeleteRate(c *fiber.Ctx) error {
+	id, err := rateId(c)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(deleteRateErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.shippingUsecase.DeleteRate(id); err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(deleteRateErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *shippingHandler) Quote(c *fiber.Ctx) error {
+	req := new(shipping.QuoteReq)
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(quoteErr), err).Res()
+	}
+
+	quote, err := h.shippingUsecase.Quote(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			shippingStatus(err),
+			string(quoteErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, quote).Res()
+}
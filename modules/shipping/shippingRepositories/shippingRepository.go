@@ -0,0 +1,167 @@
+package shippingRepositories
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/shipping"
+)
+
+type IShippingRepository interface {
+	FindRates(req *shipping.RateFilter) ([]*shipping.Rate, error)
+	FindOneRate(rateId string) (*shipping.Rate, error)
+	InsertRate(req *shipping.RateReq) (string, error)
+	UpdateRate(rateId string, req *shipping.RateReq) error
+	DeleteRate(rateId string) error
+}
+
+type shippingRepository struct {
+	db *sqlx.DB
+}
+
+func ShippingRepository(db *sqlx.DB) IShippingRepository {
+	return &shippingRepository{
+		db: db,
+	}
+}
+
+// findRates returns the rates matching where, args are its placeholders
+func (r *shippingRepository) findRates(where string, args ...any) ([]*shipping.Rate, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY "t"."position", "t"."name")), '[]'::json)
+	FROM (
+		SELECT
+			"s"."id",
+			"s"."name",
+			"s"."strategy",
+			"s"."currency",
+			"s"."amount",
+			"s"."threshold",
+			"s"."tiers",
+			"s"."active",
+			"s"."position",
+			"s"."created_at",
+			"s"."updated_at"
+		FROM "shipping_rates" "s"
+		WHERE 1 = 1
+		` + where + `
+	) AS "t";`
+
+	bytes := make([]byte, 0)
+	res := make([]*shipping.Rate, 0)
+
+	if err := r.db.Get(&bytes, query, args...); err != nil {
+		return nil, fmt.Errorf("get shipping rates failed: %v", err)
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, fmt.Errorf("unmarshal shipping rates failed: %v", err)
+	}
+	return res, nil
+}
+
+func (r *shippingRepository) FindRates(req *shipping.RateFilter) ([]*shipping.Rate, error) {
+	if req.Active != nil {
+		return r.findRates(` AND "s"."active" = $1`, *req.Active)
+	}
+	return r.findRates("")
+}
+
+func (r *shippingRepository) FindOneRate(rateId string) (*shipping.Rate, error) {
+	res, err := r.findRates(` AND "s"."id" = $1`, rateId)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, shipping.ErrRateNotFound
+	}
+	return res[0], nil
+}
+
+func (r *shippingRepository) InsertRate(req *shipping.RateReq) (string, error) {
+	tiers, err := json.Marshal(req.Tiers)
+	if err != nil {
+		return "", fmt.Errorf("marshal tiers failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "shipping_rates" (
+		"name",
+		"strategy",
+		"currency",
+		"amount",
+		"threshold",
+		"tiers",
+		"active",
+		"position"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING "id";`
+
+	var id string
+	if err := r.db.QueryRowx(
+		query,
+		req.Name,
+		req.Strategy,
+		req.Currency,
+		req.Amount,
+		req.Threshold,
+		tiers,
+		*req.Active,
+		req.Position,
+	).Scan(&id); err != nil {
+		return "", fmt.Errorf("insert shipping rate failed: %v", err)
+	}
+	return id, nil
+}
+
+func (r *shippingRepository) UpdateRate(rateId string, req *shipping.RateReq) error {
+	tiers, err := json.Marshal(req.Tiers)
+	if err != nil {
+		return fmt.Errorf("marshal tiers failed: %v", err)
+	}
+
+	query := `
+	UPDATE "shipping_rates" SET
+		"name" = $2,
+		"strategy" = $3,
+		"currency" = $4,
+		"amount" = $5,
+		"threshold" = $6,
+		"tiers" = $7,
+		"active" = $8,
+		"position" = $9
+	WHERE "id" = $1;`
+
+	res, err := r.db.Exec(
+		query,
+		rateId,
+		req.Name,
+		req.Strategy,
+		req.Currency,
+		req.Amount,
+		req.Threshold,
+		tiers,
+		*req.Active,
+		req.Position,
+	)
+	if err != nil {
+		return fmt.Errorf("update shipping rate failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return shipping.ErrRateNotFound
+	}
+	return nil
+}
+
+func (r *shippingRepository) DeleteRate(rateId string) error {
+	res, err := r.db.Exec(`DELETE FROM "shipping_rates" WHERE "id" = $1;`, rateId)
+	if err != nil {
+		return fmt.Errorf("delete shipping rate failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return shipping.ErrRateNotFound
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package shippingUsecases
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/currencies/currenciesUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/shipping"
+	"github.com/k0msak007/kawaii-shop/modules/shipping/shippingRepositories"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiishipping"
+)
+
+type IShippingUsecase interface {
+	FindRates(req *shipping.RateFilter) ([]*shipping.Rate, error)
+	FindOneRate(rateId string) (*shipping.Rate, error)
+	InsertRate(req *shipping.RateReq) (*shipping.Rate, error)
+	UpdateRate(rateId string, req *shipping.RateReq) (*shipping.Rate, error)
+	DeleteRate(rateId string) error
+	// Quote prices the shipping of the lines with every active rate that
+	// covers them
+	Quote(req *shipping.QuoteReq) (*shipping.Quote, error)
+}
+
+type shippingUsecase struct {
+	cfg                config.IConfig
+	shippingRepository shippingRepositories.IShippingRepository
+	productsUsecase    productsUsecases.IProductsUsecase
+	currenciesUsecase  currenciesUsecases.ICurrenciesUsecase
+}
+
+func ShippingUsecase(cfg config.IConfig, shippingRepository shippingRepositories.IShippingRepository, productsUsecase productsUsecases.IProductsUsecase, currenciesUsecase currenciesUsecases.ICurrenciesUsecase) IShippingUsecase {
+	return &shippingUsecase{
+		cfg:                cfg,
+		shippingRepository: shippingRepository,
+		productsUsecase:    productsUsecase,
+		currenciesUsecase:  currenciesUsecase,
+	}
+}
+
+// normalize fills the defaults and checks the strategy can be built from
+// the fields it needs
+func (u *shippingUsecase) normalize(req *shipping.RateReq) error {
+	req.Name = strings.TrimSpace(req.Name)
+
+	if req.Currency == "" {
+		req.Currency = u.cfg.App().Currency()
+	}
+	c, err := kawaiimoney.Lookup(req.Currency)
+	if err != nil {
+		return fmt.Errorf("%w, %v", shipping.ErrRateInvalid, err)
+	}
+	req.Currency = c.Code
+
+	if req.Tiers == nil {
+		req.Tiers = make([]*kawaiishipping.Tier, 0)
+	}
+	if req.Strategy != kawaiishipping.StrategyFreeOver {
+		req.Threshold = nil
+	}
+	if req.Strategy == kawaiishipping.StrategyFlat {
+		req.Tiers = req.Tiers[:0]
+	}
+
+	rate := &shipping.Rate{
+		Strategy:  req.Strategy,
+		Amount:    req.Amount,
+		Threshold: req.Threshold,
+		Tiers:     req.Tiers,
+	}
+	if _, err := rate.Calculator(); err != nil {
+		return err
+	}
+
+	if req.Active == nil {
+		active := true
+		req.Active = &active
+	}
+	return nil
+}
+
+func (u *shippingUsecase) FindRates(req *shipping.RateFilter) ([]*shipping.Rate, error) {
+	return u.shippingRepository.FindRates(req)
+}
+
+func (u *shippingUsecase) FindOneRate(rateId string) (*shipping.Rate, error) {
+	return u.shippingRepository.FindOneRate(rateId)
+}
+
+func (u *shippingUsecase) InsertRate(req *shipping.RateReq) (*shipping.Rate, error) {
+	if err := u.normalize(req); err != nil {
+		return nil, err
+	}
+
+	id, err := u.shippingRepository.InsertRate(req)
+	if err != nil {
+		return nil, err
+	}
+	return u.shippingRepository.FindOneRate(id)
+}
+
+func (u *shippingUsecase) UpdateRate(rateId string, req *shipping.RateReq) (*shipping.Rate, error) {
+	if err := u.normalize(req); err != nil {
+		return nil, err
+	}
+
+	if err := u.shippingRepository.UpdateRate(rateId, req); err != nil {
+		return nil, err
+	}
+	return u.shippingRepository.FindOneRate(rateId)
+}
+
+func (u *shippingUsecase) DeleteRate(rateId string) error {
+	return u.shippingRepository.DeleteRate(rateId)
+}
+
+// Quote weighs and prices the lines in the currency asked for. A rate in
+// another currency is compared against the subtotal converted into its
+// currency and its amount converted back.
+func (u *shippingUsecase) Quote(req *shipping.QuoteReq) (*shipping.Quote, error) {
+	if req.Currency == "" {
+		req.Currency = u.cfg.App().Currency()
+	}
+	c, err := kawaiimoney.Lookup(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	parcel := new(kawaiishipping.Parcel)
+	for _, l := range req.Lines {
+		product, err := u.productsUsecase.FindOneProduct(l.ProductId, c.Code)
+		if err != nil {
+			return nil, err
+		}
+		unit, _, err := product.UnitPrice(l.VariantId)
+		if err != nil {
+			return nil, err
+		}
+		parcel.Weight += product.Weight * l.Quantity
+		parcel.Subtotal += unit.Amount * int64(l.Quantity)
+	}
+
+	active := true
+	rates, err := u.shippingRepository.FindRates(&shipping.RateFilter{Active: &active})
+	if err != nil {
+		return nil, err
+	}
+	fx, err := u.currenciesUsecase.Rates()
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &shipping.Quote{
+		Currency: c.Code,
+		Weight:   parcel.Weight,
+		Subtotal: kawaiimoney.New(parcel.Subtotal, c),
+		Options:  make([]*shipping.Option, 0, len(rates)),
+	}
+	for _, r := range rates {
+		// A rate that cannot ship the parcel, or has no exchange rate to
+		// the currency asked for, is no option
+		amount, err := price(r, parcel, c, fx)
+		if errors.Is(err, kawaiishipping.ErrNotCovered) || errors.Is(err, kawaiimoney.ErrNoRate) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("shipping rate %s: %w", r.Name, err)
+		}
+		quote.Options = append(quote.Options, &shipping.Option{
+			RateId:   r.Id,
+			Name:     r.Name,
+			Strategy: r.Strategy,
+			Amount:   kawaiimoney.New(amount, c),
+			Free:     amount == 0,
+		})
+	}
+
+	// Rates come by position, a stable sort keeps it among equal prices
+	sort.SliceStable(quote.Options, func(i, j int) bool {
+		return quote.Options[i].Amount.Amount < quote.Options[j].Amount.Amount
+	})
+	return quote, nil
+}
+
+// price quotes parcel, priced in c, with rate r and returns the amount in c
+func price(r *shipping.Rate, parcel *kawaiishipping.Parcel, c *kawaiimoney.Currency, fx kawaiimoney.Rates) (int64, error) {
+	calculator, err := r.Calculator()
+	if err != nil {
+		return 0, err
+	}
+	if r.Currency == c.Code {
+		return calculator.Quote(parcel)
+	}
+
+	rc, err := kawaiimoney.Lookup(r.Currency)
+	if err != nil {
+		return 0, err
+	}
+	to, err := fx.Rate(c.Code, rc.Code)
+	if err != nil {
+		return 0, err
+	}
+	back, err := fx.Rate(rc.Code, c.Code)
+	if err != nil {
+		return 0, err
+	}
+
+	amount, err := calculator.Quote(&kawaiishipping.Parcel{
+		Weight:   parcel.Weight,
+		Subtotal: kawaiimoney.Convert(parcel.Subtotal, c, rc, to),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return kawaiimoney.Convert(amount, rc, c, back), nil
+}
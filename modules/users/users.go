@@ -2,8 +2,8 @@ package users
 
 import (
 	"fmt"
-	"regexp"
 
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,14 +15,14 @@ type User struct {
 }
 
 type UserRegisterReq struct {
-	Email    string `db:"email" json:"email" form:"email"`
-	Password string `db:"password" json:"password" form:"password"`
-	Username string `db:"username" json:"username" form:"username"`
+	Email    string `db:"email" json:"email" form:"email" validate:"required,email,max=255"`
+	Password string `db:"password" json:"password" form:"password" validate:"required,min=6,max=72"`
+	Username string `db:"username" json:"username" form:"username" validate:"required,max=64"`
 }
 
 type UserCredential struct {
-	Email    string `db:"email" json:"email" form:"email"`
-	Password string `db:"password" json:"password" form:"password"`
+	Email    string `db:"email" json:"email" form:"email" validate:"required"`
+	Password string `db:"password" json:"password" form:"password" validate:"required"`
 }
 
 type UserCredentialCheck struct {
@@ -44,11 +44,7 @@ func (obj *UserRegisterReq) BcryptHashing() error {
 }
 
 func (obj *UserRegisterReq) IsEmail() bool {
-	match, err := regexp.MatchString(`^[\w-\.]+@([\w-]+\.)+[\w-]{2,4}$`, obj.Email)
-	if err != nil {
-		return false
-	}
-	return match
+	return kawaiivalidator.IsEmail(obj.Email)
 }
 
 type UserPassport struct {
@@ -68,7 +64,7 @@ type UserClaims struct {
 }
 
 type UserRefreshCredentail struct {
-	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token" validate:"required"`
 }
 
 type Oauth struct {
@@ -77,5 +73,5 @@ type Oauth struct {
 }
 
 type UserRemoveCredential struct {
-	OauthId string `db:"id" json:"oauth_id"`
+	OauthId string `db:"id" json:"oauth_id" validate:"required,uuid"`
 }
@@ -1,10 +1,12 @@
 package usersHandlers
 
 import (
+	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/carts/cartsUsecases"
 	"github.com/k0msak007/kawaii-shop/modules/entities"
 	"github.com/k0msak007/kawaii-shop/modules/users"
 	"github.com/k0msak007/kawaii-shop/modules/users/usersUsecases"
@@ -36,33 +38,22 @@ type IUsersHandler interface {
 type usersHandler struct {
 	cfg          config.IConfig
 	usersUsecase usersUsecases.IUsersUsecase
+	cartsUsecase cartsUsecases.ICartsUsecase
 }
 
-func UsersHandler(cfg config.IConfig, usersUsecase usersUsecases.IUsersUsecase) IUsersHandler {
+func UsersHandler(cfg config.IConfig, usersUsecase usersUsecases.IUsersUsecase, cartsUsecase cartsUsecases.ICartsUsecase) IUsersHandler {
 	return &usersHandler{
 		cfg:          cfg,
 		usersUsecase: usersUsecase,
+		cartsUsecase: cartsUsecase,
 	}
 }
 
 func (h *usersHandler) SignUpCustomer(c *fiber.Ctx) error {
 	req := new(users.UserRegisterReq)
 
-	if err := c.BodyParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(signUpCustomerErr),
-			err.Error(),
-		).Res()
-	}
-
-	// Email validation
-	if !req.IsEmail() {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(signUpCustomerErr),
-			"email pattern is invalid",
-		).Res()
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(signUpCustomerErr), err).Res()
 	}
 
 	// Insert
@@ -96,21 +87,8 @@ func (h *usersHandler) SignUpCustomer(c *fiber.Ctx) error {
 func (h *usersHandler) SignUpAdmin(c *fiber.Ctx) error {
 	req := new(users.UserRegisterReq)
 
-	if err := c.BodyParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(signUpCustomerErr),
-			err.Error(),
-		).Res()
-	}
-
-	// Email validation
-	if !req.IsEmail() {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(signUpCustomerErr),
-			"email pattern is invalid",
-		).Res()
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(signUpCustomerErr), err).Res()
 	}
 
 	result, err := h.usersUsecase.InsertCustomer(req)
@@ -162,12 +140,8 @@ func (h *usersHandler) GenerateAdminToken(c *fiber.Ctx) error {
 
 func (h *usersHandler) SignIn(c *fiber.Ctx) error {
 	req := new(users.UserCredential)
-	if err := c.BodyParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(signInErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(signInErr), err).Res()
 	}
 
 	passport, err := h.usersUsecase.GetPassport(req)
@@ -178,17 +152,21 @@ func (h *usersHandler) SignIn(c *fiber.Ctx) error {
 			err.Error(),
 		).Res()
 	}
+
+	// The anonymous cart built before signing in joins the user's cart, a
+	// cart that cannot be merged does not fail the sign in
+	if token := c.Get("X-Cart-Token"); token != "" {
+		if err := h.cartsUsecase.MergeCart(passport.User.Id, token); err != nil {
+			log.Printf("merge cart failed: %v", err)
+		}
+	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
 }
 
 func (h *usersHandler) RefressPassport(c *fiber.Ctx) error {
 	req := new(users.UserRefreshCredentail)
-	if err := c.BodyParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(refreshPassportErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(refreshPassportErr), err).Res()
 	}
 
 	passport, err := h.usersUsecase.RefreshPassport(req)
@@ -205,12 +183,8 @@ func (h *usersHandler) RefressPassport(c *fiber.Ctx) error {
 func (h *usersHandler) SignOut(c *fiber.Ctx) error {
 	req := new(users.UserRemoveCredential)
 
-	if err := c.BodyParser(req); err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
-			string(signOutErr),
-			err.Error(),
-		).Res()
+	if err := entities.ParseBody(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(signOutErr), err).Res()
 	}
 
 	if err := h.usersUsecase.DeleteOauth(req.OauthId); err != nil {
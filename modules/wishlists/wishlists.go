@@ -0,0 +1,69 @@
+package wishlists
+
+import (
+	"errors"
+
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+)
+
+var (
+	ErrNotFavorited         = errors.New("product is not in the wishlist")
+	ErrNotificationNotFound = errors.New("notification not found")
+)
+
+const (
+	KindPriceDrop   = "price_drop"
+	KindBackInStock = "back_in_stock"
+)
+
+type WishlistFilter struct {
+	// ISO 4217 code to price the products in, their own currency when empty
+	Currency string `query:"currency"`
+	*entities.PaginationReq
+}
+
+// Notification tells a user a wishlisted product got cheaper or is back in
+// stock. The prices are those of the product in its own currency.
+type Notification struct {
+	Id           string             `json:"id"`
+	ProductId    string             `json:"product_id"`
+	ProductTitle string             `json:"product_title"`
+	Kind         string             `json:"kind"`
+	OldPrice     *kawaiimoney.Money `json:"old_price"`
+	NewPrice     *kawaiimoney.Money `json:"new_price"`
+	ReadAt       *string            `json:"read_at"`
+	CreatedAt    string             `json:"created_at"`
+}
+
+// Format fills the decimal and display forms of the prices
+func (n *Notification) Format() {
+	for _, m := range []**kawaiimoney.Money{&n.OldPrice, &n.NewPrice} {
+		if *m == nil {
+			continue
+		}
+		c, err := kawaiimoney.Lookup((*m).Currency)
+		if err != nil {
+			continue
+		}
+		*m = kawaiimoney.New((*m).Amount, c)
+	}
+}
+
+type NotificationFilter struct {
+	// Only the notifications not read yet
+	Unread bool `query:"unread"`
+	*entities.PaginationReq
+}
+
+// Favorites is how many users wishlisted a product
+type Favorites struct {
+	ProductId string `db:"product_id" json:"product_id"`
+	Title     string `db:"title" json:"title"`
+	Count     int    `db:"count" json:"favorite_count"`
+}
+
+type FavoritesFilter struct {
+	ProductId string `query:"product_id"`
+	*entities.PaginationReq
+}
@@ -0,0 +1,184 @@
+package wishlistsHandlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists/wishlistsUsecases"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type wishlistsHandlersErrCode string
+
+const (
+	findWishlistErr      wishlistsHandlersErrCode = "wishlists-001"
+	addFavoriteErr       wishlistsHandlersErrCode = "wishlists-002"
+	removeFavoriteErr    wishlistsHandlersErrCode = "wishlists-003"
+	findNotificationsErr wishlistsHandlersErrCode = "wishlists-004"
+	readNotificationErr  wishlistsHandlersErrCode = "wishlists-005"
+	countFavoritesErr    wishlistsHandlersErrCode = "wishlists-006"
+)
+
+type IWishlistsHandler interface {
+	FindWishlist(c *fiber.Ctx) error
+	AddFavorite(c *fiber.Ctx) error
+	RemoveFavorite(c *fiber.Ctx) error
+	FindNotifications(c *fiber.Ctx) error
+	ReadNotification(c *fiber.Ctx) error
+	CountFavorites(c *fiber.Ctx) error
+}
+
+type wishlistsHandler struct {
+	cfg              config.IConfig
+	wishlistsUsecase wishlistsUsecases.IWishlistsUsecase
+}
+
+func WishlistsHandler(cfg config.IConfig, wishlistsUsecase wishlistsUsecases.IWishlistsUsecase) IWishlistsHandler {
+	return &wishlistsHandler{
+		cfg:              cfg,
+		wishlistsUsecase: wishlistsUsecase,
+	}
+}
+
+func wishlistsStatus(err error) int {
+	switch {
+	case errors.Is(err, wishlists.ErrNotFavorited),
+		errors.Is(err, wishlists.ErrNotificationNotFound),
+		errors.Is(err, products.ErrProductNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, kawaiimoney.ErrUnknownCurrency),
+		errors.Is(err, kawaiimoney.ErrNoRate):
+		return fiber.ErrBadRequest.Code
+	default:
+		return fiber.ErrInternalServerError.Code
+	}
+}
+
+// page fills the defaults of a page request
+func page(req *entities.PaginationReq) {
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 5 {
+		req.Limit = 5
+	}
+}
+
+// FindWishlist lists the products the user_id param wishlisted, the latest
+// first. ParamsCheck makes it the signed in user.
+func (h *wishlistsHandler) FindWishlist(c *fiber.Ctx) error {
+	req := &wishlists.WishlistFilter{
+		PaginationReq: &entities.PaginationReq{},
+	}
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findWishlistErr), err).Res()
+	}
+	page(req.PaginationReq)
+
+	res, err := h.wishlistsUsecase.FindWishlist(c.Params("user_id"), req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(findWishlistErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *wishlistsHandler) AddFavorite(c *fiber.Ctx) error {
+	productId := strings.TrimSpace(c.Params("product_id"))
+
+	product, err := h.wishlistsUsecase.AddFavorite(c.Params("user_id"), productId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(addFavoriteErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, product).Res()
+}
+
+func (h *wishlistsHandler) RemoveFavorite(c *fiber.Ctx) error {
+	productId := strings.TrimSpace(c.Params("product_id"))
+
+	if err := h.wishlistsUsecase.RemoveFavorite(c.Params("user_id"), productId); err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(removeFavoriteErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// FindNotifications lists the price drops and restocks of the wishlisted
+// products of the user_id param, the latest first
+func (h *wishlistsHandler) FindNotifications(c *fiber.Ctx) error {
+	req := &wishlists.NotificationFilter{
+		PaginationReq: &entities.PaginationReq{},
+	}
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(findNotificationsErr), err).Res()
+	}
+	page(req.PaginationReq)
+
+	res, err := h.wishlistsUsecase.FindNotifications(c.Params("user_id"), req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(findNotificationsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
+
+func (h *wishlistsHandler) ReadNotification(c *fiber.Ctx) error {
+	id := c.Params("notification_id")
+	if !kawaiivalidator.IsUUID(id) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(readNotificationErr),
+			wishlists.ErrNotificationNotFound.Error(),
+		).Res()
+	}
+
+	if err := h.wishlistsUsecase.ReadNotification(c.Params("user_id"), id); err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(readNotificationErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// CountFavorites lists how many users wishlisted each product, the most
+// favorited first
+func (h *wishlistsHandler) CountFavorites(c *fiber.Ctx) error {
+	req := &wishlists.FavoritesFilter{
+		PaginationReq: &entities.PaginationReq{},
+	}
+	if err := entities.ParseQuery(c, req); err != nil {
+		return entities.NewResponse(c).ParseError(string(countFavoritesErr), err).Res()
+	}
+	page(req.PaginationReq)
+
+	res, err := h.wishlistsUsecase.CountFavorites(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			wishlistsStatus(err),
+			string(countFavoritesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
+}
@@ -0,0 +1,186 @@
+package wishlistsRepositories
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists"
+)
+
+type IWishlistsRepository interface {
+	// FindFavorites returns a page of the product ids the user wishlisted,
+	// the latest first, and how many there are
+	FindFavorites(userId string, req *wishlists.WishlistFilter) ([]string, int, error)
+	AddFavorite(userId, productId string) error
+	RemoveFavorite(userId, productId string) error
+	FindNotifications(userId string, req *wishlists.NotificationFilter) ([]*wishlists.Notification, int, error)
+	ReadNotification(userId, notificationId string) error
+	CountFavorites(req *wishlists.FavoritesFilter) ([]*wishlists.Favorites, int, error)
+}
+
+type wishlistsRepository struct {
+	db *sqlx.DB
+}
+
+func WishlistsRepository(db *sqlx.DB) IWishlistsRepository {
+	return &wishlistsRepository{
+		db: db,
+	}
+}
+
+func (r *wishlistsRepository) FindFavorites(userId string, req *wishlists.WishlistFilter) ([]string, int, error) {
+	var count int
+	if err := r.db.Get(&count, `SELECT COUNT(*) FROM "wishlists" WHERE "user_id" = $1;`, userId); err != nil {
+		return nil, 0, fmt.Errorf("count wishlist failed: %v", err)
+	}
+
+	query := `
+	SELECT
+		"product_id"
+	FROM "wishlists"
+	WHERE "user_id" = $1
+	ORDER BY "created_at" DESC, "product_id"
+	OFFSET $2 LIMIT $3;`
+
+	ids := make([]string, 0)
+	if err := r.db.Select(&ids, query, userId, (req.Page-1)*req.Limit, req.Limit); err != nil {
+		return nil, 0, fmt.Errorf("get wishlist failed: %v", err)
+	}
+	return ids, count, nil
+}
+
+// AddFavorite wishlists the product, a product wishlisted already keeps
+// its place
+func (r *wishlistsRepository) AddFavorite(userId, productId string) error {
+	query := `
+	INSERT INTO "wishlists" (
+		"user_id",
+		"product_id"
+	)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING;`
+
+	if _, err := r.db.Exec(query, userId, productId); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "wishlists_product_id_fkey" {
+			return products.ErrProductNotFound
+		}
+		return fmt.Errorf("insert wishlist failed: %v", err)
+	}
+	return nil
+}
+
+func (r *wishlistsRepository) RemoveFavorite(userId, productId string) error {
+	res, err := r.db.Exec(`DELETE FROM "wishlists" WHERE "user_id" = $1 AND "product_id" = $2;`, userId, productId)
+	if err != nil {
+		return fmt.Errorf("delete wishlist failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return wishlists.ErrNotFavorited
+	}
+	return nil
+}
+
+func (r *wishlistsRepository) FindNotifications(userId string, req *wishlists.NotificationFilter) ([]*wishlists.Notification, int, error) {
+	where := ` WHERE "n"."user_id" = $1`
+	if req.Unread {
+		where += ` AND "n"."read_at" IS NULL`
+	}
+
+	var count int
+	if err := r.db.Get(&count, `SELECT COUNT(*) FROM "wishlist_notifications" "n"`+where, userId); err != nil {
+		return nil, 0, fmt.Errorf("count notifications failed: %v", err)
+	}
+
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t" ORDER BY "t"."created_at" DESC, "t"."id")), '[]'::json)
+	FROM (
+		SELECT
+			"n"."id",
+			"n"."product_id",
+			"p"."title" AS "product_title",
+			"n"."kind",
+			CASE WHEN "n"."old_price" IS NULL THEN NULL
+			ELSE jsonb_build_object('amount', "n"."old_price", 'currency', "n"."currency")
+			END AS "old_price",
+			CASE WHEN "n"."new_price" IS NULL THEN NULL
+			ELSE jsonb_build_object('amount', "n"."new_price", 'currency', "n"."currency")
+			END AS "new_price",
+			"n"."read_at",
+			"n"."created_at"
+		FROM "wishlist_notifications" "n"
+			JOIN "products" "p" ON "p"."id" = "n"."product_id"
+		` + where + `
+		ORDER BY "n"."created_at" DESC, "n"."id"
+		OFFSET $2 LIMIT $3
+	) AS "t";`
+
+	bytes := make([]byte, 0)
+	res := make([]*wishlists.Notification, 0)
+
+	if err := r.db.Get(&bytes, query, userId, (req.Page-1)*req.Limit, req.Limit); err != nil {
+		return nil, 0, fmt.Errorf("get notifications failed: %v", err)
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return nil, 0, fmt.Errorf("unmarshal notifications failed: %v", err)
+	}
+	return res, count, nil
+}
+
+// ReadNotification marks a notification read, reading it again keeps the
+// first time
+func (r *wishlistsRepository) ReadNotification(userId, notificationId string) error {
+	query := `
+	UPDATE "wishlist_notifications" SET
+		"read_at" = COALESCE("read_at", now())
+	WHERE "id" = $1
+	AND "user_id" = $2;`
+
+	res, err := r.db.Exec(query, notificationId, userId)
+	if err != nil {
+		return fmt.Errorf("read notification failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return wishlists.ErrNotificationNotFound
+	}
+	return nil
+}
+
+// CountFavorites returns a page of the wishlisted products, the most
+// favorited first
+func (r *wishlistsRepository) CountFavorites(req *wishlists.FavoritesFilter) ([]*wishlists.Favorites, int, error) {
+	where := ""
+	args := make([]any, 0)
+	if req.ProductId != "" {
+		args = append(args, req.ProductId)
+		where = ` WHERE "w"."product_id" = $1`
+	}
+
+	var count int
+	if err := r.db.Get(&count, `SELECT COUNT(DISTINCT "w"."product_id") FROM "wishlists" "w"`+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count favorites failed: %v", err)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+		"w"."product_id",
+		"p"."title",
+		COUNT(*) AS "count"
+	FROM "wishlists" "w"
+		JOIN "products" "p" ON "p"."id" = "w"."product_id"
+	%s
+	GROUP BY "w"."product_id", "p"."title"
+	ORDER BY "count" DESC, "w"."product_id"
+	OFFSET $%d LIMIT $%d;`, where, len(args)+1, len(args)+2)
+
+	res := make([]*wishlists.Favorites, 0)
+	if err := r.db.Select(&res, query, append(args, (req.Page-1)*req.Limit, req.Limit)...); err != nil {
+		return nil, 0, fmt.Errorf("get favorites failed: %v", err)
+	}
+	return res, count, nil
+}
@@ -0,0 +1,126 @@
+package wishlistsUsecases
+
+import (
+	"log"
+	"math"
+
+	"github.com/k0msak007/kawaii-shop/modules/entities"
+	"github.com/k0msak007/kawaii-shop/modules/products"
+	"github.com/k0msak007/kawaii-shop/modules/products/productsUsecases"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists"
+	"github.com/k0msak007/kawaii-shop/modules/wishlists/wishlistsRepositories"
+)
+
+type IWishlistsUsecase interface {
+	// FindWishlist returns a page of the wishlisted products priced in the
+	// currency of the filter
+	FindWishlist(userId string, req *wishlists.WishlistFilter) (*entities.PaginateRes, error)
+	AddFavorite(userId, productId string) (*products.Product, error)
+	RemoveFavorite(userId, productId string) error
+	FindNotifications(userId string, req *wishlists.NotificationFilter) (*entities.PaginateRes, error)
+	ReadNotification(userId, notificationId string) error
+	CountFavorites(req *wishlists.FavoritesFilter) (*entities.PaginateRes, error)
+}
+
+type wishlistsUsecase struct {
+	wishlistsRepository wishlistsRepositories.IWishlistsRepository
+	productsUsecase     productsUsecases.IProductsUsecase
+}
+
+func WishlistsUsecase(wishlistsRepository wishlistsRepositories.IWishlistsRepository, productsUsecase productsUsecases.IProductsUsecase) IWishlistsUsecase {
+	return &wishlistsUsecase{
+		wishlistsRepository: wishlistsRepository,
+		productsUsecase:     productsUsecase,
+	}
+}
+
+func paginate(data any, page *entities.PaginationReq, count int) *entities.PaginateRes {
+	return &entities.PaginateRes{
+		Data:      data,
+		Page:      page.Page,
+		Limit:     page.Limit,
+		TotalItem: count,
+		TotalPage: int(math.Ceil(float64(count) / float64(page.Limit))),
+	}
+}
+
+func (u *wishlistsUsecase) FindWishlist(userId string, req *wishlists.WishlistFilter) (*entities.PaginateRes, error) {
+	ids, count, err := u.wishlistsRepository.FindFavorites(userId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*products.Product, 0, len(ids))
+	if len(ids) == 0 {
+		return paginate(res, req.PaginationReq, count), nil
+	}
+
+	// The whole page in one query, put back in the order of the wishlist
+	page, err := u.productsUsecase.FindProduct(&products.ProductFilter{
+		Ids:           ids,
+		Currency:      req.Currency,
+		PaginationReq: &entities.PaginationReq{Page: 1, Limit: len(ids)},
+		SortReq:       new(entities.SortReq),
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*products.Product, len(ids))
+	for _, p := range page.Data.([]*products.Product) {
+		found[p.Id] = p
+	}
+
+	favorited := true
+	for _, id := range ids {
+		product, ok := found[id]
+		if !ok {
+			// Deleted since the page was read
+			log.Printf("wishlisted product %s is gone", id)
+			continue
+		}
+		product.Favorited = &favorited
+		res = append(res, product)
+	}
+	return paginate(res, req.PaginationReq, count), nil
+}
+
+func (u *wishlistsUsecase) AddFavorite(userId, productId string) (*products.Product, error) {
+	if err := u.wishlistsRepository.AddFavorite(userId, productId); err != nil {
+		return nil, err
+	}
+
+	product, err := u.productsUsecase.FindOneProduct(productId, "")
+	if err != nil {
+		return nil, err
+	}
+	favorited := true
+	product.Favorited = &favorited
+	return product, nil
+}
+
+func (u *wishlistsUsecase) RemoveFavorite(userId, productId string) error {
+	return u.wishlistsRepository.RemoveFavorite(userId, productId)
+}
+
+func (u *wishlistsUsecase) FindNotifications(userId string, req *wishlists.NotificationFilter) (*entities.PaginateRes, error) {
+	res, count, err := u.wishlistsRepository.FindNotifications(userId, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range res {
+		n.Format()
+	}
+	return paginate(res, req.PaginationReq, count), nil
+}
+
+func (u *wishlistsUsecase) ReadNotification(userId, notificationId string) error {
+	return u.wishlistsRepository.ReadNotification(userId, notificationId)
+}
+
+func (u *wishlistsUsecase) CountFavorites(req *wishlists.FavoritesFilter) (*entities.PaginateRes, error) {
+	res, count, err := u.wishlistsRepository.CountFavorites(req)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(res, req.PaginationReq, count), nil
+}
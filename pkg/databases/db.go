@@ -1,9 +1,11 @@
 package databases
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/k0msak007/kawaii-shop/config"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiimoney"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -21,3 +23,24 @@ func DbConnect(c config.IDbConfig) *sqlx.DB {
 
 	return db
 }
+
+// CheckCurrencies fails when a price is stored in a currency kawaiimoney
+// does not know, its amounts cannot be scaled or converted. Migration
+// 000010 labels the prices before it THB.
+func CheckCurrencies(db *sqlx.DB) error {
+	query := `
+	SELECT DISTINCT TRIM("currency") FROM "products"
+	UNION
+	SELECT DISTINCT TRIM("currency") FROM "product_prices";`
+
+	codes := make([]string, 0)
+	if err := db.Select(&codes, query); err != nil {
+		return fmt.Errorf("get price currencies failed: %v", err)
+	}
+	for _, code := range codes {
+		if _, err := kawaiimoney.Lookup(code); err != nil {
+			return fmt.Errorf("prices are stored in %s: %w", code, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+BEGIN;
+
+CREATE TABLE "idempotency_keys" (
+  "key" VARCHAR(64) PRIMARY KEY,
+  "fingerprint" VARCHAR(64) NOT NULL,
+  "status_code" INT,
+  "content_type" VARCHAR,
+  "response" BYTEA,
+  "completed" BOOLEAN NOT NULL DEFAULT FALSE,
+  "expires_at" TIMESTAMP NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "idempotency_keys_expires_at_idx" ON "idempotency_keys" ("expires_at");
+
+CREATE TRIGGER set_updated_at_timestamp_idempotency_keys_table BEFORE UPDATE ON "idempotency_keys" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
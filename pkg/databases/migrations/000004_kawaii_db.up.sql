@@ -0,0 +1,6 @@
+BEGIN;
+
+-- Rendition name -> url, e.g. {"thumbnail": "https://..."}
+ALTER TABLE "images" ADD COLUMN "variants" jsonb NOT NULL DEFAULT '{}'::jsonb;
+
+COMMIT;
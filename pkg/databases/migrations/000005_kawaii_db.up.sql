@@ -0,0 +1,8 @@
+BEGIN;
+
+-- Private images are served by signed urls only. From now on "url" and the
+-- values of "variants" hold storage keys, the urls are made at read time.
+-- Absolute urls of older rows are returned as they are.
+ALTER TABLE "images" ADD COLUMN "private" BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS count_file_references_images_table ON "images";
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_files_table ON "files";
+
+DROP FUNCTION IF EXISTS count_file_references();
+
+ALTER TABLE "images" DROP COLUMN IF EXISTS "file_id";
+
+DROP TABLE IF EXISTS "files" CASCADE;
+
+COMMIT;
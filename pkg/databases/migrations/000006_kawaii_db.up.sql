@@ -0,0 +1,52 @@
+BEGIN;
+
+CREATE TABLE "files" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "owner_id" VARCHAR NOT NULL,
+  "destination" VARCHAR NOT NULL UNIQUE,
+  "filename" VARCHAR NOT NULL,
+  "size" BIGINT NOT NULL,
+  "content_type" VARCHAR NOT NULL,
+  "checksum" VARCHAR(64) NOT NULL,
+  "private" BOOLEAN NOT NULL DEFAULT FALSE,
+  -- Rendition name -> storage key
+  "variants" jsonb NOT NULL DEFAULT '{}'::jsonb,
+  "ref_count" INT NOT NULL DEFAULT 0 CHECK ("ref_count" >= 0),
+  -- Since when no image uses the file, the garbage collector waits for a
+  -- grace period before removing it
+  "unreferenced_at" TIMESTAMP DEFAULT now(),
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "files_owner_id_idx" ON "files" ("owner_id");
+CREATE INDEX "files_unreferenced_at_idx" ON "files" ("unreferenced_at") WHERE "ref_count" = 0;
+
+ALTER TABLE "images" ADD COLUMN "file_id" uuid;
+ALTER TABLE "images" ADD FOREIGN KEY ("file_id") REFERENCES "files" ("id") ON DELETE RESTRICT;
+CREATE INDEX "images_file_id_idx" ON "images" ("file_id");
+
+-- Keeps "files"."ref_count" in step with the images pointing at a file
+CREATE OR REPLACE FUNCTION count_file_references()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.file_id IS NOT NULL THEN
+        UPDATE "files" SET
+            "ref_count" = "ref_count" - 1,
+            "unreferenced_at" = CASE WHEN "ref_count" = 1 THEN now() ELSE "unreferenced_at" END
+        WHERE "id" = OLD.file_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.file_id IS NOT NULL THEN
+        UPDATE "files" SET
+            "ref_count" = "ref_count" + 1,
+            "unreferenced_at" = NULL
+        WHERE "id" = NEW.file_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER count_file_references_images_table AFTER INSERT OR DELETE OR UPDATE OF "file_id" ON "images" FOR EACH ROW EXECUTE PROCEDURE count_file_references();
+CREATE TRIGGER set_updated_at_timestamp_files_table BEFORE UPDATE ON "files" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_upload_sessions_table ON "upload_sessions";
+
+DROP TABLE IF EXISTS "upload_sessions" CASCADE;
+
+COMMIT;
@@ -0,0 +1,24 @@
+BEGIN;
+
+-- Resumable uploads, the bytes are staged on disk under the id until the
+-- upload is completed and turned into a row of "files"
+CREATE TABLE "upload_sessions" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "owner_id" VARCHAR NOT NULL,
+  "destination" VARCHAR NOT NULL UNIQUE,
+  "filename" VARCHAR NOT NULL,
+  "size" BIGINT NOT NULL CHECK ("size" > 0),
+  "offset" BIGINT NOT NULL DEFAULT 0 CHECK ("offset" >= 0 AND "offset" <= "size"),
+  "content_type" VARCHAR NOT NULL,
+  "checksum" VARCHAR(64) NOT NULL,
+  "private" BOOLEAN NOT NULL DEFAULT FALSE,
+  "expires_at" TIMESTAMP NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "upload_sessions_expires_at_idx" ON "upload_sessions" ("expires_at");
+
+CREATE TRIGGER set_updated_at_timestamp_upload_sessions_table BEFORE UPDATE ON "upload_sessions" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "images_product_id_primary_idx";
+DROP INDEX IF EXISTS "images_product_id_position_idx";
+
+ALTER TABLE "images" DROP COLUMN IF EXISTS "is_primary";
+ALTER TABLE "images" DROP COLUMN IF EXISTS "alt";
+ALTER TABLE "images" DROP COLUMN IF EXISTS "position";
+
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+
+-- Images of a product are ordered by "position", one of them may be the
+-- primary image shown in listings
+ALTER TABLE "images" ADD COLUMN "position" INT NOT NULL DEFAULT 0;
+ALTER TABLE "images" ADD COLUMN "alt" VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE "images" ADD COLUMN "is_primary" BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE "images" SET
+  "position" = "o"."position",
+  "is_primary" = "o"."position" = 0
+FROM (
+  SELECT
+    "id",
+    ROW_NUMBER() OVER (PARTITION BY "product_id" ORDER BY "created_at", "id") - 1 AS "position"
+  FROM "images"
+) AS "o"
+WHERE "images"."id" = "o"."id";
+
+CREATE INDEX "images_product_id_position_idx" ON "images" ("product_id", "position");
+CREATE UNIQUE INDEX "images_product_id_primary_idx" ON "images" ("product_id") WHERE "is_primary";
+
+COMMIT;
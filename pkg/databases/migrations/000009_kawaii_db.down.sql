@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_product_options_table ON "product_options";
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_product_variants_table ON "product_variants";
+
+DROP TABLE IF EXISTS "product_variants" CASCADE;
+DROP TABLE IF EXISTS "product_options" CASCADE;
+
+COMMIT;
@@ -0,0 +1,40 @@
+BEGIN;
+
+-- Option axes of a product, e.g. size with ["S", "M", "L"]
+CREATE TABLE "product_options" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "name" VARCHAR NOT NULL,
+  "values" jsonb NOT NULL DEFAULT '[]'::jsonb,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE ("product_id", "name")
+);
+
+-- A sellable combination of one value per option axis. "price" overrides
+-- the price of the product when set.
+CREATE TABLE "product_variants" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "sku" VARCHAR NOT NULL UNIQUE,
+  "options" jsonb NOT NULL DEFAULT '{}'::jsonb,
+  "price" FLOAT CHECK ("price" >= 0),
+  "stock" INT NOT NULL DEFAULT 0 CHECK ("stock" >= 0),
+  "image_id" uuid,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE ("product_id", "options")
+);
+
+ALTER TABLE "product_options" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "product_variants" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "product_variants" ADD FOREIGN KEY ("image_id") REFERENCES "images" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "product_variants_product_id_idx" ON "product_variants" ("product_id", "position");
+
+CREATE TRIGGER set_updated_at_timestamp_product_options_table BEFORE UPDATE ON "product_options" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+CREATE TRIGGER set_updated_at_timestamp_product_variants_table BEFORE UPDATE ON "product_variants" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
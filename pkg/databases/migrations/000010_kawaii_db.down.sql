@@ -0,0 +1,15 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_product_prices_table ON "product_prices";
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_exchange_rates_table ON "exchange_rates";
+
+DROP TABLE IF EXISTS "exchange_rates" CASCADE;
+DROP TABLE IF EXISTS "product_prices" CASCADE;
+
+ALTER TABLE "product_variants" ALTER COLUMN "price" TYPE FLOAT USING "price" / 100.0;
+
+ALTER TABLE "products" DROP COLUMN IF EXISTS "currency";
+ALTER TABLE "products" DROP CONSTRAINT IF EXISTS "products_price_check";
+ALTER TABLE "products" ALTER COLUMN "price" TYPE FLOAT USING "price" / 100.0;
+
+COMMIT;
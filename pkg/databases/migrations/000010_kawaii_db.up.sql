@@ -0,0 +1,50 @@
+BEGIN;
+
+-- Prices are integers of the minor unit of "currency", 150.00 THB is 15000.
+-- The catalog before this migration is taken to be priced in THB, whatever
+-- APP_CURRENCY is, and scaled by its 2 decimals. A catalog priced in
+-- another currency is relabelled and scaled to the exponent of it by hand,
+-- e.g. for JPY:
+--   UPDATE "products" SET "currency" = 'JPY', "price" = "price" / 100;
+-- The server refuses to start while a price is in an unsupported currency.
+ALTER TABLE "products" ALTER COLUMN "price" TYPE BIGINT USING ROUND("price"::NUMERIC * 100);
+ALTER TABLE "products" ADD CONSTRAINT "products_price_check" CHECK ("price" >= 0);
+ALTER TABLE "products" ADD COLUMN "currency" CHAR(3) NOT NULL DEFAULT 'THB';
+
+-- Overrides are in the currency of the product
+ALTER TABLE "product_variants" ALTER COLUMN "price" TYPE BIGINT USING ROUND("price"::NUMERIC * 100);
+
+-- Fixed prices in other currencies, of the product when "variant_id" is
+-- null or of one variant
+CREATE TABLE "product_prices" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "variant_id" uuid,
+  "currency" CHAR(3) NOT NULL,
+  "price" BIGINT NOT NULL CHECK ("price" >= 0),
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "product_prices" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "product_prices" ADD FOREIGN KEY ("variant_id") REFERENCES "product_variants" ("id") ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX "product_prices_product_currency_key" ON "product_prices" ("product_id", "currency") WHERE "variant_id" IS NULL;
+CREATE UNIQUE INDEX "product_prices_variant_currency_key" ON "product_prices" ("variant_id", "currency") WHERE "variant_id" IS NOT NULL;
+
+-- One "base" is "rate" "quote", the inverse is used when only the other
+-- direction is known
+CREATE TABLE "exchange_rates" (
+  "base" CHAR(3) NOT NULL,
+  "quote" CHAR(3) NOT NULL,
+  "rate" NUMERIC(24, 12) NOT NULL CHECK ("rate" > 0),
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  PRIMARY KEY ("base", "quote"),
+  CHECK ("base" <> "quote")
+);
+
+CREATE TRIGGER set_updated_at_timestamp_product_prices_table BEFORE UPDATE ON "product_prices" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+CREATE TRIGGER set_updated_at_timestamp_exchange_rates_table BEFORE UPDATE ON "exchange_rates" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_promotions_table ON "promotions";
+
+DROP TABLE IF EXISTS "promotion_redemptions" CASCADE;
+DROP TABLE IF EXISTS "promotion_categories" CASCADE;
+DROP TABLE IF EXISTS "promotion_products" CASCADE;
+DROP TABLE IF EXISTS "promotions" CASCADE;
+
+COMMIT;
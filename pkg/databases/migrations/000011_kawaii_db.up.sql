@@ -0,0 +1,61 @@
+BEGIN;
+
+-- Coupons, "value" is basis points for percentage and minor units of
+-- "currency" for fixed
+CREATE TABLE "promotions" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "code" VARCHAR NOT NULL UNIQUE,
+  "title" VARCHAR NOT NULL DEFAULT '',
+  "kind" VARCHAR NOT NULL CHECK ("kind" IN ('percentage', 'fixed')),
+  "value" BIGINT NOT NULL CHECK ("value" > 0),
+  "currency" CHAR(3) NOT NULL,
+  "min_spend" BIGINT NOT NULL DEFAULT 0 CHECK ("min_spend" >= 0),
+  "usage_limit" INT CHECK ("usage_limit" > 0),
+  "per_user_limit" INT CHECK ("per_user_limit" > 0),
+  "starts_at" TIMESTAMPTZ,
+  "ends_at" TIMESTAMPTZ,
+  "active" BOOLEAN NOT NULL DEFAULT TRUE,
+  "priority" INT NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  CHECK ("kind" <> 'percentage' OR "value" <= 10000),
+  CHECK ("starts_at" IS NULL OR "ends_at" IS NULL OR "ends_at" > "starts_at")
+);
+
+-- A promotion with no products and no categories applies to every product
+CREATE TABLE "promotion_products" (
+  "promotion_id" uuid NOT NULL,
+  "product_id" VARCHAR NOT NULL,
+  PRIMARY KEY ("promotion_id", "product_id")
+);
+
+CREATE TABLE "promotion_categories" (
+  "promotion_id" uuid NOT NULL,
+  "category_id" INT NOT NULL,
+  PRIMARY KEY ("promotion_id", "category_id")
+);
+
+-- Uses of a promotion, counted against the usage limits
+CREATE TABLE "promotion_redemptions" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "promotion_id" uuid NOT NULL,
+  "user_id" VARCHAR NOT NULL,
+  "order_id" VARCHAR,
+  "discount" BIGINT NOT NULL DEFAULT 0,
+  "currency" CHAR(3) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "promotion_products" ADD FOREIGN KEY ("promotion_id") REFERENCES "promotions" ("id") ON DELETE CASCADE;
+ALTER TABLE "promotion_products" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "promotion_categories" ADD FOREIGN KEY ("promotion_id") REFERENCES "promotions" ("id") ON DELETE CASCADE;
+ALTER TABLE "promotion_categories" ADD FOREIGN KEY ("category_id") REFERENCES "categories" ("id") ON DELETE CASCADE;
+ALTER TABLE "promotion_redemptions" ADD FOREIGN KEY ("promotion_id") REFERENCES "promotions" ("id") ON DELETE RESTRICT;
+ALTER TABLE "promotion_redemptions" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "promotion_redemptions" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "promotion_redemptions_promotion_id_user_id_idx" ON "promotion_redemptions" ("promotion_id", "user_id");
+
+CREATE TRIGGER set_updated_at_timestamp_promotions_table BEFORE UPDATE ON "promotions" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
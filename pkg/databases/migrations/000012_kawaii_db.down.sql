@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_cart_items_table ON "cart_items";
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_carts_table ON "carts";
+
+DROP TABLE IF EXISTS "cart_items" CASCADE;
+DROP TABLE IF EXISTS "carts" CASCADE;
+
+COMMIT;
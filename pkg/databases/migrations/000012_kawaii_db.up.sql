@@ -0,0 +1,38 @@
+BEGIN;
+
+-- A cart belongs to a user, or to whoever holds its cart token when
+-- "user_id" is NULL
+CREATE TABLE "carts" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" VARCHAR UNIQUE,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- "unit_price" is the price in minor units of "currency" when the item was
+-- added, reads compare it with the current price. "variant_id" has no
+-- foreign key so an item of a removed variant stays to be flagged.
+CREATE TABLE "cart_items" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "cart_id" uuid NOT NULL,
+  "product_id" VARCHAR NOT NULL,
+  "variant_id" uuid,
+  "quantity" INT NOT NULL CHECK ("quantity" > 0),
+  "unit_price" BIGINT NOT NULL CHECK ("unit_price" >= 0),
+  "currency" CHAR(3) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "carts" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "cart_items" ADD FOREIGN KEY ("cart_id") REFERENCES "carts" ("id") ON DELETE CASCADE;
+ALTER TABLE "cart_items" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+
+-- One line per product, or per variant
+CREATE UNIQUE INDEX "cart_items_cart_product_key" ON "cart_items" ("cart_id", "product_id") WHERE "variant_id" IS NULL;
+CREATE UNIQUE INDEX "cart_items_cart_variant_key" ON "cart_items" ("cart_id", "variant_id") WHERE "variant_id" IS NOT NULL;
+
+CREATE TRIGGER set_updated_at_timestamp_carts_table BEFORE UPDATE ON "carts" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+CREATE TRIGGER set_updated_at_timestamp_cart_items_table BEFORE UPDATE ON "cart_items" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_payments_table ON "payments";
+
+DROP TABLE IF EXISTS "payment_events" CASCADE;
+DROP TABLE IF EXISTS "payment_ledger" CASCADE;
+DROP TABLE IF EXISTS "payments" CASCADE;
+
+COMMIT;
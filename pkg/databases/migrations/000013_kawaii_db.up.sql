@@ -0,0 +1,66 @@
+BEGIN;
+
+-- A payment of an order at a provider, "provider_ref" is the id of the
+-- intent there. Amounts are minor units of "currency". A coupon taken off
+-- the order is held as "redemption_id" while the payment is open, so the
+-- usage limits count it, and released when the payment fails or is canceled.
+CREATE TABLE "payments" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "order_id" VARCHAR NOT NULL,
+  "provider" VARCHAR NOT NULL,
+  "provider_ref" VARCHAR NOT NULL,
+  "method" VARCHAR NOT NULL,
+  "status" VARCHAR NOT NULL CHECK ("status" IN ('pending', 'authorized', 'succeeded', 'failed', 'canceled', 'refunded')),
+  "amount" BIGINT NOT NULL CHECK ("amount" > 0),
+  "currency" CHAR(3) NOT NULL,
+  "redemption_id" uuid UNIQUE,
+  "discount" BIGINT NOT NULL DEFAULT 0 CHECK ("discount" >= 0),
+  "qr_code_url" VARCHAR,
+  "expires_at" TIMESTAMPTZ,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE ("provider", "provider_ref")
+);
+
+-- An order has one payment that is not failed or canceled
+CREATE UNIQUE INDEX "payments_order_open_key" ON "payments" ("order_id") WHERE "status" IN ('pending', 'authorized', 'succeeded', 'refunded');
+
+-- Money moved by payments, captures positive and refunds negative. An
+-- entry is recorded once whether the api or a webhook reports it first.
+CREATE TABLE "payment_ledger" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "payment_id" uuid NOT NULL,
+  "order_id" VARCHAR NOT NULL,
+  "kind" VARCHAR NOT NULL CHECK ("kind" IN ('authorization', 'capture', 'refund', 'failure', 'cancel')),
+  "amount" BIGINT NOT NULL,
+  "currency" CHAR(3) NOT NULL,
+  "reference" VARCHAR NOT NULL,
+  "event_id" VARCHAR,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE ("payment_id", "kind", "reference")
+);
+
+-- Webhook events by provider id, a redelivered event is not processed
+-- again
+CREATE TABLE "payment_events" (
+  "provider" VARCHAR NOT NULL,
+  "event_id" VARCHAR NOT NULL,
+  "type" VARCHAR NOT NULL,
+  "payment_id" uuid,
+  "payload" jsonb NOT NULL,
+  "received_at" TIMESTAMP NOT NULL DEFAULT now(),
+  PRIMARY KEY ("provider", "event_id")
+);
+
+-- Orders with payments cannot be removed, the ledger keeps their history
+ALTER TABLE "payments" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE RESTRICT;
+ALTER TABLE "payment_ledger" ADD FOREIGN KEY ("payment_id") REFERENCES "payments" ("id") ON DELETE RESTRICT;
+ALTER TABLE "payment_ledger" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE RESTRICT;
+ALTER TABLE "payment_events" ADD FOREIGN KEY ("payment_id") REFERENCES "payments" ("id") ON DELETE SET NULL;
+ALTER TABLE "payments" ADD FOREIGN KEY ("redemption_id") REFERENCES "promotion_redemptions" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "payment_ledger_order_id_idx" ON "payment_ledger" ("order_id", "created_at");
+
+CREATE TRIGGER set_updated_at_timestamp_payments_table BEFORE UPDATE ON "payments" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
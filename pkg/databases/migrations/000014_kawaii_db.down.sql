@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_shipping_rates_table ON "shipping_rates";
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_addresses_table ON "addresses";
+
+DROP TABLE IF EXISTS "shipping_rates" CASCADE;
+DROP TABLE IF EXISTS "addresses" CASCADE;
+
+ALTER TABLE "products" DROP COLUMN IF EXISTS "weight";
+
+COMMIT;
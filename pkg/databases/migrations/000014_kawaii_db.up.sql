@@ -0,0 +1,50 @@
+BEGIN;
+
+-- Shipping weight in grams
+ALTER TABLE "products" ADD COLUMN "weight" INT NOT NULL DEFAULT 0 CHECK ("weight" >= 0);
+
+-- Address book, a user with addresses has exactly one default
+CREATE TABLE "addresses" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" VARCHAR NOT NULL,
+  "label" VARCHAR NOT NULL DEFAULT '',
+  "recipient" VARCHAR NOT NULL,
+  "phone" VARCHAR NOT NULL,
+  "line1" VARCHAR NOT NULL,
+  "line2" VARCHAR NOT NULL DEFAULT '',
+  "subdistrict" VARCHAR NOT NULL,
+  "district" VARCHAR NOT NULL,
+  "province" VARCHAR NOT NULL,
+  "postal_code" CHAR(5) NOT NULL CHECK ("postal_code" ~ '^[1-9][0-9]{4}$'),
+  "is_default" BOOLEAN NOT NULL DEFAULT FALSE,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "addresses" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "addresses_user_id_idx" ON "addresses" ("user_id");
+CREATE UNIQUE INDEX "addresses_user_default_key" ON "addresses" ("user_id") WHERE "is_default";
+
+-- Shipping rates, amounts are minor units of "currency". "tiers" lists
+-- {"max_weight": grams, "amount": minor units} for weight_tier, and for
+-- free_over below "threshold" when not empty.
+CREATE TABLE "shipping_rates" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "name" VARCHAR NOT NULL,
+  "strategy" VARCHAR NOT NULL CHECK ("strategy" IN ('flat', 'weight_tier', 'free_over')),
+  "currency" CHAR(3) NOT NULL,
+  "amount" BIGINT NOT NULL DEFAULT 0 CHECK ("amount" >= 0),
+  "threshold" BIGINT CHECK ("threshold" > 0),
+  "tiers" jsonb NOT NULL DEFAULT '[]'::jsonb,
+  "active" BOOLEAN NOT NULL DEFAULT TRUE,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  CHECK ("strategy" <> 'free_over' OR "threshold" IS NOT NULL)
+);
+
+CREATE TRIGGER set_updated_at_timestamp_addresses_table BEFORE UPDATE ON "addresses" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+CREATE TRIGGER set_updated_at_timestamp_shipping_rates_table BEFORE UPDATE ON "shipping_rates" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+-- Deleting the rows first gives their files back to the garbage collector
+DELETE FROM "reviews";
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_reviews_table ON "reviews";
+DROP TRIGGER IF EXISTS count_file_references_reviews_table ON "reviews";
+
+DROP TABLE IF EXISTS "reviews" CASCADE;
+
+COMMIT;
@@ -0,0 +1,41 @@
+BEGIN;
+
+-- Product reviews, one per customer and product. Only approved reviews are
+-- listed and counted in the rating of the product.
+CREATE TABLE "reviews" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "user_id" VARCHAR NOT NULL,
+  -- The completed order the product was bought in
+  "order_id" VARCHAR,
+  "rating" SMALLINT NOT NULL CHECK ("rating" BETWEEN 1 AND 5),
+  "title" VARCHAR NOT NULL,
+  "body" TEXT NOT NULL DEFAULT '',
+  "file_id" uuid,
+  "status" VARCHAR NOT NULL DEFAULT 'pending' CHECK ("status" IN ('pending', 'approved', 'rejected')),
+  -- Why the review was rejected
+  "reason" VARCHAR NOT NULL DEFAULT '',
+  "moderated_by" VARCHAR,
+  "moderated_at" TIMESTAMP,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE ("product_id", "user_id")
+);
+
+ALTER TABLE "reviews" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "reviews" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "reviews" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE SET NULL;
+ALTER TABLE "reviews" ADD FOREIGN KEY ("file_id") REFERENCES "files" ("id") ON DELETE RESTRICT;
+ALTER TABLE "reviews" ADD FOREIGN KEY ("moderated_by") REFERENCES "users" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "reviews_user_id_idx" ON "reviews" ("user_id");
+CREATE INDEX "reviews_status_created_at_idx" ON "reviews" ("status", "created_at");
+CREATE INDEX "reviews_file_id_idx" ON "reviews" ("file_id");
+CREATE INDEX "reviews_product_id_approved_idx" ON "reviews" ("product_id") WHERE "status" = 'approved';
+
+-- A review image keeps its file from the garbage collector like a product
+-- image does
+CREATE TRIGGER count_file_references_reviews_table AFTER INSERT OR DELETE OR UPDATE OF "file_id" ON "reviews" FOR EACH ROW EXECUTE PROCEDURE count_file_references();
+CREATE TRIGGER set_updated_at_timestamp_reviews_table BEFORE UPDATE ON "reviews" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS notify_wishlist_back_in_stock_product_variants_table ON "product_variants";
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_products_table ON "products";
+
+DROP FUNCTION IF EXISTS notify_wishlist_back_in_stock();
+DROP FUNCTION IF EXISTS notify_wishlist_price_drop();
+
+DROP TABLE IF EXISTS "wishlist_notifications" CASCADE;
+DROP TABLE IF EXISTS "wishlists" CASCADE;
+
+COMMIT;
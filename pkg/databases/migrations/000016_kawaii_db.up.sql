@@ -0,0 +1,72 @@
+BEGIN;
+
+-- Products a user saved for later
+CREATE TABLE "wishlists" (
+  "user_id" VARCHAR NOT NULL,
+  "product_id" VARCHAR NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  PRIMARY KEY ("user_id", "product_id")
+);
+
+ALTER TABLE "wishlists" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "wishlists" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "wishlists_product_id_idx" ON "wishlists" ("product_id");
+
+-- What changed on a wishlisted product, prices are minor units of
+-- "currency"
+CREATE TABLE "wishlist_notifications" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" VARCHAR NOT NULL,
+  "product_id" VARCHAR NOT NULL,
+  "kind" VARCHAR NOT NULL CHECK ("kind" IN ('price_drop', 'back_in_stock')),
+  "old_price" BIGINT,
+  "new_price" BIGINT,
+  "currency" CHAR(3),
+  "read_at" TIMESTAMP,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "wishlist_notifications" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+ALTER TABLE "wishlist_notifications" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "wishlist_notifications_user_id_created_at_idx" ON "wishlist_notifications" ("user_id", "created_at");
+
+-- Tells the users wishing for a product its price went down
+CREATE OR REPLACE FUNCTION notify_wishlist_price_drop()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.price < OLD.price AND NEW.currency = OLD.currency THEN
+        INSERT INTO "wishlist_notifications" ("user_id", "product_id", "kind", "old_price", "new_price", "currency")
+        SELECT "w"."user_id", NEW.id, 'price_drop', OLD.price, NEW.price, NEW.currency
+        FROM "wishlists" "w"
+        WHERE "w"."product_id" = NEW.id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+-- Tells the users wishing for a product a variant is back in stock, when
+-- no other variant was in stock
+CREATE OR REPLACE FUNCTION notify_wishlist_back_in_stock()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.stock = 0 AND NEW.stock > 0 AND NOT EXISTS (
+        SELECT 1 FROM "product_variants"
+        WHERE "product_id" = NEW.product_id
+        AND "id" <> NEW.id
+        AND "stock" > 0
+    ) THEN
+        INSERT INTO "wishlist_notifications" ("user_id", "product_id", "kind")
+        SELECT "w"."user_id", NEW.product_id, 'back_in_stock'
+        FROM "wishlists" "w"
+        WHERE "w"."product_id" = NEW.product_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER notify_wishlist_price_drop_products_table AFTER UPDATE OF "price" ON "products" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+CREATE TRIGGER notify_wishlist_back_in_stock_product_variants_table AFTER UPDATE OF "stock" ON "product_variants" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_back_in_stock();
+
+COMMIT;
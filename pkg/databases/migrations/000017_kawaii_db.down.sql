@@ -0,0 +1,29 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_wishlists_table ON "wishlists";
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_product_prices_table ON "product_prices";
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_product_variants_table ON "product_variants";
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_products_table ON "products";
+
+DROP FUNCTION IF EXISTS wishlist_check_price(VARCHAR);
+DROP FUNCTION IF EXISTS wishlist_min_price(VARCHAR, CHAR(3));
+
+DROP TABLE IF EXISTS "wishlist_prices" CASCADE;
+
+-- Back to telling drops of the product price alone
+CREATE OR REPLACE FUNCTION notify_wishlist_price_drop()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.price < OLD.price AND NEW.currency = OLD.currency THEN
+        INSERT INTO "wishlist_notifications" ("user_id", "product_id", "kind", "old_price", "new_price", "currency")
+        SELECT "w"."user_id", NEW.id, 'price_drop', OLD.price, NEW.price, NEW.currency
+        FROM "wishlists" "w"
+        WHERE "w"."product_id" = NEW.id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER notify_wishlist_price_drop_products_table AFTER UPDATE OF "price" ON "products" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+
+COMMIT;
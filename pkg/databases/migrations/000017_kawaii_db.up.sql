@@ -0,0 +1,143 @@
+BEGIN;
+
+-- The lowest price a wishlisted product was last seen at in each currency
+-- it has fixed prices in, so a drop is told whichever of the product, its
+-- variants or its price list went down. Prices converted at the exchange
+-- rates are not tracked, the rates move on their own.
+CREATE TABLE "wishlist_prices" (
+  "product_id" VARCHAR NOT NULL,
+  "currency" CHAR(3) NOT NULL,
+  "price" BIGINT NOT NULL,
+  PRIMARY KEY ("product_id", "currency")
+);
+
+ALTER TABLE "wishlist_prices" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+
+-- The lowest price of a product in a currency as the catalog prices it, in
+-- the currency of the product a variant without an override costs the
+-- product price, in another one a variant takes its price list entry, then
+-- the entry of the product unless it has an override to convert. Null when
+-- nothing is priced in the currency.
+CREATE OR REPLACE FUNCTION wishlist_min_price(pid VARCHAR, cur CHAR(3))
+RETURNS BIGINT AS $$
+DECLARE
+    p RECORD;
+    base BIGINT;
+BEGIN
+    SELECT "price", "currency" INTO p FROM "products" WHERE "id" = pid;
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+
+    IF p.currency = cur THEN
+        RETURN (
+            SELECT COALESCE(MIN(COALESCE("v"."price", p.price)), p.price)
+            FROM "product_variants" "v"
+            WHERE "v"."product_id" = pid
+        );
+    END IF;
+
+    SELECT "pp"."price" INTO base
+    FROM "product_prices" "pp"
+    WHERE "pp"."product_id" = pid
+    AND "pp"."variant_id" IS NULL
+    AND "pp"."currency" = cur;
+
+    IF NOT EXISTS (SELECT 1 FROM "product_variants" "v" WHERE "v"."product_id" = pid) THEN
+        RETURN base;
+    END IF;
+    RETURN (
+        SELECT MIN(COALESCE("pp"."price", CASE WHEN "v"."price" IS NULL THEN base END))
+        FROM "product_variants" "v"
+            LEFT JOIN "product_prices" "pp" ON "pp"."variant_id" = "v"."id" AND "pp"."currency" = cur
+        WHERE "v"."product_id" = pid
+    );
+END;
+$$ language 'plpgsql';
+
+-- Compares the lowest prices of a product with the ones last seen, tells
+-- the users wishing for it of each that went down and remembers them. A
+-- product nobody wishes for is not tracked.
+CREATE OR REPLACE FUNCTION wishlist_check_price(pid VARCHAR)
+RETURNS VOID AS $$
+DECLARE
+    c CHAR(3);
+    last_price BIGINT;
+    min_price BIGINT;
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM "wishlists" "w" WHERE "w"."product_id" = pid) THEN
+        DELETE FROM "wishlist_prices" "wp" WHERE "wp"."product_id" = pid;
+        RETURN;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM "products" WHERE "id" = pid) THEN
+        RETURN;
+    END IF;
+
+    DELETE FROM "wishlist_prices" "wp"
+    WHERE "wp"."product_id" = pid
+    AND "wp"."currency" NOT IN (
+        SELECT "p"."currency" FROM "products" "p" WHERE "p"."id" = pid
+        UNION
+        SELECT "pp"."currency" FROM "product_prices" "pp" WHERE "pp"."product_id" = pid
+    );
+
+    FOR c IN
+        SELECT "p"."currency" FROM "products" "p" WHERE "p"."id" = pid
+        UNION
+        SELECT "pp"."currency" FROM "product_prices" "pp" WHERE "pp"."product_id" = pid
+    LOOP
+        min_price := wishlist_min_price(pid, c);
+        SELECT "wp"."price" INTO last_price
+        FROM "wishlist_prices" "wp"
+        WHERE "wp"."product_id" = pid
+        AND "wp"."currency" = c;
+
+        IF min_price IS NULL THEN
+            DELETE FROM "wishlist_prices" "wp" WHERE "wp"."product_id" = pid AND "wp"."currency" = c;
+            CONTINUE;
+        END IF;
+
+        IF min_price < last_price THEN
+            INSERT INTO "wishlist_notifications" ("user_id", "product_id", "kind", "old_price", "new_price", "currency")
+            SELECT "w"."user_id", pid, 'price_drop', last_price, min_price, c
+            FROM "wishlists" "w"
+            WHERE "w"."product_id" = pid;
+        END IF;
+
+        INSERT INTO "wishlist_prices" ("product_id", "currency", "price")
+        VALUES (pid, c, min_price)
+        ON CONFLICT ("product_id", "currency") DO UPDATE SET
+            "price" = EXCLUDED."price";
+    END LOOP;
+END;
+$$ language 'plpgsql';
+
+-- Checks the prices of the product a row of products, product_variants,
+-- product_prices or wishlists belongs to. A new wishlist row sets the
+-- prices a drop is told from, the last one removed stops the tracking.
+CREATE OR REPLACE FUNCTION notify_wishlist_price_drop()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM wishlist_check_price(OLD.product_id);
+    ELSIF TG_TABLE_NAME = 'products' THEN
+        PERFORM wishlist_check_price(NEW.id);
+    ELSE
+        PERFORM wishlist_check_price(NEW.product_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS notify_wishlist_price_drop_products_table ON "products";
+
+CREATE TRIGGER notify_wishlist_price_drop_products_table AFTER INSERT OR UPDATE OF "price", "currency" ON "products" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+CREATE TRIGGER notify_wishlist_price_drop_product_variants_table AFTER INSERT OR UPDATE OF "price" OR DELETE ON "product_variants" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+CREATE TRIGGER notify_wishlist_price_drop_product_prices_table AFTER INSERT OR UPDATE OF "price" OR DELETE ON "product_prices" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+CREATE TRIGGER notify_wishlist_price_drop_wishlists_table AFTER INSERT OR DELETE ON "wishlists" FOR EACH ROW EXECUTE PROCEDURE notify_wishlist_price_drop();
+
+SELECT wishlist_check_price("p"."id")
+FROM "products" "p"
+WHERE EXISTS (SELECT 1 FROM "wishlists" "w" WHERE "w"."product_id" = "p"."id");
+
+COMMIT;
@@ -264,3 +264,53 @@ func newApiKey(cfg config.IJwtConfig) IKawaiiAuth {
 		},
 	}
 }
+
+// cartTokenTTL is how long an anonymous cart can be picked up again
+const cartTokenTTL = 30 * 24 * time.Hour
+
+// cartKey signs cart tokens. It differs from the key of access tokens so a
+// cart token is never taken for one.
+func cartKey(cfg config.IJwtConfig) []byte {
+	return append([]byte("cart:"), cfg.SecretKey()...)
+}
+
+// SignCartToken signs the id of an anonymous cart, whoever holds the
+// token owns the cart
+func SignCartToken(cfg config.IJwtConfig, cartId string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "kawaiishop-api",
+		Subject:   "cart-token",
+		ID:        cartId,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(cartTokenTTL)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	})
+	ss, _ := token.SignedString(cartKey(cfg))
+
+	return ss
+}
+
+// ParseCartToken returns the cart id of a cart token
+func ParseCartToken(cfg config.IJwtConfig, tokenString string) (string, error) {
+	claims := new(jwt.RegisteredClaims)
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("signing method is invalid")
+		}
+
+		return cartKey(cfg), nil
+	})
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return "", fmt.Errorf("cart token format is invalid")
+		} else if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", fmt.Errorf("cart token had expired")
+		} else {
+			return "", fmt.Errorf("parse cart token failed: %v", err)
+		}
+	}
+	if claims.Subject != "cart-token" || claims.ID == "" {
+		return "", fmt.Errorf("cart token is invalid")
+	}
+	return claims.ID, nil
+}
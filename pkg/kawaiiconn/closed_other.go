@@ -0,0 +1,11 @@
+//go:build !unix
+
+package kawaiiconn
+
+import "syscall"
+
+// closed cannot peek at a socket here, a disconnect is only seen when the
+// response is written.
+func closed(raw syscall.RawConn) bool {
+	return false
+}
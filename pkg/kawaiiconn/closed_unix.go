@@ -0,0 +1,36 @@
+//go:build unix
+
+package kawaiiconn
+
+import (
+	"errors"
+	"syscall"
+)
+
+// closed peeks at the socket without taking any byte from it, the server
+// reads them as the next request. A read of nothing is the end of the
+// stream.
+func closed(raw syscall.RawConn) bool {
+	buf := make([]byte, 1)
+
+	var (
+		n    int
+		rerr error
+	)
+	if err := raw.Read(func(fd uintptr) bool {
+		n, _, rerr = syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// Done either way, waiting for data is what the server does
+		return true
+	}); err != nil {
+		return true
+	}
+
+	switch {
+	case errors.Is(rerr, syscall.EAGAIN), errors.Is(rerr, syscall.EWOULDBLOCK), errors.Is(rerr, syscall.EINTR):
+		return false
+	case rerr != nil:
+		return true
+	default:
+		return n == 0
+	}
+}
@@ -0,0 +1,60 @@
+package kawaiiconn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrDisconnected is the cause of a context cancelled by WithDisconnect
+var ErrDisconnected = errors.New("client disconnected")
+
+// WithDisconnect returns a context that is cancelled once the peer of conn
+// closes it, checked every interval. fasthttp only cancels the context of
+// a request when the server shuts down, a request whose client went away
+// would otherwise run to the end.
+func WithDisconnect(parent context.Context, conn net.Conn, interval time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	raw := rawConn(conn)
+	if raw == nil {
+		return ctx, func() { cancel(context.Canceled) }
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if closed(raw) {
+					cancel(ErrDisconnected)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// rawConn is the socket under conn, nil when there is none to look at
+func rawConn(conn net.Conn) syscall.RawConn {
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	return raw
+}
@@ -0,0 +1,65 @@
+package kawaiiconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pair is both ends of a loopback tcp connection
+func pair(t *testing.T) (server, client net.Conn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, err = ln.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+func TestWithDisconnect(t *testing.T) {
+	server, client := pair(t)
+
+	ctx, cancel := WithDisconnect(context.Background(), server, 10*time.Millisecond)
+	defer cancel()
+
+	// A pipelined request is left for the server to read
+	if _, err := client.Write([]byte("G")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+		t.Fatal("context is done while the client is connected")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	buf := make([]byte, 1)
+	if _, err := server.Read(buf); err != nil || buf[0] != 'G' {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+
+	client.Close()
+	select {
+	case <-ctx.Done():
+		if !errors.Is(context.Cause(ctx), ErrDisconnected) {
+			t.Errorf("got cause %v, want ErrDisconnected", context.Cause(ctx))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("context is not done after the client disconnected")
+	}
+}
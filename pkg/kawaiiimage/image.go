@@ -0,0 +1,171 @@
+package kawaiiimage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+type Rendition struct {
+	Name  string
+	Width int
+}
+
+type Options struct {
+	Renditions []*Rendition
+	Quality    int
+	// WebPEncoder is the path of cwebp, empty keeps the format of the
+	// upload for the renditions.
+	WebPEncoder string
+	// Zero means no limit
+	MaxDimension int
+	MaxPixels    int64
+}
+
+type Output struct {
+	Name        string
+	Data        []byte
+	Ext         string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+type Result struct {
+	Original *Output
+	Variants []*Output
+}
+
+type IProcessor interface {
+	Inspect(data []byte, ext string) (*Info, error)
+	InspectReader(r io.Reader, ext string) (*Info, error)
+	Process(ctx context.Context, data []byte, ext string) (*Result, error)
+}
+
+type processor struct {
+	opts *Options
+}
+
+func NewProcessor(opts *Options) IProcessor {
+	return &processor{
+		opts: opts,
+	}
+}
+
+// Process inspects an uploaded png or jpeg, strips its metadata and makes
+// its renditions. Renditions are never scaled up, a small upload keeps its
+// size.
+func (p *processor) Process(ctx context.Context, data []byte, ext string) (*Result, error) {
+	info, err := p.Inspect(data, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode image failed: %v", ErrUnsupported, err)
+	}
+	if format != info.Format {
+		return nil, fmt.Errorf("%w: .%s file contains %s", ErrMismatch, ext, format)
+	}
+
+	original := &Output{
+		Ext:         ext,
+		ContentType: "image/" + format,
+	}
+
+	switch format {
+	case "jpeg":
+		// Stripping EXIF loses the orientation, bake it into the pixels
+		// before it is gone.
+		if o := orientation(data); o > 1 {
+			img = orient(img, o)
+			if original.Data, err = p.encode(img, format); err != nil {
+				return nil, err
+			}
+		} else if original.Data, err = stripJpeg(data); err != nil {
+			return nil, err
+		}
+	case "png":
+		if original.Data, err = stripPng(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("image format %s is not supported", format)
+	}
+	original.Width, original.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	result := &Result{
+		Original: original,
+		Variants: make([]*Output, 0, len(p.opts.Renditions)),
+	}
+	for _, r := range p.opts.Renditions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		resized := resize(img, r.Width)
+		out := &Output{
+			Name:        r.Name,
+			Ext:         ext,
+			ContentType: original.ContentType,
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+		}
+
+		if p.opts.WebPEncoder != "" {
+			out.Ext, out.ContentType = "webp", "image/webp"
+			out.Data, err = encodeWebp(ctx, p.opts.WebPEncoder, resized, p.opts.Quality)
+		} else {
+			out.Data, err = p.encode(resized, format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode %s rendition failed: %v", r.Name, err)
+		}
+		result.Variants = append(result.Variants, out)
+	}
+	return result, nil
+}
+
+func (p *processor) encode(img image.Image, format string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(buf, img)
+	default:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: p.opts.Quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode %s failed: %v", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize fits img into a width x width box keeping the aspect ratio
+func resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= width && h <= width {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*width/w)
+		w = width
+	} else {
+		w = max(1, w*width/h)
+		h = width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,100 @@
+package kawaiiimage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnsupported = errors.New("file is not a supported image")
+	ErrMismatch    = errors.New("file extension does not match its content")
+	ErrTooLarge    = errors.New("image dimensions are too large")
+)
+
+type Info struct {
+	Format string
+	Width  int
+	Height int
+}
+
+// formats maps the accepted extensions to the format found by sniffing
+var formats = map[string]string{
+	"png":  "png",
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+}
+
+// sniff looks at the magic bytes only, the extension and the content type
+// sent by the client are not trusted.
+func sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return "png"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	}
+	return ""
+}
+
+// Inspect checks that data really is an image of the format named by ext
+// and reads its dimensions from the header, before anything is decoded.
+// A few bytes of png can claim a 100000 x 100000 image, decoding it would
+// allocate tens of gigabytes.
+func (p *processor) Inspect(data []byte, ext string) (*Info, error) {
+	return p.InspectReader(bytes.NewReader(data), ext)
+}
+
+// InspectReader is Inspect on a stream, only the header of the image is
+// read from r.
+func (p *processor) InspectReader(r io.Reader, ext string) (*Info, error) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+
+	expected, ok := formats[ext]
+	if !ok {
+		return nil, fmt.Errorf("%w: extension %s is not accepted", ErrUnsupported, ext)
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read image failed: %v", err)
+	}
+
+	format := sniff(head)
+	if format == "" {
+		return nil, fmt.Errorf("%w: content is %s", ErrUnsupported, http.DetectContentType(head))
+	}
+	if format != expected {
+		return nil, fmt.Errorf("%w: .%s file contains %s", ErrMismatch, ext, format)
+	}
+
+	cfg, decoded, err := image.DecodeConfig(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header is invalid: %v", ErrUnsupported, err)
+	}
+	if decoded != format {
+		return nil, fmt.Errorf("%w: .%s file contains %s", ErrMismatch, ext, decoded)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%w: image is empty", ErrUnsupported)
+	}
+	if p.opts.MaxDimension > 0 && (cfg.Width > p.opts.MaxDimension || cfg.Height > p.opts.MaxDimension) {
+		return nil, fmt.Errorf("%w: %dx%d, each side must be at most %d pixels", ErrTooLarge, cfg.Width, cfg.Height, p.opts.MaxDimension)
+	}
+	if p.opts.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > p.opts.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d, must be at most %d pixels", ErrTooLarge, cfg.Width, cfg.Height, p.opts.MaxPixels)
+	}
+
+	return &Info{
+		Format: format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}, nil
+}
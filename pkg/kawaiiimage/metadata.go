@@ -0,0 +1,190 @@
+package kawaiiimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+var errCorrupted = errors.New("image is corrupted")
+
+// stripJpeg drops the APP1 (EXIF, XMP) and APP13 (IPTC) segments without
+// decoding the image, the pixels stay byte for byte the same. The ICC
+// profile in APP2 is kept, colors look wrong without it.
+func stripJpeg(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errCorrupted
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errCorrupted
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+
+		// Start of scan, the rest is entropy coded data
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+
+		// Markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errCorrupted
+		}
+		if marker != 0xE1 && marker != 0xED {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return nil, errCorrupted
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPng drops the exif, text and time chunks
+func stripPng(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errCorrupted
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errCorrupted
+		}
+
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// orientation reads the EXIF orientation of a jpeg, 1 is upright and is
+// also returned when there is no EXIF.
+func orientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+
+		seg := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient turns img upright for an EXIF orientation from 2 to 8
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// Orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
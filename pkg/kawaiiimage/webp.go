@@ -0,0 +1,44 @@
+package kawaiiimage
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// encodeWebp runs cwebp, go only has a webp decoder. The image goes through
+// a lossless png so the quality setting is applied once.
+func encodeWebp(ctx context.Context, encoder string, img image.Image, quality int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "kawaiiimage-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out.webp")
+
+	file, err := os.Create(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, encoder, "-quiet", "-metadata", "none", "-q", strconv.Itoa(quality), in, "-o", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cwebp failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(out)
+}
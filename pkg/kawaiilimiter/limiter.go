@@ -0,0 +1,107 @@
+package kawaiilimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Bucket struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// IStore takes one token from the bucket stored under key. Stores shared
+// between instances (redis, database, ...) must do it atomically.
+type IStore interface {
+	Take(key string, b *Bucket) (*Result, error)
+}
+
+type bucketState struct {
+	tokens float64
+	rate   float64
+	burst  int
+	last   time.Time
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucketState
+	lastSweep time.Time
+}
+
+func NewMemoryStore() IStore {
+	return &memoryStore{
+		buckets:   make(map[string]*bucketState),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryStore) Take(key string, b *Bucket) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	// tokens per second
+	rate := float64(b.Limit) / b.Period.Seconds()
+
+	state, ok := s.buckets[key]
+	if !ok {
+		state = &bucketState{
+			tokens: float64(b.Burst),
+			last:   now,
+		}
+		s.buckets[key] = state
+	}
+
+	state.rate = rate
+	state.burst = b.Burst
+	state.tokens = math.Min(float64(b.Burst), state.tokens+now.Sub(state.last).Seconds()*rate)
+	state.last = now
+
+	res := &Result{
+		Limit: b.Burst,
+	}
+
+	if state.tokens >= 1 {
+		state.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - state.tokens) / rate)
+	}
+
+	res.Remaining = int(math.Floor(state.tokens))
+	res.ResetAfter = secondsToDuration((float64(b.Burst) - state.tokens) / rate)
+
+	return res, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again,
+// a missing bucket starts full so nothing is lost.
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for k, v := range s.buckets {
+		if v.tokens+now.Sub(v.last).Seconds()*v.rate >= float64(v.burst) {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
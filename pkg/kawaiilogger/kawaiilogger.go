@@ -33,7 +33,7 @@ type kawaiiLogger struct {
 func InitKawaiiLogger(c *fiber.Ctx, res any) IKawaiiLogger {
 	log := &kawaiiLogger{
 		Time:       time.Now().Local().Format("2006-01-02 15:04:05"),
-		Ip:         c.IP(),
+		Ip:         utils.RealIP(c),
 		Method:     c.Method(),
 		Path:       c.Path(),
 		StatusCode: c.Response().StatusCode(),
@@ -0,0 +1,192 @@
+package kawaiimoney
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("currency is not supported")
+	ErrNoRate          = errors.New("no exchange rate")
+	ErrInvalidAmount   = errors.New("amount is invalid")
+)
+
+// Currency is an ISO 4217 currency, amounts of it are kept as integers of
+// its minor unit, satang for THB and cents for USD.
+type Currency struct {
+	Code     string `json:"code"`
+	Exponent int    `json:"exponent"`
+	Symbol   string `json:"symbol"`
+}
+
+var currencies = map[string]*Currency{
+	"THB": {Code: "THB", Exponent: 2, Symbol: "฿"},
+	"USD": {Code: "USD", Exponent: 2, Symbol: "$"},
+	"EUR": {Code: "EUR", Exponent: 2, Symbol: "€"},
+	"GBP": {Code: "GBP", Exponent: 2, Symbol: "£"},
+	"SGD": {Code: "SGD", Exponent: 2, Symbol: "S$"},
+	"CNY": {Code: "CNY", Exponent: 2, Symbol: "CN¥"},
+	"JPY": {Code: "JPY", Exponent: 0, Symbol: "¥"},
+	"KRW": {Code: "KRW", Exponent: 0, Symbol: "₩"},
+}
+
+// Lookup returns the currency of an ISO 4217 code in any case
+func Lookup(code string) (*Currency, error) {
+	c, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// Currencies lists the supported currencies
+func Currencies() []*Currency {
+	res := make([]*Currency, 0, len(currencies))
+	for _, c := range currencies {
+		res = append(res, c)
+	}
+	return res
+}
+
+// Money is an amount in minor units, Decimal and Display are filled by
+// New for responses.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Decimal  string `json:"decimal,omitempty"`
+	Display  string `json:"display,omitempty"`
+}
+
+// New returns amount minor units of c with its decimal and display forms
+func New(amount int64, c *Currency) *Money {
+	return &Money{
+		Amount:   amount,
+		Currency: c.Code,
+		Decimal:  Decimal(amount, c),
+		Display:  Format(amount, c),
+	}
+}
+
+// Decimal writes amount in major units, 123450 THB is 1234.50
+func Decimal(amount int64, c *Currency) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	if c.Exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= c.Exponent {
+		digits = strings.Repeat("0", c.Exponent-len(digits)+1) + digits
+	}
+	point := len(digits) - c.Exponent
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Format writes amount for people, 123450 THB is ฿1,234.50
+func Format(amount int64, c *Currency) string {
+	d := Decimal(amount, c)
+	sign := ""
+	if strings.HasPrefix(d, "-") {
+		sign, d = "-", d[1:]
+	}
+
+	major, minor, _ := strings.Cut(d, ".")
+	grouped := make([]byte, 0, len(major)+len(major)/3)
+	for i := range major {
+		if i > 0 && (len(major)-i)%3 == 0 {
+			grouped = append(grouped, ',')
+		}
+		grouped = append(grouped, major[i])
+	}
+
+	s := sign + c.Symbol + string(grouped)
+	if minor != "" {
+		s += "." + minor
+	}
+	return s
+}
+
+// Parse reads a decimal amount in major units, digits past the minor unit
+// are rounded half away from zero.
+func Parse(s string, c *Currency) (int64, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+	}
+	return round(r.Mul(r, scale(c.Exponent))), nil
+}
+
+// ParseRate reads an exchange rate, a positive decimal
+func ParseRate(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: rate %s", ErrInvalidAmount, s)
+	}
+	return r, nil
+}
+
+// FormatRate writes a rate with up to 12 decimals and no trailing zeros,
+// the scale exchange rates are stored with
+func FormatRate(r *big.Rat) string {
+	s := r.FloatString(12)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Convert turns amount minor units of from into minor units of to, one
+// from is rate to. The result is rounded half away from zero.
+func Convert(amount int64, from, to *Currency, rate *big.Rat) int64 {
+	r := new(big.Rat).SetInt64(amount)
+	r.Mul(r, UnitRate(from, to, rate))
+	return round(r)
+}
+
+// UnitRate is how many minor units of to one minor unit of from is worth,
+// one from is rate to. Unlike Convert it is not rounded, for comparisons
+// made by the database.
+func UnitRate(from, to *Currency, rate *big.Rat) *big.Rat {
+	r := new(big.Rat).Set(rate)
+	r.Mul(r, scale(to.Exponent))
+	return r.Quo(r, scale(from.Exponent))
+}
+
+func scale(exponent int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+}
+
+func round(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if m.Lsh(m, 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// Rates are exchange rates by base and quote currency, one base is rate
+// quote.
+type Rates map[[2]string]*big.Rat
+
+// Rate finds the rate from base to quote, the inverse of quote to base
+// when only that one is known.
+func (r Rates) Rate(base, quote string) (*big.Rat, error) {
+	if base == quote {
+		return big.NewRat(1, 1), nil
+	}
+	if rate, ok := r[[2]string{base, quote}]; ok {
+		return rate, nil
+	}
+	if rate, ok := r[[2]string{quote, base}]; ok {
+		return new(big.Rat).Inv(rate), nil
+	}
+	return nil, fmt.Errorf("%w from %s to %s", ErrNoRate, base, quote)
+}
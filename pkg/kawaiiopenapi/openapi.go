@@ -0,0 +1,515 @@
+package kawaiiopenapi
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/k0msak007/kawaii-shop/pkg/kawaiivalidator"
+)
+
+type Auth string
+
+const (
+	None   Auth = ""
+	ApiKey Auth = "apikey"
+	Bearer Auth = "bearer"
+	Admin  Auth = "admin"
+	// Cart is a Bearer JWT, a cart token or neither
+	Cart Auth = "cart"
+	// ApiKeyUser is an api key, with or without a Bearer JWT
+	ApiKeyUser Auth = "apikey_user"
+)
+
+// Operation documents one route. Query, Body, Form and Response take a
+// zero value of the struct, e.g. &users.UserRegisterReq{}.
+type Operation struct {
+	Summary   string
+	Tags      []string
+	Auth      Auth
+	Query     any
+	Body      any
+	Form      any
+	Response  any
+	Paginated bool
+	Status    int
+}
+
+type IRegistry interface {
+	Add(method, path string, op *Operation)
+	// Check reads the validate tags of the Query, Body and Form of every
+	// operation
+	Check() error
+	Build(title, version string, routes []fiber.Route) map[string]any
+}
+
+type registry struct {
+	mu  sync.Mutex
+	ops map[string]*Operation
+}
+
+func NewRegistry() IRegistry {
+	return &registry{
+		ops: make(map[string]*Operation),
+	}
+}
+
+func (r *registry) Add(method, path string, op *Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ops[method+" "+path] = op
+}
+
+func (r *registry) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, op := range r.ops {
+		for _, v := range []any{op.Query, op.Body, op.Form} {
+			if v == nil {
+				continue
+			}
+			if err := kawaiivalidator.Check(v); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	pathParamRe = regexp.MustCompile(`:([A-Za-z0-9_]+)\??`)
+	versionRe   = regexp.MustCompile(`^v\d+$`)
+)
+
+// Build describes every route registered on the app, routes without an
+// Operation are listed with their path parameters only.
+func (r *registry) Build(title, version string, routes []fiber.Route) map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &schemas{
+		components: make(map[string]any),
+	}
+	s.components["ErrorResponse"] = s.objectSchema(reflect.TypeOf(errorResponse{}))
+
+	paths := make(map[string]map[string]any)
+	for _, route := range routes {
+		if route.Method == fiber.MethodHead || route.Method == fiber.MethodConnect || route.Method == fiber.MethodTrace {
+			continue
+		}
+		if strings.Contains(route.Path, "*") {
+			continue
+		}
+
+		path := pathParamRe.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+
+		op := r.ops[route.Method+" "+route.Path]
+		if op == nil {
+			op = new(Operation)
+		}
+		paths[path][strings.ToLower(route.Method)] = s.operation(route, op)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-Api-Key",
+				},
+				"bearerAuth": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"cartToken": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-Cart-Token",
+				},
+			},
+		},
+	}
+}
+
+// errorResponse mirrors entities.ErrorResponse, entities cannot be imported
+// from pkg.
+type errorResponse struct {
+	TraceId string                        `json:"trace_id"`
+	Msg     string                        `json:"message"`
+	Errors  []*kawaiivalidator.FieldError `json:"errors,omitempty"`
+}
+
+type schemas struct {
+	components map[string]any
+}
+
+func (s *schemas) operation(route fiber.Route, op *Operation) map[string]any {
+	o := map[string]any{
+		"operationId": operationId(route),
+	}
+	if op.Summary != "" {
+		o["summary"] = op.Summary
+	}
+	if len(op.Tags) > 0 {
+		o["tags"] = op.Tags
+	} else if tag := firstSegment(route.Path); tag != "" {
+		o["tags"] = []string{tag}
+	}
+
+	params := make([]any, 0)
+	for _, name := range route.Params {
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	if op.Query != nil {
+		params = append(params, s.queryParams(reflect.TypeOf(op.Query))...)
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	switch {
+	case op.Form != nil:
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				fiber.MIMEMultipartForm: map[string]any{
+					"schema": s.formSchema(reflect.TypeOf(op.Form)),
+				},
+			},
+		}
+	case op.Body != nil:
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				fiber.MIMEApplicationJSON: map[string]any{
+					"schema": s.schemaOf(reflect.TypeOf(op.Body)),
+				},
+			},
+		}
+	}
+
+	switch op.Auth {
+	case ApiKey:
+		o["security"] = []any{map[string]any{"apiKey": []string{}}}
+	case ApiKeyUser:
+		o["security"] = []any{
+			map[string]any{"apiKey": []string{}, "bearerAuth": []string{}},
+			map[string]any{"apiKey": []string{}},
+		}
+		o["description"] = "A Bearer JWT is optional, it personalizes the response to the signed in user."
+	case Bearer:
+		o["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+	case Admin:
+		o["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+		o["x-required-role"] = "admin"
+		o["description"] = "Requires a Bearer JWT of a user with the admin role."
+	case Cart:
+		o["security"] = []any{
+			map[string]any{"bearerAuth": []string{}},
+			map[string]any{"cartToken": []string{}},
+			map[string]any{},
+		}
+		o["description"] = "The cart of the signed in user, else the anonymous cart of the X-Cart-Token header."
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = fiber.StatusOK
+	}
+
+	success := map[string]any{
+		"description": http.StatusText(status),
+	}
+	if op.Response != nil {
+		schema := s.schemaOf(reflect.TypeOf(op.Response))
+		if op.Paginated {
+			schema = s.paginated(schema)
+		}
+		success["content"] = map[string]any{
+			fiber.MIMEApplicationJSON: map[string]any{"schema": schema},
+		}
+	}
+
+	errorRes := func(code int) map[string]any {
+		return map[string]any{
+			"description": http.StatusText(code),
+			"content": map[string]any{
+				fiber.MIMEApplicationJSON: map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/ErrorResponse"},
+				},
+			},
+		}
+	}
+
+	responses := map[string]any{
+		strconv.Itoa(status): success,
+		"400":                errorRes(fiber.StatusBadRequest),
+		"500":                errorRes(fiber.StatusInternalServerError),
+	}
+	if op.Auth != None {
+		responses["401"] = errorRes(fiber.StatusUnauthorized)
+	}
+	if op.Body != nil || op.Query != nil {
+		responses["422"] = errorRes(fiber.StatusUnprocessableEntity)
+	}
+	o["responses"] = responses
+
+	return o
+}
+
+func (s *schemas) paginated(data map[string]any) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"data": map[string]any{
+				"type":  "array",
+				"items": data,
+			},
+			"page":       map[string]any{"type": "integer"},
+			"limit":      map[string]any{"type": "integer"},
+			"total_page": map[string]any{"type": "integer"},
+			"total_item": map[string]any{"type": "integer"},
+		},
+	}
+}
+
+func (s *schemas) queryParams(t reflect.Type) []any {
+	params := make([]any, 0)
+	for _, f := range fields(t, "query") {
+		_, required := f.rules["required"]
+		params = append(params, map[string]any{
+			"name":     f.name,
+			"in":       "query",
+			"required": required,
+			"schema":   withRules(s.schemaOf(f.typ), f.rules),
+		})
+	}
+	return params
+}
+
+func (s *schemas) formSchema(t reflect.Type) map[string]any {
+	return s.fieldsSchema(fields(t, "form"))
+}
+
+var fileHeaderType = reflect.TypeOf(multipart.FileHeader{})
+
+// schemaOf turns a go type into a json schema, named structs become
+// components referenced by $ref.
+func (s *schemas) schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == fileHeaderType {
+			return map[string]any{"type": "string", "format": "binary"}
+		}
+
+		name := schemaName(t)
+		if name == "" {
+			return s.objectSchema(t)
+		}
+		if _, ok := s.components[name]; !ok {
+			// Reserve the name first, structs may refer to themselves
+			s.components[name] = map[string]any{}
+			s.components[name] = s.objectSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (s *schemas) objectSchema(t reflect.Type) map[string]any {
+	return s.fieldsSchema(fields(t, "json"))
+}
+
+func (s *schemas) fieldsSchema(fs []*field) map[string]any {
+	props := make(map[string]any)
+	required := make([]string, 0)
+	for _, f := range fs {
+		props[f.name] = withRules(s.schemaOf(f.typ), f.rules)
+		if _, ok := f.rules["required"]; ok {
+			required = append(required, f.name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withRules describes the validate tag of a field, a $ref cannot have
+// siblings so referenced schemas are left as they are.
+func withRules(schema map[string]any, rules map[string]string) map[string]any {
+	if len(rules) == 0 || schema["$ref"] != nil {
+		return schema
+	}
+
+	out := make(map[string]any, len(schema))
+	for k, v := range schema {
+		out[k] = v
+	}
+
+	bound := func(str, arr, num string, param string) {
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+		switch out["type"] {
+		case "string":
+			out[str] = int(n)
+		case "array":
+			out[arr] = int(n)
+		case "integer", "number":
+			out[num] = n
+		}
+	}
+
+	for rule, param := range rules {
+		switch rule {
+		case "min":
+			bound("minLength", "minItems", "minimum", param)
+		case "max":
+			bound("maxLength", "maxItems", "maximum", param)
+		case "email":
+			out["format"] = "email"
+		case "uuid":
+			out["format"] = "uuid"
+		case "enum":
+			out["enum"] = strings.Split(param, "|")
+		case "thpostcode":
+			out["pattern"] = kawaiivalidator.ThaiPostcodePattern
+		}
+	}
+	return out
+}
+
+type field struct {
+	name  string
+	typ   reflect.Type
+	rules map[string]string
+}
+
+// fields lists the fields named by tag, embedded structs are flattened like
+// encoding/json does.
+func fields(t reflect.Type, tag string) []*field {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	result := make([]*field, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Tag.Get(tag) == "" {
+			result = append(result, fields(f.Type, tag)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		result = append(result, &field{
+			name:  name,
+			typ:   f.Type,
+			rules: kawaiivalidator.Rules(f),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+func schemaName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	if pkg == "" || strings.EqualFold(pkg, t.Name()) {
+		return t.Name()
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+func operationId(route fiber.Route) string {
+	parts := []string{strings.ToLower(route.Method)}
+	for _, seg := range strings.Split(route.Path, "/") {
+		seg = strings.TrimPrefix(strings.TrimSuffix(seg, "?"), ":")
+		if seg == "" {
+			continue
+		}
+		for _, w := range strings.FieldsFunc(seg, func(r rune) bool { return r == '_' || r == '-' }) {
+			parts = append(parts, strings.ToUpper(w[:1])+w[1:])
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// firstSegment skips the version, /v1/users/signup is tagged users.
+func firstSegment(path string) string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) > 1 && versionRe.MatchString(segs[0]) {
+		return segs[1]
+	}
+	return segs[0]
+}
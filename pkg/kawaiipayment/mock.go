@@ -0,0 +1,217 @@
+package kawaiipayment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockSignatureHeader carries "t=<unix seconds>,v1=<hex hmac>" on mock
+// webhooks
+const MockSignatureHeader = "X-Mock-Signature"
+
+// MockFailToken is a card token the mock provider declines
+const MockFailToken = "tok_mock_fail"
+
+type mockIntent struct {
+	*Intent
+	captured int64
+	refunded int64
+}
+
+// mockEvent is the webhook body of the mock provider
+type mockEvent struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	IntentId string `json:"intent_id"`
+	Status   Status `json:"status"`
+	Amount   int64  `json:"amount"`
+	RefundId string `json:"refund_id,omitempty"`
+}
+
+type mockProvider struct {
+	secret    []byte
+	tolerance time.Duration
+	mu        sync.Mutex
+	intents   map[string]*mockIntent
+}
+
+// IMockProvider is the mock provider, Simulate plays the payer for local
+// development
+type IMockProvider interface {
+	IProvider
+	// Simulate moves an intent to status as the payer would and returns
+	// the signed webhook the provider sends about it
+	Simulate(intentId string, status Status) (body []byte, signature string, err error)
+}
+
+// NewMockProvider keeps intents in memory and signs its webhooks with
+// secret, nothing leaves the process. Intents are gone on restart.
+func NewMockProvider(secret []byte, tolerance time.Duration) IMockProvider {
+	return &mockProvider{
+		secret:    secret,
+		tolerance: tolerance,
+		intents:   make(map[string]*mockIntent),
+	}
+}
+
+func (p *mockProvider) Name() string { return "mock" }
+
+func mockId(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+func (p *mockProvider) CreateIntent(ctx context.Context, req *IntentReq) (*Intent, error) {
+	intent := &Intent{
+		Id:       mockId("mock_pi_"),
+		Status:   StatusPending,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Method:   req.Method,
+	}
+
+	captured := int64(0)
+	switch req.Method {
+	case MethodPromptPay:
+		expires := time.Now().Add(15 * time.Minute)
+		intent.ExpiresAt = &expires
+		intent.QrCodeUrl = "https://mock.invalid/promptpay/" + intent.Id + ".png"
+	case MethodCard:
+		switch {
+		case req.Token == MockFailToken:
+			intent.Status = StatusFailed
+		case req.Capture:
+			intent.Status = StatusSucceeded
+			captured = req.Amount
+		default:
+			intent.Status = StatusAuthorized
+		}
+	default:
+		return nil, fmt.Errorf("%w: method %s is not supported", ErrProvider, req.Method)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.intents[intent.Id] = &mockIntent{Intent: intent, captured: captured}
+
+	res := *intent
+	return &res, nil
+}
+
+func (p *mockProvider) Capture(ctx context.Context, intentId string, amount int64) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i, ok := p.intents[intentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if i.Status != StatusAuthorized {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidState, i.Status)
+	}
+	if amount == 0 {
+		amount = i.Amount
+	}
+	if amount < 0 || amount > i.Amount {
+		return nil, fmt.Errorf("%w: capture %d of %d", ErrInvalidState, amount, i.Amount)
+	}
+
+	i.Status = StatusSucceeded
+	i.captured = amount
+	res := *i.Intent
+	res.Amount = amount
+	return &res, nil
+}
+
+func (p *mockProvider) Refund(ctx context.Context, intentId string, amount int64) (*Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i, ok := p.intents[intentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if i.Status != StatusSucceeded {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidState, i.Status)
+	}
+	if amount <= 0 || amount > i.captured-i.refunded {
+		return nil, fmt.Errorf("%w: refund %d of %d left", ErrInvalidState, amount, i.captured-i.refunded)
+	}
+
+	i.refunded += amount
+	return &Refund{
+		Id:       mockId("mock_re_"),
+		IntentId: intentId,
+		Amount:   amount,
+	}, nil
+}
+
+func (p *mockProvider) Simulate(intentId string, status Status) ([]byte, string, error) {
+	p.mu.Lock()
+	i, ok := p.intents[intentId]
+	if !ok {
+		p.mu.Unlock()
+		return nil, "", ErrNotFound
+	}
+	if i.Status != StatusPending {
+		p.mu.Unlock()
+		return nil, "", fmt.Errorf("%w: %s", ErrInvalidState, i.Status)
+	}
+	i.Status = status
+	if status == StatusSucceeded {
+		i.captured = i.Amount
+	}
+	event := &mockEvent{
+		Id:       mockId("mock_evt_"),
+		Type:     EventIntent,
+		IntentId: i.Id,
+		Status:   status,
+		Amount:   i.Amount,
+	}
+	p.mu.Unlock()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return body, "t=" + timestamp + ",v1=" + sign(p.secret, timestamp, body), nil
+}
+
+func (p *mockProvider) VerifyWebhook(body []byte, header func(key string) string) (*Event, error) {
+	timestamp := ""
+	signatures := make([]string, 0, 1)
+	for _, part := range strings.Split(header(MockSignatureHeader), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	if err := verify(p.secret, timestamp, signatures, body, p.tolerance, time.Now()); err != nil {
+		return nil, err
+	}
+
+	e := new(mockEvent)
+	if err := json.Unmarshal(body, e); err != nil || e.Id == "" {
+		return nil, fmt.Errorf("%w: event is invalid", ErrProvider)
+	}
+	return &Event{
+		Id:       e.Id,
+		Type:     e.Type,
+		IntentId: e.IntentId,
+		Status:   e.Status,
+		Amount:   e.Amount,
+		RefundId: e.RefundId,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package kawaiipayment
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := sign(key, timestamp, body)
+
+	tests := []struct {
+		name       string
+		key        []byte
+		timestamp  string
+		signatures []string
+		body       []byte
+		ok         bool
+	}{
+		{"valid", key, timestamp, []string{signature}, body, true},
+		{"one of several", key, timestamp, []string{"bad", signature}, body, true},
+		{"other key", []byte("other"), timestamp, []string{signature}, body, false},
+		{"other body", key, timestamp, []string{signature}, []byte(`{"id":"evt_2"}`), false},
+		{"no signature", key, timestamp, nil, body, false},
+		{"bad timestamp", key, "soon", []string{signature}, body, false},
+		{"too old", key, strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), []string{signature}, body, false},
+		{"too new", key, strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10), []string{signature}, body, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.key, tt.timestamp, tt.signatures, tt.body, 5*time.Minute, now)
+			if tt.ok && err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrSignature) {
+				t.Errorf("got %v, want ErrSignature", err)
+			}
+		})
+	}
+}
+
+func TestMockWebhook(t *testing.T) {
+	p := NewMockProvider([]byte("secret"), 5*time.Minute)
+
+	intent, err := p.CreateIntent(context.Background(), &IntentReq{
+		Amount:   15000,
+		Currency: "THB",
+		Method:   MethodPromptPay,
+	})
+	if err != nil {
+		t.Fatalf("create intent failed: %v", err)
+	}
+
+	body, signature, err := p.Simulate(intent.Id, StatusSucceeded)
+	if err != nil {
+		t.Fatalf("simulate failed: %v", err)
+	}
+	header := func(value string) func(key string) string {
+		return func(key string) string {
+			if key == MockSignatureHeader {
+				return value
+			}
+			return ""
+		}
+	}
+
+	e, err := p.VerifyWebhook(body, header(signature))
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if e.Type != EventIntent || e.IntentId != intent.Id || e.Status != StatusSucceeded || e.Amount != 15000 {
+		t.Errorf("got event %+v", e)
+	}
+
+	if _, err := p.VerifyWebhook(append(body, ' '), header(signature)); !errors.Is(err, ErrSignature) {
+		t.Errorf("tampered body: got %v, want ErrSignature", err)
+	}
+	if _, err := p.VerifyWebhook(body, header("")); !errors.Is(err, ErrSignature) {
+		t.Errorf("missing header: got %v, want ErrSignature", err)
+	}
+	other := NewMockProvider([]byte("other"), 5*time.Minute)
+	if _, err := other.VerifyWebhook(body, header(signature)); !errors.Is(err, ErrSignature) {
+		t.Errorf("other secret: got %v, want ErrSignature", err)
+	}
+
+	if _, _, err := p.Simulate(intent.Id, StatusFailed); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("simulate twice: got %v, want ErrInvalidState", err)
+	}
+}
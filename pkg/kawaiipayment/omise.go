@@ -0,0 +1,254 @@
+package kawaiipayment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OmiseSignatureHeader          = "Omise-Signature"
+	OmiseSignatureTimestampHeader = "Omise-Signature-Timestamp"
+)
+
+type omiseProvider struct {
+	apiUrl        string
+	secretKey     string
+	webhookSecret []byte
+	tolerance     time.Duration
+	client        *http.Client
+}
+
+// NewOmiseProvider takes payments through the Omise charges api, PromptPay
+// as a QR source and cards by the token of Omise.js. webhookSecret is the
+// base64 secret of the dashboard.
+func NewOmiseProvider(apiUrl, secretKey, webhookSecret string, tolerance time.Duration) (IProvider, error) {
+	secret, err := base64.StdEncoding.DecodeString(webhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("decode omise webhook secret failed: %v", err)
+	}
+	return &omiseProvider{
+		apiUrl:        strings.TrimSuffix(apiUrl, "/"),
+		secretKey:     secretKey,
+		webhookSecret: secret,
+		tolerance:     tolerance,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *omiseProvider) Name() string { return "omise" }
+
+type omiseCharge struct {
+	Object     string `json:"object"`
+	Id         string `json:"id"`
+	Amount     int64  `json:"amount"`
+	Currency   string `json:"currency"`
+	Status     string `json:"status"`
+	Authorized bool   `json:"authorized"`
+	Paid       bool   `json:"paid"`
+	ExpiresAt  string `json:"expires_at"`
+	Source     *struct {
+		Type          string `json:"type"`
+		ScannableCode *struct {
+			Image *struct {
+				DownloadUri string `json:"download_uri"`
+			} `json:"image"`
+		} `json:"scannable_code"`
+	} `json:"source"`
+}
+
+type omiseRefund struct {
+	Object string `json:"object"`
+	Id     string `json:"id"`
+	Amount int64  `json:"amount"`
+	Charge string `json:"charge"`
+}
+
+type omiseError struct {
+	Object  string `json:"object"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type omiseEvent struct {
+	Object string          `json:"object"`
+	Id     string          `json:"id"`
+	Key    string          `json:"key"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// status tells where a charge is, an authorized charge stays pending at
+// Omise until it is captured
+func (c *omiseCharge) status() Status {
+	switch c.Status {
+	case "successful":
+		return StatusSucceeded
+	case "failed", "expired":
+		return StatusFailed
+	case "reversed":
+		return StatusCanceled
+	}
+	if c.Authorized && !c.Paid {
+		return StatusAuthorized
+	}
+	return StatusPending
+}
+
+func (c *omiseCharge) intent() *Intent {
+	i := &Intent{
+		Id:       c.Id,
+		Status:   c.status(),
+		Amount:   c.Amount,
+		Currency: strings.ToUpper(c.Currency),
+		Method:   MethodCard,
+	}
+	if c.Source != nil && c.Source.Type == MethodPromptPay {
+		i.Method = MethodPromptPay
+		if c.Source.ScannableCode != nil && c.Source.ScannableCode.Image != nil {
+			i.QrCodeUrl = c.Source.ScannableCode.Image.DownloadUri
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, c.ExpiresAt); err == nil {
+		i.ExpiresAt = &t
+	}
+	return i
+}
+
+// post sends form to the api and decodes the response into res
+func (p *omiseProvider) post(ctx context.Context, path string, form url.Values, res any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiUrl+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	if resp.StatusCode >= 300 {
+		e := new(omiseError)
+		json.Unmarshal(body, e)
+		switch {
+		case e.Code == "not_found":
+			return ErrNotFound
+		case e.Code == "failed_capture" || e.Code == "failed_refund" || e.Code == "invalid_charge":
+			return fmt.Errorf("%w: %s", ErrInvalidState, e.Message)
+		default:
+			return fmt.Errorf("%w: %d %s %s", ErrProvider, resp.StatusCode, e.Code, e.Message)
+		}
+	}
+	if err := json.Unmarshal(body, res); err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	return nil
+}
+
+func (p *omiseProvider) CreateIntent(ctx context.Context, req *IntentReq) (*Intent, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("metadata[reference]", req.Reference)
+
+	switch req.Method {
+	case MethodPromptPay:
+		form.Set("source[type]", MethodPromptPay)
+	case MethodCard:
+		form.Set("card", req.Token)
+		form.Set("capture", strconv.FormatBool(req.Capture))
+	default:
+		return nil, fmt.Errorf("%w: method %s is not supported", ErrProvider, req.Method)
+	}
+
+	charge := new(omiseCharge)
+	if err := p.post(ctx, "/charges", form, charge); err != nil {
+		return nil, err
+	}
+	return charge.intent(), nil
+}
+
+func (p *omiseProvider) Capture(ctx context.Context, intentId string, amount int64) (*Intent, error) {
+	form := url.Values{}
+	if amount > 0 {
+		form.Set("capture_amount", strconv.FormatInt(amount, 10))
+	}
+
+	charge := new(omiseCharge)
+	if err := p.post(ctx, "/charges/"+url.PathEscape(intentId)+"/capture", form, charge); err != nil {
+		return nil, err
+	}
+	i := charge.intent()
+	if amount > 0 {
+		i.Amount = amount
+	}
+	return i, nil
+}
+
+func (p *omiseProvider) Refund(ctx context.Context, intentId string, amount int64) (*Refund, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(amount, 10))
+
+	refund := new(omiseRefund)
+	if err := p.post(ctx, "/charges/"+url.PathEscape(intentId)+"/refunds", form, refund); err != nil {
+		return nil, err
+	}
+	return &Refund{
+		Id:       refund.Id,
+		IntentId: refund.Charge,
+		Amount:   refund.Amount,
+	}, nil
+}
+
+// VerifyWebhook checks the HMAC-SHA256 of "timestamp.body", the signature
+// header lists several while the secret is being rotated
+func (p *omiseProvider) VerifyWebhook(body []byte, header func(key string) string) (*Event, error) {
+	signatures := strings.Split(header(OmiseSignatureHeader), ",")
+	if err := verify(p.webhookSecret, header(OmiseSignatureTimestampHeader), signatures, body, p.tolerance, time.Now()); err != nil {
+		return nil, err
+	}
+
+	e := new(omiseEvent)
+	if err := json.Unmarshal(body, e); err != nil || e.Id == "" {
+		return nil, fmt.Errorf("%w: event is invalid", ErrProvider)
+	}
+
+	event := &Event{Id: e.Id}
+	switch {
+	case strings.HasPrefix(e.Key, "charge."):
+		charge := new(omiseCharge)
+		if err := json.Unmarshal(e.Data, charge); err != nil {
+			return nil, fmt.Errorf("%w: charge is invalid", ErrProvider)
+		}
+		event.Type = EventIntent
+		event.IntentId = charge.Id
+		event.Status = charge.status()
+		event.Amount = charge.Amount
+	case strings.HasPrefix(e.Key, "refund."):
+		refund := new(omiseRefund)
+		if err := json.Unmarshal(e.Data, refund); err != nil {
+			return nil, fmt.Errorf("%w: refund is invalid", ErrProvider)
+		}
+		event.Type = EventRefund
+		event.IntentId = refund.Charge
+		event.Amount = refund.Amount
+		event.RefundId = refund.Id
+	default:
+		// Other events are acknowledged and ignored
+		event.Type = e.Key
+	}
+	return event, nil
+}
@@ -0,0 +1,123 @@
+package kawaiipayment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignature    = errors.New("webhook signature is invalid or expired")
+	ErrNotFound     = errors.New("payment intent not found")
+	ErrInvalidState = errors.New("payment intent cannot do that in its state")
+	ErrProvider     = errors.New("payment provider failed")
+)
+
+type Status string
+
+const (
+	// StatusPending waits for the payer, e.g. to scan a PromptPay QR
+	StatusPending Status = "pending"
+	// StatusAuthorized holds the amount until it is captured
+	StatusAuthorized Status = "authorized"
+	StatusSucceeded  Status = "succeeded"
+	StatusFailed     Status = "failed"
+	StatusCanceled   Status = "canceled"
+)
+
+const (
+	MethodPromptPay = "promptpay"
+	MethodCard      = "card"
+)
+
+// IntentReq asks for Amount minor units of Currency. Reference is ours,
+// the order id, providers keep it with the intent.
+type IntentReq struct {
+	Amount    int64
+	Currency  string
+	Method    string
+	Reference string
+	// Token is the card token of the provider's client library
+	Token string
+	// Capture takes a card payment at once, else it is only authorized
+	Capture bool
+}
+
+type Intent struct {
+	Id       string
+	Status   Status
+	Amount   int64
+	Currency string
+	Method   string
+	// QrCodeUrl is the PromptPay QR to show the payer
+	QrCodeUrl string
+	ExpiresAt *time.Time
+}
+
+type Refund struct {
+	Id       string
+	IntentId string
+	Amount   int64
+}
+
+const (
+	EventIntent = "intent"
+	EventRefund = "refund"
+)
+
+// Event is a verified webhook. An intent event reports the Status of
+// IntentId, a refund event RefundId of Amount.
+type Event struct {
+	Id       string
+	Type     string
+	IntentId string
+	Status   Status
+	Amount   int64
+	RefundId string
+}
+
+// IProvider takes payments. Amounts are minor units.
+type IProvider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req *IntentReq) (*Intent, error)
+	// Capture takes amount of an authorized intent, all of it when zero
+	Capture(ctx context.Context, intentId string, amount int64) (*Intent, error)
+	Refund(ctx context.Context, intentId string, amount int64) (*Refund, error)
+	// VerifyWebhook checks the signature of a webhook body, header reads
+	// the request headers. It returns ErrSignature when it does not match.
+	VerifyWebhook(body []byte, header func(key string) string) (*Event, error)
+}
+
+// sign is the hex HMAC-SHA256 of "timestamp.body"
+func sign(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks timestamp is within tolerance of now and one of the
+// signatures is the one of the body
+func verify(key []byte, timestamp string, signatures []string, body []byte, tolerance time.Duration, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > tolerance || age < -tolerance {
+		return ErrSignature
+	}
+
+	expected := []byte(sign(key, timestamp, body))
+	for _, s := range signatures {
+		if hmac.Equal(expected, []byte(strings.TrimSpace(s))) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
@@ -0,0 +1,121 @@
+package kawaiiscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrInfected = errors.New("file is infected")
+
+// IScanner checks an upload before it is stored. Scan returns an error
+// wrapping ErrInfected when the file must be rejected, any other error
+// means the file could not be scanned.
+type IScanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+type noopScanner struct{}
+
+// NewNoopScanner accepts every file, it is used when no scanner is
+// configured.
+func NewNoopScanner() IScanner {
+	return &noopScanner{}
+}
+
+func (s *noopScanner) Scan(ctx context.Context, r io.Reader) error {
+	return nil
+}
+
+// chunkSize stays well below the StreamMaxLength default of clamd
+const chunkSize = 64 * 1024
+
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner talks to clamd at addr, tcp://host:port or
+// unix:///path/to/clamd.sock, with the INSTREAM command.
+func NewClamdScanner(addr string, timeout time.Duration) IScanner {
+	network, address, _ := strings.Cut(addr, "://")
+	return &clamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return fmt.Errorf("connect clamd failed: %v", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// z prefixed commands are terminated by a null byte
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("send clamd command failed: %v", err)
+	}
+
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := conn.Write(append(size, buf[:n]...)); werr != nil {
+				// clamd closes the connection once the stream is too long,
+				// its reply says why.
+				return s.reply(conn)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read file failed: %v", err)
+		}
+	}
+
+	// A zero length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("send clamd stream failed: %v", err)
+	}
+	return s.reply(conn)
+}
+
+// reply reads "stream: OK", "stream: <signature> FOUND" or
+// "<reason> ERROR".
+func (s *clamdScanner) reply(conn net.Conn) error {
+	line, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(line) == 0 {
+		return fmt.Errorf("read clamd reply failed: %v", err)
+	}
+	res := strings.TrimSpace(string(bytes.TrimRight(line, "\x00")))
+
+	switch {
+	case strings.HasSuffix(res, " OK"):
+		return nil
+	case strings.HasSuffix(res, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(res, "stream: "), " FOUND")
+		return fmt.Errorf("%w: %s", ErrInfected, signature)
+	default:
+		return fmt.Errorf("clamd failed: %s", res)
+	}
+}
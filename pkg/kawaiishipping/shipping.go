@@ -0,0 +1,138 @@
+package kawaiishipping
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrNotCovered is returned when a rate does not ship a parcel, e.g. it
+	// is heavier than the last weight tier
+	ErrNotCovered = errors.New("rate does not cover the parcel")
+	ErrInvalid    = errors.New("shipping rate is invalid")
+)
+
+const (
+	StrategyFlat       = "flat"
+	StrategyWeightTier = "weight_tier"
+	StrategyFreeOver   = "free_over"
+)
+
+// Parcel is what is shipped, Subtotal in minor units of the currency of
+// the calculator
+type Parcel struct {
+	// Weight in grams
+	Weight   int
+	Subtotal int64
+}
+
+// ICalculator prices the shipping of a parcel in minor units
+type ICalculator interface {
+	Quote(p *Parcel) (int64, error)
+}
+
+// Tier charges Amount up to MaxWeight grams
+type Tier struct {
+	MaxWeight int   `json:"max_weight" validate:"required,min=1"`
+	Amount    int64 `json:"amount" validate:"min=0"`
+}
+
+type flatRate struct {
+	amount int64
+}
+
+// NewFlatRate charges amount whatever the parcel
+func NewFlatRate(amount int64) ICalculator {
+	return &flatRate{amount: amount}
+}
+
+func (r *flatRate) Quote(p *Parcel) (int64, error) {
+	return r.amount, nil
+}
+
+type weightTier struct {
+	tiers []*Tier
+}
+
+// NewWeightTier charges the amount of the lightest tier the parcel fits,
+// a parcel heavier than every tier is not covered
+func NewWeightTier(tiers []*Tier) (ICalculator, error) {
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("%w, weight tiers are empty", ErrInvalid)
+	}
+
+	sorted := make([]*Tier, len(tiers))
+	copy(sorted, tiers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].MaxWeight < sorted[j].MaxWeight
+	})
+	for i, t := range sorted {
+		if t.MaxWeight <= 0 || t.Amount < 0 {
+			return nil, fmt.Errorf("%w, a tier needs a positive max_weight and an amount of at least 0", ErrInvalid)
+		}
+		if i > 0 && t.MaxWeight == sorted[i-1].MaxWeight {
+			return nil, fmt.Errorf("%w, two tiers up to %d g", ErrInvalid, t.MaxWeight)
+		}
+	}
+	return &weightTier{tiers: sorted}, nil
+}
+
+func (r *weightTier) Quote(p *Parcel) (int64, error) {
+	for _, t := range r.tiers {
+		if p.Weight <= t.MaxWeight {
+			return t.Amount, nil
+		}
+	}
+	return 0, fmt.Errorf("%w, %d g is over %d g", ErrNotCovered, p.Weight, r.tiers[len(r.tiers)-1].MaxWeight)
+}
+
+type freeOver struct {
+	threshold int64
+	below     ICalculator
+}
+
+// NewFreeOver ships for free once the subtotal reaches threshold, below
+// it prices like below
+func NewFreeOver(threshold int64, below ICalculator) ICalculator {
+	return &freeOver{
+		threshold: threshold,
+		below:     below,
+	}
+}
+
+func (r *freeOver) Quote(p *Parcel) (int64, error) {
+	if p.Subtotal >= r.threshold {
+		return 0, nil
+	}
+	return r.below.Quote(p)
+}
+
+// New builds the calculator of a strategy. A free_over rate charges by
+// tiers under threshold when it has any, else amount.
+func New(strategy string, amount, threshold int64, tiers []*Tier) (ICalculator, error) {
+	if amount < 0 || threshold < 0 {
+		return nil, fmt.Errorf("%w, amounts must be at least 0", ErrInvalid)
+	}
+
+	switch strategy {
+	case StrategyFlat:
+		return NewFlatRate(amount), nil
+	case StrategyWeightTier:
+		return NewWeightTier(tiers)
+	case StrategyFreeOver:
+		if threshold <= 0 {
+			return nil, fmt.Errorf("%w, free_over needs a threshold", ErrInvalid)
+		}
+		below := NewFlatRate(amount)
+		if len(tiers) > 0 {
+			var err error
+			if below, err = NewWeightTier(tiers); err != nil {
+				return nil, err
+			}
+		}
+		return NewFreeOver(threshold, below), nil
+	default:
+		return nil, fmt.Errorf("%w, unknown strategy %s", ErrInvalid, strategy)
+	}
+}
@@ -0,0 +1,72 @@
+package kawaiishutdown
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+type IShutdown interface {
+	Register(name string, hook func(ctx context.Context) error)
+	Draining() bool
+	Drain()
+	Run(ctx context.Context)
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// shutdown runs the registered hooks in reverse order like defer, whatever
+// was set up first (e.g. the database) is torn down last.
+type shutdown struct {
+	mu       sync.Mutex
+	hooks    []*hook
+	draining atomic.Bool
+}
+
+func NewShutdown() IShutdown {
+	return &shutdown{
+		hooks: make([]*hook, 0),
+	}
+}
+
+func (s *shutdown) Register(name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = append(s.hooks, &hook{
+		name: name,
+		fn:   fn,
+	})
+}
+
+func (s *shutdown) Draining() bool {
+	return s.draining.Load()
+}
+
+// Drain marks the server as not ready so load balancers stop sending
+// new requests.
+func (s *shutdown) Drain() {
+	s.draining.Store(true)
+}
+
+// Run executes every hook even if one fails or ctx is done, each hook
+// decides how long it is willing to wait.
+func (s *shutdown) Run(ctx context.Context) {
+	s.Drain()
+
+	s.mu.Lock()
+	hooks := s.hooks
+	s.hooks = make([]*hook, 0)
+	s.mu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		log.Printf("Shutdown hook %s running", hooks[i].name)
+		if err := hooks[i].fn(ctx); err != nil {
+			log.Printf("Shutdown hook %s failed: %v", hooks[i].name, err)
+		}
+	}
+}
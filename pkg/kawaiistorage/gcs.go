@@ -0,0 +1,134 @@
+package kawaiistorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+type gcsStorage struct {
+	bucket string
+	ttl    time.Duration
+	mu     sync.Mutex
+	client *storage.Client
+}
+
+// NewGcsStorage stores objects in a Google Cloud Storage bucket. The client
+// is created on first use so the server starts without credentials, e.g.
+// to generate the api docs.
+func NewGcsStorage(bucket string, ttl time.Duration) IStorage {
+	return &gcsStorage{
+		bucket: bucket,
+		ttl:    ttl,
+	}
+}
+
+func (s *gcsStorage) getClient(ctx context.Context) (*storage.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewClient: %w", err)
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+func (s *gcsStorage) Upload(ctx context.Context, destination string, r io.Reader, contentType string, private bool) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	o := client.Bucket(s.bucket).Object(destination)
+
+	// Upload an object with storage.Writer.
+	wc := o.NewWriter(ctx)
+	wc.ContentType = contentType
+
+	if _, err := io.Copy(wc, r); err != nil {
+		wc.Close()
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+	// Data can continue to be added to the file until the writer is closed.
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %v", err)
+	}
+
+	if private {
+		return nil
+	}
+	if err := o.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		return fmt.Errorf("ACLHandle.Set: %w", err)
+	}
+	return nil
+}
+
+func (s *gcsStorage) Delete(ctx context.Context, destination string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	o := client.Bucket(s.bucket).Object(destination)
+
+	// Optional: set a generation-match precondition to avoid potential race
+	// conditions and data corruptions. The request to delete the file is aborted
+	// if the object's generation number does not match your precondition.
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotExist, destination)
+		}
+		return fmt.Errorf("object.Attrs: %w", err)
+	}
+	o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+
+	if err := o.Delete(ctx); err != nil {
+		return fmt.Errorf("Object(%q).Delete: %w", destination, err)
+	}
+	return nil
+}
+
+// Url signs private objects with V4 signing, the credentials of the client
+// are used so the service account needs iam.serviceAccounts.signBlob.
+func (s *gcsStorage) Url(ctx context.Context, destination string, private bool) (string, error) {
+	if !private {
+		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, destination), nil
+	}
+
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	url, err := client.Bucket(s.bucket).SignedURL(destination, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(s.ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("sign url of %s failed: %v", destination, err)
+	}
+	return url, nil
+}
+
+func (s *gcsStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
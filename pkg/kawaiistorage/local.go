@@ -0,0 +1,180 @@
+package kawaiistorage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ILocalStorage is served by the app itself, Open is called by the
+// handler behind Url.
+type ILocalStorage interface {
+	IStorage
+	// Open verifies the expires and signature query of a private object and
+	// returns the file to send.
+	Open(destination string, query url.Values) (string, error)
+}
+
+// localStorage keeps public and private objects in separate directories,
+// a public url can never reach a private file.
+type localStorage struct {
+	dir     string
+	baseUrl string
+	key     []byte
+	ttl     time.Duration
+}
+
+func NewLocalStorage(dir, baseUrl string, key []byte, ttl time.Duration) ILocalStorage {
+	return &localStorage{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		key:     key,
+		ttl:     ttl,
+	}
+}
+
+// clean rejects keys escaping the storage directory
+func clean(destination string) (string, error) {
+	cleaned := path.Clean("/" + destination)
+	if cleaned == "/" || cleaned != "/"+strings.TrimPrefix(destination, "/") {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKey, destination)
+	}
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
+
+func (s *localStorage) path(destination string, private bool) string {
+	visibility := "public"
+	if private {
+		visibility = "private"
+	}
+	return filepath.Join(s.dir, visibility, filepath.FromSlash(destination))
+}
+
+func (s *localStorage) Upload(ctx context.Context, destination string, r io.Reader, contentType string, private bool) error {
+	destination, err := clean(destination)
+	if err != nil {
+		return err
+	}
+
+	p := s.path(destination, private)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("create directory failed: %v", err)
+	}
+
+	// Write next to the target and rename, readers never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("create file failed: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write file failed: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write file failed: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("write file failed: %v", err)
+	}
+
+	// The same key may have changed visibility
+	os.Remove(s.path(destination, !private))
+	return nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, destination string) error {
+	destination, err := clean(destination)
+	if err != nil {
+		return err
+	}
+
+	deleted := false
+	for _, private := range []bool{false, true} {
+		err := os.Remove(s.path(destination, private))
+		if err == nil {
+			deleted = true
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete %s failed: %v", destination, err)
+		}
+	}
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrNotExist, destination)
+	}
+	return nil
+}
+
+func (s *localStorage) Url(ctx context.Context, destination string, private bool) (string, error) {
+	destination, err := clean(destination)
+	if err != nil {
+		return "", err
+	}
+
+	u := s.baseUrl + "/" + (&url.URL{Path: destination}).EscapedPath()
+	if !private {
+		return u, nil
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 10)
+	q := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(destination, expires)},
+	}
+	return u + "?" + q.Encode(), nil
+}
+
+func (s *localStorage) sign(destination, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(destination + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *localStorage) Open(destination string, query url.Values) (string, error) {
+	destination, err := clean(destination)
+	if err != nil {
+		return "", err
+	}
+
+	if p := s.path(destination, false); exists(p) {
+		return p, nil
+	}
+
+	expires, signature := query.Get("expires"), query.Get("signature")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return "", ErrSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(destination, expires))) {
+		return "", ErrSignature
+	}
+
+	p := s.path(destination, true)
+	if !exists(p) {
+		return "", fmt.Errorf("%w: %s", ErrNotExist, destination)
+	}
+	return p, nil
+}
+
+func (s *localStorage) Close() error {
+	return nil
+}
+
+func exists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,30 @@
+package kawaiistorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotExist   = errors.New("object does not exist")
+	ErrSignature  = errors.New("signature is invalid or expired")
+	ErrInvalidKey = errors.New("object key is invalid")
+)
+
+// IStorage keeps uploaded objects. A private object is only reachable
+// through a signed url that expires, a public one has a stable url.
+type IStorage interface {
+	Upload(ctx context.Context, destination string, r io.Reader, contentType string, private bool) error
+	// Delete returns ErrNotExist when there is nothing at destination
+	Delete(ctx context.Context, destination string) error
+	Url(ctx context.Context, destination string, private bool) (string, error)
+	Close() error
+}
+
+// IsKey tells stored object keys from absolute urls, images seeded before
+// the storage layer point somewhere else and are returned as they are.
+func IsKey(s string) bool {
+	return s != "" && !strings.Contains(s, "://")
+}
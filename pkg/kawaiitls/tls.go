@@ -0,0 +1,128 @@
+package kawaiitls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type ICertReloader interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// certReloader serves the key pair from disk and picks up a renewed pair
+// without a restart, a broken pair on disk keeps the previous one in use.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (ICertReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	cert := r.cert
+	stale := time.Since(r.lastCheck) > 10*time.Second
+	r.mu.RUnlock()
+
+	if stale {
+		r.mu.Lock()
+		r.lastCheck = time.Now()
+		r.mu.Unlock()
+
+		if r.changed() {
+			if err := r.reload(); err != nil {
+				log.Printf("reload certificate failed, keep the current one: %v", err)
+			}
+		}
+
+		r.mu.RLock()
+		cert = r.cert
+		r.mu.RUnlock()
+	}
+	return cert, nil
+}
+
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !latestModTime(r.certFile, r.keyFile).Equal(r.modTime)
+}
+
+func (r *certReloader) reload() error {
+	modTime := latestModTime(r.certFile, r.keyFile)
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair failed: %v", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// ServerConfig builds a tls config serving certFile/keyFile. A clientCAFile
+// turns on mutual TLS, only clients signed by that CA can connect.
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca failed: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client ca %s has no certificate", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
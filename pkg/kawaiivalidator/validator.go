@@ -0,0 +1,380 @@
+package kawaiivalidator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules are read from the validate tag, comma separated:
+//
+//	Email string `json:"email" validate:"required,email,max=255"`
+//	Sort  string `query:"sort" validate:"enum=ASC|DESC"`
+//
+// Supported rules are required, min, max, email, uuid, enum and thpostcode.
+// min and max count characters of a string, items of a slice or the value
+// of a number.
+const tagName = "validate"
+
+// ErrRule is a validate tag the validator cannot read, a bug of the struct
+// rather than of the request
+var ErrRule = errors.New("validate tag is invalid")
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError holds every failed rule of a request, not only the first
+// one, so clients can show them all at once.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, f := range e.Errors {
+		msgs = append(msgs, f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
+
+var (
+	emailRe = regexp.MustCompile(`^[\w.+-]+@([A-Za-z0-9-]+\.)+[A-Za-z0-9-]{2,}$`)
+	uuidRe  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+func IsEmail(s string) bool {
+	return emailRe.MatchString(s)
+}
+
+func IsUUID(s string) bool {
+	return uuidRe.MatchString(s)
+}
+
+// ThaiPostcodePattern is 5 digits, the first two the province
+const ThaiPostcodePattern = `^[1-9][0-9]{4}$`
+
+var thaiPostcodeRe = regexp.MustCompile(ThaiPostcodePattern)
+
+// IsThaiPostcode tells s is a postal code of a Thai province, 10xxx for
+// Bangkok to 96xxx for Narathiwat
+func IsThaiPostcode(s string) bool {
+	if !thaiPostcodeRe.MatchString(s) {
+		return false
+	}
+	province, _ := strconv.Atoi(s[:2])
+	switch {
+	case province >= 10 && province <= 27,
+		province >= 30 && province <= 58,
+		province >= 60 && province <= 67,
+		province >= 70 && province <= 77,
+		province >= 80 && province <= 86,
+		province >= 90 && province <= 96:
+		return true
+	}
+	return false
+}
+
+// Validate checks v, a struct or a slice of structs, against its validate
+// tags. It returns a *ValidationError when any rule fails and an ErrRule
+// when a tag cannot be read.
+func Validate(v any) error {
+	s := new(state)
+	s.value(reflect.ValueOf(v), "")
+
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.errs) > 0 {
+		return &ValidationError{Errors: s.errs}
+	}
+	return nil
+}
+
+// state is what a Validate call found, the failed rules and the first tag
+// that could not be read
+type state struct {
+	errs []*FieldError
+	err  error
+}
+
+func (s *state) value(v reflect.Value, path string) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			s.value(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Struct:
+		s.structure(v, path)
+	}
+}
+
+func (s *state) structure(v reflect.Value, path string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		// Embedded structs share the field names of their parent
+		if f.Anonymous {
+			s.value(v.Field(i), path)
+			continue
+		}
+
+		name := join(path, fieldName(f))
+		if tag := f.Tag.Get(tagName); tag != "" && tag != "-" {
+			s.field(v.Field(i), name, tag)
+		}
+		s.value(v.Field(i), name)
+	}
+}
+
+func (s *state) field(v reflect.Value, name, tag string) {
+	rules := strings.Split(tag, ",")
+
+	// A bad tag fails every request, whether the field is set or not
+	for _, rule := range rules {
+		key, param, _ := strings.Cut(rule, "=")
+		if err := checkRule(key, param); err != nil {
+			if s.err == nil {
+				s.err = fmt.Errorf("%w, %s: %v", ErrRule, name, err)
+			}
+			return
+		}
+	}
+
+	if isEmpty(v) {
+		for _, rule := range rules {
+			if rule == "required" {
+				s.errs = append(s.errs, &FieldError{
+					Field:   name,
+					Rule:    "required",
+					Message: fmt.Sprintf("%s is required", name),
+				})
+			}
+		}
+		// Optional fields are only checked when they are set
+		return
+	}
+
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		key, param, _ := strings.Cut(rule, "=")
+
+		var msg string
+		switch key {
+		case "min":
+			if n, ok := size(v); ok && n < atof(param) {
+				msg = minMessage(v, name, param)
+			}
+		case "max":
+			if n, ok := size(v); ok && n > atof(param) {
+				msg = maxMessage(v, name, param)
+			}
+		case "email":
+			if v.Kind() == reflect.String && !IsEmail(v.String()) {
+				msg = fmt.Sprintf("%s must be a valid email address", name)
+			}
+		case "uuid":
+			if v.Kind() == reflect.String && !IsUUID(v.String()) {
+				msg = fmt.Sprintf("%s must be a valid uuid", name)
+			}
+		case "thpostcode":
+			if v.Kind() == reflect.String && !IsThaiPostcode(v.String()) {
+				msg = fmt.Sprintf("%s must be a Thai postal code", name)
+			}
+		case "enum":
+			options := strings.Split(param, "|")
+			if !contains(options, fmt.Sprint(v.Interface())) {
+				msg = fmt.Sprintf("%s must be one of %s", name, strings.Join(options, ", "))
+			}
+		}
+
+		if msg != "" {
+			s.errs = append(s.errs, &FieldError{
+				Field:   name,
+				Rule:    key,
+				Message: msg,
+			})
+		}
+	}
+}
+
+// checkRule tells key is a supported rule and param fits it
+func checkRule(key, param string) error {
+	switch key {
+	case "required", "email", "uuid", "thpostcode":
+		return nil
+	case "min", "max":
+		if _, err := strconv.ParseFloat(param, 64); err != nil {
+			return fmt.Errorf("%s=%q is not a number", key, param)
+		}
+		return nil
+	case "enum":
+		if param == "" {
+			return fmt.Errorf("enum has no options")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown rule %q", key)
+}
+
+// Check reads the validate tags of the type of v, a struct or a slice of
+// structs, and of every struct it holds, without a value. Run it on the
+// request types at startup so a bad tag stops the server rather than a
+// request.
+func Check(v any) error {
+	return check(reflect.TypeOf(v), "", make(map[reflect.Type]bool))
+}
+
+func check(t reflect.Type, path string, seen map[reflect.Type]bool) error {
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := path
+		if !f.Anonymous {
+			name = join(path, fieldName(f))
+		}
+		if tag := f.Tag.Get(tagName); tag != "" && tag != "-" && !f.Anonymous {
+			for _, rule := range strings.Split(tag, ",") {
+				key, param, _ := strings.Cut(rule, "=")
+				if err := checkRule(key, param); err != nil {
+					return fmt.Errorf("%w, %s.%s: %v", ErrRule, t.Name(), name, err)
+				}
+			}
+		}
+		if err := check(f.Type, name, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// size is the length of a string, slice or map, or the value of a number
+func size(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func minMessage(v reflect.Value, name, param string) string {
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%s must be at least %s characters", name, param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("%s must have at least %s items", name, param)
+	}
+	return fmt.Sprintf("%s must be at least %s", name, param)
+}
+
+func maxMessage(v reflect.Value, name, param string) string {
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%s must be at most %s characters", name, param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("%s must have at most %s items", name, param)
+	}
+	return fmt.Sprintf("%s must be at most %s", name, param)
+}
+
+// atof reads the param of min or max, checkRule made sure it is a number
+func atof(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func contains(options []string, s string) bool {
+	for _, o := range options {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName is the name the client sent, taken from the json, query or
+// form tag.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Rules returns the parsed validate tag of f, used to describe the rules in
+// the api docs.
+func Rules(f reflect.StructField) map[string]string {
+	tag := f.Tag.Get(tagName)
+	if tag == "" || tag == "-" {
+		return nil
+	}
+
+	rules := make(map[string]string)
+	for _, rule := range strings.Split(tag, ",") {
+		key, param, _ := strings.Cut(rule, "=")
+		rules[key] = param
+	}
+	return rules
+}
@@ -0,0 +1,77 @@
+package kawaiivalidator
+
+import (
+	"errors"
+	"testing"
+)
+
+type ruleReq struct {
+	Name  string `json:"name" validate:"required,max=5"`
+	Email string `json:"email" validate:"email"`
+	Items []*ruleItem
+}
+
+type ruleItem struct {
+	Qty int `json:"qty" validate:"min=1"`
+}
+
+type badRuleReq struct {
+	Name string `json:"name" validate:"requird"`
+}
+
+type badParamReq struct {
+	Items []struct {
+		Qty int `json:"qty" validate:"min=one"`
+	} `json:"items"`
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate(&ruleReq{Name: "kawaii shop", Email: "nope", Items: []*ruleItem{{Qty: -1}, {Qty: 2}}})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	want := []string{"name", "email", "Items[0].qty"}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("Validate() failed %d rules, want %d: %v", len(verr.Errors), len(want), err)
+	}
+	for i, f := range verr.Errors {
+		if f.Field != want[i] {
+			t.Errorf("error %d is of %s, want %s", i, f.Field, want[i])
+		}
+	}
+}
+
+func TestValidateBadTag(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"unknown rule on an empty field", &badRuleReq{}},
+		{"unknown rule on a set field", &badRuleReq{Name: "kawaii"}},
+		{"param that is not a number", &badParamReq{Items: []struct {
+			Qty int `json:"qty" validate:"min=one"`
+		}{{Qty: 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.v); !errors.Is(err, ErrRule) {
+				t.Errorf("Validate() = %v, want ErrRule", err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(&ruleReq{}); err != nil {
+		t.Errorf("Check(ruleReq) = %v, want nil", err)
+	}
+	// Found without a value, through the element type of the slice
+	if err := Check(&badParamReq{}); !errors.Is(err, ErrRule) {
+		t.Errorf("Check(badParamReq) = %v, want ErrRule", err)
+	}
+	if err := Check([]*badRuleReq{}); !errors.Is(err, ErrRule) {
+		t.Errorf("Check([]badRuleReq) = %v, want ErrRule", err)
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ParseNetworks accepts ips and cidrs, e.g. "10.0.0.1" or "10.0.0.0/8".
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an ip or cidr", v)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			v = fmt.Sprintf("%s/%d", v, bits)
+		}
+
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an ip or cidr", v)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP walks X-Forwarded-For from the right, skipping trusted proxies.
+// The first untrusted hop is the client, anything left of it may be forged.
+func ClientIP(remote net.IP, forwardedFor string, trusted []*net.IPNet) string {
+	if !isTrusted(remote, trusted) || forwardedFor == "" {
+		return remote.String()
+	}
+
+	client := remote
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP returns the client ip resolved by the RealIP middleware.
+func RealIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("clientIp").(string); ok && ip != "" {
+		return ip
+	}
+	return c.IP()
+}